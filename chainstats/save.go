@@ -0,0 +1,90 @@
+package chainstats
+
+import (
+	"chiastat/chia"
+	"chiastat/utils"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/go-pg/pg/v10"
+)
+
+// lastSavedHeight returns end height of the last saved interval, -1 if there are no intervals yet.
+func lastSavedHeight(db *pg.DB, interval time.Duration) (int64, error) {
+	var height *int64
+	_, err := db.QueryOne(pg.Scan(&height), `
+		SELECT max(end_height) FROM chain_stats WHERE interval_seconds = ?`,
+		int64(interval/time.Second))
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if height == nil {
+		return -1, nil
+	}
+	return *height, nil
+}
+
+func saveInterval(db *pg.DB, interval time.Duration, st *chia.ChainStatsInterval) error {
+	var meanBlockTime *float64
+	if st.MeanBlockTime > 0 {
+		secs := st.MeanBlockTime.Seconds()
+		meanBlockTime = &secs
+	}
+	_, err := db.Exec(`
+		INSERT INTO chain_stats (
+			interval_seconds, interval_start, start_height, end_height, blocks_count, tx_blocks_count,
+			netspace, difficulty, total_fees, total_cost, mean_block_time
+		) VALUES (?, ?, ?, ?, ?, ?, ?::numeric, ?, ?::numeric, ?::numeric, ?)
+		ON CONFLICT (interval_seconds, interval_start) DO NOTHING`,
+		int64(interval/time.Second), st.Start, st.StartHeight, st.EndHeight, st.BlocksCount, st.TxBlocksCount,
+		st.Space.String(), st.Difficulty, st.Fees, st.Cost, meanBlockTime,
+	)
+	return merry.Wrap(err)
+}
+
+func CMDSaveChainStats() error {
+	srcFlags := utils.AddBlockSourceFlags()
+	interval := flag.Duration("interval", 24*time.Hour, "stats interval (aligned to UTC)")
+	window := flag.Uint("window", chia.DEFAULT_SPACE_WINDOW_BLOCKS, "number of blocks space is estimated over")
+	flag.Parse()
+	if *interval < time.Minute || *interval%time.Second != 0 {
+		return merry.Errorf("interval should be whole number of seconds, at least one minute")
+	}
+
+	db := utils.MakePGConnection()
+
+	src, err := srcFlags.Open()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+
+	lastHeight, err := lastSavedHeight(db, *interval)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	startHeight := uint32(lastHeight + 1)
+	log.Printf("SAVE:CHAIN_STATS: starting from height %d", startHeight)
+
+	count := 0
+	logPrint := utils.NewSyncInterval(10*time.Second, func() {
+		log.Printf("SAVE:CHAIN_STATS: intervals: +%d", count)
+		count = 0
+	})
+	cfg := chia.ChainStatsConfig{Interval: *interval, WindowBlocks: uint32(*window), StartHeight: startHeight}
+	err = chia.ForEachChainStatsInterval(src, cfg, func(st *chia.ChainStatsInterval) error {
+		if err := saveInterval(db, *interval, st); err != nil {
+			return merry.Wrap(err)
+		}
+		count += 1
+		logPrint.Trigger()
+		return nil
+	})
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	log.Printf("SAVE:CHAIN_STATS: done, intervals: +%d", count)
+	return nil
+}
@@ -0,0 +1,494 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrBlockNotFound = merry.New("block not found")
+
+// BlockSource provides main chain blocks. Heights ranges are inclusive,
+// ForEach* stop at the peak if endHeight is above it (math.MaxUint32 may be used for "till the end").
+//
+// Sources which do not store block records (exported full blocks, peers)
+// return records made by BlockRecordFromFullBlock.
+type BlockSource interface {
+	PeakHeight() (uint32, error)
+	FullBlockByHeight(height uint32) (*types.FullBlock, error)
+	FullBlockByHash(headerHash [32]byte) (*types.FullBlock, error)
+	BlockRecordByHeight(height uint32) (*types.BlockRecord, error)
+	BlockRecordByHash(headerHash [32]byte) (*types.BlockRecord, error)
+	ForEachFullBlock(startHeight, endHeight uint32, handler func(*types.FullBlock) error) error
+	ForEachBlockRecord(startHeight, endHeight uint32, handler func(*types.BlockRecord) error) error
+	Close() error
+}
+
+// BlockRecordFromFullBlock fills block record fields that can be taken from the block itself.
+// Fields that depend on previous blocks (SubSlotIters, RequiredIters, Deficit, Overflow,
+// PrevTransactionBlockHeight, finished slot hashes, etc.) are left empty.
+func BlockRecordFromFullBlock(block *types.FullBlock) *types.BlockRecord {
+	rcb := &block.RewardChainBlock
+	br := &types.BlockRecord{
+		HeaderHash:         block.HeaderHash(),
+		PrevHash:           block.Foliage.PrevBlockHash,
+		Height:             rcb.Height,
+		Weight:             rcb.Weight,
+		TotalIters:         rcb.TotalIters,
+		SignagePointIndex:  rcb.SignagePointIndex,
+		ChallengeVdfOutput: rcb.ChallengeChainIpVdf.Output,
+		PoolPuzzleHash:     block.Foliage.FoliageBlockData.PoolTarget.PuzzleHash,
+		FarmerPuzzleHash:   block.Foliage.FoliageBlockData.FarmerRewardPuzzleHash,
+	}
+	if block.FoliageTransactionBlock != nil {
+		br.Timestamp = block.FoliageTransactionBlock.Timestamp
+		prevHash := block.FoliageTransactionBlock.PrevTransactionBlockHash
+		br.PrevTransactionBlockHash = &prevHash
+	}
+	if block.TransactionsInfo != nil {
+		br.Fees = block.TransactionsInfo.Fees
+		br.RewardClaimsIncorporated = block.TransactionsInfo.RewardClaimsIncorporated
+	}
+	return br
+}
+
+var _ BlockSource = (*SqliteBlockSource)(nil)
+var _ BlockSource = (*RawFileBlockSource)(nil)
+var _ BlockSource = (*PeerBlockSource)(nil)
+
+func clampEndHeight(src BlockSource, endHeight uint32) (uint32, error) {
+	peakHeight, err := src.PeakHeight()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if endHeight > peakHeight {
+		endHeight = peakHeight
+	}
+	return endHeight, nil
+}
+
+// lastTimestampBefore returns timestamp of the last transaction block below height
+// (non-transaction blocks have no timestamps). Zero time if there is no such block.
+func lastTimestampBefore(src BlockSource, height uint32) (time.Time, error) {
+	for h := int64(height) - 1; h >= 0; h-- {
+		br, err := src.BlockRecordByHeight(uint32(h))
+		if merry.Is(err, ErrBlockNotFound) {
+			break
+		}
+		if err != nil {
+			return time.Time{}, merry.Wrap(err)
+		}
+		if br.Timestamp != 0 {
+			return time.Unix(int64(br.Timestamp), 0).UTC(), nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// SqliteBlockSource reads main chain blocks from full node database. Both schemas are supported:
+//
+// v1 (blockchain_v1_mainnet.sqlite): block_records and full_blocks tables with plain serialized blocks;
+//
+// v2 (blockchain_v2_mainnet.sqlite): single full_blocks table keyed by header_hash
+// with zstd-compressed blocks, block records in block_record column and main chain marked by in_main_chain.
+// Version is stored in database_version table (missing in v1).
+//
+// v1 database may have no block_records table (like blocksync.Store),
+// records are made from full blocks in that case.
+//
+// v1 has no main chain flag, fork blocks are found on open (see loadMainChain) and skipped.
+type SqliteBlockSource struct {
+	db              *sql.DB
+	version         int
+	hasBlockRecords bool
+	zstd            *zstd.Decoder
+	// v1 only: header hashes (hex, as stored) of blocks not in the main chain
+	orphans map[string]bool
+	// v1 only: peak from block_records (max height may belong to a fork)
+	v1PeakHeight *uint32
+}
+
+func hasTable(db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0, merry.Wrap(err)
+}
+
+func DetectDBVersion(db *sql.DB) (int, error) {
+	hasVersion, err := hasTable(db, "database_version")
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if !hasVersion {
+		return 1, nil
+	}
+	var version int
+	if err := db.QueryRow("SELECT version FROM database_version").Scan(&version); err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if version != 1 && version != 2 {
+		return 0, merry.Errorf("unsupported blockchain database version: %d", version)
+	}
+	return version, nil
+}
+
+func NewSqliteBlockSource(db *sql.DB) (*SqliteBlockSource, error) {
+	version, err := DetectDBVersion(db)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	hasBlockRecords := true
+	if version == 1 {
+		if hasBlockRecords, err = hasTable(db, "block_records"); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	src := &SqliteBlockSource{db: db, version: version, hasBlockRecords: hasBlockRecords, zstd: dec}
+	if version == 1 {
+		if err := src.loadMainChain(); err != nil {
+			dec.Close()
+			return nil, merry.Wrap(err)
+		}
+	}
+	return src, nil
+}
+
+// loadMainChain finds peak and fork blocks of v1 database: it has no main chain flag,
+// so the chain is walked from the peak record (is_peak) down by prev_hash.
+// Database without block_records is expected to have no forks.
+func (s *SqliteBlockSource) loadMainChain() error {
+	s.orphans = make(map[string]bool)
+	if !s.hasBlockRecords {
+		var height uint32
+		err := s.db.QueryRow("SELECT height FROM full_blocks GROUP BY height HAVING count(*) > 1 LIMIT 1").Scan(&height)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		return merry.Errorf("several blocks at height %d and no block_records to find the main chain", height)
+	}
+
+	var peakHash, expectedHash string
+	var peakHeight uint32
+	err := s.db.QueryRow("SELECT header_hash, prev_hash, height FROM block_records WHERE is_peak = 1").
+		Scan(&peakHash, &expectedHash, &peakHeight)
+	if err == sql.ErrNoRows {
+		return nil //empty database
+	}
+	if err != nil {
+		return merry.Prependf(err, "peak block record")
+	}
+	s.v1PeakHeight = &peakHeight
+
+	for _, table := range []string{"full_blocks", "block_records"} {
+		if err := s.forEachHashRow("SELECT header_hash, '', height FROM "+table+" WHERE height > ?", peakHeight,
+			func(hash, prevHash string, height uint32) error {
+				s.orphans[hash] = true
+				return nil
+			}); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	// rows are ordered by height descending, main block of each height is the parent of the previous main one
+	expectedHeight := int64(peakHeight) - 1
+	err = s.forEachHashRow("SELECT header_hash, prev_hash, height FROM block_records WHERE height <= ? ORDER BY height DESC", peakHeight,
+		func(hash, prevHash string, height uint32) error {
+			switch {
+			case height == peakHeight:
+				if hash != peakHash {
+					s.orphans[hash] = true
+				}
+			case int64(height) < expectedHeight:
+				return merry.Errorf("main chain block record %d not found", expectedHeight)
+			case hash == expectedHash:
+				expectedHash = prevHash
+				expectedHeight = int64(height) - 1
+			default:
+				s.orphans[hash] = true
+			}
+			return nil
+		})
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if expectedHeight >= 0 {
+		return merry.Errorf("main chain block record %d not found", expectedHeight)
+	}
+	return nil
+}
+
+func (s *SqliteBlockSource) forEachHashRow(query string, arg interface{}, handler func(hash, prevHash string, height uint32) error) error {
+	rows, err := s.db.Query(query, arg)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash, prevHash string
+		var height uint32
+		if err := rows.Scan(&hash, &prevHash, &height); err != nil {
+			return merry.Wrap(err)
+		}
+		if err := handler(hash, prevHash, height); err != nil {
+			return err
+		}
+	}
+	return merry.Wrap(rows.Err())
+}
+
+// isOrphan checks header hash (as stored) against v1 fork blocks.
+func (s *SqliteBlockSource) isOrphan(hash []byte) bool {
+	return s.orphans[string(hash)]
+}
+
+// OpenSqliteBlockSource opens existing database file, Close will close it.
+func OpenSqliteBlockSource(dbPath string) (*SqliteBlockSource, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil, merry.Errorf("not found: %s", dbPath)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	src, err := NewSqliteBlockSource(db)
+	if err != nil {
+		db.Close()
+		return nil, merry.Wrap(err)
+	}
+	return src, nil
+}
+
+func (s *SqliteBlockSource) Version() int {
+	return s.version
+}
+
+func (s *SqliteBlockSource) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SqliteBlockSource) Close() error {
+	s.zstd.Close()
+	return merry.Wrap(s.db.Close())
+}
+
+// blocksQuery returns table, block column and main chain condition for "full_blocks" or "block_records" data.
+// There is no such condition for v1, fork blocks should be skipped with isOrphan.
+func (s *SqliteBlockSource) blocksQuery(tableName string) (string, string, string, error) {
+	if tableName != "full_blocks" && tableName != "block_records" {
+		return "", "", "", merry.Errorf(`unexpected table name "%s", expected "full_blocks" or "block_records"`, tableName)
+	}
+	if s.version == 1 {
+		return tableName, "block", "1", nil
+	}
+	if tableName == "block_records" {
+		return "full_blocks", "block_record", "in_main_chain = 1", nil
+	}
+	return "full_blocks", "block", "in_main_chain = 1", nil
+}
+
+// rawBlockData returns serialized block (or block record) as it is stored in v1 database,
+// v2 full blocks are decompressed.
+func (s *SqliteBlockSource) rawBlockData(tableName string, data []byte) ([]byte, error) {
+	if s.version == 2 && tableName == "full_blocks" {
+		res, err := s.zstd.DecodeAll(data, nil)
+		return res, merry.Wrap(err)
+	}
+	return data, nil
+}
+
+func (s *SqliteBlockSource) PeakHeight() (uint32, error) {
+	if s.v1PeakHeight != nil {
+		return *s.v1PeakHeight, nil
+	}
+	table, _, mainCond, err := s.blocksQuery("full_blocks")
+	if err != nil {
+		return 0, err
+	}
+	var height sql.NullInt64
+	if err := s.db.QueryRow("SELECT max(height) FROM " + table + " WHERE " + mainCond).Scan(&height); err != nil {
+		return 0, merry.Wrap(err)
+	}
+	if !height.Valid {
+		return 0, ErrBlockNotFound.Here().WithMessage("database is empty")
+	}
+	return uint32(height.Int64), nil
+}
+
+// hashCond returns header_hash condition and argument: hash is stored as hex text in v1 and as blob in v2.
+func (s *SqliteBlockSource) hashCond(headerHash [32]byte) (string, interface{}) {
+	if s.version == 1 {
+		return "header_hash = ?", hex.EncodeToString(headerHash[:])
+	}
+	return "header_hash = ?", headerHash[:]
+}
+
+func (s *SqliteBlockSource) blockData(tableName, cond string, args ...interface{}) ([]byte, error) {
+	table, column, mainCond, err := s.blocksQuery(tableName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT header_hash, "+column+" FROM "+table+" WHERE "+cond+" AND "+mainCond, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash, data []byte
+		if err := rows.Scan(&hash, &data); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if !s.isOrphan(hash) {
+			return s.rawBlockData(tableName, data)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return nil, ErrBlockNotFound.Here()
+}
+
+func (s *SqliteBlockSource) fullBlock(cond string, args ...interface{}) (*types.FullBlock, error) {
+	data, err := s.blockData("full_blocks", cond, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	var block types.FullBlock
+	if err := utils.FromByteSliceExact(data, &block); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return &block, nil
+}
+
+func (s *SqliteBlockSource) blockRecord(cond string, args ...interface{}) (*types.BlockRecord, error) {
+	if !s.hasBlockRecords {
+		block, err := s.fullBlock(cond, args...)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		return BlockRecordFromFullBlock(block), nil
+	}
+	data, err := s.blockData("block_records", cond, args...)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	var br types.BlockRecord
+	if err := utils.FromByteSliceExact(data, &br); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return &br, nil
+}
+
+func (s *SqliteBlockSource) FullBlockByHeight(height uint32) (*types.FullBlock, error) {
+	return s.fullBlock("height = ?", height)
+}
+
+func (s *SqliteBlockSource) FullBlockByHash(headerHash [32]byte) (*types.FullBlock, error) {
+	cond, arg := s.hashCond(headerHash)
+	return s.fullBlock(cond, arg)
+}
+
+func (s *SqliteBlockSource) BlockRecordByHeight(height uint32) (*types.BlockRecord, error) {
+	return s.blockRecord("height = ?", height)
+}
+
+func (s *SqliteBlockSource) BlockRecordByHash(headerHash [32]byte) (*types.BlockRecord, error) {
+	cond, arg := s.hashCond(headerHash)
+	return s.blockRecord(cond, arg)
+}
+
+// ForEachRawBlock calls handler for every main chain block (or block record) data
+// with startHeight <= height <= endHeight ordered by height, data is in v1 format (uncompressed).
+// Blocks are loaded in chunks of chunkSize heights.
+func (s *SqliteBlockSource) ForEachRawBlock(tableName string, startHeight, endHeight uint32, chunkSize int, handler func(height uint32, data []byte) error) error {
+	table, column, mainCond, err := s.blocksQuery(tableName)
+	if err != nil {
+		return err
+	}
+	endHeight, err = clampEndHeight(s, endHeight)
+	if merry.Is(err, ErrBlockNotFound) {
+		return nil //empty database
+	}
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	lastHeight := int64(-1)
+	for chunkStart := int64(startHeight); chunkStart <= int64(endHeight); chunkStart += int64(chunkSize) {
+		chunkEnd := chunkStart + int64(chunkSize) - 1
+		if chunkEnd > int64(endHeight) {
+			chunkEnd = int64(endHeight)
+		}
+		rows, err := s.db.Query("SELECT height, header_hash, "+column+" FROM "+table+" WHERE height >= ? AND height <= ? AND "+mainCond+" ORDER BY height",
+			chunkStart, chunkEnd)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		for rows.Next() {
+			var height uint32
+			var hash, data []byte
+			if err := rows.Scan(&height, &hash, &data); err != nil {
+				rows.Close()
+				return merry.Wrap(err)
+			}
+			if s.isOrphan(hash) {
+				continue
+			}
+			if int64(height) == lastHeight {
+				rows.Close()
+				return merry.Errorf("several main chain blocks at height %d", height)
+			}
+			lastHeight = int64(height)
+			data, err = s.rawBlockData(tableName, data)
+			if err != nil {
+				rows.Close()
+				return merry.Wrap(err)
+			}
+			if err := handler(height, data); err != nil {
+				rows.Close()
+				return merry.Wrap(err)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (s *SqliteBlockSource) ForEachFullBlock(startHeight, endHeight uint32, handler func(*types.FullBlock) error) error {
+	return s.ForEachRawBlock("full_blocks", startHeight, endHeight, 1000, func(height uint32, data []byte) error {
+		var block types.FullBlock
+		if err := utils.FromByteSliceExact(data, &block); err != nil {
+			return merry.Wrap(err)
+		}
+		return handler(&block)
+	})
+}
+
+func (s *SqliteBlockSource) ForEachBlockRecord(startHeight, endHeight uint32, handler func(*types.BlockRecord) error) error {
+	if !s.hasBlockRecords {
+		return s.ForEachFullBlock(startHeight, endHeight, func(block *types.FullBlock) error {
+			return handler(BlockRecordFromFullBlock(block))
+		})
+	}
+	return s.ForEachRawBlock("block_records", startHeight, endHeight, 10000, func(height uint32, data []byte) error {
+		var br types.BlockRecord
+		if err := utils.FromByteSliceExact(data, &br); err != nil {
+			return merry.Wrap(err)
+		}
+		return handler(&br)
+	})
+}
@@ -0,0 +1,157 @@
+package chia
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// upstream full node sends at most 32 blocks per RespondBlocks (max_blocks_to_send)
+const PEER_SOURCE_BATCH_SIZE = 32
+
+// PeerBlockSource requests blocks from PeerManager peers, peak is the heaviest peers' peak.
+// Blocks are NOT validated, only heights and prev_hash links are checked.
+//
+// Peer protocol has no request by header hash, so only blocks already received
+// through this source can be found by hash.
+type PeerBlockSource struct {
+	peers        *network.PeerManager
+	hashMutex    sync.Mutex
+	heightByHash map[[32]byte]uint32
+}
+
+// NewPeerBlockSource wraps started PeerManager, Close will stop it.
+func NewPeerBlockSource(peers *network.PeerManager) *PeerBlockSource {
+	return &PeerBlockSource{peers: peers, heightByHash: make(map[[32]byte]uint32)}
+}
+
+// WaitForPeak waits until at least one connected peer has sent its peak.
+func (s *PeerBlockSource) WaitForPeak(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if peak, _ := s.peers.Peak(); peak != nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return merry.New("timeout waiting for peers' peak")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *PeerBlockSource) Close() error {
+	s.peers.Stop()
+	return nil
+}
+
+func (s *PeerBlockSource) PeakHeight() (uint32, error) {
+	peak, _ := s.peers.Peak()
+	if peak == nil {
+		return 0, merry.New("no peer has sent its peak yet")
+	}
+	return peak.Height, nil
+}
+
+func (s *PeerBlockSource) remember(blocks []types.FullBlock) {
+	s.hashMutex.Lock()
+	defer s.hashMutex.Unlock()
+	for i := range blocks {
+		s.heightByHash[blocks[i].HeaderHash()] = blocks[i].RewardChainBlock.Height
+	}
+}
+
+func (s *PeerBlockSource) FullBlockByHeight(height uint32) (*types.FullBlock, error) {
+	block, err := s.peers.RequestBlock(height, true)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if block.RewardChainBlock.Height != height {
+		return nil, merry.Errorf("expected block %d, got %d", height, block.RewardChainBlock.Height)
+	}
+	s.remember([]types.FullBlock{*block})
+	return block, nil
+}
+
+func (s *PeerBlockSource) FullBlockByHash(headerHash [32]byte) (*types.FullBlock, error) {
+	s.hashMutex.Lock()
+	height, ok := s.heightByHash[headerHash]
+	s.hashMutex.Unlock()
+	if !ok {
+		return nil, ErrBlockNotFound.Here().WithMessage("block was not received yet, peers can not be asked by hash")
+	}
+	block, err := s.FullBlockByHeight(height)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if block.HeaderHash() != headerHash {
+		return nil, ErrBlockNotFound.Here().WithMessage("block is not in the main chain anymore")
+	}
+	return block, nil
+}
+
+func (s *PeerBlockSource) BlockRecordByHeight(height uint32) (*types.BlockRecord, error) {
+	block, err := s.FullBlockByHeight(height)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return BlockRecordFromFullBlock(block), nil
+}
+
+func (s *PeerBlockSource) BlockRecordByHash(headerHash [32]byte) (*types.BlockRecord, error) {
+	block, err := s.FullBlockByHash(headerHash)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return BlockRecordFromFullBlock(block), nil
+}
+
+func (s *PeerBlockSource) ForEachFullBlock(startHeight, endHeight uint32, handler func(*types.FullBlock) error) error {
+	endHeight, err := clampEndHeight(s, endHeight)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	var prevHash *[32]byte
+	for start := startHeight; start <= endHeight; start += PEER_SOURCE_BATCH_SIZE {
+		end := start + PEER_SOURCE_BATCH_SIZE - 1
+		if end > endHeight || end < start {
+			end = endHeight
+		}
+		blocks, err := s.peers.RequestBlocks(start, end, true)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if len(blocks) != int(end-start+1) {
+			return merry.Errorf("expected %d blocks, got %d", end-start+1, len(blocks))
+		}
+		for i := range blocks {
+			block := &blocks[i]
+			if block.RewardChainBlock.Height != start+uint32(i) {
+				return merry.Errorf("expected block %d, got %d", start+uint32(i), block.RewardChainBlock.Height)
+			}
+			if prevHash != nil && block.Foliage.PrevBlockHash != *prevHash {
+				return merry.Errorf("block %d does not follow previous one, chain has probably changed", block.RewardChainBlock.Height)
+			}
+			hash := block.HeaderHash()
+			prevHash = &hash
+		}
+		s.remember(blocks)
+		for i := range blocks {
+			if err := handler(&blocks[i]); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		if end == endHeight {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *PeerBlockSource) ForEachBlockRecord(startHeight, endHeight uint32, handler func(*types.BlockRecord) error) error {
+	return s.ForEachFullBlock(startHeight, endHeight, func(block *types.FullBlock) error {
+		return handler(BlockRecordFromFullBlock(block))
+	})
+}
@@ -0,0 +1,463 @@
+package chia
+
+import (
+	"bufio"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/ansel1/merry"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Raw blocks file (written by export-blocks) layout:
+//
+//	header  optional, 8 bytes: RAW_HEADER_MAGIC, format version (1), flags (RAW_FLAG_*), 2 zero bytes;
+//	blocks  main chain full blocks (or block records) starting from height 0,
+//	        each one is prefixed with 4-byte big-endian length;
+//	index   optional: 8-byte big-endian offset of every block (from the file start),
+//	        then 8-byte offset of the index itself and RAW_INDEX_MAGIC.
+//
+// Plain files (without header and index) are just the length-prefixed blocks.
+// Header can not be confused with a block: its magic would mean a 1.1GB block.
+const RAW_HEADER_MAGIC = "CHBK"
+const RAW_INDEX_MAGIC = "CHBKIDX1"
+const RAW_FORMAT_VERSION = 1
+
+const (
+	// Every block is compressed separately (as a zstd frame), so random access still works.
+	RAW_FLAG_ZSTD = 1 << iota
+)
+
+var ErrNotAvailable = merry.New("data is not available in this source")
+
+type RawFileConfig struct {
+	// Compress blocks with zstd (header is written).
+	Zstd bool
+	// Append height->offset index, so file can be opened without scanning it.
+	Index bool
+}
+
+// RawBlocksWriter writes raw blocks file, blocks must be written in height order starting from 0.
+type RawBlocksWriter struct {
+	out     *bufio.Writer
+	cfg     RawFileConfig
+	zstd    *zstd.Encoder
+	offset  int64
+	offsets []int64
+}
+
+func NewRawBlocksWriter(out io.Writer, cfg RawFileConfig) (*RawBlocksWriter, error) {
+	w := &RawBlocksWriter{out: bufio.NewWriterSize(out, 1024*1024), cfg: cfg}
+	if cfg.Zstd {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		w.zstd = enc
+		header := append([]byte(RAW_HEADER_MAGIC), RAW_FORMAT_VERSION, RAW_FLAG_ZSTD, 0, 0)
+		if err := w.write(header); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	return w, nil
+}
+
+func (w *RawBlocksWriter) write(data []byte) error {
+	n, err := w.out.Write(data)
+	w.offset += int64(n)
+	return merry.Wrap(err)
+}
+
+// WriteData writes serialized (uncompressed) block or block record.
+// Returns the number of bytes actually written for the block data.
+func (w *RawBlocksWriter) WriteData(data []byte) (int, error) {
+	if w.zstd != nil {
+		data = w.zstd.EncodeAll(data, nil)
+	}
+	if w.cfg.Index {
+		w.offsets = append(w.offsets, w.offset)
+	}
+	sizeBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBuf, uint32(len(data)))
+	if err := w.write(sizeBuf); err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return len(data), w.write(data)
+}
+
+// Close writes index (if enabled) and flushes buffered data. Underlying writer is not closed.
+func (w *RawBlocksWriter) Close() error {
+	if w.zstd != nil {
+		w.zstd.Close()
+	}
+	if w.cfg.Index {
+		indexOffset := w.offset
+		buf := make([]byte, 8)
+		for _, offset := range w.offsets {
+			binary.BigEndian.PutUint64(buf, uint64(offset))
+			if err := w.write(buf); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		binary.BigEndian.PutUint64(buf, uint64(indexOffset))
+		if err := w.write(append(buf, RAW_INDEX_MAGIC...)); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return merry.Wrap(w.out.Flush())
+}
+
+func ExportBlocksData(src *SqliteBlockSource, tableName string, out io.Writer, cfg RawFileConfig) error {
+	chunkSize := 10000
+	countTotal := 0
+	sizeTotal := 0
+	sizeMax := 0
+
+	w, err := NewRawBlocksWriter(out, cfg)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	err = src.ForEachRawBlock(tableName, 0, math.MaxUint32, chunkSize, func(height uint32, blockBytes []byte) error {
+		size, err := w.WriteData(blockBytes)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+
+		countTotal += 1
+		sizeTotal += size
+		if size > sizeMax {
+			sizeMax = size
+		}
+		if countTotal%(chunkSize*2) == 0 {
+			log.Printf("%d blocks, %.1f MiB, %.2f KiB/block (max %.1f KiB)",
+				countTotal, float64(sizeTotal)/(1024*1024),
+				float64(sizeTotal)/float64(countTotal)/1024, float64(sizeMax)/1024)
+		}
+		return nil
+	})
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(w.Close())
+}
+
+// RawFileBlockSource reads raw blocks file (see RAW_HEADER_MAGIC for layout).
+//
+// If file has no index, block offsets are collected on open (only lengths are read).
+// Hash lookups read the whole file on the first call to build hash index, which may be slow for full blocks.
+type RawFileBlockSource struct {
+	file         *os.File
+	tableName    string
+	indexed      bool
+	zstd         *zstd.Decoder
+	dataEnd      int64
+	offsets      []int64
+	hashMutex    sync.Mutex
+	heightByHash map[[32]byte]uint32
+}
+
+// OpenRawFileBlockSource opens file exported from tableName ("full_blocks" or "block_records").
+func OpenRawFileBlockSource(fpath, tableName string) (*RawFileBlockSource, error) {
+	if tableName != "full_blocks" && tableName != "block_records" {
+		return nil, merry.Errorf(`unexpected table name "%s", expected "full_blocks" or "block_records"`, tableName)
+	}
+	file, err := os.Open(fpath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	src := &RawFileBlockSource{file: file, tableName: tableName}
+	if err := src.readLayout(); err != nil {
+		src.Close()
+		return nil, merry.Prepend(err, fpath)
+	}
+	return src, nil
+}
+
+func (s *RawFileBlockSource) readLayout() error {
+	stat, err := s.file.Stat()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	dataStart := int64(0)
+	s.dataEnd = stat.Size()
+
+	header := make([]byte, 8)
+	if s.dataEnd >= 8 {
+		if _, err := s.file.ReadAt(header, 0); err != nil {
+			return merry.Prepend(err, "reading header")
+		}
+		if string(header[:4]) == RAW_HEADER_MAGIC {
+			if header[4] != RAW_FORMAT_VERSION {
+				return merry.Errorf("unsupported format version %d", header[4])
+			}
+			dataStart = 8
+		}
+	}
+	if dataStart > 0 && header[5]&RAW_FLAG_ZSTD != 0 {
+		if s.zstd, err = zstd.NewReader(nil); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	footer := make([]byte, 16)
+	if s.dataEnd >= dataStart+16 {
+		if _, err := s.file.ReadAt(footer, s.dataEnd-16); err != nil {
+			return merry.Prepend(err, "reading footer")
+		}
+		if string(footer[8:]) == RAW_INDEX_MAGIC {
+			return s.readIndex(dataStart, int64(binary.BigEndian.Uint64(footer[:8])))
+		}
+	}
+	return s.scanOffsets(dataStart)
+}
+
+func (s *RawFileBlockSource) readIndex(dataStart, indexOffset int64) error {
+	indexEnd := s.dataEnd - 16
+	if indexOffset < dataStart || indexOffset > indexEnd || (indexEnd-indexOffset)%8 != 0 {
+		return merry.Errorf("malformed index: offset %d, file size %d", indexOffset, s.dataEnd)
+	}
+	index := make([]byte, indexEnd-indexOffset)
+	if _, err := s.file.ReadAt(index, indexOffset); err != nil {
+		return merry.Prepend(err, "reading index")
+	}
+	s.offsets = make([]int64, len(index)/8)
+	for i := range s.offsets {
+		s.offsets[i] = int64(binary.BigEndian.Uint64(index[i*8:]))
+		if s.offsets[i] < dataStart || s.offsets[i] >= indexOffset {
+			return merry.Errorf("malformed index: block %d offset %d is out of range", i, s.offsets[i])
+		}
+	}
+	s.dataEnd = indexOffset
+	s.indexed = true
+	return nil
+}
+
+func (s *RawFileBlockSource) scanOffsets(dataStart int64) error {
+	sizeBuf := make([]byte, 4)
+	offset := dataStart
+	for offset < s.dataEnd {
+		if _, err := s.file.ReadAt(sizeBuf, offset); err != nil {
+			return merry.Prepend(err, "reading block size")
+		}
+		s.offsets = append(s.offsets, offset)
+		offset += 4 + int64(binary.BigEndian.Uint32(sizeBuf))
+	}
+	if offset != s.dataEnd {
+		return merry.Errorf("last block is truncated: expected %d bytes, file size is %d", offset, s.dataEnd)
+	}
+	return nil
+}
+
+func (s *RawFileBlockSource) Close() error {
+	if s.zstd != nil {
+		s.zstd.Close()
+	}
+	return merry.Wrap(s.file.Close())
+}
+
+// IsIndexed returns true if file has index footer.
+func (s *RawFileBlockSource) IsIndexed() bool {
+	return s.indexed
+}
+
+func (s *RawFileBlockSource) IsCompressed() bool {
+	return s.zstd != nil
+}
+
+func (s *RawFileBlockSource) PeakHeight() (uint32, error) {
+	if len(s.offsets) == 0 {
+		return 0, ErrBlockNotFound.Here().WithMessage("file is empty")
+	}
+	return uint32(len(s.offsets) - 1), nil
+}
+
+// uncompress returns data as is for plain files, data may be reused by caller in that case.
+func (s *RawFileBlockSource) uncompress(data []byte) ([]byte, error) {
+	if s.zstd == nil {
+		return data, nil
+	}
+	res, err := s.zstd.DecodeAll(data, nil)
+	return res, merry.Wrap(err)
+}
+
+func (s *RawFileBlockSource) readData(height uint32) ([]byte, error) {
+	if int64(height) >= int64(len(s.offsets)) {
+		return nil, ErrBlockNotFound.Here()
+	}
+	offset := s.offsets[height]
+	sizeBuf := make([]byte, 4)
+	if _, err := s.file.ReadAt(sizeBuf, offset); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	size := int64(binary.BigEndian.Uint32(sizeBuf))
+	if offset+4+size > s.dataEnd {
+		return nil, merry.Errorf("block %d is truncated", height)
+	}
+	data := make([]byte, size)
+	if _, err := s.file.ReadAt(data, offset+4); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return s.uncompress(data)
+}
+
+func decodeRawFullBlock(height uint32, data []byte) (*types.FullBlock, error) {
+	var block types.FullBlock
+	if err := utils.FromByteSliceExact(data, &block); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if block.RewardChainBlock.Height != height {
+		return nil, merry.Errorf("expected block %d, got %d", height, block.RewardChainBlock.Height)
+	}
+	return &block, nil
+}
+
+func decodeRawBlockRecord(height uint32, data []byte) (*types.BlockRecord, error) {
+	var br types.BlockRecord
+	if err := utils.FromByteSliceExact(data, &br); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if br.Height != height {
+		return nil, merry.Errorf("expected block record %d, got %d", height, br.Height)
+	}
+	return &br, nil
+}
+
+func (s *RawFileBlockSource) FullBlockByHeight(height uint32) (*types.FullBlock, error) {
+	if s.tableName != "full_blocks" {
+		return nil, ErrNotAvailable.Here().WithMessage("file contains only block records")
+	}
+	data, err := s.readData(height)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return decodeRawFullBlock(height, data)
+}
+
+func (s *RawFileBlockSource) BlockRecordByHeight(height uint32) (*types.BlockRecord, error) {
+	data, err := s.readData(height)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if s.tableName == "full_blocks" {
+		block, err := decodeRawFullBlock(height, data)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		return BlockRecordFromFullBlock(block), nil
+	}
+	return decodeRawBlockRecord(height, data)
+}
+
+func (s *RawFileBlockSource) heightOf(headerHash [32]byte) (uint32, error) {
+	s.hashMutex.Lock()
+	defer s.hashMutex.Unlock()
+	if s.heightByHash == nil {
+		heightByHash := make(map[[32]byte]uint32, len(s.offsets))
+		err := s.ForEachBlockRecord(0, uint32(len(s.offsets)), func(br *types.BlockRecord) error {
+			heightByHash[br.HeaderHash] = br.Height
+			return nil
+		})
+		if err != nil {
+			return 0, merry.Wrap(err)
+		}
+		s.heightByHash = heightByHash
+	}
+	height, ok := s.heightByHash[headerHash]
+	if !ok {
+		return 0, ErrBlockNotFound.Here()
+	}
+	return height, nil
+}
+
+func (s *RawFileBlockSource) FullBlockByHash(headerHash [32]byte) (*types.FullBlock, error) {
+	if s.tableName != "full_blocks" {
+		return nil, ErrNotAvailable.Here().WithMessage("file contains only block records")
+	}
+	height, err := s.heightOf(headerHash)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return s.FullBlockByHeight(height)
+}
+
+func (s *RawFileBlockSource) BlockRecordByHash(headerHash [32]byte) (*types.BlockRecord, error) {
+	height, err := s.heightOf(headerHash)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return s.BlockRecordByHeight(height)
+}
+
+// ForEachRawBlock calls handler for (uncompressed) blocks data in height order.
+// Data slice may be reused between handler calls.
+func (s *RawFileBlockSource) ForEachRawBlock(startHeight, endHeight uint32, handler func(height uint32, data []byte) error) error {
+	if int64(startHeight) >= int64(len(s.offsets)) || startHeight > endHeight {
+		return nil
+	}
+	if int64(endHeight) >= int64(len(s.offsets)) {
+		endHeight = uint32(len(s.offsets) - 1)
+	}
+	start := s.offsets[startHeight]
+	r := bufio.NewReaderSize(io.NewSectionReader(s.file, start, s.dataEnd-start), 1024*1024)
+	sizeBuf := make([]byte, 4)
+	var buf []byte
+	for height := startHeight; ; height++ {
+		if _, err := io.ReadFull(r, sizeBuf); err != nil {
+			return merry.Wrap(err)
+		}
+		size := int(binary.BigEndian.Uint32(sizeBuf))
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return merry.Wrap(err)
+		}
+		data, err := s.uncompress(buf)
+		if err != nil {
+			return merry.Prependf(err, "block %d", height)
+		}
+		if err := handler(height, data); err != nil {
+			return merry.Wrap(err)
+		}
+		if height == endHeight {
+			return nil
+		}
+	}
+}
+
+func (s *RawFileBlockSource) ForEachFullBlock(startHeight, endHeight uint32, handler func(*types.FullBlock) error) error {
+	if s.tableName != "full_blocks" {
+		return ErrNotAvailable.Here().WithMessage("file contains only block records")
+	}
+	return s.ForEachRawBlock(startHeight, endHeight, func(height uint32, data []byte) error {
+		block, err := decodeRawFullBlock(height, data)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		return handler(block)
+	})
+}
+
+func (s *RawFileBlockSource) ForEachBlockRecord(startHeight, endHeight uint32, handler func(*types.BlockRecord) error) error {
+	return s.ForEachRawBlock(startHeight, endHeight, func(height uint32, data []byte) error {
+		if s.tableName == "full_blocks" {
+			block, err := decodeRawFullBlock(height, data)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+			return handler(BlockRecordFromFullBlock(block))
+		}
+		br, err := decodeRawBlockRecord(height, data)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		return handler(br)
+	})
+}
@@ -0,0 +1,401 @@
+package chia
+
+import (
+	"bytes"
+	"chiastat/chia/network"
+	"chiastat/chia/network/nettest"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/klauspost/compress/zstd"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testSourceBlocks(count int, salt byte) ([]types.FullBlock, []types.BlockRecord) {
+	var blocks []types.FullBlock
+	var records []types.BlockRecord
+	for i := 0; i < count; i++ {
+		var block types.FullBlock
+		block.RewardChainBlock.Height = uint32(i)
+		block.RewardChainBlock.Weight = big.NewInt(int64(i+1) * 100)
+		block.RewardChainBlock.TotalIters = big.NewInt(int64(i) * 1000)
+		block.Foliage.RewardBlockHash[0] = salt
+		block.Foliage.FoliageBlockDataSignature.Bytes = make([]byte, 96)
+		block.RewardChainBlock.ChallengeChainSpSignature.Bytes = make([]byte, 96)
+		block.RewardChainBlock.RewardChainSpSignature.Bytes = make([]byte, 96)
+		block.RewardChainBlock.ProofOfSpace.PlotPublicKey.Bytes = make([]byte, 48)
+		if i > 0 {
+			block.Foliage.PrevBlockHash = blocks[i-1].HeaderHash()
+		}
+		blocks = append(blocks, block)
+		records = append(records, types.BlockRecord{
+			HeaderHash: block.HeaderHash(),
+			PrevHash:   block.Foliage.PrevBlockHash,
+			Height:     block.RewardChainBlock.Height,
+			Weight:     block.RewardChainBlock.Weight,
+			TotalIters: block.RewardChainBlock.TotalIters,
+			Timestamp:  uint64(1600000000 + i*18),
+		})
+	}
+	return blocks, records
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeTestDBv1(t *testing.T, blocks []types.FullBlock, records []types.BlockRecord, orphans ...*types.FullBlock) string {
+	path := filepath.Join(t.TempDir(), "blockchain_v1_mainnet.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mustExec(t, db, "CREATE TABLE full_blocks(header_hash text PRIMARY KEY, height bigint, is_block tinyint, is_fully_compactified tinyint, block blob)")
+	mustExec(t, db, "CREATE TABLE block_records(header_hash text PRIMARY KEY, prev_hash text, height bigint, block blob, sub_epoch_summary blob, is_peak tinyint, is_block tinyint)")
+	insert := func(block *types.FullBlock, record *types.BlockRecord, isPeak bool) {
+		hash := block.HeaderHash()
+		height := block.RewardChainBlock.Height
+		mustExec(t, db, "INSERT INTO full_blocks (header_hash, height, block) VALUES (?, ?, ?)",
+			hex.EncodeToString(hash[:]), height, utils.ToByteSlice(block))
+		mustExec(t, db, "INSERT INTO block_records (header_hash, prev_hash, height, block, is_peak) VALUES (?, ?, ?, ?, ?)",
+			hex.EncodeToString(hash[:]), hex.EncodeToString(block.Foliage.PrevBlockHash[:]), height, utils.ToByteSlice(record), isPeak)
+	}
+	for i := range blocks {
+		insert(&blocks[i], &records[i], i == len(blocks)-1)
+	}
+	for _, orphan := range orphans {
+		insert(orphan, &types.BlockRecord{Height: orphan.RewardChainBlock.Height, Weight: big.NewInt(1), TotalIters: big.NewInt(1)}, false)
+	}
+	return path
+}
+
+func makeTestDBv2(t *testing.T, blocks []types.FullBlock, records []types.BlockRecord, orphan *types.FullBlock) string {
+	path := filepath.Join(t.TempDir(), "blockchain_v2_mainnet.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	mustExec(t, db, "CREATE TABLE database_version(version int)")
+	mustExec(t, db, "INSERT INTO database_version VALUES(2)")
+	mustExec(t, db, "CREATE TABLE full_blocks(header_hash blob PRIMARY KEY, prev_hash blob, height bigint, sub_epoch_summary blob, is_fully_compactified tinyint, in_main_chain tinyint, block blob, block_record blob)")
+	insert := func(block *types.FullBlock, record *types.BlockRecord, inMainChain bool) {
+		hash := block.HeaderHash()
+		mustExec(t, db, "INSERT INTO full_blocks (header_hash, height, in_main_chain, block, block_record) VALUES (?, ?, ?, ?, ?)",
+			hash[:], block.RewardChainBlock.Height, inMainChain,
+			enc.EncodeAll(utils.ToByteSlice(block), nil), utils.ToByteSlice(record))
+	}
+	for i := range blocks {
+		insert(&blocks[i], &records[i], true)
+	}
+	if orphan != nil {
+		insert(orphan, &types.BlockRecord{Height: orphan.RewardChainBlock.Height, Weight: big.NewInt(1), TotalIters: big.NewInt(1)}, false)
+	}
+	return path
+}
+
+func TestSqliteBlockSource(t *testing.T) {
+	blocks, records := testSourceBlocks(25, 1)
+	// v1 fork block at height 25 is above the main chain peak
+	orphans, _ := testSourceBlocks(26, 2)
+
+	for _, check := range []struct {
+		version int
+		path    string
+	}{
+		{1, makeTestDBv1(t, blocks, records, &orphans[7], &orphans[8], &orphans[24], &orphans[25])},
+		{2, makeTestDBv2(t, blocks, records, &orphans[7])},
+	} {
+		src, err := OpenSqliteBlockSource(check.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+
+		if src.Version() != check.version {
+			t.Fatalf("v%d: version: got %d", check.version, src.Version())
+		}
+
+		peak, err := src.PeakHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peak != 24 {
+			t.Errorf("v%d: peak height: expected 24, got %d", check.version, peak)
+		}
+
+		block, err := src.FullBlockByHeight(7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.HeaderHash() != blocks[7].HeaderHash() {
+			t.Errorf("v%d: block 7: wrong block", check.version)
+		}
+		block, err = src.FullBlockByHeight(24)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.HeaderHash() != blocks[24].HeaderHash() {
+			t.Errorf("v%d: block 24: wrong block", check.version)
+		}
+		block, err = src.FullBlockByHash(blocks[9].HeaderHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.RewardChainBlock.Height != 9 {
+			t.Errorf("v%d: block by hash: expected 9, got %d", check.version, block.RewardChainBlock.Height)
+		}
+		if _, err := src.FullBlockByHash(orphans[7].HeaderHash()); !merry.Is(err, ErrBlockNotFound) {
+			t.Errorf("v%d: orphan block by hash: expected ErrBlockNotFound, got %v", check.version, err)
+		}
+
+		br, err := src.BlockRecordByHeight(7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if br.HeaderHash != records[7].HeaderHash || br.Timestamp != records[7].Timestamp {
+			t.Errorf("v%d: block record 7: wrong record", check.version)
+		}
+
+		var heights []uint32
+		err = src.ForEachRawBlock("full_blocks", 10, 100, 4, func(height uint32, data []byte) error {
+			var block types.FullBlock
+			if err := utils.FromByteSliceExact(data, &block); err != nil {
+				return err
+			}
+			if block.HeaderHash() != blocks[height].HeaderHash() {
+				t.Errorf("v%d: block %d: wrong block", check.version, height)
+			}
+			heights = append(heights, height)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(heights) != 15 || heights[0] != 10 || heights[14] != 24 {
+			t.Errorf("v%d: unexpected ForEachRawBlock heights: %v", check.version, heights)
+		}
+
+		count := 0
+		err = src.ForEachBlockRecord(0, math.MaxUint32, func(br *types.BlockRecord) error {
+			if br.Height != uint32(count) {
+				t.Errorf("v%d: expected record %d, got %d", check.version, count, br.Height)
+			}
+			count += 1
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 25 {
+			t.Errorf("v%d: expected 25 records, got %d", check.version, count)
+		}
+	}
+}
+
+func TestSqliteBlockSourceWithoutBlockRecords(t *testing.T) {
+	blocks, records := testSourceBlocks(5, 1)
+	path := makeTestDBv1(t, blocks, records)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, "DROP TABLE block_records")
+	db.Close()
+
+	src, err := OpenSqliteBlockSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	br, err := src.BlockRecordByHash(records[3].HeaderHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Height != 3 || br.Weight.Cmp(records[3].Weight) != 0 || br.PrevHash != records[3].PrevHash {
+		t.Errorf("unexpected block record: %#v", br)
+	}
+}
+
+func TestRawFileBlockSource(t *testing.T) {
+	blocks, records := testSourceBlocks(25, 1)
+	orphans, _ := testSourceBlocks(26, 2)
+	v1Src, err := OpenSqliteBlockSource(makeTestDBv1(t, blocks, records, &orphans[3], &orphans[24], &orphans[25]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v1Src.Close()
+	v2Src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, &orphans[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v2Src.Close()
+
+	for _, check := range []struct {
+		dbSrc     *SqliteBlockSource
+		tableName string
+		cfg       RawFileConfig
+	}{
+		{v2Src, "full_blocks", RawFileConfig{}},
+		{v2Src, "full_blocks", RawFileConfig{Index: true}},
+		{v2Src, "full_blocks", RawFileConfig{Zstd: true}},
+		{v2Src, "full_blocks", RawFileConfig{Zstd: true, Index: true}},
+		{v2Src, "block_records", RawFileConfig{}},
+		{v2Src, "block_records", RawFileConfig{Zstd: true, Index: true}},
+		{v1Src, "full_blocks", RawFileConfig{}},
+		{v1Src, "full_blocks", RawFileConfig{Zstd: true, Index: true}},
+		{v1Src, "block_records", RawFileConfig{Index: true}},
+	} {
+		dbSrc := check.dbSrc
+		tableName := check.tableName
+		name := fmt.Sprintf("v%d %s %+v", dbSrc.Version(), tableName, check.cfg)
+		var buf bytes.Buffer
+		if err := ExportBlocksData(dbSrc, tableName, &buf, check.cfg); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), tableName+".raw")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		src, err := OpenRawFileBlockSource(path, tableName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		if src.IsIndexed() != check.cfg.Index || src.IsCompressed() != check.cfg.Zstd {
+			t.Errorf("%s: unexpected layout: indexed=%t compressed=%t", name, src.IsIndexed(), src.IsCompressed())
+		}
+
+		peak, err := src.PeakHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peak != 24 {
+			t.Errorf("%s: peak height: expected 24, got %d", name, peak)
+		}
+		br, err := src.BlockRecordByHash(records[12].HeaderHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if br.Height != 12 {
+			t.Errorf("%s: record by hash: expected 12, got %d", name, br.Height)
+		}
+
+		var heights []uint32
+		err = src.ForEachBlockRecord(20, math.MaxUint32, func(br *types.BlockRecord) error {
+			if br.HeaderHash != records[br.Height].HeaderHash {
+				t.Errorf("%s: record %d: wrong record", name, br.Height)
+			}
+			heights = append(heights, br.Height)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(heights) != 5 || heights[0] != 20 || heights[4] != 24 {
+			t.Errorf("%s: unexpected ForEachBlockRecord heights: %v", name, heights)
+		}
+
+		block, err := src.FullBlockByHeight(24)
+		if tableName == "full_blocks" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block.HeaderHash() != blocks[24].HeaderHash() {
+				t.Errorf("%s: block 24: wrong block", name)
+			}
+		} else if !merry.Is(err, ErrNotAvailable) {
+			t.Errorf("%s: expected ErrNotAvailable, got %v", name, err)
+		}
+	}
+}
+
+func TestPeerBlockSource(t *testing.T) {
+	blocks, _ := testSourceBlocks(70, 1)
+
+	ca, err := nettest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := nettest.StartWithCA(ca, nettest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	node.HandleFunc(types.MSG_REQUEST_BLOCKS, func(c *network.WSChiaConnection, msg utils.FromBytes) nettest.Response {
+		req := msg.(*types.RequestBlocks)
+		return nettest.Response{Message: types.RespondBlocks{
+			StartHeight: req.StartHeight, EndHeight: req.EndHeight, Blocks: blocks[req.StartHeight : req.EndHeight+1]}}
+	})
+	node.HandleFunc(types.MSG_REQUEST_BLOCK, func(c *network.WSChiaConnection, msg utils.FromBytes) nettest.Response {
+		req := msg.(*types.RequestBlock)
+		return nettest.Response{Message: types.RespondBlock{Block: blocks[req.Height]}}
+	})
+
+	tlsCert, err := ca.IssueTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := network.NewPeerManager(network.PeerManagerConfig{
+		TLSConfig:      network.MakeTSLConfig(ca.CertPool(), tlsCert),
+		RequestTimeout: time.Second,
+	})
+	peers.AddAddress(node.Addr())
+	peers.Start()
+	src := NewPeerBlockSource(peers)
+	defer src.Close()
+	if err := peers.WaitForPeers(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	peak := &blocks[len(blocks)-1]
+	node.Broadcast(types.NewPeak{HeaderHash: peak.HeaderHash(), Height: peak.RewardChainBlock.Height, Weight: peak.RewardChainBlock.Weight})
+	if err := src.WaitForPeak(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := src.FullBlockByHash(blocks[50].HeaderHash()); !merry.Is(err, ErrBlockNotFound) {
+		t.Errorf("not yet received block by hash: expected ErrBlockNotFound, got %v", err)
+	}
+
+	count := 0
+	err = src.ForEachBlockRecord(5, math.MaxUint32, func(br *types.BlockRecord) error {
+		if br.HeaderHash != blocks[br.Height].HeaderHash() || br.Height != uint32(5+count) {
+			t.Errorf("unexpected record %d", br.Height)
+		}
+		count += 1
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 65 {
+		t.Errorf("expected 65 records, got %d", count)
+	}
+
+	block, err := src.FullBlockByHash(blocks[50].HeaderHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.RewardChainBlock.Height != 50 {
+		t.Errorf("block by hash: expected 50, got %d", block.RewardChainBlock.Height)
+	}
+}
@@ -6,14 +6,9 @@ import (
 	"chiastat/chia/utils"
 	"database/sql"
 	_ "embed"
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"io"
-	"log"
 	"math/big"
-	"strings"
-	"time"
 
 	"github.com/ansel1/merry"
 )
@@ -57,37 +52,30 @@ func FullBlockByHeight(db *sql.DB, height uint32) (*types.FullBlock, error) {
 	return FullBlockFromRow(row)
 }
 
-func estimateNetworkSpaceInner(weight0, weight1 *big.Int, totalIters0, totalIters1 *big.Int) *big.Int {
-	// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/rpc/full_node_rpc_api.py#L276
-	deltaWeight := (&big.Int{}).Sub(weight1, weight0)
-	deltaIters := (&big.Int{}).Sub(totalIters1, totalIters0)
-	additionalDifficultyConstant := (&big.Int{}).Exp(big.NewInt(2), big.NewInt(67), nil)
-	eligiblePlotsFilterMultiplier := (&big.Int{}).Exp(big.NewInt(2), big.NewInt(9), nil)
-	UI_ACTUAL_SPACE_CONSTANT_FACTOR := 0.762
-
-	spaceEstimate := &big.Int{}
-	spaceEstimate.Mul(additionalDifficultyConstant, eligiblePlotsFilterMultiplier)
-	spaceEstimate.Mul(spaceEstimate, deltaWeight)
-	spaceEstimate.Div(spaceEstimate, deltaIters)
-	spaceEstimate.Mul(spaceEstimate, big.NewInt(int64(UI_ACTUAL_SPACE_CONSTANT_FACTOR*1000000)))
-	spaceEstimate.Div(spaceEstimate, big.NewInt(1000000))
-	return spaceEstimate
+func estimateNetworkSpaceInner(weight0, weight1 *big.Int, totalIters0, totalIters1 *big.Int, height uint32) *big.Int {
+	return DefaultSpaceEstimateParams.Estimate(weight0, weight1, totalIters0, totalIters1, height)
 }
 func EstimateNetworkSpace(br0, br1 *types.BlockRecord) *big.Int {
-	return estimateNetworkSpaceInner(br0.Weight, br1.Weight, br0.TotalIters, br1.TotalIters)
+	return estimateNetworkSpaceInner(br0.Weight, br1.Weight, br0.TotalIters, br1.TotalIters, br1.Height)
 }
 func EstimateNetworkSpaceFull(fb0, fb1 *types.FullBlock) *big.Int {
 	rcb0 := &fb0.RewardChainBlock
 	rcb1 := &fb1.RewardChainBlock
-	return estimateNetworkSpaceInner(rcb0.Weight, rcb1.Weight, rcb0.TotalIters, rcb1.TotalIters)
+	return estimateNetworkSpaceInner(rcb0.Weight, rcb1.Weight, rcb0.TotalIters, rcb1.TotalIters, rcb1.Height)
+}
+func EstimateNetworkSpaceHeader(hb0, hb1 *types.HeaderBlock) *big.Int {
+	rcb0 := &hb0.RewardChainBlock
+	rcb1 := &hb1.RewardChainBlock
+	return estimateNetworkSpaceInner(rcb0.Weight, rcb1.Weight, rcb0.TotalIters, rcb1.TotalIters, rcb1.Height)
 }
 
-func EstimateNetworkSpaceFromDB(db *sql.DB, lastHeight, pastOffset int64) (*big.Int, error) {
+func EstimateNetworkSpaceFromDB(src BlockSource, lastHeight, pastOffset int64) (*big.Int, error) {
 	if lastHeight < 0 {
-		err := db.QueryRow("SELECT max(height) FROM block_records").Scan(&lastHeight)
+		peakHeight, err := src.PeakHeight()
 		if err != nil {
 			return nil, merry.Wrap(err)
 		}
+		lastHeight = int64(peakHeight)
 	}
 
 	firstHeight := lastHeight - pastOffset
@@ -95,11 +83,11 @@ func EstimateNetworkSpaceFromDB(db *sql.DB, lastHeight, pastOffset int64) (*big.
 		firstHeight = 0
 	}
 
-	br0, err := BlockRecordByHeight(db, firstHeight)
+	br0, err := src.BlockRecordByHeight(uint32(firstHeight))
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
-	br1, err := BlockRecordByHeight(db, lastHeight)
+	br1, err := src.BlockRecordByHeight(uint32(lastHeight))
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
@@ -155,133 +143,31 @@ type StampedRecord struct {
 	Stamp int64
 }
 
-func PrintNetworkSpaceChartFromDB(db *sql.DB) error {
-	rows, err := db.Query("SELECT block FROM block_records ORDER BY height")
-	if err != nil {
-		return merry.Wrap(err)
-	}
-	defer rows.Close()
-
-	blocks := NewRingBuf(4608 + 1)
-	prevDay := int64(0)
-	prevStampMS := int64(0)
-	prevDayBlocksCount := 0
-	prevDaySizePib := int64(0)
-	for rows.Next() {
-		br, err := BlockRecordFromRow(rows)
-		if err != nil {
-			return merry.Wrap(err)
-		}
-
-		// fmt.Println(len(blocks.items), cap(blocks.items), blocks.pos, blocks.Last().Height-blocks.First().Height)
-		// if br.Height > 0 {
-		// 	spaceEstimate := EstimateNetworkSpace(blocks.First(), blocks.Last())
-		// 	pib := (&big.Int{}).Div(spaceEstimate, big.NewInt(1024*1024*1024*1024*1024))
-		// 	fmt.Println(br.Height, pib)
-		// }
-
-		// if br.Height > 1 {
-		// 	dayStartBlock := blocks.First().(StampedRecord)
-		// 	dayEndBlock := blocks.Last().(StampedRecord)
-		// 	spaceEstimate := EstimateNetworkSpace(dayStartBlock.Block, dayEndBlock.Block)
-		// 	pib := (&big.Int{}).Div(spaceEstimate, big.NewInt(1024*1024*1024*1024*1024)).Int64()
-		// 	daySizePibSum += pib
-		// 	daySizePibCount += 1
-		// }
-
-		// if bytes.Equal(br.FarmerPuzzleHash[:], ph[:]) {
-		// 	fmt.Printf("%#v\n", br.FarmerPuzzleHash)
-		// 	fmt.Printf("%#v\n", br.RewardClaimsIncorporated)
-		// 	for _, val := range br.RewardClaimsIncorporated {
-		// 		sum += val.Amount
-		// 	}
-		// 	fmt.Println(sum)
-		// }
-		// if br.Height > 329355-10000 {
-		// 	for _, coin := range br.RewardClaimsIncorporated {
-		// 		// if bytes.Equal(coin.PuzzleHash[:], ph[:]) {
-		// 		sum += coin.Amount
-		// 		fmt.Println(sum/1000/1000/1000/1000, EncodePuzzleHash(coin.PuzzleHash, "xch"))
-		// 		// if coin.Amount%1000 != 0 {
-		// 		// 	fmt.Println(coin.Amount, coin.ParentCoinInfo)
-		// 		// }
-		// 		// }
-		// 	}
-		// 	// if bytes.Equal(br.FarmerPuzzleHash[:], ph[:]) {
-		// 	// 	sum += 1
-		// 	// 	fmt.Println(sum, sum/1000/1000/1000/1000)
-		// 	// }
-		// }
-
-		stampMS := prevStampMS + 312
-		if br.Timestamp != 0 {
-			// fmt.Println(stampMS/1000-int64(br.Timestamp), stampMS, br.Timestamp)
-			stampMS = int64(br.Timestamp) * 1000
-		}
-		brs := StampedRecord{br, stampMS / 1000}
-
-		day := stampMS / (24 * 3600 * 1000)
-		if day != prevDay && prevDay != 0 {
-			dayStartBlock := blocks.First().(StampedRecord)
-			dayEndBlock := blocks.Last().(StampedRecord)
-			spaceEstimate := EstimateNetworkSpace(dayStartBlock.Block, dayEndBlock.Block)
-			pib := (&big.Int{}).Div(spaceEstimate, big.NewInt(1024*1024*1024*1024*1024)).Int64()
-			// fmt.Println(br.Height, br.Timestamp, time.Unix(int64(br.Timestamp), 0))
-			// fmt.Println(prevDayBlocksCount, time.Unix(dayStartBlock.Stamp, 0), time.Unix(dayEndBlock.Stamp, 0), time.Unix(stampMS/1000, 0))
-			bar := ""
-			if prevDaySizePib > 0 && prevDaySizePib <= pib {
-				bar = strings.Repeat("*", int((pib-prevDaySizePib)*300/prevDaySizePib))
-			}
-			fmt.Printf("%s\t%d\t%5.2f\t%5.1f%%\t%s\n",
-				time.Unix(dayEndBlock.Stamp, 0).In(time.UTC).Format("2006-01-02 15:04:05"),
-				prevDayBlocksCount,
-				float64(pib)/1024,
-				float64(pib-prevDaySizePib)*100/float64(prevDaySizePib),
-				bar)
-			prevDayBlocksCount = 0
-			prevDaySizePib = pib
-		}
-
-		blocks.Add(brs)
-		prevDay = day
-		prevStampMS = stampMS
-		prevDayBlocksCount += 1
-		// blockTime := time.Unix(int64(br.Timestamp), 0)
-		// day := br.Timestamp
-	}
-	err = rows.Err()
-	if err != nil {
-		return merry.Wrap(err)
-	}
-
-	return nil
-}
-
 // https://raw.githubusercontent.com/Chia-Network/chia-blockchain/latest/chia/wallet/puzzles/rom_bootstrap_generator.clvm
 // https://raw.githubusercontent.com/Chia-Network/chia-blockchain/latest/chia/wallet/puzzles/rom_bootstrap_generator.clvm.hex
 //go:embed rom_bootstrap_generator.clvm.hex
 var ROM_BOOTSTRAP_GENERATOR_HEX string
 var ROM_BOOTSTRAP_GENERATOR = clvm.MustSExpFromHex(ROM_BOOTSTRAP_GENERATOR_HEX)
 
-func EvalFullBlockFromDB(db *sql.DB, height uint32) error {
-	// 225698 first with transaction generator
-	// 271489
-	block, err := FullBlockByHeight(db, height)
-	if err != nil {
-		return merry.Wrap(err)
-	}
+// CoinSpendConditions is a coin spent by block generator with conditions its puzzle returned.
+type CoinSpendConditions struct {
+	Coin       types.Coin
+	Conditions []clvm.SExp
+}
 
+// RunBlockGenerator runs block transactions generator (with generators of referenced blocks
+// as arguments) and returns spent coins. Returns nil if the block has no generator.
+func RunBlockGenerator(src BlockSource, block *types.FullBlock) ([]CoinSpendConditions, error) {
 	if block.TransactionsGenerator == nil {
-		fmt.Printf("block %d has no transactions generator\n", height)
-		return nil
+		return nil, nil
 	}
-	fmt.Println("ref list:", block.TransactionsGeneratorRefList)
 
 	refBlocks := make([]*types.FullBlock, len(block.TransactionsGeneratorRefList))
 	for i, refHeight := range block.TransactionsGeneratorRefList {
-		refBlocks[i], err = FullBlockByHeight(db, refHeight)
+		var err error
+		refBlocks[i], err = src.FullBlockByHeight(refHeight)
 		if err != nil {
-			return merry.Wrap(err)
+			return nil, merry.Wrap(err)
 		}
 	}
 
@@ -295,93 +181,86 @@ func EvalFullBlockFromDB(db *sql.DB, height uint32) error {
 	args = clvm.Pair{block.TransactionsGenerator.Root, clvm.Pair{args, clvm.NULL}}
 	_, result, err := clvm.RunProgram(ROM_BOOTSTRAP_GENERATOR, args)
 	if err != nil {
-		return merry.Wrap(err)
+		return nil, merry.Wrap(err)
 	}
 
-	fmt.Println("spent coins:")
+	var spends []CoinSpendConditions
 	coinIter := clvm.NewIter(result.(clvm.Pair).First)
 	for coinIter.Next() {
-		cur := coinIter.Get()
-		spent_coin_parent_id, _ := cur.(clvm.Pair).First.(clvm.Atom).AsBytes32()
-		spent_coin_puzzle_hash, _ := cur.(clvm.Pair).Rest.(clvm.Pair).First.(clvm.Atom).AsBytes32()
-		spent_coin_amount, _ := cur.(clvm.Pair).Rest.(clvm.Pair).Rest.(clvm.Pair).First.(clvm.Atom).AsInt64()
-		// spent_coin: Coin = Coin(spent_coin_parent_id, spent_coin_puzzle_hash, spent_coin_amount)
-		fmt.Println(" == coin", hex.EncodeToString(spent_coin_parent_id[:]), EncodePuzzleHash(spent_coin_puzzle_hash, "xch"), spent_coin_amount)
-
-		iter := clvm.NewIter(cur.(clvm.Pair).Rest.(clvm.Pair).Rest.(clvm.Pair).Rest.(clvm.Pair).First)
+		// (parent_id puzzle_hash amount (conditions...) ...)
+		items, ok := listItems(coinIter.Get(), 4)
+		if !ok {
+			return nil, merry.Errorf("unexpected generator output item: %s", coinIter.Get())
+		}
+		parent, parentOk := items[0].(clvm.Atom)
+		puzzleHash, puzzleHashOk := items[1].(clvm.Atom)
+		amount, amountOk := items[2].(clvm.Atom)
+		if !parentOk || !puzzleHashOk || !amountOk || len(parent.Bytes) != 32 || len(puzzleHash.Bytes) != 32 ||
+			amount.AsInt().Sign() < 0 || !amount.AsInt().IsUint64() {
+			return nil, merry.Errorf("unexpected generator output coin: %s", coinIter.Get())
+		}
+		var spend CoinSpendConditions
+		copy(spend.Coin.ParentCoinInfo[:], parent.Bytes)
+		copy(spend.Coin.PuzzleHash[:], puzzleHash.Bytes)
+		spend.Coin.Amount = amount.AsInt().Uint64()
+
+		iter := clvm.NewIter(items[3])
 		for iter.Next() {
-			cond := iter.Get()
-			// opcode := cond.(clvm.Pair).First
-			// condList, err := clvm.AtomSliceFromList(cond.(clvm.Pair).Rest)
-			// if err != nil {
-			// 	return err
-			// }
-			// fmt.Println(cond, "op", opcode, condList)
-			fmt.Println("cond:", cond)
+			spend.Conditions = append(spend.Conditions, iter.Get())
 		}
 		if err := iter.Err(); err != nil {
-			return err
+			return nil, merry.Wrap(err)
 		}
+		spends = append(spends, spend)
 	}
-
-	fmt.Println("done")
-	return nil
+	if err := coinIter.Err(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return spends, nil
 }
 
-func ExportBlocksData(db *sql.DB, tableName string, out io.Writer) error {
-	chunkSize := 10000
-	lastHeight := -1
-	countTotal := 0
-	sizeTotal := 0
-	sizeMax := 0
-	blockSizeBuf := []byte{0, 0, 0, 0}
-
-	for {
-		rows, err := db.Query("SELECT height, block FROM "+tableName+" WHERE height > ? ORDER BY height LIMIT ?",
-			lastHeight, chunkSize)
-		if err != nil {
-			return merry.Wrap(err)
-		}
-		defer rows.Close()
-
-		count := 0
-		for rows.Next() {
-			var height uint64
-			var blockBytes []byte
-			err := rows.Scan(&height, &blockBytes)
-			if err != nil {
-				return merry.Wrap(err)
-			}
-
-			binary.BigEndian.PutUint32(blockSizeBuf, uint32(len(blockBytes)))
-			if _, err := out.Write(blockSizeBuf); err != nil {
-				return merry.Wrap(err)
-			}
-			if _, err := out.Write(blockBytes); err != nil {
-				return merry.Wrap(err)
-			}
-
-			lastHeight = int(height)
-			count += 1
-			countTotal += 1
-			sizeTotal += len(blockBytes)
-			if len(blockBytes) > sizeMax {
-				sizeMax = len(blockBytes)
-			}
-		}
-		if err := rows.Err(); err != nil {
-			return merry.Wrap(err)
+// listItems returns first count items of the list, false if it is shorter.
+func listItems(list clvm.SExp, count int) ([]clvm.SExp, bool) {
+	items := make([]clvm.SExp, count)
+	for i := range items {
+		pair, ok := list.(clvm.Pair)
+		if !ok {
+			return nil, false
 		}
+		items[i] = pair.First
+		list = pair.Rest
+	}
+	return items, true
+}
 
-		if count < chunkSize {
-			break
-		}
-		if countTotal%(chunkSize*2) == 0 {
-			log.Printf("%d blocks, %.1f MiB, %.2f KiB/block (max %.1f KiB)",
-				countTotal, float64(sizeTotal)/(1024*1024),
-				float64(sizeTotal)/float64(countTotal)/1024, float64(sizeMax)/1024)
+func EvalFullBlockFromDB(src BlockSource, height uint32) error {
+	// 225698 first with transaction generator
+	// 271489
+	block, err := src.FullBlockByHeight(height)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	if block.TransactionsGenerator == nil {
+		fmt.Printf("block %d has no transactions generator\n", height)
+		return nil
+	}
+	fmt.Println("ref list:", block.TransactionsGeneratorRefList)
+
+	spends, err := RunBlockGenerator(src, block)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	fmt.Println("spent coins:")
+	for _, spend := range spends {
+		coin := spend.Coin
+		fmt.Println(" == coin", hex.EncodeToString(coin.ParentCoinInfo[:]), EncodePuzzleHash(coin.PuzzleHash, "xch"), coin.Amount)
+		for _, cond := range spend.Conditions {
+			fmt.Println("cond:", cond)
 		}
 	}
 
+	fmt.Println("done")
 	return nil
 }
@@ -0,0 +1,44 @@
+package chia
+
+import (
+	"chiastat/chia/clvm"
+	"chiastat/chia/types"
+	"strings"
+	"testing"
+)
+
+func TestRunBlockGenerator(t *testing.T) {
+	parent := "0x" + strings.Repeat("11", 32)
+	puzzleHash := "0x" + strings.Repeat("22", 32)
+	puzzleIR := "(q . ((51 " + puzzleHash + " 100) (52 23)))"
+	puzzle, err := clvm.SExpFromIRString(puzzleIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// generator returns ((spends...)), spend is (parent puzzle amount solution)
+	gen, err := clvm.SExpFromIRString("(q . (((" + parent + " " + puzzleIR + " 123 ()))))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &types.FullBlock{TransactionsGenerator: &types.SerializedProgram{Root: gen, Bytes: gen.Dump()}}
+
+	spends, err := RunBlockGenerator(nil, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spends) != 1 {
+		t.Fatalf("expected 1 spend, got %d", len(spends))
+	}
+	coin := spends[0].Coin
+	if coin.ParentCoinInfo[0] != 0x11 || coin.PuzzleHash != clvm.TreeHash(puzzle) || coin.Amount != 123 {
+		t.Errorf("unexpected coin: %+v", coin)
+	}
+	if len(spends[0].Conditions) != 2 || spends[0].Conditions[1].String() != "(52 23)" {
+		t.Errorf("unexpected conditions: %v", spends[0].Conditions)
+	}
+
+	spends, err = RunBlockGenerator(nil, &types.FullBlock{})
+	if spends != nil || err != nil {
+		t.Errorf("expected nothing for block without generator, got %v, %v", spends, err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package blocksync downloads blocks from the network into local sqlite store.
+package blocksync
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"database/sql"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/ansel1/merry"
+)
+
+var ErrNotLinked = merry.New("block does not extend the chain")
+
+// Store keeps main chain blocks in full_blocks table with the same layout
+// as in upstream blockchain_v1_mainnet.sqlite, so chia.FullBlockByHeight and friends work with it.
+// Blocks removed by reorgs are moved to orphan_blocks.
+// Block records are not stored (they need consensus state syncer doesn't track),
+// so record-only fields like sub-slot iterations are unknown for this store.
+type Store struct {
+	db *sql.DB
+}
+
+// StoredPeak describes the last block in store.
+type StoredPeak struct {
+	Height     uint32
+	HeaderHash [32]byte
+	Weight     *big.Int
+}
+
+func OpenStore(dbPath string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	db.SetMaxOpenConns(1)
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS full_blocks(
+			header_hash text PRIMARY KEY, height bigint, is_block tinyint, is_fully_compactified tinyint, block blob)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS full_block_height ON full_blocks(height)`,
+		`CREATE TABLE IF NOT EXISTS orphan_blocks(
+			header_hash text PRIMARY KEY, height bigint, orphaned_at timestamp DEFAULT CURRENT_TIMESTAMP, block blob)`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			return nil, merry.Wrap(err)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+func (s *Store) Close() error {
+	return merry.Wrap(s.db.Close())
+}
+
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Peak returns the last stored block info or nil if store is empty.
+func (s *Store) Peak() (*StoredPeak, error) {
+	return peakFrom(s.db)
+}
+
+func peakFrom(db rowQuerier) (*StoredPeak, error) {
+	row := db.QueryRow("SELECT block FROM full_blocks ORDER BY height DESC LIMIT 1")
+	block, err := chia.FullBlockFromRow(row)
+	if merry.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return &StoredPeak{
+		Height:     block.RewardChainBlock.Height,
+		HeaderHash: block.HeaderHash(),
+		Weight:     block.RewardChainBlock.Weight,
+	}, nil
+}
+
+func (s *Store) BlockByHeight(height uint32) (*types.FullBlock, error) {
+	return chia.FullBlockByHeight(s.db, height)
+}
+
+func hexHash(hash [32]byte) string {
+	return hex.EncodeToString(hash[:])
+}
+
+// CheckLinked checks that blocks are consecutive and the first one follows peak (any block at height 0 if peak is nil).
+func CheckLinked(peak *StoredPeak, blocks []types.FullBlock) error {
+	for i := range blocks {
+		rcb := &blocks[i].RewardChainBlock
+		if peak == nil {
+			if rcb.Height != 0 {
+				return ErrNotLinked.Here().WithMessagef("expected genesis block, got height %d", rcb.Height)
+			}
+		} else {
+			if rcb.Height != peak.Height+1 {
+				return ErrNotLinked.Here().WithMessagef("expected height %d, got %d", peak.Height+1, rcb.Height)
+			}
+			if blocks[i].Foliage.PrevBlockHash != peak.HeaderHash {
+				return ErrNotLinked.Here().WithMessagef("block %d prev hash mismatch", rcb.Height)
+			}
+			if rcb.Weight == nil || rcb.Weight.Cmp(peak.Weight) <= 0 {
+				return ErrNotLinked.Here().WithMessagef("block %d weight is not increasing", rcb.Height)
+			}
+		}
+		peak = &StoredPeak{Height: rcb.Height, HeaderHash: blocks[i].HeaderHash(), Weight: rcb.Weight}
+	}
+	return nil
+}
+
+// AddBlocks appends blocks to the chain. Blocks must extend the current peak (see CheckLinked).
+func (s *Store) AddBlocks(blocks []types.FullBlock) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	peak, err := peakFrom(tx)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if err := CheckLinked(peak, blocks); err != nil {
+		return merry.Wrap(err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO full_blocks (header_hash, height, is_block, is_fully_compactified, block)
+		VALUES (?, ?, ?, 0, ?)`)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer stmt.Close()
+	for i := range blocks {
+		block := &blocks[i]
+		hash := hexHash(block.HeaderHash())
+		isBlock := block.FoliageTransactionBlock != nil
+		_, err := stmt.Exec(hash, block.RewardChainBlock.Height, isBlock, utils.ToByteSlice(block))
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		// block may return to the main chain after a reorg
+		if _, err := tx.Exec("DELETE FROM orphan_blocks WHERE header_hash = ?", hash); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return merry.Wrap(tx.Commit())
+}
+
+// Rollback moves all blocks above the given height to orphan_blocks, returns number of moved blocks.
+func (s *Store) Rollback(height uint32) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO orphan_blocks (header_hash, height, block)
+		SELECT header_hash, height, block FROM full_blocks WHERE height > ?`, height)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	res, err := tx.Exec("DELETE FROM full_blocks WHERE height > ?", height)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return count, merry.Wrap(tx.Commit())
+}
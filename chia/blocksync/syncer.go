@@ -0,0 +1,284 @@
+package blocksync
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// upstream full node sends at most 32 blocks per RespondBlocks (max_blocks_to_send)
+const DEFAULT_BATCH_SIZE = 32
+const DEFAULT_PARALLEL_BATCHES = 4
+const DEFAULT_MAX_REORG_DEPTH = 1000
+const DEFAULT_PEAK_CHECK_INTERVAL = 10 * time.Second
+
+var ErrStopped = merry.New("sync stopped")
+var ErrReorgTooDeep = merry.New("fork is too deep")
+
+type SyncerConfig struct {
+	BatchSize uint32
+	// How many RequestBlocks are sent simultaneously (to different peers if possible).
+	ParallelBatches int
+	// Syncer fails if fork point is deeper than this (relative to local peak).
+	MaxReorgDepth uint32
+	// Peak is re-checked with this interval even if there were no NewPeak messages.
+	PeakCheckInterval time.Duration
+}
+
+// Syncer downloads blocks from peers into Store, follows new peaks and handles reorgs.
+//
+// Blocks are NOT validated (no proofs or signatures are checked), syncer only checks
+// that blocks are linked by prev_hash and have increasing weight. Chain choice relies on peers' NewPeak weights.
+type Syncer struct {
+	store *Store
+	peers *network.PeerManager
+	cfg   SyncerConfig
+	stop  chan struct{}
+
+	stopOnce sync.Once
+
+	lastLogStamp  time.Time
+	lastLogHeight uint32
+}
+
+func NewSyncer(store *Store, peers *network.PeerManager, cfg SyncerConfig) *Syncer {
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DEFAULT_BATCH_SIZE
+	}
+	if cfg.ParallelBatches == 0 {
+		cfg.ParallelBatches = DEFAULT_PARALLEL_BATCHES
+	}
+	if cfg.MaxReorgDepth == 0 {
+		cfg.MaxReorgDepth = DEFAULT_MAX_REORG_DEPTH
+	}
+	if cfg.PeakCheckInterval == 0 {
+		cfg.PeakCheckInterval = DEFAULT_PEAK_CHECK_INTERVAL
+	}
+	return &Syncer{store: store, peers: peers, cfg: cfg, stop: make(chan struct{})}
+}
+
+// Stop interrupts Run and SyncTo.
+func (s *Syncer) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *Syncer) isStopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run follows the heaviest peers' peak until Stop is called.
+// Errors are logged and retried, only too deep reorg stops it.
+func (s *Syncer) Run() error {
+	wake := make(chan struct{}, 1)
+	unsubscribe := s.peers.SubscribeNewPeak(func(peer *network.Peer, peak *types.NewPeak) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	for {
+		if err := s.followPeak(); err != nil {
+			if merry.Is(err, ErrStopped) {
+				return nil
+			}
+			if merry.Is(err, ErrReorgTooDeep) {
+				return merry.Wrap(err)
+			}
+			log.Printf("SYNC: %s", err)
+		}
+		select {
+		case <-s.stop:
+			return nil
+		case <-wake:
+		case <-time.After(s.cfg.PeakCheckInterval):
+		}
+	}
+}
+
+func (s *Syncer) followPeak() error {
+	target, _ := s.peers.Peak()
+	if target == nil {
+		return nil
+	}
+	local, err := s.store.Peak()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	if local != nil && target.Height <= local.Height {
+		if target.Weight.Cmp(local.Weight) <= 0 {
+			return nil
+		}
+		// heavier peak at the same or lower height: our tip is probably on a fork
+		block, err := s.store.BlockByHeight(target.Height)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if block.HeaderHash() == target.HeaderHash {
+			return nil
+		}
+		if local.Height-target.Height >= s.cfg.MaxReorgDepth {
+			return ErrReorgTooDeep.Here().WithMessagef("peak %d is heavier than local %d", target.Height, local.Height)
+		}
+		if err := s.rollback(target.Height - 1); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return merry.Wrap(s.SyncTo(target.Height))
+}
+
+func (s *Syncer) rollback(height uint32) error {
+	count, err := s.store.Rollback(height)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	log.Printf("SYNC: reorg, %d block(s) above %d moved to orphans", count, height)
+	return nil
+}
+
+type blocksBatch struct {
+	start  uint32
+	end    uint32
+	blocks []types.FullBlock
+	peer   *network.Peer
+	err    error
+}
+
+func (s *Syncer) fetchBatch(batch *blocksBatch) {
+	req := types.RequestBlocks{StartHeight: batch.start, EndHeight: batch.end, IncludeTransactionBlock: true}
+	resp, peer, err := s.peers.Request(req, func(p *network.Peer) bool {
+		peak := p.Peak()
+		return peak == nil || peak.Height >= batch.end
+	})
+	if err != nil {
+		batch.err = merry.Wrap(err)
+		return
+	}
+	blocksResp, ok := resp.(*types.RespondBlocks)
+	if !ok {
+		batch.err = utils.WrongRespError(resp)
+		return
+	}
+	batch.peer = peer
+	batch.blocks = blocksResp.Blocks
+
+	if len(batch.blocks) != int(batch.end-batch.start+1) {
+		batch.err = merry.Errorf("expected %d blocks, got %d", batch.end-batch.start+1, len(batch.blocks))
+	} else if batch.blocks[0].RewardChainBlock.Height != batch.start {
+		batch.err = merry.Errorf("expected block %d, got %d", batch.start, batch.blocks[0].RewardChainBlock.Height)
+	} else {
+		first := &batch.blocks[0]
+		firstPeak := &StoredPeak{Height: batch.start, HeaderHash: first.HeaderHash(), Weight: first.RewardChainBlock.Weight}
+		batch.err = CheckLinked(firstPeak, batch.blocks[1:])
+	}
+	if batch.err != nil {
+		s.peers.ReportBadData(peer, batch.err)
+	}
+}
+
+// fetchBatches requests up to ParallelBatches consecutive batches in parallel.
+func (s *Syncer) fetchBatches(start, end uint32) []*blocksBatch {
+	var batches []*blocksBatch
+	for i := 0; i < s.cfg.ParallelBatches && start <= end; i++ {
+		batchEnd := start + s.cfg.BatchSize - 1
+		if batchEnd > end {
+			batchEnd = end
+		}
+		batches = append(batches, &blocksBatch{start: start, end: batchEnd})
+		start = batchEnd + 1
+	}
+
+	wg := sync.WaitGroup{}
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch *blocksBatch) {
+			defer wg.Done()
+			s.fetchBatch(batch)
+		}(batch)
+	}
+	wg.Wait()
+	return batches
+}
+
+// SyncTo downloads blocks until local peak reaches the given height.
+func (s *Syncer) SyncTo(height uint32) error {
+	reorgStep := uint32(1)
+	reorgDepth := uint32(0)
+	for {
+		if s.isStopped() {
+			return ErrStopped.Here()
+		}
+		peak, err := s.store.Peak()
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		start := uint32(0)
+		if peak != nil {
+			if peak.Height >= height {
+				return nil
+			}
+			start = peak.Height + 1
+		}
+
+		for _, batch := range s.fetchBatches(start, height) {
+			if batch.err != nil {
+				return merry.Wrap(batch.err)
+			}
+			err := CheckLinked(peak, batch.blocks[:1])
+			if merry.Is(err, ErrNotLinked) {
+				// first block does not follow our peak: either peer or we are on a fork
+				if peak == nil || peak.Height == 0 {
+					return merry.Wrap(err)
+				}
+				if reorgDepth+reorgStep > s.cfg.MaxReorgDepth {
+					return ErrReorgTooDeep.Here().WithMessagef("no common block in last %d", reorgDepth)
+				}
+				reorgDepth += reorgStep
+				rollbackTo := int64(peak.Height) - int64(reorgStep)
+				if rollbackTo < 0 {
+					rollbackTo = 0
+				}
+				if err := s.rollback(uint32(rollbackTo)); err != nil {
+					return merry.Wrap(err)
+				}
+				reorgStep *= 2
+				break
+			}
+			if err != nil {
+				return merry.Wrap(err)
+			}
+			if err := s.store.AddBlocks(batch.blocks); err != nil {
+				return merry.Wrap(err)
+			}
+			last := &batch.blocks[len(batch.blocks)-1]
+			peak = &StoredPeak{Height: batch.end, HeaderHash: last.HeaderHash(), Weight: last.RewardChainBlock.Weight}
+			s.logProgress(peak.Height, height)
+		}
+	}
+}
+
+func (s *Syncer) logProgress(height, target uint32) {
+	now := time.Now()
+	if now.Sub(s.lastLogStamp) < 10*time.Second && height < target {
+		return
+	}
+	speed := float64(0)
+	if !s.lastLogStamp.IsZero() && height > s.lastLogHeight {
+		speed = float64(height-s.lastLogHeight) / now.Sub(s.lastLogStamp).Seconds()
+	}
+	log.Printf("SYNC: height %d / %d, %.1f blocks/s, %d peers", height, target, speed, len(s.peers.Peers()))
+	s.lastLogStamp = now
+	s.lastLogHeight = height
+}
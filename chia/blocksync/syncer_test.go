@@ -0,0 +1,203 @@
+package blocksync
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/network/nettest"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// extendChain appends count fake blocks (only linked by prev hash, no proofs) to chain copy.
+func extendChain(chain []types.FullBlock, count int, weightStep int64, salt byte) []types.FullBlock {
+	chain = append([]types.FullBlock(nil), chain...)
+	for i := 0; i < count; i++ {
+		var block types.FullBlock
+		block.RewardChainBlock.Height = uint32(len(chain))
+		block.RewardChainBlock.Weight = big.NewInt(weightStep)
+		block.RewardChainBlock.TotalIters = big.NewInt(int64(len(chain)) * 1000)
+		block.Foliage.RewardBlockHash[0] = salt
+		block.Foliage.FoliageBlockDataSignature.Bytes = make([]byte, 96)
+		block.RewardChainBlock.ChallengeChainSpSignature.Bytes = make([]byte, 96)
+		block.RewardChainBlock.RewardChainSpSignature.Bytes = make([]byte, 96)
+		block.RewardChainBlock.ProofOfSpace.PlotPublicKey.Bytes = make([]byte, 48)
+		if len(chain) > 0 {
+			prev := &chain[len(chain)-1]
+			block.Foliage.PrevBlockHash = prev.HeaderHash()
+			block.RewardChainBlock.Weight.Add(block.RewardChainBlock.Weight, prev.RewardChainBlock.Weight)
+		}
+		chain = append(chain, block)
+	}
+	return chain
+}
+
+type chainServer struct {
+	node  *nettest.Node
+	chain []types.FullBlock
+	mutex sync.Mutex
+}
+
+func startChainServer(t *testing.T, ca *nettest.CA, chain []types.FullBlock) *chainServer {
+	t.Helper()
+	node, err := nettest.StartWithCA(ca, nettest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	s := &chainServer{node: node, chain: chain}
+	node.HandleFunc(types.MSG_REQUEST_BLOCKS, func(c *network.WSChiaConnection, msg utils.FromBytes) nettest.Response {
+		req := msg.(*types.RequestBlocks)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if int(req.EndHeight) >= len(s.chain) || req.StartHeight > req.EndHeight {
+			return nettest.Response{Message: types.RejectBlocks{StartHeight: req.StartHeight, EndHeight: req.EndHeight}}
+		}
+		blocks := s.chain[req.StartHeight : req.EndHeight+1]
+		return nettest.Response{Message: types.RespondBlocks{StartHeight: req.StartHeight, EndHeight: req.EndHeight, Blocks: blocks}}
+	})
+	return s
+}
+
+func (s *chainServer) setChain(chain []types.FullBlock) {
+	s.mutex.Lock()
+	s.chain = chain
+	s.mutex.Unlock()
+	peak := &chain[len(chain)-1]
+	s.node.Broadcast(types.NewPeak{
+		HeaderHash: peak.HeaderHash(),
+		Height:     peak.RewardChainBlock.Height,
+		Weight:     peak.RewardChainBlock.Weight,
+	})
+}
+
+func waitPeak(t *testing.T, store *Store, hash [32]byte) *StoredPeak {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		peak, err := store.Peak()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if peak != nil && peak.HeaderHash == hash {
+			return peak
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peak was not reached, current: %#v", peak)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSyncer(t *testing.T) {
+	ca, err := nettest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainA := extendChain(nil, 100, 10, 'a')
+	server := startChainServer(t, ca, chainA[:1])
+
+	tlsCert, err := ca.IssueTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := network.NewPeerManager(network.PeerManagerConfig{
+		TLSConfig:      network.MakeTSLConfig(ca.CertPool(), tlsCert),
+		RequestTimeout: time.Second,
+	})
+	peers.AddAddress(server.node.Addr())
+	peers.Start()
+	defer peers.Stop()
+	if err := peers.WaitForPeers(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "blocks.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	syncer := NewSyncer(store, peers, SyncerConfig{BatchSize: 7, ParallelBatches: 3, PeakCheckInterval: 50 * time.Millisecond})
+	runErr := make(chan error, 1)
+	go func() { runErr <- syncer.Run() }()
+
+	server.setChain(chainA)
+	waitPeak(t, store, chainA[99].HeaderHash())
+	block, err := store.BlockByHeight(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.HeaderHash() != chainA[42].HeaderHash() {
+		t.Errorf("unexpected block at 42")
+	}
+
+	// heavier fork from height 90
+	chainB := extendChain(chainA[:90], 20, 20, 'b')
+	server.setChain(chainB)
+	waitPeak(t, store, chainB[109].HeaderHash())
+	var orphans int
+	if err := store.DB().QueryRow("SELECT count(*) FROM orphan_blocks").Scan(&orphans); err != nil {
+		t.Fatal(err)
+	}
+	if orphans != 10 {
+		t.Errorf("expected 10 orphans, got %d", orphans)
+	}
+	for _, height := range []uint32{89, 90, 95} {
+		block, err := store.BlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.HeaderHash() != chainB[height].HeaderHash() {
+			t.Errorf("unexpected block at %d", height)
+		}
+	}
+
+	// heavier chain at lower height replaces the tip
+	chainC := extendChain(chainB[:105], 1, 1000, 'c')
+	server.setChain(chainC)
+	waitPeak(t, store, chainC[105].HeaderHash())
+
+	syncer.Stop()
+	if err := <-runErr; err != nil {
+		t.Fatal(err)
+	}
+	syncer.Stop() //should not panic
+}
+
+func TestStoreRejectsUnlinked(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "blocks.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	chain := extendChain(nil, 10, 1, 'a')
+	other := extendChain(chain[:5], 5, 1, 'b')
+	if err := store.AddBlocks(chain[1:3]); err == nil {
+		t.Error("expected genesis error")
+	}
+	if err := store.AddBlocks(chain[:6]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddBlocks(other[6:]); err == nil {
+		t.Error("expected prev hash error")
+	}
+	if err := store.AddBlocks(chain[7:]); err == nil {
+		t.Error("expected height error")
+	}
+	if _, err := store.Rollback(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddBlocks(other[5:]); err != nil {
+		t.Fatal(err)
+	}
+	if peak, err := store.Peak(); err != nil || peak.HeaderHash != other[9].HeaderHash() {
+		t.Errorf("unexpected peak: %#v %v", peak, err)
+	}
+}
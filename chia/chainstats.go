@@ -0,0 +1,123 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+type ChainStatsConfig struct {
+	// Intervals are aligned to UTC, one day if zero.
+	Interval time.Duration
+	// Space is estimated over this number of blocks ending at the interval's last block, DEFAULT_SPACE_WINDOW_BLOCKS if zero.
+	WindowBlocks uint32
+	// Intervals starting before this height are skipped (but blocks before it are still read to fill the window).
+	StartHeight uint32
+}
+
+// ChainStatsInterval contains metrics of blocks with timestamps in [Start, Start+Interval).
+//
+// Only transaction blocks have timestamps, other blocks belong to the interval of the previous transaction block.
+type ChainStatsInterval struct {
+	Start         time.Time
+	StartHeight   uint32
+	EndHeight     uint32
+	BlocksCount   int
+	TxBlocksCount int
+	// Estimated space in bytes at the interval's last block.
+	Space *big.Int
+	// Difficulty (weight increment) of the interval's last block.
+	Difficulty uint64
+	Fees       uint64
+	Cost       uint64
+	// Time between the last transaction block before the interval and the last one in it,
+	// divided by the number of blocks in between. Zero if unknown.
+	MeanBlockTime time.Duration
+}
+
+type weightIters struct {
+	height     uint32
+	weight     *big.Int
+	totalIters *big.Int
+}
+
+// ForEachChainStatsInterval calls handler for every finished interval (the last one, which may
+// still get new blocks, is skipped). Full blocks are read since costs are not stored in block records.
+func ForEachChainStatsInterval(src BlockSource, cfg ChainStatsConfig, handler func(*ChainStatsInterval) error) error {
+	if cfg.Interval == 0 {
+		cfg.Interval = 24 * time.Hour
+	}
+	if cfg.WindowBlocks == 0 {
+		cfg.WindowBlocks = DEFAULT_SPACE_WINDOW_BLOCKS
+	}
+
+	readFrom := uint32(0)
+	if cfg.StartHeight > cfg.WindowBlocks {
+		readFrom = cfg.StartHeight - cfg.WindowBlocks
+	}
+
+	window := NewRingBuf(int(cfg.WindowBlocks) + 1)
+	var cur *ChainStatsInterval
+	var prevWeight *big.Int
+	var lastTxStamp, prevIntervalTxStamp uint64
+	var lastTxHeight, prevIntervalTxHeight uint32
+
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		first := window.First().(weightIters)
+		last := window.Last().(weightIters)
+		if last.totalIters.Cmp(first.totalIters) > 0 {
+			cur.Space = estimateNetworkSpaceInner(first.weight, last.weight, first.totalIters, last.totalIters, last.height)
+		} else {
+			cur.Space = new(big.Int)
+		}
+		if prevIntervalTxStamp > 0 && lastTxHeight > prevIntervalTxHeight {
+			cur.MeanBlockTime = time.Duration(lastTxStamp-prevIntervalTxStamp) * time.Second / time.Duration(lastTxHeight-prevIntervalTxHeight)
+		}
+		return merry.Wrap(handler(cur))
+	}
+
+	err := src.ForEachFullBlock(readFrom, math.MaxUint32, func(block *types.FullBlock) error {
+		rcb := &block.RewardChainBlock
+		var difficulty uint64
+		if prevWeight != nil {
+			difficulty = new(big.Int).Sub(rcb.Weight, prevWeight).Uint64()
+		} else if rcb.Height == 0 {
+			difficulty = rcb.Weight.Uint64()
+		}
+		prevWeight = rcb.Weight
+
+		if block.FoliageTransactionBlock != nil {
+			stamp := time.Unix(int64(block.FoliageTransactionBlock.Timestamp), 0).UTC()
+			intervalStart := stamp.Truncate(cfg.Interval)
+			if rcb.Height >= cfg.StartHeight && (cur == nil || !intervalStart.Equal(cur.Start)) {
+				if err := finish(); err != nil {
+					return merry.Wrap(err)
+				}
+				prevIntervalTxStamp, prevIntervalTxHeight = lastTxStamp, lastTxHeight
+				cur = &ChainStatsInterval{Start: intervalStart, StartHeight: rcb.Height}
+			}
+			lastTxStamp, lastTxHeight = block.FoliageTransactionBlock.Timestamp, rcb.Height
+		}
+
+		window.Add(weightIters{rcb.Height, rcb.Weight, rcb.TotalIters})
+		if cur == nil {
+			return nil //before StartHeight or before the first transaction block
+		}
+		cur.EndHeight = rcb.Height
+		cur.BlocksCount += 1
+		cur.Difficulty = difficulty
+		if block.TransactionsInfo != nil {
+			cur.TxBlocksCount += 1
+			cur.Fees += block.TransactionsInfo.Fees
+			cur.Cost += block.TransactionsInfo.Cost
+		}
+		return nil
+	})
+	return merry.Wrap(err)
+}
@@ -0,0 +1,76 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestForEachChainStatsInterval(t *testing.T) {
+	// every third block is a transaction one, 20s between blocks, 3min intervals: [0-8] [9-17] [18-26] [27-29]
+	t0 := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	blocks, _ := testSourceBlocks(30, 1)
+	var records []types.BlockRecord
+	for i := range blocks {
+		block := &blocks[i]
+		if i%3 == 0 {
+			block.FoliageTransactionBlock = &types.FoliageTransactionBlock{Timestamp: uint64(t0.Unix()) + uint64(i*20)}
+			block.TransactionsInfo = &types.TransactionsInfo{Fees: 5, Cost: 7}
+			block.TransactionsInfo.AggregatedSignature.Bytes = make([]byte, 96)
+		}
+		if i > 0 {
+			block.Foliage.PrevBlockHash = blocks[i-1].HeaderHash()
+		}
+		records = append(records, *BlockRecordFromFullBlock(block))
+	}
+	src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	collect := func(cfg ChainStatsConfig) []ChainStatsInterval {
+		var res []ChainStatsInterval
+		err := ForEachChainStatsInterval(src, cfg, func(st *ChainStatsInterval) error {
+			res = append(res, *st)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	stats := collect(ChainStatsConfig{Interval: 3 * time.Minute, WindowBlocks: 5})
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 finished intervals, got %d", len(stats))
+	}
+	for i, st := range stats {
+		if !st.Start.Equal(t0.Add(time.Duration(i) * 3 * time.Minute)) {
+			t.Errorf("interval %d: unexpected start %s", i, st.Start)
+		}
+		if st.StartHeight != uint32(i*9) || st.EndHeight != uint32(i*9+8) || st.BlocksCount != 9 || st.TxBlocksCount != 3 {
+			t.Errorf("interval %d: unexpected blocks: %+v", i, st)
+		}
+		if st.Fees != 15 || st.Cost != 21 || st.Difficulty != 100 {
+			t.Errorf("interval %d: unexpected fees/cost/difficulty: %+v", i, st)
+		}
+		expectedSpace := EstimateNetworkSpace(&records[st.EndHeight-5], &records[st.EndHeight])
+		if st.Space.Cmp(expectedSpace) != 0 {
+			t.Errorf("interval %d: expected space %s, got %s", i, expectedSpace, st.Space)
+		}
+		expectedBlockTime := 20 * time.Second
+		if i == 0 {
+			expectedBlockTime = 0
+		}
+		if st.MeanBlockTime != expectedBlockTime {
+			t.Errorf("interval %d: expected block time %s, got %s", i, expectedBlockTime, st.MeanBlockTime)
+		}
+	}
+
+	resumed := collect(ChainStatsConfig{Interval: 3 * time.Minute, WindowBlocks: 5, StartHeight: 18})
+	if len(resumed) != 1 || !reflect.DeepEqual(resumed[0], stats[2]) {
+		t.Errorf("resumed stats differ: %+v", resumed)
+	}
+}
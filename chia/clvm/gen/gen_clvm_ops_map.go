@@ -61,12 +61,8 @@ func main() {
 	}
 	var kwOmitFromList = map[string]bool{
 		//TODO: implement
-		"x":              true,
-		"strlen":         true,
-		"-":              true,
 		"/":              true,
 		"divmod":         true,
-		">":              true,
 		"ash":            true,
 		"lsh":            true,
 		"logior":         true,
@@ -74,9 +70,6 @@ func main() {
 		"lognot":         true,
 		"point_add":      true,
 		"pubkey_for_exp": true,
-		"not":            true,
-		"any":            true,
-		"all":            true,
 		"softfork":       true,
 	}
 	var kwAsConstAtom = map[string]bool{
@@ -102,6 +102,15 @@ func irReadSpaces(str string, pos int) int {
 }
 func irReadToken(str string, pos int) (string, int) {
 	startPos := pos
+	if pos < len(str) && (str[pos] == '\'' || str[pos] == '"') {
+		// quoted string, may contain spaces and parens
+		end := strings.IndexByte(str[pos+1:], str[pos])
+		if end == -1 {
+			return str[startPos:], len(str)
+		}
+		pos += 1 + end + 1
+		return str[startPos:pos], pos
+	}
 	for pos < len(str) {
 		c := str[pos]
 		if c == '(' || c == ')' || c == ' ' {
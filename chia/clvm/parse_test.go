@@ -200,6 +200,9 @@ func TestFromIRString(t *testing.T) {
 	test(`"A"`, `41`)
 	test(`"ABC"`, `414243`)
 	test(`'ABC'`, `414243`)
+	test(`"A B"`, `412042`)
+	test(`("A (B)" 'C"D')`, `(4120284229 . (432244 . nil))`)
+	test(`"A B`, `FAIL: from ir: unterminated string starting at pos 0: "A B`)
 
 	test("q", "01")
 	test("a", "02")
@@ -238,3 +241,19 @@ func TestFromIRString(t *testing.T) {
 	test("(1 . 2 . 3)", "FAIL: from ir: unexpected '.' at pos 8")
 	test("(1 . 2 3)", "FAIL: from ir: unexpected '.' at pos 4")
 }
+
+func TestTreeHash(t *testing.T) {
+	testOk := func(irStr string, hashHex string) {
+		sexp, err := SExpFromIRString(irStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := TreeHash(sexp)
+		if resHex := hex.EncodeToString(hash[:]); resHex != hashHex {
+			t.Errorf("TreeHash(%s) = %s, expected %s", irStr, resHex, hashHex)
+		}
+	}
+	testOk("()", "4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a")
+	testOk("1", "9dcf97a184f32623d11a73124ceb99a5709b083721e878a16d78f596718ba7b2")
+	testOk("(q 51 0xcafe 1000)", "67183e3b49880d856d321e82ccdc83531b5d20113a608fe702209d3220664d45")
+}
@@ -116,8 +116,9 @@ func opListp(args SExp) (int64, SExp, error) {
 	}
 }
 
-// def op_raise(args):
-//     raise EvalError("clvm raise", args)
+func opRaise(args SExp) (int64, SExp, error) {
+	return 0, nil, NewEvalError("clvm raise").With("args", args)
+}
 
 func opEq(args SExp) (int64, SExp, error) {
 	if err := ensureArgsLen("=", args, 2); err != nil {
@@ -139,6 +140,20 @@ func opEq(args SExp) (int64, SExp, error) {
 	return cost, FALSE, nil
 }
 
+func opStrlen(args SExp) (int64, SExp, error) {
+	if err := ensureArgsLen("strlen", args, 1); err != nil {
+		return 0, nil, err
+	}
+	a0, ok := args.(Pair).First.(Atom)
+	if !ok {
+		return 0, nil, NewEvalError("strlen on list").With("arg", args.(Pair).First)
+	}
+	size := len(a0.Bytes)
+	cost := int64(STRLEN_BASE_COST) + int64(size)*STRLEN_COST_PER_BYTE
+	cost, res := mallocCost(cost, AtomFromInt(big.NewInt(int64(size))))
+	return cost, res, nil
+}
+
 func opAdd(args SExp) (int64, SExp, error) {
 	total := big.NewInt(0)
 	cost := int64(ARITH_BASE_COST)
@@ -162,6 +177,35 @@ func opAdd(args SExp) (int64, SExp, error) {
 	return cost, res, nil
 }
 
+func opSubtract(args SExp) (int64, SExp, error) {
+	total := big.NewInt(0)
+	cost := int64(ARITH_BASE_COST)
+	argSize := int64(0)
+	isFirst := true
+	argIter := NewIter(args)
+	for argIter.Next() {
+		item := argIter.Get()
+		if atom, ok := item.(Atom); ok {
+			if isFirst {
+				total.Add(total, atom.AsInt())
+				isFirst = false
+			} else {
+				total.Sub(total, atom.AsInt())
+			}
+			argSize += int64(len(atom.Bytes))
+			cost += ARITH_COST_PER_ARG
+		} else {
+			return cost, nil, NewEvalError("- on list").With("arg", item)
+		}
+	}
+	if err := argIter.Err(); err != nil {
+		return cost, nil, err
+	}
+	cost += argSize * ARITH_COST_PER_BYTE
+	cost, res := mallocCost(cost, AtomFromInt(total))
+	return cost, res, nil
+}
+
 func opMultiply(args SExp) (int64, SExp, error) {
 	cost := int64(MUL_BASE_COST)
 
@@ -244,6 +288,26 @@ func opGrBytes(args SExp) (int64, SExp, error) {
 	return cost, FALSE, nil
 }
 
+func opGr(args SExp) (int64, SExp, error) {
+	if err := ensureArgsLen(">", args, 2); err != nil {
+		return 0, nil, err
+	}
+	a0, ok0 := args.(Pair).First.(Atom)
+	a1, ok1 := args.(Pair).Rest.(Pair).First.(Atom)
+	if !ok0 {
+		return 0, nil, NewEvalError("> on list").With("arg0", args.(Pair).First)
+	}
+	if !ok1 {
+		return 0, nil, NewEvalError("> on list").With("arg1", args.(Pair).Rest.(Pair).First)
+	}
+	cost := int64(GR_BASE_COST)
+	cost += int64(len(a0.Bytes)+len(a1.Bytes)) * GR_COST_PER_BYTE
+	if a0.AsInt().Cmp(a1.AsInt()) > 0 {
+		return cost, TRUE, nil
+	}
+	return cost, FALSE, nil
+}
+
 func opSubstr(args SExp) (int64, SExp, error) {
 	argCount := args.ListLen()
 	if argCount != 2 && argCount != 3 {
@@ -339,6 +403,43 @@ func opLogand(args SExp) (int64, SExp, error) {
 	return binopReduction("logand", new(big.Int).SetInt64(-1), args, binop)
 }
 
+func opNot(args SExp) (int64, SExp, error) {
+	if err := ensureArgsLen("not", args, 1); err != nil {
+		return 0, nil, err
+	}
+	if args.(Pair).First.Nullp() {
+		return BOOL_BASE_COST, TRUE, nil
+	}
+	return BOOL_BASE_COST, FALSE, nil
+}
+
+func boolReduction(args SExp, initialValue bool, stopOnNull bool) (int64, SExp, error) {
+	cost := int64(BOOL_BASE_COST)
+	res := initialValue
+	argIter := NewIter(args)
+	for argIter.Next() {
+		cost += BOOL_COST_PER_ARG
+		if argIter.Get().Nullp() == stopOnNull {
+			res = !initialValue
+		}
+	}
+	if err := argIter.Err(); err != nil {
+		return cost, nil, err
+	}
+	if res {
+		return cost, TRUE, nil
+	}
+	return cost, FALSE, nil
+}
+
+func opAny(args SExp) (int64, SExp, error) {
+	return boolReduction(args, false, false)
+}
+
+func opAll(args SExp) (int64, SExp, error) {
+	return boolReduction(args, true, true)
+}
+
 func msbMask(b byte) byte {
 	b |= (b >> 1)
 	b |= (b >> 2)
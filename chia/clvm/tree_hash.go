@@ -0,0 +1,20 @@
+package clvm
+
+import "crypto/sha256"
+
+// TreeHash returns sha256tree of the expression (same as upstream Program.get_tree_hash()),
+// coin's puzzle hash is the tree hash of its puzzle.
+func TreeHash(sexp SExp) [32]byte {
+	switch sexp := sexp.(type) {
+	case Pair:
+		left := TreeHash(sexp.First)
+		right := TreeHash(sexp.Rest)
+		buf := make([]byte, 0, 1+32+32)
+		buf = append(append(append(buf, 2), left[:]...), right[:]...)
+		return sha256.Sum256(buf)
+	case Atom:
+		return sha256.Sum256(append([]byte{1}, sexp.Bytes...))
+	default:
+		panic("unexpected sexp type")
+	}
+}
@@ -0,0 +1,58 @@
+package chia
+
+import (
+	"encoding/hex"
+	"math/big"
+	"time"
+)
+
+// ConsensusConstants contains part of upstream chia/consensus/constants.py used here.
+type ConsensusConstants struct {
+	SlotBlocksTarget           uint32
+	SubSlotTimeTarget          uint32
+	MinBlocksPerChallengeBlock uint8
+	SubEpochBlocks             uint32
+	EpochBlocks                uint32
+	NumSPsSubSlot              uint8
+	NumSPIntervalsExtra        uint8
+	DifficultyStarting         uint64
+	SubSlotItersStarting       uint64
+	DifficultyConstantFactor   *big.Int
+	GenesisChallenge           [32]byte
+}
+
+func mustBytes32FromHex(str string) [32]byte {
+	var res [32]byte
+	buf, err := hex.DecodeString(str)
+	if err != nil || len(buf) != 32 {
+		panic("invalid bytes32 hex: " + str)
+	}
+	copy(res[:], buf)
+	return res
+}
+
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/consensus/default_constants.py
+// (with GENESIS_CHALLENGE from mainnet config)
+var MAINNET_CONSTANTS = ConsensusConstants{
+	SlotBlocksTarget:           32,
+	SubSlotTimeTarget:          600,
+	MinBlocksPerChallengeBlock: 16,
+	SubEpochBlocks:             384,
+	EpochBlocks:                4608,
+	NumSPsSubSlot:              64,
+	NumSPIntervalsExtra:        3,
+	DifficultyStarting:         7,
+	SubSlotItersStarting:       1 << 27,
+	DifficultyConstantFactor:   new(big.Int).Lsh(big.NewInt(1), 67),
+	GenesisChallenge:           mustBytes32FromHex("ccd5bb71183532bff220ba46c268991a3ff07eb358e8255a65c30a2dce0e5fbb"),
+}
+
+// IsOverflowBlock is upstream is_overflow_block (signage point is in the previous sub-slot).
+func (c *ConsensusConstants) IsOverflowBlock(signagePointIndex uint8) bool {
+	return signagePointIndex >= c.NumSPsSubSlot-c.NumSPIntervalsExtra
+}
+
+// BlockTimeTarget is the average time between blocks difficulty adjustment aims for.
+func (c *ConsensusConstants) BlockTimeTarget() time.Duration {
+	return time.Duration(c.SubSlotTimeTarget) * time.Second / time.Duration(c.SlotBlocksTarget)
+}
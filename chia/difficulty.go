@@ -0,0 +1,278 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+type DifficultySeriesConfig struct {
+	StartHeight uint32
+	// Peak if zero.
+	EndHeight uint32
+	// MAINNET_CONSTANTS if nil.
+	Constants *ConsensusConstants
+}
+
+// DifficultyEpoch contains stats of consecutive blocks with same difficulty and sub-slot iterations.
+// Usually it is a whole epoch (~4608 blocks), but the first and the last ones may be cut
+// by the height range, and epochs that kept both values unchanged are merged.
+type DifficultyEpoch struct {
+	StartHeight uint32
+	EndHeight   uint32
+	// Timestamps of the first and the last transaction blocks.
+	StartTime time.Time
+	EndTime   time.Time
+	// Weight increment of every block.
+	Difficulty uint64
+	// Zero if unknown (source without block records).
+	SubSlotIters uint64
+	// Values announced by a sub-epoch summary included in this epoch (zero if none).
+	NewDifficulty   uint64
+	NewSubSlotIters uint64
+	// Number of blocks for every signage point index.
+	SignagePoints []int
+	// Number of blocks with signage point in the previous sub-slot.
+	OverflowBlocks int
+	// Time between the last transaction block before the epoch (or the first one in it)
+	// and the last one in it, divided by the number of blocks in between. Zero if unknown.
+	BlockTime       time.Duration
+	BlockTimeTarget time.Duration
+	// Time of one sub-slot calculated from iterations and time passed. Zero if unknown.
+	SubSlotTime time.Duration
+	// Last epoch may be not finished yet.
+	Partial bool
+
+	firstTxIters *big.Int
+	lastTxIters  *big.Int
+}
+
+func (e DifficultyEpoch) BlocksCount() uint32 {
+	return e.EndHeight - e.StartHeight + 1
+}
+
+// SignagePointSpread returns coefficient of variation (std dev / mean) of blocks count
+// per signage point index. Zero means blocks are spread evenly.
+func (e DifficultyEpoch) SignagePointSpread() float64 {
+	if len(e.SignagePoints) == 0 {
+		return 0
+	}
+	mean := float64(e.BlocksCount()) / float64(len(e.SignagePoints))
+	sum := 0.0
+	for _, count := range e.SignagePoints {
+		sum += (float64(count) - mean) * (float64(count) - mean)
+	}
+	return math.Sqrt(sum/float64(len(e.SignagePoints))) / mean
+}
+
+// DifficultySeries splits blocks into epochs (see DifficultyEpoch) and calculates their stats.
+func DifficultySeries(src BlockSource, cfg DifficultySeriesConfig) ([]DifficultyEpoch, error) {
+	if cfg.Constants == nil {
+		cfg.Constants = &MAINNET_CONSTANTS
+	}
+	if cfg.EndHeight == 0 {
+		cfg.EndHeight = math.MaxUint32
+	}
+	consts := cfg.Constants
+
+	// reading one extra block before range to get the first difficulty and timestamp
+	readFrom := cfg.StartHeight
+	if readFrom > 0 {
+		readFrom -= 1
+	}
+
+	var epochs []DifficultyEpoch
+	var cur *DifficultyEpoch
+	var prevWeight *big.Int
+	var lastTxStamp, prevEpochTxStamp, firstTxStamp uint64
+	var lastTxHeight, prevEpochTxHeight, firstTxHeight uint32
+
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		fromStamp, fromHeight := prevEpochTxStamp, prevEpochTxHeight
+		if fromStamp == 0 {
+			fromStamp, fromHeight = firstTxStamp, firstTxHeight
+		}
+		if firstTxStamp > 0 {
+			cur.StartTime = time.Unix(int64(firstTxStamp), 0).UTC()
+			cur.EndTime = time.Unix(int64(lastTxStamp), 0).UTC()
+		}
+		if fromStamp > 0 && lastTxHeight > fromHeight {
+			elapsed := time.Duration(lastTxStamp-fromStamp) * time.Second
+			cur.BlockTime = elapsed / time.Duration(lastTxHeight-fromHeight)
+		}
+		if firstTxStamp > 0 && lastTxStamp > firstTxStamp && cur.SubSlotIters > 0 {
+			iters := new(big.Int).Sub(cur.lastTxIters, cur.firstTxIters)
+			if iters.Sign() > 0 {
+				subSlots := new(big.Float).Quo(new(big.Float).SetInt(iters), new(big.Float).SetUint64(cur.SubSlotIters))
+				subSlotsF, _ := subSlots.Float64()
+				cur.SubSlotTime = time.Duration(float64(time.Duration(lastTxStamp-firstTxStamp)*time.Second) / subSlotsF)
+			}
+		}
+		epochs = append(epochs, *cur)
+	}
+
+	err := src.ForEachBlockRecord(readFrom, cfg.EndHeight, func(br *types.BlockRecord) error {
+		var difficulty uint64
+		if prevWeight != nil {
+			difficulty = new(big.Int).Sub(br.Weight, prevWeight).Uint64()
+		} else if br.Height == 0 {
+			difficulty = br.Weight.Uint64()
+		}
+		prevWeight = br.Weight
+
+		if br.Height < cfg.StartHeight {
+			if br.Timestamp != 0 {
+				lastTxStamp, lastTxHeight = br.Timestamp, br.Height
+			}
+			return nil
+		}
+
+		if cur == nil || difficulty != cur.Difficulty || br.SubSlotIters != cur.SubSlotIters {
+			finish()
+			prevEpochTxStamp, prevEpochTxHeight = lastTxStamp, lastTxHeight
+			firstTxStamp, firstTxHeight = 0, 0
+			cur = &DifficultyEpoch{
+				StartHeight:     br.Height,
+				Difficulty:      difficulty,
+				SubSlotIters:    br.SubSlotIters,
+				SignagePoints:   make([]int, consts.NumSPsSubSlot),
+				BlockTimeTarget: consts.BlockTimeTarget(),
+			}
+		}
+		cur.EndHeight = br.Height
+		if int(br.SignagePointIndex) < len(cur.SignagePoints) {
+			cur.SignagePoints[br.SignagePointIndex] += 1
+		}
+		if consts.IsOverflowBlock(br.SignagePointIndex) {
+			cur.OverflowBlocks += 1
+		}
+		if ses := br.SubEpochSummaryIncluded; ses != nil {
+			if ses.NewDifficulty != 0 {
+				cur.NewDifficulty = ses.NewDifficulty
+			}
+			if ses.NewSubSlotIters != 0 {
+				cur.NewSubSlotIters = ses.NewSubSlotIters
+			}
+		}
+		if br.Timestamp != 0 {
+			if firstTxStamp == 0 {
+				firstTxStamp, firstTxHeight = br.Timestamp, br.Height
+				cur.firstTxIters = br.TotalIters
+			}
+			lastTxStamp, lastTxHeight = br.Timestamp, br.Height
+			cur.lastTxIters = br.TotalIters
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cur != nil {
+		cur.Partial = true
+	}
+	finish()
+	return epochs, nil
+}
+
+// PrintDifficultySeries writes epochs in "csv", "json" (array of objects) or "table" format.
+func PrintDifficultySeries(out io.Writer, epochs []DifficultyEpoch, format string) error {
+	const timeFmt = "2006-01-02 15:04:05"
+	fmtSeconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	}
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"start_time", "end_time", "start_height", "end_height", "blocks",
+			"difficulty", "sub_slot_iters", "new_difficulty", "new_sub_slot_iters",
+			"block_time", "block_time_target", "sub_slot_time", "sp_spread", "overflow_blocks", "partial"})
+		for _, e := range epochs {
+			w.Write([]string{
+				e.StartTime.Format(time.RFC3339), e.EndTime.Format(time.RFC3339),
+				strconv.FormatUint(uint64(e.StartHeight), 10), strconv.FormatUint(uint64(e.EndHeight), 10),
+				strconv.FormatUint(uint64(e.BlocksCount()), 10),
+				strconv.FormatUint(e.Difficulty, 10), strconv.FormatUint(e.SubSlotIters, 10),
+				strconv.FormatUint(e.NewDifficulty, 10), strconv.FormatUint(e.NewSubSlotIters, 10),
+				fmtSeconds(e.BlockTime), fmtSeconds(e.BlockTimeTarget), fmtSeconds(e.SubSlotTime),
+				strconv.FormatFloat(e.SignagePointSpread(), 'f', 4, 64),
+				strconv.Itoa(e.OverflowBlocks), strconv.FormatBool(e.Partial),
+			})
+		}
+		w.Flush()
+		return merry.Wrap(w.Error())
+	case "json":
+		type jsonEpoch struct {
+			StartTime       time.Time `json:"start_time"`
+			EndTime         time.Time `json:"end_time"`
+			StartHeight     uint32    `json:"start_height"`
+			EndHeight       uint32    `json:"end_height"`
+			Blocks          uint32    `json:"blocks"`
+			Difficulty      uint64    `json:"difficulty"`
+			SubSlotIters    uint64    `json:"sub_slot_iters"`
+			NewDifficulty   uint64    `json:"new_difficulty"`
+			NewSubSlotIters uint64    `json:"new_sub_slot_iters"`
+			BlockTime       float64   `json:"block_time"`
+			BlockTimeTarget float64   `json:"block_time_target"`
+			SubSlotTime     float64   `json:"sub_slot_time"`
+			SignagePoints   []int     `json:"signage_points"`
+			SPSpread        float64   `json:"sp_spread"`
+			OverflowBlocks  int       `json:"overflow_blocks"`
+			Partial         bool      `json:"partial"`
+		}
+		res := make([]jsonEpoch, len(epochs))
+		for i, e := range epochs {
+			res[i] = jsonEpoch{e.StartTime, e.EndTime, e.StartHeight, e.EndHeight, e.BlocksCount(),
+				e.Difficulty, e.SubSlotIters, e.NewDifficulty, e.NewSubSlotIters,
+				e.BlockTime.Seconds(), e.BlockTimeTarget.Seconds(), e.SubSlotTime.Seconds(),
+				e.SignagePoints, e.SignagePointSpread(), e.OverflowBlocks, e.Partial}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return merry.Wrap(enc.Encode(res))
+	case "table":
+		if _, err := fmt.Fprintf(out, "%-19s  %8s  %6s  %10s  %12s  %12s  %9s  %9s  %6s  %6s\n",
+			"start time", "height", "blocks", "difficulty", "ss iters", "block time", "target", "ss time", "spread", "ovfl"); err != nil {
+			return merry.Wrap(err)
+		}
+		for _, e := range epochs {
+			blockTime := "?"
+			if e.BlockTime > 0 {
+				blockTime = fmt.Sprintf("%.2fs %+5.1f%%", e.BlockTime.Seconds(), (e.BlockTime.Seconds()/e.BlockTimeTarget.Seconds()-1)*100)
+			}
+			subSlotIters := "?"
+			if e.SubSlotIters > 0 {
+				subSlotIters = strconv.FormatUint(e.SubSlotIters, 10)
+			}
+			subSlotTime := "?"
+			if e.SubSlotTime > 0 {
+				subSlotTime = fmt.Sprintf("%.1fs", e.SubSlotTime.Seconds())
+			}
+			note := ""
+			if e.Partial {
+				note = " (partial)"
+			}
+			_, err := fmt.Fprintf(out, "%-19s  %8d  %6d  %10d  %12s  %12s  %8.2fs  %9s  %6.3f  %5.1f%%%s\n",
+				e.StartTime.Format(timeFmt), e.StartHeight, e.BlocksCount(), e.Difficulty, subSlotIters,
+				blockTime, e.BlockTimeTarget.Seconds(), subSlotTime, e.SignagePointSpread(),
+				float64(e.OverflowBlocks)*100/float64(e.BlocksCount()), note)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	default:
+		return merry.Errorf(`unexpected format "%s", expected "csv", "json" or "table"`, format)
+	}
+}
@@ -0,0 +1,100 @@
+package chia
+
+import (
+	"bytes"
+	"chiastat/chia/types"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDifficultySeries(t *testing.T) {
+	// difficulty 100 for [0-9], 200 for [10-19], every second block is a transaction one
+	blocks, records := testSourceBlocks(20, 1)
+	weight := int64(0)
+	for i := range records {
+		br := &records[i]
+		if i < 10 {
+			weight += 100
+			br.SubSlotIters = 16000
+		} else {
+			weight += 200
+			br.SubSlotIters = 32000
+		}
+		br.Weight = big.NewInt(weight)
+		br.SignagePointIndex = uint8(i % 4)
+		if i%2 == 1 {
+			br.Timestamp = 0
+		}
+		if i == 8 {
+			br.SubEpochSummaryIncluded = &types.SubEpochSummary{NewDifficulty: 200, NewSubSlotIters: 32000}
+		}
+	}
+	records[19].SignagePointIndex = 62
+	src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	epochs, err := DifficultySeries(src, DifficultySeriesConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(epochs) != 2 {
+		t.Fatalf("expected 2 epochs, got %d", len(epochs))
+	}
+	e0, e1 := epochs[0], epochs[1]
+	if e0.StartHeight != 0 || e0.EndHeight != 9 || e0.Difficulty != 100 || e0.SubSlotIters != 16000 || e0.Partial {
+		t.Errorf("unexpected first epoch: %+v", e0)
+	}
+	if e0.NewDifficulty != 200 || e0.NewSubSlotIters != 32000 {
+		t.Errorf("expected sub-epoch summary values in first epoch: %+v", e0)
+	}
+	if e1.StartHeight != 10 || e1.EndHeight != 19 || e1.Difficulty != 200 || e1.SubSlotIters != 32000 || !e1.Partial {
+		t.Errorf("unexpected second epoch: %+v", e1)
+	}
+	if !e1.StartTime.Equal(time.Unix(1600000000+10*18, 0)) || !e1.EndTime.Equal(time.Unix(1600000000+18*18, 0)) {
+		t.Errorf("unexpected second epoch times: %s - %s", e1.StartTime, e1.EndTime)
+	}
+	for i, e := range epochs {
+		if e.BlockTime != 18*time.Second || e.BlockTimeTarget != 18750*time.Millisecond {
+			t.Errorf("epoch %d: unexpected block time %s (target %s)", i, e.BlockTime, e.BlockTimeTarget)
+		}
+	}
+	// 8 tx blocks apart: 8000 iters, 144s
+	if e0.SubSlotTime != 288*time.Second || e1.SubSlotTime != 576*time.Second {
+		t.Errorf("unexpected sub-slot times: %s, %s", e0.SubSlotTime, e1.SubSlotTime)
+	}
+	if e0.SignagePoints[0] != 3 || e0.SignagePoints[3] != 2 || e0.OverflowBlocks != 0 {
+		t.Errorf("unexpected first epoch signage points: %v", e0.SignagePoints)
+	}
+	if e1.SignagePoints[3] != 2 || e1.SignagePoints[62] != 1 || e1.OverflowBlocks != 1 {
+		t.Errorf("unexpected second epoch signage points: %v", e1.SignagePoints)
+	}
+
+	epochs, err = DifficultySeries(src, DifficultySeriesConfig{StartHeight: 12, EndHeight: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(epochs) != 1 || epochs[0].StartHeight != 12 || epochs[0].EndHeight != 15 || epochs[0].Difficulty != 200 ||
+		epochs[0].BlockTime != 18*time.Second {
+		t.Errorf("unexpected epochs in range: %+v", epochs)
+	}
+}
+
+func TestPrintDifficultySeriesUnknownSubSlotIters(t *testing.T) {
+	epochs := []DifficultyEpoch{{StartHeight: 0, EndHeight: 9, Difficulty: 100, SignagePoints: make([]int, 64), BlockTimeTarget: 18750 * time.Millisecond}}
+	var buf bytes.Buffer
+	if err := PrintDifficultySeries(&buf, epochs, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected header and one row, got %q", buf.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[5] != "?" {
+		t.Errorf("expected unknown sub-slot iters, got row %q", lines[1])
+	}
+}
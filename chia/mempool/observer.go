@@ -0,0 +1,228 @@
+package mempool
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+const DEFAULT_TRACK_DURATION = 2 * time.Minute
+const DEFAULT_FETCH_ATTEMPTS = 3
+const DEFAULT_PARALLEL_FETCHES = 16
+
+// Announce is a NewTransaction message received from a peer.
+type Announce struct {
+	PeerAddress string
+	At          time.Time
+	Cost        uint64
+	Fees        uint64
+}
+
+// Transaction is a mempool transaction observed during ObserverConfig.TrackDuration.
+// Announces are ordered by time, the first one is the transaction discovery.
+type Transaction struct {
+	ID        [32]byte
+	Announces []Announce
+	// nil if transaction was not fetched (FetchErr is set) or could not be decoded (DecodeErr is set).
+	Bundle    *types.SpendBundle
+	Info      *SpendInfo
+	FetchErr  error
+	DecodeErr error
+}
+
+func (tx *Transaction) FirstSeenAt() time.Time {
+	return tx.Announces[0].At
+}
+
+// Delays returns time between transaction discovery and announce from each peer.
+func (tx *Transaction) Delays() map[string]time.Duration {
+	res := make(map[string]time.Duration, len(tx.Announces))
+	for _, ann := range tx.Announces {
+		if _, ok := res[ann.PeerAddress]; !ok {
+			res[ann.PeerAddress] = ann.At.Sub(tx.FirstSeenAt())
+		}
+	}
+	return res
+}
+
+type ObserverConfig struct {
+	// How long announces of a transaction are collected before it is emitted, DEFAULT_TRACK_DURATION if zero.
+	TrackDuration time.Duration
+	// Max number of announcing peers asked for transaction, DEFAULT_FETCH_ATTEMPTS if zero.
+	FetchAttempts int
+	// DEFAULT_PARALLEL_FETCHES if zero.
+	ParallelFetches int
+}
+
+type txState struct {
+	tx        *Transaction
+	peers     []*network.Peer
+	fetched   bool
+	emitted   bool
+	createdAt time.Time
+}
+
+// Observer listens for NewTransaction messages from all PeerManager peers,
+// fetches spend bundles (from peers that announced them) and
+// emits transactions with per-peer announce timings once TrackDuration has passed.
+type Observer struct {
+	peers     *network.PeerManager
+	cfg       ObserverConfig
+	txs       map[[32]byte]*txState
+	fetchSem  chan struct{}
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	mutex     sync.Mutex
+	fetchWait sync.WaitGroup
+}
+
+func NewObserver(peers *network.PeerManager, cfg ObserverConfig) *Observer {
+	if cfg.TrackDuration == 0 {
+		cfg.TrackDuration = DEFAULT_TRACK_DURATION
+	}
+	if cfg.FetchAttempts == 0 {
+		cfg.FetchAttempts = DEFAULT_FETCH_ATTEMPTS
+	}
+	if cfg.ParallelFetches == 0 {
+		cfg.ParallelFetches = DEFAULT_PARALLEL_FETCHES
+	}
+	return &Observer{
+		peers:    peers,
+		cfg:      cfg,
+		txs:      make(map[[32]byte]*txState),
+		fetchSem: make(chan struct{}, cfg.ParallelFetches),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Run sends observed transactions to out until Stop is called. out is not closed.
+func (o *Observer) Run(out chan<- *Transaction) {
+	unsub := o.peers.SubscribeNewTransaction(o.handleNewTransaction)
+	defer unsub()
+
+	ticker := time.NewTicker(o.cfg.TrackDuration / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.stopChan:
+			// handlers check stopChan under the mutex, so no fetches will be added after this point
+			o.mutex.Lock()
+			o.mutex.Unlock()
+			o.fetchWait.Wait()
+			return
+		case <-ticker.C:
+			for _, tx := range o.popReady(time.Now()) {
+				select {
+				case out <- tx:
+				case <-o.stopChan:
+				}
+			}
+		}
+	}
+}
+
+func (o *Observer) Stop() {
+	o.stopOnce.Do(func() { close(o.stopChan) })
+}
+
+func (o *Observer) handleNewTransaction(peer *network.Peer, msg *types.NewTransaction) {
+	ann := Announce{PeerAddress: peer.Address, At: time.Now(), Cost: msg.Cost, Fees: msg.Fees}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	select {
+	case <-o.stopChan:
+		return
+	default:
+	}
+	state, ok := o.txs[msg.TransactionID]
+	if !ok {
+		state = &txState{tx: &Transaction{ID: msg.TransactionID}, createdAt: ann.At}
+		o.txs[msg.TransactionID] = state
+		o.fetchWait.Add(1)
+		go o.fetch(state)
+	}
+	if state.emitted {
+		return //late announce, transaction is already saved and kept only to not be re-observed
+	}
+	state.tx.Announces = append(state.tx.Announces, ann)
+	state.peers = append(state.peers, peer)
+}
+
+func (o *Observer) announcers(state *txState) []*network.Peer {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]*network.Peer(nil), state.peers...)
+}
+
+func (o *Observer) fetch(state *txState) {
+	defer o.fetchWait.Done()
+	select {
+	case o.fetchSem <- struct{}{}:
+		defer func() { <-o.fetchSem }()
+	case <-o.stopChan:
+		return
+	}
+
+	tx := state.tx
+	var bundle *types.SpendBundle
+	var err error
+	for i := 0; i < o.cfg.FetchAttempts; i++ {
+		peers := o.announcers(state)
+		if i >= len(peers) {
+			break
+		}
+		bundle, err = o.peers.RequestTransactionFrom(peers[i], tx.ID)
+		if err == nil && bundle.ID() != tx.ID {
+			gotID := bundle.ID()
+			o.peers.ReportBadData(peers[i], merry.Errorf("requested transaction %s, got %s",
+				hex.EncodeToString(tx.ID[:]), hex.EncodeToString(gotID[:])))
+			bundle, err = nil, merry.New("transaction ID mismatch")
+		}
+		if err == nil {
+			break
+		}
+	}
+
+	var info *SpendInfo
+	var decodeErr error
+	if err == nil {
+		info, decodeErr = AnalyzeSpendBundle(bundle)
+		if decodeErr != nil {
+			log.Printf("WARN: mempool: decoding %s: %s", hex.EncodeToString(tx.ID[:]), decodeErr)
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	state.fetched = true
+	tx.Bundle = bundle
+	tx.Info = info
+	tx.FetchErr = err
+	tx.DecodeErr = decodeErr
+}
+
+// popReady returns fetched transactions tracked for at least TrackDuration
+// and forgets emitted ones which can no longer be re-announced.
+func (o *Observer) popReady(now time.Time) []*Transaction {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	var res []*Transaction
+	for id, state := range o.txs {
+		age := now.Sub(state.createdAt)
+		if !state.emitted && state.fetched && age >= o.cfg.TrackDuration {
+			state.emitted = true
+			state.peers = nil
+			res = append(res, state.tx)
+		}
+		if state.emitted && age >= 10*o.cfg.TrackDuration {
+			delete(o.txs, id)
+		}
+	}
+	return res
+}
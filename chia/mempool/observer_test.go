@@ -0,0 +1,83 @@
+package mempool
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/network/nettest"
+	"chiastat/chia/types"
+	"testing"
+	"time"
+)
+
+func TestObserver(t *testing.T) {
+	ca, err := nettest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := testBundle(t, "((51 "+testPuzzleHash+" 900))")
+	announce := types.NewTransaction{TransactionID: bundle.ID(), Cost: 123, Fees: 100}
+
+	// first node announces transaction but does not respond to requests, second one does
+	silentNode, err := nettest.StartWithCA(ca, nettest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentNode.Close()
+	node, err := nettest.StartWithCA(ca, nettest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	node.On(types.MSG_REQUEST_TRANSACTION, nettest.Response{Message: types.RespondTransaction{Transaction: *bundle}})
+
+	tlsCert, err := ca.IssueTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := network.NewPeerManager(network.PeerManagerConfig{
+		TLSConfig:      network.MakeTSLConfig(ca.CertPool(), tlsCert),
+		RequestTimeout: 200 * time.Millisecond,
+	})
+	peers.AddAddress(silentNode.Addr())
+	peers.AddAddress(node.Addr())
+	peers.Start()
+	defer peers.Stop()
+	if err := peers.WaitForPeers(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	observer := NewObserver(peers, ObserverConfig{TrackDuration: 500 * time.Millisecond})
+	out := make(chan *Transaction, 1)
+	done := make(chan struct{})
+	go func() {
+		observer.Run(out)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond) //subscribing
+	silentNode.Broadcast(announce)
+	time.Sleep(50 * time.Millisecond)
+	node.Broadcast(announce)
+
+	select {
+	case tx := <-out:
+		if tx.ID != bundle.ID() || tx.FetchErr != nil || tx.DecodeErr != nil || tx.Info == nil || tx.Info.Fees != 100 {
+			t.Errorf("unexpected transaction: %#v", tx)
+		}
+		delays := tx.Delays()
+		if len(delays) != 2 || delays[silentNode.Addr()] != 0 || delays[node.Addr()] < 40*time.Millisecond {
+			t.Errorf("unexpected delays: %v", delays)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction was not observed")
+	}
+
+	// same transaction is not reported again
+	node.Broadcast(announce)
+	select {
+	case tx := <-out:
+		t.Errorf("unexpected second transaction: %#v", tx)
+	case <-time.After(time.Second):
+	}
+
+	observer.Stop()
+	<-done
+}
@@ -0,0 +1,193 @@
+package mempool
+
+import (
+	"chiastat/chia/clvm"
+	"chiastat/chia/types"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/ansel1/merry"
+)
+
+// https://github.com/Chia-Network/chia-blockchain/blob/main/chia/types/condition_opcodes.py
+const (
+	COND_REMARK                     = 1
+	COND_AGG_SIG_UNSAFE             = 49
+	COND_AGG_SIG_ME                 = 50
+	COND_CREATE_COIN                = 51
+	COND_RESERVE_FEE                = 52
+	COND_CREATE_COIN_ANNOUNCEMENT   = 60
+	COND_ASSERT_COIN_ANNOUNCEMENT   = 61
+	COND_CREATE_PUZZLE_ANNOUNCEMENT = 62
+	COND_ASSERT_PUZZLE_ANNOUNCEMENT = 63
+	COND_ASSERT_MY_COIN_ID          = 70
+	COND_ASSERT_MY_PARENT_ID        = 71
+	COND_ASSERT_MY_PUZZLEHASH       = 72
+	COND_ASSERT_MY_AMOUNT           = 73
+	COND_ASSERT_SECONDS_RELATIVE    = 80
+	COND_ASSERT_SECONDS_ABSOLUTE    = 81
+	COND_ASSERT_HEIGHT_RELATIVE     = 82
+	COND_ASSERT_HEIGHT_ABSOLUTE     = 83
+)
+
+// https://github.com/Chia-Network/chia-blockchain/blob/main/chia/types/condition_costs.py
+// https://github.com/Chia-Network/chia-blockchain/blob/main/chia/consensus/default_constants.py
+const AGG_SIG_COST = 1200000
+const CREATE_COIN_COST = 1800000
+const COST_PER_BYTE = 12000
+
+var ErrPuzzleHashMismatch = merry.New("puzzle reveal does not match coin puzzle hash")
+var ErrBadCondition = merry.New("malformed condition")
+var ErrNegativeFee = merry.New("spend bundle creates more than it spends")
+
+// SpendInfo is a result of spend bundle puzzles evaluation.
+//
+// Cost is close to (but not exactly) the upstream one: puzzles are run separately
+// instead of as a single block generator. NewTransaction.Cost announced by the peer is the authoritative value.
+type SpendInfo struct {
+	Removals       []types.Coin
+	Additions      []types.Coin
+	ReservedFee    uint64
+	Fees           uint64
+	ExecutionCost  uint64
+	ConditionsCost uint64
+	SizeCost       uint64
+}
+
+func (s *SpendInfo) Cost() uint64 {
+	return s.ExecutionCost + s.ConditionsCost + s.SizeCost
+}
+
+// FeePerCost returns mojos per cost unit, zero for zero-cost bundle.
+func (s *SpendInfo) FeePerCost() float64 {
+	cost := s.Cost()
+	if cost == 0 {
+		return 0
+	}
+	return float64(s.Fees) / float64(cost)
+}
+
+// AnalyzeSpendBundle runs every coin puzzle with its solution and collects created coins and fees.
+// Signatures are NOT verified.
+func AnalyzeSpendBundle(bundle *types.SpendBundle) (*SpendInfo, error) {
+	info := &SpendInfo{SizeCost: uint64(generatorSize(bundle)) * COST_PER_BYTE}
+	var totalIn, totalOut uint64
+
+	for _, spend := range bundle.CoinSolutions {
+		coin := spend.Coin
+		coinID := coin.ID()
+		if clvm.TreeHash(spend.PuzzleReveal.Root) != coin.PuzzleHash {
+			return nil, ErrPuzzleHashMismatch.Here().WithValue("coin", hex.EncodeToString(coinID[:]))
+		}
+		cost, conds, err := clvm.RunProgram(spend.PuzzleReveal.Root, spend.Solution.Root)
+		if err != nil {
+			return nil, merry.Prepend(err, "running puzzle of coin "+hex.EncodeToString(coinID[:]))
+		}
+		info.ExecutionCost += uint64(cost)
+		info.Removals = append(info.Removals, coin)
+		totalIn += coin.Amount
+
+		condIter := clvm.NewIter(conds)
+		for condIter.Next() {
+			cond := condIter.Get()
+			args := condAtoms(cond)
+			if len(args) == 0 {
+				return nil, ErrBadCondition.Here().WithValue("cond", cond.String())
+			}
+			if len(args[0].Bytes) != 1 {
+				continue
+			}
+			switch args[0].Bytes[0] {
+			case COND_AGG_SIG_UNSAFE, COND_AGG_SIG_ME:
+				info.ConditionsCost += AGG_SIG_COST
+			case COND_CREATE_COIN:
+				addition, _, err := CreatedCoin(cond, coinID)
+				if err != nil {
+					return nil, merry.Wrap(err)
+				}
+				info.Additions = append(info.Additions, addition)
+				info.ConditionsCost += CREATE_COIN_COST
+				totalOut += addition.Amount
+			case COND_RESERVE_FEE:
+				if len(args) < 2 {
+					return nil, ErrBadCondition.Here().WithValue("cond", cond.String())
+				}
+				amount, ok := condAmount(args[1])
+				if !ok {
+					return nil, ErrBadCondition.Here().WithValue("cond", cond.String())
+				}
+				info.ReservedFee += amount
+			}
+		}
+		if err := condIter.Err(); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+
+	if totalOut > totalIn {
+		return nil, ErrNegativeFee.Here()
+	}
+	info.Fees = totalIn - totalOut
+	return info, nil
+}
+
+// CreatedCoin returns coin created by CREATE_COIN condition, false for other conditions.
+func CreatedCoin(cond clvm.SExp, parentID [32]byte) (types.Coin, bool, error) {
+	args := condAtoms(cond)
+	if len(args) == 0 || len(args[0].Bytes) != 1 || args[0].Bytes[0] != COND_CREATE_COIN {
+		return types.Coin{}, false, nil
+	}
+	if len(args) < 3 || len(args[1].Bytes) != 32 {
+		return types.Coin{}, false, ErrBadCondition.Here().WithValue("cond", cond.String())
+	}
+	amount, ok := condAmount(args[2])
+	if !ok {
+		return types.Coin{}, false, ErrBadCondition.Here().WithValue("cond", cond.String())
+	}
+	coin := types.Coin{ParentCoinInfo: parentID, Amount: amount}
+	copy(coin.PuzzleHash[:], args[1].Bytes)
+	return coin, true, nil
+}
+
+func condAmount(atom clvm.Atom) (uint64, bool) {
+	v := atom.AsInt()
+	if v.Sign() < 0 || !v.IsUint64() {
+		return 0, false
+	}
+	return v.Uint64(), true
+}
+
+// condAtoms returns condition opcode and leading atom arguments
+// (conditions may have extra list arguments, like memos, which are not needed here).
+func condAtoms(cond clvm.SExp) []clvm.Atom {
+	var atoms []clvm.Atom
+	for {
+		pair, ok := cond.(clvm.Pair)
+		if !ok {
+			return atoms
+		}
+		atom, ok := pair.First.(clvm.Atom)
+		if !ok {
+			return atoms
+		}
+		atoms = append(atoms, atom)
+		cond = pair.Rest
+	}
+}
+
+// generatorSize returns size of the block generator upstream builds for a single spend bundle
+// (simple_solution_generator), cost of its bytes is included in the transaction cost.
+func generatorSize(bundle *types.SpendBundle) int {
+	size := 1 + 1 + 1 + 1 // (q . (list...))
+	for _, spend := range bundle.CoinSolutions {
+		var amount []byte
+		clvm.SerializeAtomBytes(&amount, clvm.AtomFromInt(new(big.Int).SetUint64(spend.Coin.Amount)).Bytes)
+		size += 1 + // entry cons
+			1 + 33 + // parent coin ID
+			1 + len(spend.PuzzleReveal.Bytes) +
+			1 + len(amount) +
+			1 + len(spend.Solution.Bytes) +
+			1 // entry list end
+	}
+	return size + 1 // list end
+}
@@ -0,0 +1,75 @@
+package mempool
+
+import (
+	"chiastat/chia/clvm"
+	"chiastat/chia/types"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ansel1/merry"
+)
+
+func testProgram(t *testing.T, irStr string) types.SerializedProgram {
+	t.Helper()
+	sexp, err := clvm.SExpFromIRString(irStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.SerializedProgram{Root: sexp, Bytes: sexp.Dump()}
+}
+
+// testBundle spends 1000-mojo coin with identity puzzle (returns solution as conditions)
+// creating 900-mojo coin, so 100 mojos are left as fee.
+func testBundle(t *testing.T, conditions string) *types.SpendBundle {
+	puzzle := testProgram(t, "1")
+	coin := types.Coin{PuzzleHash: clvm.TreeHash(puzzle.Root), Amount: 1000}
+	coin.ParentCoinInfo[0] = 1
+	return &types.SpendBundle{
+		CoinSolutions: []types.CoinSolution{{
+			Coin:         coin,
+			PuzzleReveal: puzzle,
+			Solution:     testProgram(t, conditions),
+		}},
+		AggregatedSignature: types.G2Element{Bytes: make([]byte, 96)},
+	}
+}
+
+const testPuzzleHash = "0xcafecafecafecafecafecafecafecafecafecafecafecafecafecafecafecafe"
+
+func TestAnalyzeSpendBundle(t *testing.T) {
+	bundle := testBundle(t, "((50 0x1234 0x5678) (51 "+testPuzzleHash+" 900 (0xabcd)) (52 50))")
+	info, err := AnalyzeSpendBundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Fees != 100 || info.ReservedFee != 50 {
+		t.Errorf("unexpected fees: %d, reserved %d", info.Fees, info.ReservedFee)
+	}
+	if len(info.Removals) != 1 || len(info.Additions) != 1 {
+		t.Fatalf("unexpected coins: %#v", info)
+	}
+	addition := info.Additions[0]
+	if addition.ParentCoinInfo != bundle.CoinSolutions[0].Coin.ID() ||
+		hex.EncodeToString(addition.PuzzleHash[:]) != testPuzzleHash[2:] || addition.Amount != 900 {
+		t.Errorf("unexpected addition: %#v", addition)
+	}
+	if info.ConditionsCost != AGG_SIG_COST+CREATE_COIN_COST {
+		t.Errorf("unexpected conditions cost: %d", info.ConditionsCost)
+	}
+	if info.ExecutionCost == 0 || info.SizeCost == 0 || info.FeePerCost() != 100/float64(info.Cost()) {
+		t.Errorf("unexpected costs: %#v", info)
+	}
+
+	bundle.CoinSolutions[0].Coin.PuzzleHash[0] ^= 1
+	if _, err := AnalyzeSpendBundle(bundle); !merry.Is(err, ErrPuzzleHashMismatch) {
+		t.Errorf("expected puzzle hash error, got %v", err)
+	}
+	bundle = testBundle(t, "((51 "+testPuzzleHash+" 1001))")
+	if _, err := AnalyzeSpendBundle(bundle); !merry.Is(err, ErrNegativeFee) {
+		t.Errorf("expected negative fee error, got %v", err)
+	}
+	bundle = testBundle(t, "((51 0xcafe 1))")
+	if _, err := AnalyzeSpendBundle(bundle); !merry.Is(err, ErrBadCondition) {
+		t.Errorf("expected bad condition error, got %v", err)
+	}
+}
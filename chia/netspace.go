@@ -0,0 +1,267 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// same as upstream UI: 4608 blocks is about one day
+const DEFAULT_SPACE_WINDOW_BLOCKS = 4608
+
+var SPACE_UNITS = map[string]int64{
+	"B":   1,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"PiB": 1 << 50,
+	"EiB": 1 << 60,
+}
+
+type NetSpaceSeriesConfig struct {
+	// Points are made either for every BucketDuration (hour, day, week; buckets are aligned to UTC)
+	// or for every BucketBlocks blocks. One day if both are zero.
+	BucketDuration time.Duration
+	BucketBlocks   uint32
+	// Space is estimated over this number of blocks ending at the bucket's last block, DEFAULT_SPACE_WINDOW_BLOCKS if zero.
+	WindowBlocks uint32
+	StartHeight  uint32
+	// Peak if zero.
+	EndHeight uint32
+}
+
+// ParseBucket sets bucket from "hour", "day", "week" or "<N>blocks" string.
+func (c *NetSpaceSeriesConfig) ParseBucket(str string) (err error) {
+	c.BucketDuration, c.BucketBlocks, err = parseBucket(str)
+	return merry.Wrap(err)
+}
+
+func parseBucket(str string) (time.Duration, uint32, error) {
+	switch str {
+	case "hour":
+		return time.Hour, 0, nil
+	case "day":
+		return 24 * time.Hour, 0, nil
+	case "week":
+		return 7 * 24 * time.Hour, 0, nil
+	default:
+		count, err := strconv.ParseUint(strings.TrimSuffix(str, "blocks"), 10, 32)
+		if err != nil || count == 0 || !strings.HasSuffix(str, "blocks") {
+			return 0, 0, merry.Errorf(`unexpected bucket "%s", expected "hour", "day", "week" or "<N>blocks"`, str)
+		}
+		return 0, uint32(count), nil
+	}
+}
+
+// bucketer splits consecutive blocks into buckets either by time (aligned to UTC) or by height.
+type bucketer struct {
+	duration time.Duration
+	blocks   uint32
+	curKey   int64
+}
+
+// newBucketer makes time buckets of duration or, if blocks is not zero, buckets of blocks heights.
+func newBucketer(duration time.Duration, blocks uint32) *bucketer {
+	return &bucketer{duration: duration, blocks: blocks, curKey: -1}
+}
+
+// next returns bucket start for the block (block time itself for block buckets)
+// and whether the block opens a new bucket.
+func (b *bucketer) next(height uint32, stamp time.Time) (time.Time, bool) {
+	var key int64
+	var start time.Time
+	if b.blocks > 0 {
+		key = int64(height / b.blocks)
+		start = stamp
+	} else {
+		start = stamp.Truncate(b.duration)
+		key = start.Unix()
+	}
+	isNew := key != b.curKey
+	b.curKey = key
+	return start, isNew
+}
+
+type NetSpacePoint struct {
+	// Bucket start time for time buckets, first block time for block buckets.
+	BucketStart time.Time
+	// Time of the last block in bucket.
+	Time        time.Time
+	StartHeight uint32
+	EndHeight   uint32
+	// Estimated space in bytes.
+	Space *big.Int
+	// Last bucket may be not finished yet.
+	Partial bool
+}
+
+func (p NetSpacePoint) BlocksCount() uint32 {
+	return p.EndHeight - p.StartHeight + 1
+}
+
+// SpaceIn returns space in units of unitSize bytes.
+func (p NetSpacePoint) SpaceIn(unitSize int64) float64 {
+	res, _ := new(big.Float).Quo(new(big.Float).SetInt(p.Space), big.NewFloat(float64(unitSize))).Float64()
+	return res
+}
+
+// NetworkSpaceSeries returns network space estimates for consecutive buckets of blocks.
+//
+// Only transaction blocks have timestamps, other blocks get the previous timestamp (plus few ms
+// to keep time increasing), so time buckets are split at transaction blocks.
+func NetworkSpaceSeries(src BlockSource, cfg NetSpaceSeriesConfig) ([]NetSpacePoint, error) {
+	if cfg.BucketDuration == 0 && cfg.BucketBlocks == 0 {
+		cfg.BucketDuration = 24 * time.Hour
+	}
+	if cfg.WindowBlocks == 0 {
+		cfg.WindowBlocks = DEFAULT_SPACE_WINDOW_BLOCKS
+	}
+	if cfg.EndHeight == 0 {
+		cfg.EndHeight = math.MaxUint32
+	}
+
+	readFrom := uint32(0)
+	if cfg.StartHeight > cfg.WindowBlocks {
+		readFrom = cfg.StartHeight - cfg.WindowBlocks
+	}
+
+	// range may start with non-transaction block, its time is taken from previous blocks
+	prevStamp, err := lastTimestampBefore(src, readFrom)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	prevStampMS := int64(0)
+	if !prevStamp.IsZero() {
+		prevStampMS = prevStamp.UnixNano() / int64(time.Millisecond)
+	}
+
+	var points []NetSpacePoint
+	blocks := NewRingBuf(int(cfg.WindowBlocks) + 1)
+	var cur *NetSpacePoint
+	buckets := newBucketer(cfg.BucketDuration, cfg.BucketBlocks)
+
+	finishBucket := func() {
+		if cur == nil || len(blocks.items) < 2 {
+			return
+		}
+		windowStart := blocks.First().(StampedRecord)
+		windowEnd := blocks.Last().(StampedRecord)
+		cur.Space = EstimateNetworkSpace(windowStart.Block, windowEnd.Block)
+		points = append(points, *cur)
+	}
+
+	err = src.ForEachBlockRecord(readFrom, cfg.EndHeight, func(br *types.BlockRecord) error {
+		stampMS := prevStampMS + 312
+		if br.Timestamp != 0 {
+			stampMS = int64(br.Timestamp) * 1000
+		}
+		prevStampMS = stampMS
+		stamp := time.Unix(0, stampMS*int64(time.Millisecond)).UTC()
+
+		if br.Height >= cfg.StartHeight {
+			if bucketStart, isNew := buckets.next(br.Height, stamp); isNew {
+				finishBucket()
+				cur = &NetSpacePoint{BucketStart: bucketStart, StartHeight: br.Height}
+			}
+			cur.Time = stamp
+			cur.EndHeight = br.Height
+		}
+
+		blocks.Add(StampedRecord{br, stampMS / 1000})
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cur != nil {
+		// there is no way to know whether the last time bucket will get more blocks
+		cur.Partial = cfg.BucketBlocks == 0 || cur.BlocksCount() < cfg.BucketBlocks
+	}
+	finishBucket()
+	return points, nil
+}
+
+// PrintNetworkSpaceSeries writes points in "csv", "json" (array of objects) or "table" format,
+// space is written in the given unit (see SPACE_UNITS).
+func PrintNetworkSpaceSeries(out io.Writer, points []NetSpacePoint, format, unit string) error {
+	unitSize, ok := SPACE_UNITS[unit]
+	if !ok {
+		return merry.Errorf("unknown unit: %s", unit)
+	}
+	const timeFmt = "2006-01-02 15:04:05"
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"bucket_start", "time", "start_height", "end_height", "blocks", "space_" + strings.ToLower(unit), "partial"})
+		for _, p := range points {
+			w.Write([]string{
+				p.BucketStart.Format(time.RFC3339), p.Time.Format(time.RFC3339),
+				strconv.FormatUint(uint64(p.StartHeight), 10), strconv.FormatUint(uint64(p.EndHeight), 10),
+				strconv.FormatUint(uint64(p.BlocksCount()), 10),
+				strconv.FormatFloat(p.SpaceIn(unitSize), 'f', 3, 64),
+				strconv.FormatBool(p.Partial),
+			})
+		}
+		w.Flush()
+		return merry.Wrap(w.Error())
+	case "json":
+		type jsonPoint struct {
+			BucketStart time.Time `json:"bucket_start"`
+			Time        time.Time `json:"time"`
+			StartHeight uint32    `json:"start_height"`
+			EndHeight   uint32    `json:"end_height"`
+			Blocks      uint32    `json:"blocks"`
+			SpaceBytes  *big.Int  `json:"space_bytes"`
+			Space       float64   `json:"space"`
+			Unit        string    `json:"unit"`
+			Partial     bool      `json:"partial"`
+		}
+		res := make([]jsonPoint, len(points))
+		for i, p := range points {
+			res[i] = jsonPoint{p.BucketStart, p.Time, p.StartHeight, p.EndHeight, p.BlocksCount(),
+				p.Space, p.SpaceIn(unitSize), unit, p.Partial}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return merry.Wrap(enc.Encode(res))
+	case "table":
+		if _, err := fmt.Fprintf(out, "%-19s  %-19s  %8s  %6s  %10s  %7s\n",
+			"bucket start", "last block", "height", "blocks", unit, "change"); err != nil {
+			return merry.Wrap(err)
+		}
+		for i, p := range points {
+			space := p.SpaceIn(unitSize)
+			change, bar := "", ""
+			if i > 0 {
+				prev := points[i-1].SpaceIn(unitSize)
+				if prev > 0 {
+					change = fmt.Sprintf("%+6.1f%%", (space-prev)*100/prev)
+					if space > prev {
+						bar = strings.Repeat("*", int((space-prev)*300/prev))
+					}
+				}
+			}
+			if p.Partial {
+				bar += " (partial)"
+			}
+			_, err := fmt.Fprintf(out, "%-19s  %-19s  %8d  %6d  %10.2f  %7s  %s\n",
+				p.BucketStart.Format(timeFmt), p.Time.Format(timeFmt),
+				p.EndHeight, p.BlocksCount(), space, change, bar)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	default:
+		return merry.Errorf(`unexpected format "%s", expected "csv", "json" or "table"`, format)
+	}
+}
@@ -0,0 +1,110 @@
+package chia
+
+import (
+	"bytes"
+	"chiastat/chia/types"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestNetworkSpaceSeries(t *testing.T) {
+	blocks, records := testSourceBlocks(25, 1)
+	src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	points, err := NetworkSpaceSeries(src, NetSpaceSeriesConfig{BucketBlocks: 10, WindowBlocks: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("expected 3 points, got %d", len(points))
+	}
+	for i, p := range points {
+		start, end := uint32(i*10), uint32(i*10+9)
+		if end > 24 {
+			end = 24
+		}
+		if p.StartHeight != start || p.EndHeight != end || p.Partial != (i == 2) {
+			t.Errorf("point %d: unexpected range %d-%d partial=%t", i, p.StartHeight, p.EndHeight, p.Partial)
+		}
+		expected := EstimateNetworkSpace(&records[end-5], &records[end])
+		if p.Space.Cmp(expected) != 0 {
+			t.Errorf("point %d: expected space %s, got %s", i, expected, p.Space)
+		}
+	}
+
+	// timestamps start at 12:26:40 with 18s step
+	points, err = NetworkSpaceSeries(src, NetSpaceSeriesConfig{BucketDuration: 2 * time.Minute, StartHeight: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 4 {
+		t.Fatalf("expected 4 points, got %d", len(points))
+	}
+	if points[0].StartHeight != 3 || points[0].EndHeight != 4 || points[1].StartHeight != 5 || points[1].EndHeight != 11 {
+		t.Errorf("unexpected points: %+v", points[:2])
+	}
+	if points[1].BucketStart != time.Date(2020, 9, 13, 12, 28, 0, 0, time.UTC) {
+		t.Errorf("unexpected bucket start: %s", points[1].BucketStart)
+	}
+	if points[0].Partial || !points[3].Partial {
+		t.Errorf("only the last point should be partial")
+	}
+
+	// reading starts at non-transaction blocks
+	noStampRecords := append([]types.BlockRecord(nil), records...)
+	noStampRecords[4].Timestamp = 0
+	noStampRecords[5].Timestamp = 0
+	noStampSrc, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, noStampRecords, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noStampSrc.Close()
+	dayPoints, err := NetworkSpaceSeries(noStampSrc, NetSpaceSeriesConfig{BucketDuration: 24 * time.Hour, StartHeight: 5, EndHeight: 6, WindowBlocks: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dayPoints) != 1 || dayPoints[0].BucketStart != time.Date(2020, 9, 13, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("unexpected points: %+v", dayPoints)
+	}
+
+	var buf bytes.Buffer
+	if err := PrintNetworkSpaceSeries(&buf, points, "csv", "TiB"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || rows[0][5] != "space_tib" || rows[2][2] != "5" {
+		t.Errorf("unexpected csv: %v", rows)
+	}
+}
+
+func TestBucketer(t *testing.T) {
+	day := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	stamps := []time.Time{day.Add(23 * time.Hour), day.Add(23*time.Hour + 30*time.Minute), day.Add(25 * time.Hour)}
+
+	b := newBucketer(24*time.Hour, 0)
+	for i, expected := range []struct {
+		start time.Time
+		isNew bool
+	}{{day, true}, {day, false}, {day.Add(24 * time.Hour), true}} {
+		start, isNew := b.next(uint32(i), stamps[i])
+		if !start.Equal(expected.start) || isNew != expected.isNew {
+			t.Errorf("time bucket %d: expected %s %t, got %s %t", i, expected.start, expected.isNew, start, isNew)
+		}
+	}
+
+	b = newBucketer(0, 2)
+	for i, isNewExpected := range []bool{true, false, true} {
+		start, isNew := b.next(uint32(i+2), stamps[i])
+		if !start.Equal(stamps[i]) || isNew != isNewExpected {
+			t.Errorf("block bucket %d: expected %s %t, got %s %t", i, stamps[i], isNewExpected, start, isNew)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DEFAULT_MIN_BACKOFF = 10 * time.Second
+const DEFAULT_MAX_BACKOFF = 30 * time.Minute
+
+type knownAddr struct {
+	address     string
+	lastSeen    time.Time
+	failures    int
+	nextAttempt time.Time
+	inUse       bool
+}
+
+// AddressBook keeps known peer addresses (host:port) with their connection history.
+// Addresses that failed are not returned by Pick for an exponentially growing backoff duration.
+type AddressBook struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	addrs      map[string]*knownAddr
+	mutex      sync.Mutex
+}
+
+// NewAddressBook creates address book, zero backoffs are replaced by DEFAULT_MIN_BACKOFF/DEFAULT_MAX_BACKOFF.
+func NewAddressBook(minBackoff, maxBackoff time.Duration) *AddressBook {
+	if minBackoff == 0 {
+		minBackoff = DEFAULT_MIN_BACKOFF
+	}
+	if maxBackoff == 0 {
+		maxBackoff = DEFAULT_MAX_BACKOFF
+	}
+	return &AddressBook{
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		addrs:      make(map[string]*knownAddr),
+	}
+}
+
+func (b *AddressBook) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.addrs)
+}
+
+// Add adds new address or updates last seen time of an existing one.
+func (b *AddressBook) Add(address string, lastSeen time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if addr, ok := b.addrs[address]; ok {
+		if lastSeen.After(addr.lastSeen) {
+			addr.lastSeen = lastSeen
+		}
+		return
+	}
+	b.addrs[address] = &knownAddr{address: address, lastSeen: lastSeen}
+}
+
+func (b *AddressBook) AddPeers(peers []types.TimestampedPeerInfo) {
+	for _, peer := range peers {
+		address := net.JoinHostPort(peer.Host, strconv.Itoa(int(peer.Port)))
+		b.Add(address, time.Unix(int64(peer.Timestamp), 0))
+	}
+}
+
+// Pick returns an address that is not in use and is not backing off,
+// preferring ones with fewer failures and then most recently seen.
+// Returned address is marked as in use until Release or MarkFailure.
+func (b *AddressBook) Pick(now time.Time) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var best *knownAddr
+	for _, addr := range b.addrs {
+		if addr.inUse || now.Before(addr.nextAttempt) {
+			continue
+		}
+		if best == nil ||
+			addr.failures < best.failures ||
+			(addr.failures == best.failures && addr.lastSeen.After(best.lastSeen)) {
+			best = addr
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	best.inUse = true
+	return best.address, true
+}
+
+// MarkSuccess resets failures counter (connection and handshake succeeded).
+func (b *AddressBook) MarkSuccess(address string, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if addr, ok := b.addrs[address]; ok {
+		addr.failures = 0
+		addr.lastSeen = now
+	}
+}
+
+// MarkFailure releases address and delays next attempt: minBackoff * 2^(failures-1), up to maxBackoff.
+func (b *AddressBook) MarkFailure(address string, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	addr, ok := b.addrs[address]
+	if !ok {
+		return
+	}
+	addr.inUse = false
+	addr.failures += 1
+	backoff := b.minBackoff
+	for i := 1; i < addr.failures && backoff < b.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > b.maxBackoff {
+		backoff = b.maxBackoff
+	}
+	addr.nextAttempt = now.Add(backoff)
+}
+
+// Release makes address available again (after minBackoff, to avoid instant reconnects).
+func (b *AddressBook) Release(address string, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if addr, ok := b.addrs[address]; ok {
+		addr.inUse = false
+		addr.nextAttempt = now.Add(b.minBackoff)
+	}
+}
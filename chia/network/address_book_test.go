@@ -0,0 +1,64 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"testing"
+	"time"
+)
+
+func TestAddressBook(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	assertPick := func(b *AddressBook, expected string) {
+		t.Helper()
+		address, ok := b.Pick(now)
+		if expected == "" {
+			if ok {
+				t.Errorf("expected no address, got %s", address)
+			}
+		} else if address != expected {
+			t.Errorf("expected %s, got %s (ok=%v)", expected, address, ok)
+		}
+	}
+
+	b := NewAddressBook(10*time.Second, 35*time.Second)
+	b.AddPeers([]types.TimestampedPeerInfo{
+		{Host: "1.1.1.1", Port: 8444, Timestamp: uint64(now.Unix()) - 100},
+		{Host: "2.2.2.2", Port: 8444, Timestamp: uint64(now.Unix()) - 10},
+	})
+	b.Add("2.2.2.2:8444", now.Add(-time.Hour)) //older timestamp is ignored
+	if b.Len() != 2 {
+		t.Fatalf("expected 2 addresses, got %d", b.Len())
+	}
+
+	// most recently seen first, picked addresses are in use
+	assertPick(b, "2.2.2.2:8444")
+	assertPick(b, "1.1.1.1:8444")
+	assertPick(b, "")
+
+	// failed address backs off, others with fewer failures are preferred
+	b.MarkFailure("2.2.2.2:8444", now)
+	b.Release("1.1.1.1:8444", now)
+	now = now.Add(9 * time.Second)
+	assertPick(b, "")
+	now = now.Add(time.Second)
+	assertPick(b, "1.1.1.1:8444")
+	assertPick(b, "2.2.2.2:8444")
+
+	// backoff grows exponentially up to max
+	b.MarkFailure("2.2.2.2:8444", now)
+	now = now.Add(19 * time.Second)
+	assertPick(b, "")
+	now = now.Add(time.Second)
+	assertPick(b, "2.2.2.2:8444")
+	b.MarkFailure("2.2.2.2:8444", now)
+	now = now.Add(34 * time.Second)
+	assertPick(b, "")
+	now = now.Add(time.Second)
+	assertPick(b, "2.2.2.2:8444")
+
+	// success resets failures
+	b.MarkSuccess("2.2.2.2:8444", now)
+	b.MarkFailure("2.2.2.2:8444", now)
+	now = now.Add(10 * time.Second)
+	assertPick(b, "2.2.2.2:8444")
+}
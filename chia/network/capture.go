@@ -0,0 +1,261 @@
+package network
+
+import (
+	"bufio"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Capture file: CAPTURE_MAGIC followed by records:
+//
+//	uint64   timestamp (unix nanoseconds)
+//	uint8    direction
+//	[32]byte peer ID
+//	uint32   message size
+//	[]byte   serialized types.Message
+//
+// All numbers are big-endian (like in chia streamables).
+const CAPTURE_MAGIC = "CHIACAP1"
+
+const captureRecordHeaderSize = 8 + 1 + 32 + 4
+
+type CaptureDirection uint8
+
+const (
+	CaptureInbound  CaptureDirection = 0
+	CaptureOutbound CaptureDirection = 1
+)
+
+func (d CaptureDirection) String() string {
+	if d == CaptureOutbound {
+		return "out"
+	}
+	return "in"
+}
+
+type CaptureRecord struct {
+	Time      time.Time
+	Direction CaptureDirection
+	PeerID    [32]byte
+	Message   types.Message
+}
+
+// MessageRecorder receives every message sent or received by connection (including handshakes).
+// Must be safe for concurrent use.
+type MessageRecorder interface {
+	RecordMessage(c *WSChiaConnection, direction CaptureDirection, msg types.Message)
+}
+
+type CaptureWriter struct {
+	w      *bufio.Writer
+	closer io.Closer
+	mutex  sync.Mutex
+	err    error
+}
+
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(CAPTURE_MAGIC); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return &CaptureWriter{w: bw}, nil
+}
+
+// CreateCaptureFile creates (or truncates) capture file.
+func CreateCaptureFile(fpath string) (*CaptureWriter, error) {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	w, err := NewCaptureWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, merry.Wrap(err)
+	}
+	w.closer = f
+	return w, nil
+}
+
+// WriteRecord appends record and flushes it, so capture is readable while it is being written.
+func (w *CaptureWriter) WriteRecord(rec CaptureRecord) error {
+	msgBuf := utils.ToByteSlice(rec.Message)
+	buf := make([]byte, captureRecordHeaderSize, captureRecordHeaderSize+len(msgBuf))
+	binary.BigEndian.PutUint64(buf[0:], uint64(rec.Time.UnixNano()))
+	buf[8] = uint8(rec.Direction)
+	copy(buf[9:], rec.PeerID[:])
+	binary.BigEndian.PutUint32(buf[41:], uint32(len(msgBuf)))
+	buf = append(buf, msgBuf...)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.w.Write(buf); err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(w.w.Flush())
+}
+
+// RecordMessage implements MessageRecorder. Only the first write error is logged.
+func (w *CaptureWriter) RecordMessage(c *WSChiaConnection, direction CaptureDirection, msg types.Message) {
+	err := w.WriteRecord(CaptureRecord{
+		Time:      time.Now(),
+		Direction: direction,
+		PeerID:    c.PeerID(),
+		Message:   msg,
+	})
+	if err != nil {
+		w.mutex.Lock()
+		if w.err == nil {
+			w.err = err
+			log.Printf("WARN: capture write error: %s", err)
+		}
+		w.mutex.Unlock()
+	}
+}
+
+func (w *CaptureWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.w.Flush(); err != nil {
+		return merry.Wrap(err)
+	}
+	if w.closer != nil {
+		return merry.Wrap(w.closer.Close())
+	}
+	return nil
+}
+
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(CAPTURE_MAGIC))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if string(magic) != CAPTURE_MAGIC {
+		return nil, merry.Errorf("not a capture file: unexpected header %q", magic)
+	}
+	return &CaptureReader{r: br}, nil
+}
+
+// Next returns next record or io.EOF if there are no more records.
+func (r *CaptureReader) Next() (*CaptureRecord, error) {
+	header := make([]byte, captureRecordHeaderSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, merry.Wrap(err)
+	}
+	rec := &CaptureRecord{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[0:]))),
+		Direction: CaptureDirection(header[8]),
+	}
+	copy(rec.PeerID[:], header[9:41])
+	msgBuf := make([]byte, binary.BigEndian.Uint32(header[41:]))
+	if _, err := io.ReadFull(r.r, msgBuf); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err := utils.FromByteSliceExact(msgBuf, &rec.Message); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return rec, nil
+}
+
+func ReadCaptureFile(fpath string) ([]CaptureRecord, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer f.Close()
+	r, err := NewCaptureReader(f)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	var records []CaptureRecord
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		records = append(records, *rec)
+	}
+	return records, nil
+}
+
+// ReplayCapture sends outbound messages of a capture (as we sent them to the recorded peer)
+// to another peer in the same order. Handshake messages and replies to peer's requests are skipped
+// (connection should be already established). For requests that got a response in capture,
+// replayed response type must match.
+func ReplayCapture(records []CaptureRecord, c *WSChiaConnection, timeout time.Duration) error {
+	responseTypes := make(map[int]uint8) //outbound request index -> response type
+	isReply := make(map[int]bool)
+	ourPending := make(map[uint16]int)
+	peerPending := make(map[uint16]bool)
+	for i, rec := range records {
+		id := rec.Message.ID
+		if id == 0 || rec.Message.Type == types.MSG_HANDSHAKE {
+			continue
+		}
+		if rec.Direction == CaptureOutbound {
+			if peerPending[id] {
+				isReply[i] = true
+				delete(peerPending, id)
+			} else {
+				ourPending[id] = i
+			}
+		} else {
+			if j, ok := ourPending[id]; ok {
+				responseTypes[j] = rec.Message.Type
+				delete(ourPending, id)
+			} else {
+				peerPending[id] = true
+			}
+		}
+	}
+
+	for i, rec := range records {
+		if rec.Direction != CaptureOutbound || rec.Message.Type == types.MSG_HANDSHAKE || isReply[i] {
+			continue
+		}
+		data, ok := types.MessageTypeStruct(rec.Message.Type)
+		if !ok {
+			return merry.Errorf("record #%d: unsupported message type %d", i, rec.Message.Type)
+		}
+		if err := utils.FromByteSliceExact(rec.Message.Data, data); err != nil {
+			return merry.Prependf(err, "record #%d", i)
+		}
+
+		expectedType, isRequest := responseTypes[i]
+		if !isRequest {
+			if err := c.Send(data); err != nil {
+				return merry.Prependf(err, "record #%d", i)
+			}
+			continue
+		}
+		resp, err := c.SendRequestSyncTimeout(data, timeout)
+		if err != nil {
+			return merry.Prependf(err, "record #%d", i)
+		}
+		if respType, _ := types.MessageTypeFromStruct(resp); respType != expectedType {
+			return merry.Errorf("record #%d: expected response of type %d, got %d", i, expectedType, respType)
+		}
+	}
+	return nil
+}
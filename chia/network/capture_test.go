@@ -0,0 +1,76 @@
+package network
+
+import (
+	"bytes"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// messages are recorded by connection
+	c := &WSChiaConnection{
+		peerID:          [32]byte{1, 2, 3},
+		pendingRequests: make(map[uint16]chan Result),
+		mutex:           &sync.Mutex{},
+		incoming:        make(chan incomingMessage, 1),
+		recorder:        w,
+	}
+	peersMsg := types.Message{
+		Type: types.MSG_RESPOND_PEERS,
+		ID:   5,
+		Data: utils.ToByteSlice(types.RespondPeers{PeerList: []types.TimestampedPeerInfo{{Host: "1.2.3.4", Port: 8444}}}),
+	}
+	if err := c.processMessageBytes(utils.ToByteSlice(peersMsg), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	stamp := time.Unix(1600000000, 123)
+	outRec := CaptureRecord{
+		Time:      stamp,
+		Direction: CaptureOutbound,
+		PeerID:    [32]byte{4, 5, 6},
+		Message:   types.Message{Type: types.MSG_REQUEST_PEERS, Data: []byte{}},
+	}
+	if err := w.WriteRecord(outRec); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Direction != CaptureInbound || rec.PeerID != c.peerID || !reflect.DeepEqual(rec.Message, peersMsg) {
+		t.Errorf("unexpected inbound record: %#v", rec)
+	}
+	if time.Since(rec.Time) > time.Minute {
+		t.Errorf("unexpected inbound record time: %s", rec.Time)
+	}
+	rec, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Time.Equal(stamp) || !reflect.DeepEqual(*rec, outRec) {
+		t.Errorf("unexpected outbound record: %#v", rec)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if _, err := NewCaptureReader(bytes.NewReader([]byte("NOTCAPTURE"))); err == nil {
+		t.Error("expected header error")
+	}
+}
@@ -0,0 +1,264 @@
+package network
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"embed"
+	"encoding/pem"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Certificates are generated like in upstream
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/ssl/create_ssl.py
+
+// Public chia_ca from upstream (chia/ssl/chia_ca.{crt,key}), see ssl/README.md.
+//
+//go:generate go run gen/fetch_chia_ca.go -out-dir ssl
+//go:embed ssl
+var embeddedSSL embed.FS
+
+// chiaCAFiles is replaced in tests.
+var chiaCAFiles fs.FS = embeddedSSL
+
+var ErrNoEmbeddedChiaCA = merry.New("chia_ca is not embedded into this build (run `go generate ./chia/network` and rebuild)")
+
+type CertAndKey struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	// same as cryptography's x509.random_serial_number(): 20 random bytes, shifted to be positive
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return new(big.Int).Rsh(new(big.Int).SetBytes(buf), 1), nil
+}
+
+func encodeCertAndKey(certDER []byte, key *rsa.PrivateKey) *CertAndKey {
+	return &CertAndKey{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+}
+
+// GenerateCA creates new self-signed CA (like chia_ca or private_ca), valid for 10 years.
+func GenerateCA() (*CertAndKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	now := time.Now().UTC()
+	name := pkix.Name{
+		Organization:       []string{"Chia"},
+		CommonName:         "Chia CA",
+		OrganizationalUnit: []string{"Organic Farming Division"},
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               name,
+		Issuer:                name,
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, 3650),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SignatureAlgorithm:    x509.SHA256WithRSA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return encodeCertAndKey(der, key), nil
+}
+
+// ParseCA parses CA certificate and its private key (PKCS#1, PKCS#8 or EC).
+func ParseCA(ca *CertAndKey) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(ca.CertPEM)
+	if certBlock == nil {
+		return nil, nil, merry.New("CA certificate: no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+
+	keyBlock, _ := pem.Decode(ca.KeyPEM)
+	if keyBlock == nil {
+		return nil, nil, merry.New("CA key: no PEM data found")
+	}
+	var key interface{}
+	if key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err != nil {
+		if key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(keyBlock.Bytes); err != nil {
+				return nil, nil, merry.Prepend(err, "CA key")
+			}
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, merry.Errorf("CA key: unsupported key type %T", key)
+	}
+	return cert, signer, nil
+}
+
+// GenerateCASignedCert creates node certificate (like public_full_node.crt) signed by the given CA.
+func GenerateCASignedCert(ca *CertAndKey) (*CertAndKey, error) {
+	caCert, caKey, err := ParseCA(ca)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         "Chia",
+			Organization:       []string{"Chia"},
+			OrganizationalUnit: []string{"Organic Farming Division"},
+		},
+		NotBefore:          time.Now().AddDate(0, 0, -1),
+		NotAfter:           time.Date(2100, 8, 2, 0, 0, 0, 0, time.UTC),
+		DNSNames:           []string{"chia.net"},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	if _, isRSA := caKey.(*rsa.PrivateKey); !isRSA {
+		tmpl.SignatureAlgorithm = x509.UnknownSignatureAlgorithm //let Go pick one for the key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return encodeCertAndKey(der, key), nil
+}
+
+// EmbeddedChiaCA returns well-known chia_ca embedded into the binary.
+func EmbeddedChiaCA() (*CertAndKey, error) {
+	certPEM, err := fs.ReadFile(chiaCAFiles, "ssl/chia_ca.crt")
+	if err != nil {
+		return nil, ErrNoEmbeddedChiaCA.Here()
+	}
+	keyPEM, err := fs.ReadFile(chiaCAFiles, "ssl/chia_ca.key")
+	if err != nil {
+		return nil, ErrNoEmbeddedChiaCA.Here()
+	}
+	return &CertAndKey{CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
+
+func ReadCertAndKey(certPath, keyPath string) (*CertAndKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return &CertAndKey{CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
+
+// WriteCertAndKey writes certificate (0644) and key (0600), creating directories if needed.
+func WriteCertAndKey(pair *CertAndKey, certPath, keyPath string) error {
+	for _, fpath := range []string{certPath, keyPath} {
+		if err := os.MkdirAll(filepath.Dir(fpath), 0700); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	if err := os.WriteFile(certPath, pair.CertPEM, 0644); err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(os.WriteFile(keyPath, pair.KeyPEM, 0600))
+}
+
+// SSLDirConfig describes what to put into chia-like ssl directory.
+type SSLDirConfig struct {
+	// CA for public_* certificates (ca/chia_ca.*). Real network peers accept only certificates
+	// signed by the well-known chia_ca (distributed with chia-blockchain).
+	// If nil, embedded chia_ca is used (see EmbeddedChiaCA).
+	ChiaCA *CertAndKey
+	// Use private CA as chia_ca (fine for own nodes and tests, but not for mainnet). ChiaCA is ignored.
+	PrivateChiaCA bool
+	// Node names to generate certificates for, only "full_node" if empty.
+	Nodes []string
+	// Overwrite existing files.
+	Overwrite bool
+}
+
+// GenerateSSLDir creates directory structure similar to upstream `chia init`:
+//
+//	ca/private_ca.{crt,key}, ca/chia_ca.{crt,key},
+//	<node>/private_<node>.{crt,key} (signed by private CA), <node>/public_<node>.{crt,key} (signed by chia_ca).
+//
+// Returns false as the second value if private CA was used as chia_ca.
+func GenerateSSLDir(dir string, cfg SSLDirConfig) (bool, error) {
+	nodes := cfg.Nodes
+	if len(nodes) == 0 {
+		nodes = []string{"full_node"}
+	}
+	if !cfg.Overwrite {
+		for _, fpath := range []string{dir + "/ca/private_ca.crt", dir + "/ca/chia_ca.crt"} {
+			if _, err := os.Stat(fpath); err == nil {
+				return false, merry.Errorf("%s already exists", fpath)
+			}
+		}
+	}
+
+	chiaCA := cfg.ChiaCA
+	if chiaCA == nil && !cfg.PrivateChiaCA {
+		var err error
+		if chiaCA, err = EmbeddedChiaCA(); err != nil {
+			return false, merry.Wrap(err)
+		}
+	}
+
+	privateCA, err := GenerateCA()
+	if err != nil {
+		return false, merry.Wrap(err)
+	}
+	if err := WriteCertAndKey(privateCA, dir+"/ca/private_ca.crt", dir+"/ca/private_ca.key"); err != nil {
+		return false, merry.Wrap(err)
+	}
+	if cfg.PrivateChiaCA {
+		chiaCA = privateCA
+	}
+	if err := WriteCertAndKey(chiaCA, dir+"/ca/chia_ca.crt", dir+"/ca/chia_ca.key"); err != nil {
+		return false, merry.Wrap(err)
+	}
+
+	for _, node := range nodes {
+		for _, prefix := range []string{"private", "public"} {
+			ca := privateCA
+			if prefix == "public" {
+				ca = chiaCA
+			}
+			pair, err := GenerateCASignedCert(ca)
+			if err != nil {
+				return false, merry.Wrap(err)
+			}
+			base := dir + "/" + node + "/" + prefix + "_" + node
+			if err := WriteCertAndKey(pair, base+".crt", base+".key"); err != nil {
+				return false, merry.Wrap(err)
+			}
+		}
+	}
+	return !cfg.PrivateChiaCA, nil
+}
@@ -0,0 +1,104 @@
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ansel1/merry"
+)
+
+func checkSignedBy(t *testing.T, nodeCert, nodeKey, caCert string) {
+	t.Helper()
+	pool, err := LoadCACertPool(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(nodeCert, nodeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "Chia" || len(cert.DNSNames) != 1 || cert.DNSNames[0] != "chia.net" {
+		t.Errorf("unexpected certificate: %s %v", cert.Subject, cert.DNSNames)
+	}
+	opts := x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Errorf("%s: %s", nodeCert, err)
+	}
+}
+
+func TestGenerateSSLDir(t *testing.T) {
+	dir := t.TempDir()
+	usedChiaCA, err := GenerateSSLDir(dir, SSLDirConfig{PrivateChiaCA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usedChiaCA {
+		t.Error("private CA was requested")
+	}
+	checkSignedBy(t, dir+"/full_node/public_full_node.crt", dir+"/full_node/public_full_node.key", dir+"/ca/chia_ca.crt")
+	checkSignedBy(t, dir+"/full_node/private_full_node.crt", dir+"/full_node/private_full_node.key", dir+"/ca/private_ca.crt")
+	if _, err := MakeTSLConfigFromFiles(dir+"/ca/chia_ca.crt", dir+"/full_node/public_full_node.crt", dir+"/full_node/public_full_node.key"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := GenerateSSLDir(dir, SSLDirConfig{PrivateChiaCA: true}); err == nil {
+		t.Error("expected error for existing certificates")
+	}
+
+	chiaCA, err := ReadCertAndKey(dir+"/ca/private_ca.crt", dir+"/ca/private_ca.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir2 := t.TempDir()
+	usedChiaCA, err = GenerateSSLDir(dir2, SSLDirConfig{ChiaCA: chiaCA, Nodes: []string{"full_node", "introducer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !usedChiaCA {
+		t.Error("chia_ca was provided")
+	}
+	checkSignedBy(t, dir2+"/introducer/public_introducer.crt", dir2+"/introducer/public_introducer.key", dir2+"/ca/chia_ca.crt")
+	checkSignedBy(t, dir2+"/full_node/public_full_node.crt", dir2+"/full_node/public_full_node.key", dir+"/ca/private_ca.crt")
+}
+
+func TestGenerateSSLDirEmbeddedChiaCA(t *testing.T) {
+	chiaCA, err := GenerateCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(files fs.FS) { chiaCAFiles = files }(chiaCAFiles)
+
+	chiaCAFiles = fstest.MapFS{}
+	if _, err := GenerateSSLDir(t.TempDir(), SSLDirConfig{}); !merry.Is(err, ErrNoEmbeddedChiaCA) {
+		t.Errorf("expected ErrNoEmbeddedChiaCA, got %v", err)
+	}
+
+	chiaCAFiles = fstest.MapFS{
+		"ssl/chia_ca.crt": {Data: chiaCA.CertPEM},
+		"ssl/chia_ca.key": {Data: chiaCA.KeyPEM},
+	}
+	dir := t.TempDir()
+	usedChiaCA, err := GenerateSSLDir(dir, SSLDirConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !usedChiaCA {
+		t.Error("embedded chia_ca should be used by default")
+	}
+	written, err := ReadCertAndKey(dir+"/ca/chia_ca.crt", dir+"/ca/chia_ca.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written.CertPEM, chiaCA.CertPEM) {
+		t.Error("ca/chia_ca.crt differs from embedded one")
+	}
+	checkSignedBy(t, dir+"/full_node/public_full_node.crt", dir+"/full_node/public_full_node.key", dir+"/ca/chia_ca.crt")
+}
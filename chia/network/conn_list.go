@@ -0,0 +1,105 @@
+package network
+
+import "sync"
+
+type connListItem struct {
+	prev *connListItem
+	next *connListItem
+	conn *WSChiaConnection
+}
+
+// connList is a FIFO of active connections, oldest first.
+type connList struct {
+	limit  int64
+	length int64
+	start  *connListItem
+	end    *connListItem
+	mutex  sync.Mutex
+}
+
+func (l *connList) Len() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.length
+}
+
+func (l *connList) PushConn(c *WSChiaConnection) *connListItem {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.pushConnNoLock(c)
+}
+
+// PushConnIfRoom adds connection only if list is not full yet (has less than limit items).
+func (l *connList) PushConnIfRoom(c *WSChiaConnection) (*connListItem, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.limit > 0 && l.length >= l.limit {
+		return nil, false
+	}
+	return l.pushConnNoLock(c), true
+}
+
+func (l *connList) pushConnNoLock(c *WSChiaConnection) *connListItem {
+	item := &connListItem{prev: l.end, conn: c}
+	if l.end != nil {
+		l.end.next = item
+	}
+	l.end = item
+	if l.start == nil {
+		l.start = item
+	}
+	l.length += 1
+	return item
+}
+
+func (l *connList) delItemNoLock(item *connListItem) {
+	next := item.next
+	prev := item.prev
+	if l.start == item {
+		l.start = next
+	} else {
+		item.prev.next = next
+	}
+	if l.end == item {
+		l.end = prev
+	} else {
+		item.next.prev = prev
+	}
+	item.prev = nil
+	item.next = nil
+	l.length -= 1
+}
+
+func (l *connList) DelItemUnlesRemoved(item *connListItem) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if item.prev == nil && item.next == nil && l.start != item {
+		return
+	}
+	l.delItemNoLock(item)
+}
+
+// ShiftIfNeed removes and returns the oldest item if there are more than limit items.
+func (l *connList) ShiftIfNeed() *connListItem {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.length <= l.limit {
+		return nil
+	}
+	if l.start == nil {
+		return nil
+	}
+	item := l.start
+	l.delItemNoLock(item)
+	return item
+}
+
+func (l *connList) Conns() []*WSChiaConnection {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	conns := make([]*WSChiaConnection, 0, l.length)
+	for item := l.start; item != nil; item = item.next {
+		conns = append(conns, item.conn)
+	}
+	return conns
+}
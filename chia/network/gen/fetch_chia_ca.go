@@ -0,0 +1,74 @@
+package main
+
+import (
+	"chiastat/chia/network"
+	"crypto"
+	"crypto/sha256"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ansel1/merry"
+)
+
+// chia_ca has not changed since the first mainnet release, tag is pinned anyway
+// so that files can only change together with this constant.
+const DEFAULT_TAG = "2.1.4"
+
+func fetch(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, merry.Errorf("%s: %s", url, resp.Status)
+	}
+	buf, err := io.ReadAll(resp.Body)
+	return buf, merry.Wrap(err)
+}
+
+// checkChiaCA makes sure pair is a self-signed "Chia CA" with a matching key.
+func checkChiaCA(ca *network.CertAndKey) error {
+	cert, key, err := network.ParseCA(ca)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !cert.IsCA || cert.Subject.CommonName != "Chia CA" {
+		return merry.Errorf("unexpected CA certificate: %s", cert.Subject)
+	}
+	if err := cert.CheckSignatureFrom(cert); err != nil {
+		return merry.Prepend(err, "CA certificate is not self-signed")
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return merry.New("CA key does not match certificate")
+	}
+	return nil
+}
+
+func main() {
+	tag := flag.String("tag", DEFAULT_TAG, "chia-blockchain release tag")
+	outDir := flag.String("out-dir", "ssl", "where to save chia_ca.crt and chia_ca.key")
+	flag.Parse()
+
+	base := "https://raw.githubusercontent.com/Chia-Network/chia-blockchain/" + *tag + "/chia/ssl/"
+	certPEM, err := fetch(base + "chia_ca.crt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyPEM, err := fetch(base + "chia_ca.key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca := &network.CertAndKey{CertPEM: certPEM, KeyPEM: keyPEM}
+	if err := checkChiaCA(ca); err != nil {
+		log.Fatal(err)
+	}
+	if err := network.WriteCertAndKey(ca, *outDir+"/chia_ca.crt", *outDir+"/chia_ca.key"); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("chia_ca from %s saved to %s: crt sha256 %x, key sha256 %x",
+		*tag, *outDir, sha256.Sum256(certPEM), sha256.Sum256(keyPEM))
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/websocket"
+)
+
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/util/initial-config.yaml
+var DEFAULT_INTRODUCERS = []string{
+	"introducer.chia.net:8444",
+	"introducer-or.chia.net:8444",
+	"introducer-eu.chia.net:8444",
+}
+var DEFAULT_DNS_SEEDS = []string{
+	"dns-introducer.chia.net",
+	"chia.ctrlaltdel.ch",
+	"seeder.dexie.space",
+	"chia.hoffmang.com",
+}
+
+// RequestPeersFromIntroducer connects to introducer (host:port), asks it for peers and disconnects.
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/node_discovery.py (_introducer_client)
+func RequestPeersFromIntroducer(address string, tlsConfig *tls.Config, timeout time.Duration) ([]types.TimestampedPeerInfo, error) {
+	cfg := &WSChiaConnConfig{Dialer: &websocket.Dialer{HandshakeTimeout: timeout}}
+	c, err := ConnectTo(address, tlsConfig, cfg)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	defer c.Close()
+
+	hs, err := c.PerformHandshake()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if hs.NodeType != types.NODE_INTRODUCER {
+		name, _ := types.NodeTypeName(hs.NodeType)
+		return nil, merry.Errorf("not an introducer: %s", name)
+	}
+	c.StartRoutines()
+
+	resp, err := c.SendRequestSyncTimeout(types.RequestPeersIntroducer{}, timeout)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	peers, ok := resp.(*types.RespondPeersIntroducer)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	return peers.PeerList, nil
+}
+
+// ResolveDNSSeed returns addresses of all A/AAAA records of DNS seeder with the given port.
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/node_discovery.py (_query_dns)
+func ResolveDNSSeed(ctx context.Context, seed string, port uint16) ([]types.TimestampedPeerInfo, error) {
+	var resolver net.Resolver
+	addrs, err := resolver.LookupIPAddr(ctx, seed)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	stamp := uint64(time.Now().Unix())
+	peers := make([]types.TimestampedPeerInfo, len(addrs))
+	for i, addr := range addrs {
+		peers[i] = types.TimestampedPeerInfo{Host: addr.IP.String(), Port: port, Timestamp: stamp}
+	}
+	return peers, nil
+}
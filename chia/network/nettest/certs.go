@@ -0,0 +1,131 @@
+package nettest
+
+import (
+	"chiastat/chia/network"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// CA is an in-memory certificate authority for test nodes
+// (like chia_ca, but with ECDSA keys to be fast).
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	Key     *ecdsa.PrivateKey
+	KeyPEM  []byte
+}
+
+var lastSerial int64
+
+func nextSerial() *big.Int {
+	return big.NewInt(time.Now().UnixNano() + atomic.AddInt64(&lastSerial, 1))
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          nextSerial(),
+		Subject:               pkix.Name{CommonName: "Chia CA", Organization: []string{"Chia"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return &CA{
+		Cert:    cert,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:     key,
+		KeyPEM:  keyPEM,
+	}, nil
+}
+
+// IssueCert returns new node certificate signed by CA (as PEMs).
+func (ca *CA) IssueCert() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: nextSerial(),
+		Subject:      pkix.Name{CommonName: "Chia", Organization: []string{"Chia"}},
+		DNSNames:     []string{"chia.net"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// IssueTLSCert is like IssueCert but returns parsed certificate.
+func (ca *CA) IssueTLSCert() (tls.Certificate, error) {
+	certPEM, keyPEM, err := ca.IssueCert()
+	if err != nil {
+		return tls.Certificate{}, merry.Wrap(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return cert, merry.Wrap(err)
+}
+
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// WriteSSLDir creates chia-like ssl directory (ca/chia_ca.crt, full_node/public_full_node.crt, etc.)
+// with CA and new node certificate, so it can be used as -ssl-dir.
+func (ca *CA) WriteSSLDir(dir string) error {
+	certPEM, keyPEM, err := ca.IssueCert()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	caPair := &network.CertAndKey{CertPEM: ca.CertPEM, KeyPEM: ca.KeyPEM}
+	if err := network.WriteCertAndKey(caPair, dir+"/ca/chia_ca.crt", dir+"/ca/chia_ca.key"); err != nil {
+		return merry.Wrap(err)
+	}
+	node := &network.CertAndKey{CertPEM: certPEM, KeyPEM: keyPEM}
+	return merry.Wrap(network.WriteCertAndKey(node, dir+"/full_node/public_full_node.crt", dir+"/full_node/public_full_node.key"))
+}
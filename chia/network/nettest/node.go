@@ -0,0 +1,285 @@
+// Package nettest provides in-process mock chia nodes for testing network code offline.
+package nettest
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Response is a scripted reaction to an incoming message.
+type Response struct {
+	// Sent as reply to incoming message, nothing is sent if nil.
+	Message utils.ToBytes
+	// Wait before replying (or disconnecting).
+	Delay time.Duration
+	// Close connection instead of replying.
+	Disconnect bool
+}
+
+type HandlerFunc func(c *network.WSChiaConnection, msg utils.FromBytes) Response
+
+type Config struct {
+	// types.NODE_FULL if zero.
+	NodeType     uint8
+	Capabilities []uint16
+	// Close connections right after accepting, before handshake.
+	DisconnectBeforeHandshake bool
+	// Extra config for server-side connections (NodeType, ServerPort and Capabilities are overwritten).
+	ConnConfig *network.WSChiaConnConfig
+}
+
+// Node is a mock full node listening on a random local port.
+// By default it answers RequestPeers with empty list and ignores other messages,
+// responses can be scripted with On and HandleFunc.
+type Node struct {
+	CA         *CA
+	cfg        Config
+	server     *network.Server
+	listener   net.Listener
+	handlers   map[uint8]HandlerFunc
+	scripts    map[uint8][]Response
+	received   []types.Message
+	conns      []*network.WSChiaConnection
+	serveErr   chan error
+	mutex      sync.Mutex
+	handshakes int
+}
+
+// Start starts mock node with new CA.
+func Start(cfg Config) (*Node, error) {
+	ca, err := NewCA()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return StartWithCA(ca, cfg)
+}
+
+func StartWithCA(ca *CA, cfg Config) (*Node, error) {
+	if cfg.NodeType == 0 {
+		cfg.NodeType = types.NODE_FULL
+	}
+	cert, err := ca.IssueTLSCert()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	connCfg := network.WSChiaConnConfig{}
+	if cfg.ConnConfig != nil {
+		connCfg = *cfg.ConnConfig
+	}
+	connCfg.NodeType = cfg.NodeType
+	connCfg.ServerPort = port
+	connCfg.Capabilities = cfg.Capabilities
+
+	n := &Node{
+		CA:       ca,
+		cfg:      cfg,
+		listener: ln,
+		handlers: make(map[uint8]HandlerFunc),
+		scripts:  make(map[uint8][]Response),
+		serveErr: make(chan error, 1),
+	}
+	n.On(types.MSG_REQUEST_PEERS, Response{Message: types.RespondPeers{PeerList: nil}})
+
+	serverCfg := network.ServerConfig{
+		Host:        "127.0.0.1",
+		Port:        port,
+		Certificate: cert,
+		ConnConfig:  &connCfg,
+	}
+	n.server = network.NewServer(serverCfg, n.handleConn)
+	go func() {
+		n.serveErr <- n.server.Serve(ln)
+	}()
+	return n, nil
+}
+
+// Addr returns host:port node is listening on.
+func (n *Node) Addr() string {
+	return n.listener.Addr().String()
+}
+
+func (n *Node) Port() uint16 {
+	return uint16(n.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// ClientTLSConfig returns config with new client certificate signed by node's CA.
+func (n *Node) ClientTLSConfig() (*tls.Config, error) {
+	cert, err := n.CA.IssueTLSCert()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return network.MakeTSLConfig(n.CA.CertPool(), cert), nil
+}
+
+// Connect connects to node and performs handshake.
+func (n *Node) Connect(cfg *network.WSChiaConnConfig) (*network.WSChiaConnection, error) {
+	tlsCfg, err := n.ClientTLSConfig()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	c, err := network.ConnectTo(n.Addr(), tlsCfg, cfg)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if _, err := c.PerformHandshake(); err != nil {
+		c.CloseWithErr(err)
+		return nil, merry.Wrap(err)
+	}
+	c.StartRoutines()
+	return c, nil
+}
+
+// On sets responses to messages of the given type: they are used one by one
+// (for all connections), the last one is repeated.
+func (n *Node) On(msgType uint8, responses ...Response) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.handlers, msgType)
+	n.scripts[msgType] = responses
+}
+
+// HandleFunc sets handler for messages of the given type (overrides On).
+func (n *Node) HandleFunc(msgType uint8, handler HandlerFunc) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.scripts, msgType)
+	n.handlers[msgType] = handler
+}
+
+// Received returns all non-handshake messages received so far.
+func (n *Node) Received() []types.Message {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]types.Message(nil), n.received...)
+}
+
+// ReceivedCount returns number of received messages of the given type.
+func (n *Node) ReceivedCount(msgType uint8) int {
+	count := 0
+	for _, msg := range n.Received() {
+		if msg.Type == msgType {
+			count += 1
+		}
+	}
+	return count
+}
+
+// HandshakesCount returns number of successful handshakes.
+func (n *Node) HandshakesCount() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.handshakes
+}
+
+// Conns returns connections that completed handshake and are still open.
+func (n *Node) Conns() []*network.WSChiaConnection {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	var conns []*network.WSChiaConnection
+	for _, c := range n.conns {
+		if c.Err() == nil {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// Broadcast sends message (like NewPeak) to all connected peers.
+func (n *Node) Broadcast(msg utils.ToBytes) {
+	for _, c := range n.Conns() {
+		c.Send(msg)
+	}
+}
+
+// DisconnectAll closes all current connections (node keeps accepting new ones).
+func (n *Node) DisconnectAll() {
+	for _, c := range n.Conns() {
+		c.Close()
+	}
+}
+
+func (n *Node) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.server.Shutdown(ctx); err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(<-n.serveErr)
+}
+
+func (n *Node) nextResponse(c *network.WSChiaConnection, msgType uint8, msg utils.FromBytes) (Response, bool) {
+	n.mutex.Lock()
+	handler, ok := n.handlers[msgType]
+	n.mutex.Unlock()
+	if ok {
+		return handler(c, msg), true
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	responses := n.scripts[msgType]
+	if len(responses) == 0 {
+		return Response{}, false
+	}
+	resp := responses[0]
+	if len(responses) > 1 {
+		n.scripts[msgType] = responses[1:]
+	}
+	return resp, true
+}
+
+func (n *Node) handleConn(c *network.WSChiaConnection) {
+	if n.cfg.DisconnectBeforeHandshake {
+		return
+	}
+	c.SetMessageHandler(func(msgID uint16, msg utils.FromBytes) {
+		msgType, _ := types.MessageTypeFromStruct(msg)
+		n.mutex.Lock()
+		n.received = append(n.received, types.Message{Type: msgType, ID: msgID, Data: utils.ToByteSlice(msg.(utils.ToBytes))})
+		n.mutex.Unlock()
+
+		resp, ok := n.nextResponse(c, msgType, msg)
+		if !ok {
+			return
+		}
+		if resp.Delay > 0 {
+			select {
+			case <-time.After(resp.Delay):
+			case <-c.Done():
+				return
+			}
+		}
+		if resp.Disconnect {
+			c.Close()
+			return
+		}
+		if resp.Message != nil {
+			c.SendReply(msgID, resp.Message)
+		}
+	})
+
+	if _, err := c.PerformHandshake(); err != nil {
+		return
+	}
+	n.mutex.Lock()
+	n.handshakes += 1
+	n.conns = append(n.conns, c)
+	n.mutex.Unlock()
+
+	c.StartRoutines()
+	<-c.Done()
+}
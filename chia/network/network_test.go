@@ -0,0 +1,289 @@
+package network_test
+
+import (
+	"bytes"
+	"chiastat/chia/network"
+	"chiastat/chia/network/nettest"
+	"chiastat/chia/types"
+	"math/big"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+func startNode(t *testing.T, cfg nettest.Config) *nettest.Node {
+	t.Helper()
+	node, err := nettest.Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := node.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return node
+}
+
+func connect(t *testing.T, node *nettest.Node, cfg *network.WSChiaConnConfig) *network.WSChiaConnection {
+	t.Helper()
+	c, err := node.Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func waitDone(t *testing.T, c *network.WSChiaConnection, timeout time.Duration) error {
+	t.Helper()
+	select {
+	case <-c.Done():
+		return c.Err()
+	case <-time.After(timeout):
+		t.Fatal("connection is still open")
+		return nil
+	}
+}
+
+func TestConnectAndHandshake(t *testing.T) {
+	node := startNode(t, nettest.Config{Capabilities: []uint16{types.CAP_BASE, types.CAP_BLOCK_HEADERS}})
+
+	tlsCfg, err := node.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := network.ConnectTo(node.Addr(), tlsCfg, &network.WSChiaConnConfig{
+		Capabilities: []uint16{types.CAP_BASE, types.CAP_BLOCK_HEADERS, types.CAP_NONE_RESPONSE},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	hs, err := c.PerformHandshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.NodeType != types.NODE_FULL || hs.ServerPort != node.Port() || hs.NetworkID != network.NETWORK_ID {
+		t.Errorf("unexpected handshake: %#v", hs)
+	}
+	if caps := c.NegotiatedCapabilities(); !reflect.DeepEqual(caps, []uint16{types.CAP_BASE, types.CAP_BLOCK_HEADERS}) {
+		t.Errorf("unexpected negotiated capabilities: %v", caps)
+	}
+
+	// certificate from another CA is rejected
+	otherCA, err := nettest.NewCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := otherCA.IssueTLSCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.ConnectTo(node.Addr(), network.MakeTSLConfig(otherCA.CertPool(), cert), nil); err == nil {
+		t.Error("expected certificate verification error")
+	}
+
+	// node that disconnects before handshake
+	badNode := startNode(t, nettest.Config{DisconnectBeforeHandshake: true})
+	if _, err := badNode.Connect(nil); err == nil {
+		t.Error("expected handshake error")
+	}
+}
+
+func TestRequestPeers(t *testing.T) {
+	node := startNode(t, nettest.Config{})
+	peers := []types.TimestampedPeerInfo{
+		{Host: "1.2.3.4", Port: 8444, Timestamp: 1600000000},
+		{Host: "::1", Port: 8445, Timestamp: 1600000001},
+	}
+	node.On(types.MSG_REQUEST_PEERS,
+		nettest.Response{Message: types.RespondPeers{PeerList: peers}},
+		nettest.Response{Disconnect: true})
+
+	c := connect(t, node, nil)
+	resp, err := c.RequestPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.PeerList, peers) {
+		t.Errorf("unexpected peers: %#v", resp.PeerList)
+	}
+	if _, err := c.RequestPeers(); err == nil {
+		t.Error("expected error after disconnect")
+	}
+	waitDone(t, c, time.Second)
+	if node.ReceivedCount(types.MSG_REQUEST_PEERS) != 2 {
+		t.Errorf("expected 2 requests, got %d", node.ReceivedCount(types.MSG_REQUEST_PEERS))
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	node := startNode(t, nettest.Config{})
+	node.On(types.MSG_REQUEST_BLOCK,
+		nettest.Response{Message: types.RejectBlock{Height: 1}, Delay: 300 * time.Millisecond},
+		nettest.Response{Message: types.RejectBlock{Height: 2}})
+
+	c := connect(t, node, nil)
+	_, err := c.SendRequestSyncTimeout(types.RequestBlock{Height: 1}, 50*time.Millisecond)
+	if !merry.Is(err, network.ErrRequestTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	resp, err := c.SendRequestSyncTimeout(types.RequestBlock{Height: 2}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reject, ok := resp.(*types.RejectBlock); !ok || reject.Height != 2 {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+}
+
+func TestCloseWithNilErr(t *testing.T) {
+	node := startNode(t, nettest.Config{})
+	c := connect(t, node, nil)
+	c.CloseWithErr(nil)
+	if err := waitDone(t, c, time.Second); !merry.Is(err, network.ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+	c.CloseWithErr(nil) //must not close done channel twice
+	c.Close()
+}
+
+func TestKeepalive(t *testing.T) {
+	node := startNode(t, nettest.Config{})
+
+	// pongs keep connection alive
+	c := connect(t, node, &network.WSChiaConnConfig{PingInterval: 20 * time.Millisecond, ReadTimeout: 100 * time.Millisecond})
+	time.Sleep(300 * time.Millisecond)
+	if err := c.Err(); err != nil {
+		t.Fatalf("connection closed: %s", err)
+	}
+
+	// silent peer
+	c = connect(t, node, &network.WSChiaConnConfig{PingInterval: -1, ReadTimeout: 100 * time.Millisecond})
+	if err := waitDone(t, c, time.Second); !merry.Is(err, network.ErrReadTimeout) {
+		t.Errorf("expected read timeout, got %v", err)
+	}
+
+	// no protocol messages
+	c = connect(t, node, &network.WSChiaConnConfig{PingInterval: 20 * time.Millisecond, IdleTimeout: 100 * time.Millisecond})
+	if err := waitDone(t, c, time.Second); !merry.Is(err, network.ErrIdleTimeout) {
+		t.Errorf("expected idle timeout, got %v", err)
+	}
+}
+
+func TestPeerManager(t *testing.T) {
+	node0 := startNode(t, nettest.Config{})
+	node1, err := nettest.StartWithCA(node0.CA, nettest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node1.Close()
+	node0.On(types.MSG_REQUEST_BLOCK, nettest.Response{Message: types.RejectBlock{Height: 10}})
+	node1.On(types.MSG_REQUEST_BLOCK, nettest.Response{Message: types.RejectBlock{Height: 10}})
+
+	tlsCfg, err := node0.ClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := network.NewPeerManager(network.PeerManagerConfig{TLSConfig: tlsCfg, RequestTimeout: time.Second})
+	m.AddAddress(node0.Addr())
+	m.AddAddress(node1.Addr())
+	m.Start()
+	defer m.Stop()
+	if err := m.WaitForPeers(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var peaksCount int64
+	unsubscribe := m.SubscribeNewPeak(func(peer *network.Peer, peak *types.NewPeak) {
+		atomic.AddInt64(&peaksCount, 1)
+	})
+	node0.Broadcast(types.NewPeak{Height: 5, Weight: big.NewInt(100)})
+	node1.Broadcast(types.NewPeak{Height: 20, Weight: big.NewInt(200)})
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&peaksCount) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	unsubscribe()
+	if peaksCount != 2 {
+		t.Fatalf("expected 2 peaks, got %d", peaksCount)
+	}
+	if peak, peer := m.Peak(); peak == nil || peak.Height != 20 || peer.Address != node1.Addr() {
+		t.Errorf("unexpected peak: %#v", peak)
+	}
+
+	// only node1 has height 10, both reject
+	_, err = m.RequestBlock(10, false)
+	if !merry.Is(err, network.ErrRejected) {
+		t.Errorf("expected reject, got %v", err)
+	}
+	if node0.ReceivedCount(types.MSG_REQUEST_BLOCK) != 0 || node1.ReceivedCount(types.MSG_REQUEST_BLOCK) != 1 {
+		t.Errorf("request should be sent only to node1: %d, %d",
+			node0.ReceivedCount(types.MSG_REQUEST_BLOCK), node1.ReceivedCount(types.MSG_REQUEST_BLOCK))
+	}
+
+	// failover: dead peer is skipped
+	node1.On(types.MSG_REQUEST_PEERS, nettest.Response{Disconnect: true})
+	for i := 0; i < 3; i++ {
+		if _, err := m.RequestPeers(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if node0.ReceivedCount(types.MSG_REQUEST_PEERS) == 0 {
+		t.Error("expected requests to node0")
+	}
+}
+
+func TestReplayCapture(t *testing.T) {
+	node0 := startNode(t, nettest.Config{})
+	node0.On(types.MSG_REQUEST_BLOCK, nettest.Response{Message: types.RejectBlock{Height: 1}})
+
+	var buf bytes.Buffer
+	capture, err := network.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := connect(t, node0, &network.WSChiaConnConfig{Recorder: capture})
+	if _, err := c.RequestPeers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendRequestSync(types.RequestBlock{Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	r, err := network.NewCaptureReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []network.CaptureRecord
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			break
+		}
+		records = append(records, *rec)
+	}
+	if len(records) != 6 {
+		t.Fatalf("expected 6 records (handshakes, 2 requests, 2 responses), got %d", len(records))
+	}
+
+	node1 := startNode(t, nettest.Config{})
+	node1.On(types.MSG_REQUEST_BLOCK, nettest.Response{Message: types.RejectBlock{Height: 1}})
+	if err := network.ReplayCapture(records, connect(t, node1, nil), time.Second); err != nil {
+		t.Error(err)
+	}
+	if node1.ReceivedCount(types.MSG_REQUEST_PEERS) != 1 || node1.ReceivedCount(types.MSG_REQUEST_BLOCK) != 1 {
+		t.Errorf("unexpected replayed messages: %#v", node1.Received())
+	}
+
+	node2 := startNode(t, nettest.Config{})
+	node2.On(types.MSG_REQUEST_BLOCK, nettest.Response{Message: types.RespondPeers{}})
+	if err := network.ReplayCapture(records, connect(t, node2, nil), time.Second); err == nil {
+		t.Error("expected response type mismatch")
+	}
+}
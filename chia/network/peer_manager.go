@@ -0,0 +1,570 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"crypto/tls"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/websocket"
+)
+
+const DEFAULT_TARGET_OUTBOUND = 8
+const DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+const DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+const DEFAULT_REQUEST_ATTEMPTS = 3
+const DEFAULT_MIN_PEER_SCORE = -20
+
+const maxPeerScore = 100
+const scoreOnSuccess = 1
+const scoreOnReject = -2
+const scoreOnFailure = -5
+const scoreOnBadData = -10
+
+// peer connection that closes faster than this is counted as address failure
+const minHealthyConnDuration = time.Minute
+
+var ErrNoPeers = merry.New("no suitable connected peers")
+var ErrRejected = merry.New("request rejected")
+var ErrLowScore = merry.New("peer score is too low")
+var ErrPeerManagerStopped = merry.New("peer manager stopped")
+
+// Peer is an outbound full node connection managed by PeerManager.
+type Peer struct {
+	Address     string
+	Conn        *WSChiaConnection
+	ConnectedAt time.Time
+	inFlight    int32
+	score       int
+	latency     time.Duration
+	peak        *types.NewPeak
+	mutex       sync.Mutex
+}
+
+func (p *Peer) Score() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.score
+}
+
+// Latency returns moving average of successful requests durations.
+func (p *Peer) Latency() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.latency
+}
+
+// Peak returns last NewPeak received from peer, nil if there were none yet.
+func (p *Peer) Peak() *types.NewPeak {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.peak
+}
+
+func (p *Peer) setPeak(peak *types.NewPeak) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peak == nil || peak.Weight.Cmp(p.peak.Weight) >= 0 {
+		p.peak = peak
+	}
+}
+
+// addScore updates score (and latency if request succeeded), returns new score.
+func (p *Peer) addScore(delta int, latency time.Duration) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.score += delta
+	if p.score > maxPeerScore {
+		p.score = maxPeerScore
+	}
+	if latency > 0 {
+		if p.latency == 0 {
+			p.latency = latency
+		} else {
+			p.latency = (p.latency*4 + latency) / 5
+		}
+	}
+	return p.score
+}
+
+type PeerMessageHandler func(peer *Peer, msgID uint16, msg utils.FromBytes)
+
+type PeerManagerConfig struct {
+	TLSConfig *tls.Config
+	// Used for every peer connection. Dialer.HandshakeTimeout is set to DialTimeout if Dialer is nil.
+	ConnConfig *WSChiaConnConfig
+	// Number of outbound connections to maintain, DEFAULT_TARGET_OUTBOUND if zero.
+	TargetOutbound int
+	// DEFAULT_DIAL_TIMEOUT if zero.
+	DialTimeout time.Duration
+	// Timeout of a single request attempt, DEFAULT_REQUEST_TIMEOUT if zero.
+	RequestTimeout time.Duration
+	// Max number of peers a request is sent to before failing, DEFAULT_REQUEST_ATTEMPTS if zero.
+	RequestAttempts int
+	// Address failure backoff, see NewAddressBook.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Peer is disconnected when its score drops below this value, DEFAULT_MIN_PEER_SCORE if zero.
+	MinScore int
+	// Request peers from every new connection and add them to address book.
+	DiscoverPeers bool
+}
+
+// PeerManager keeps TargetOutbound connections to full nodes from address book,
+// reconnecting (with backoff) to other addresses when connections fail.
+// Requests are routed to the best suitable peer (by score and latency)
+// and retried on other peers on errors, timeouts and rejects.
+type PeerManager struct {
+	cfg        PeerManagerConfig
+	book       *AddressBook
+	peers      map[string]*Peer
+	dialing    int
+	subs       map[int]PeerMessageHandler
+	lastSubID  int
+	mutex      sync.Mutex
+	wakeup     chan struct{}
+	stop       chan struct{}
+	stopOnce   sync.Once
+	routinesWG sync.WaitGroup
+}
+
+func NewPeerManager(cfg PeerManagerConfig) *PeerManager {
+	if cfg.TargetOutbound == 0 {
+		cfg.TargetOutbound = DEFAULT_TARGET_OUTBOUND
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+	if cfg.RequestAttempts == 0 {
+		cfg.RequestAttempts = DEFAULT_REQUEST_ATTEMPTS
+	}
+	if cfg.MinScore == 0 {
+		cfg.MinScore = DEFAULT_MIN_PEER_SCORE
+	}
+	connCfg := WSChiaConnConfig{}
+	if cfg.ConnConfig != nil {
+		connCfg = *cfg.ConnConfig
+	}
+	if connCfg.Dialer == nil {
+		connCfg.Dialer = &websocket.Dialer{HandshakeTimeout: cfg.DialTimeout}
+	}
+	cfg.ConnConfig = &connCfg
+
+	return &PeerManager{
+		cfg:    cfg,
+		book:   NewAddressBook(cfg.MinBackoff, cfg.MaxBackoff),
+		peers:  make(map[string]*Peer),
+		subs:   make(map[int]PeerMessageHandler),
+		wakeup: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+func (m *PeerManager) AddressBook() *AddressBook {
+	return m.book
+}
+
+// AddAddress adds host:port to address book.
+func (m *PeerManager) AddAddress(address string) {
+	m.book.Add(address, time.Now())
+	m.wake()
+}
+
+func (m *PeerManager) Start() {
+	m.routinesWG.Add(1)
+	go m.maintainRoutine()
+}
+
+// Stop closes all connections and waits for background routines.
+func (m *PeerManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	for _, peer := range m.Peers() {
+		peer.Conn.CloseWithErr(ErrPeerManagerStopped.Here())
+	}
+	m.routinesWG.Wait()
+}
+
+func (m *PeerManager) Peers() []*Peer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// WaitForPeers blocks until at least count peers are connected.
+func (m *PeerManager) WaitForPeers(count int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if len(m.Peers()) >= count {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return merry.Errorf("timeout waiting for %d peers, got %d", count, len(m.Peers()))
+		}
+		select {
+		case <-m.stop:
+			return ErrPeerManagerStopped.Here()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Peak returns heaviest peak among connected peers (and peer it came from).
+func (m *PeerManager) Peak() (*types.NewPeak, *Peer) {
+	var bestPeak *types.NewPeak
+	var bestPeer *Peer
+	for _, peer := range m.Peers() {
+		peak := peer.Peak()
+		if peak != nil && (bestPeak == nil || peak.Weight.Cmp(bestPeak.Weight) > 0) {
+			bestPeak = peak
+			bestPeer = peer
+		}
+	}
+	return bestPeak, bestPeer
+}
+
+// Subscribe adds handler for all incoming non-response messages from all peers.
+// Handlers are called from connections' handler routines, so they should not block for long.
+// Returns unsubscribe function.
+func (m *PeerManager) Subscribe(handler PeerMessageHandler) func() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastSubID += 1
+	id := m.lastSubID
+	m.subs[id] = handler
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.subs, id)
+	}
+}
+
+func (m *PeerManager) SubscribeNewPeak(handler func(peer *Peer, peak *types.NewPeak)) func() {
+	return m.Subscribe(func(peer *Peer, msgID uint16, msg utils.FromBytes) {
+		if peak, ok := msg.(*types.NewPeak); ok {
+			handler(peer, peak)
+		}
+	})
+}
+
+func (m *PeerManager) SubscribeNewTransaction(handler func(peer *Peer, tx *types.NewTransaction)) func() {
+	return m.Subscribe(func(peer *Peer, msgID uint16, msg utils.FromBytes) {
+		if tx, ok := msg.(*types.NewTransaction); ok {
+			handler(peer, tx)
+		}
+	})
+}
+
+// Broadcast sends message to all connected peers, returns number of successful sends.
+func (m *PeerManager) Broadcast(msg utils.ToBytes) int {
+	count := 0
+	for _, peer := range m.Peers() {
+		if err := peer.Conn.Send(msg); err == nil {
+			count += 1
+		}
+	}
+	return count
+}
+
+func (m *PeerManager) wake() {
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (m *PeerManager) maintainRoutine() {
+	defer m.routinesWG.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		m.dialIfNeed()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		case <-m.wakeup:
+		}
+	}
+}
+
+func (m *PeerManager) dialIfNeed() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for len(m.peers)+m.dialing < m.cfg.TargetOutbound {
+		address, ok := m.book.Pick(time.Now())
+		if !ok {
+			break
+		}
+		m.dialing += 1
+		m.routinesWG.Add(1)
+		go m.connect(address)
+	}
+}
+
+func (m *PeerManager) connect(address string) {
+	defer m.routinesWG.Done()
+	peer, err := m.dial(address)
+
+	m.mutex.Lock()
+	m.dialing -= 1
+	stopped := false
+	select {
+	case <-m.stop:
+		stopped = true
+	default:
+	}
+	if err == nil && !stopped {
+		m.peers[address] = peer
+	}
+	m.mutex.Unlock()
+
+	if err != nil {
+		if m.cfg.ConnConfig.Debug {
+			log.Printf("DEBUG: peer %s: %s", address, err)
+		}
+		m.book.MarkFailure(address, time.Now())
+		m.wake()
+		return
+	}
+	if stopped {
+		peer.Conn.CloseWithErr(ErrPeerManagerStopped.Here())
+		m.book.Release(address, time.Now())
+		return
+	}
+	m.book.MarkSuccess(address, time.Now())
+
+	if m.cfg.DiscoverPeers {
+		if data, err := peer.Conn.SendRequestSyncTimeout(types.RequestPeers{}, m.cfg.RequestTimeout); err == nil {
+			if peers, ok := data.(*types.RespondPeers); ok {
+				m.book.AddPeers(peers.PeerList)
+			}
+		}
+	}
+
+	<-peer.Conn.Done()
+	m.mutex.Lock()
+	delete(m.peers, address)
+	m.mutex.Unlock()
+	if m.cfg.ConnConfig.Debug {
+		log.Printf("DEBUG: peer %s disconnected: %s", address, peer.Conn.Err())
+	}
+	if time.Since(peer.ConnectedAt) < minHealthyConnDuration || peer.Score() < m.cfg.MinScore {
+		m.book.MarkFailure(address, time.Now())
+	} else {
+		m.book.Release(address, time.Now())
+	}
+	m.wake()
+}
+
+func (m *PeerManager) dial(address string) (*Peer, error) {
+	c, err := ConnectTo(address, m.cfg.TLSConfig, m.cfg.ConnConfig)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	peer := &Peer{Address: address, Conn: c}
+	c.SetMessageHandler(func(msgID uint16, msg utils.FromBytes) {
+		m.handleMessage(peer, msgID, msg)
+	})
+	hs, err := c.PerformHandshake()
+	if err != nil {
+		c.CloseWithErr(err)
+		return nil, merry.Wrap(err)
+	}
+	if hs.NodeType != types.NODE_FULL {
+		name, _ := types.NodeTypeName(hs.NodeType)
+		err := merry.Errorf("not a full node: %s", name)
+		c.CloseWithErr(err)
+		return nil, err
+	}
+	peer.ConnectedAt = time.Now()
+	c.StartRoutines()
+	return peer, nil
+}
+
+func (m *PeerManager) handleMessage(peer *Peer, msgID uint16, msg utils.FromBytes) {
+	switch msg := msg.(type) {
+	case *types.RequestPeers:
+		peer.Conn.SendReply(msgID, types.RespondPeers{PeerList: nil})
+	case *types.RespondPeers:
+		m.book.AddPeers(msg.PeerList)
+	case *types.NewPeak:
+		peer.setPeak(msg)
+	}
+
+	m.mutex.Lock()
+	handlers := make([]PeerMessageHandler, 0, len(m.subs))
+	for _, handler := range m.subs {
+		handlers = append(handlers, handler)
+	}
+	m.mutex.Unlock()
+	for _, handler := range handlers {
+		handler(peer, msgID, msg)
+	}
+}
+
+// pickPeer returns best peer (highest score, then fewer requests in flight, then lower latency)
+// that matches filter and was not tried yet.
+func (m *PeerManager) pickPeer(filter func(*Peer) bool, tried map[*Peer]bool) *Peer {
+	var candidates []*Peer
+	for _, peer := range m.Peers() {
+		if !tried[peer] && (filter == nil || filter(peer)) {
+			candidates = append(candidates, peer)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Score() != b.Score() {
+			return a.Score() > b.Score()
+		}
+		aInFlight, bInFlight := atomic.LoadInt32(&a.inFlight), atomic.LoadInt32(&b.inFlight)
+		if aInFlight != bInFlight {
+			return aInFlight < bInFlight
+		}
+		return a.Latency() < b.Latency()
+	})
+	return candidates[0]
+}
+
+func (m *PeerManager) penalize(peer *Peer, delta int, err error) {
+	if peer.addScore(delta, 0) < m.cfg.MinScore {
+		peer.Conn.CloseWithErr(ErrLowScore.WithCause(err))
+	}
+}
+
+// ReportBadData lowers peer score for invalid response (like unlinked blocks),
+// peer is disconnected when its score drops below MinScore.
+func (m *PeerManager) ReportBadData(peer *Peer, err error) {
+	log.Printf("WARN: bad data from peer %s: %s", peer.Address, err)
+	m.penalize(peer, scoreOnBadData, err)
+}
+
+func isRejectResponse(msg utils.FromBytes) bool {
+	switch msg.(type) {
+	case *types.RejectBlock, *types.RejectBlocks:
+		return true
+	}
+	return false
+}
+
+// Request sends request to the best connected peer matching filter (any peer if filter is nil).
+// On error, timeout or reject the request is retried on other peers (up to RequestAttempts peers total).
+func (m *PeerManager) Request(request utils.ToBytes, filter func(*Peer) bool) (utils.FromBytes, *Peer, error) {
+	tried := make(map[*Peer]bool)
+	var lastErr error
+	for i := 0; i < m.cfg.RequestAttempts; i++ {
+		peer := m.pickPeer(filter, tried)
+		if peer == nil {
+			break
+		}
+		tried[peer] = true
+
+		atomic.AddInt32(&peer.inFlight, 1)
+		stt := time.Now()
+		resp, err := peer.Conn.SendRequestSyncTimeout(request, m.cfg.RequestTimeout)
+		atomic.AddInt32(&peer.inFlight, -1)
+
+		if err != nil {
+			lastErr = err
+			m.penalize(peer, scoreOnFailure, err)
+			continue
+		}
+		if isRejectResponse(resp) {
+			lastErr = ErrRejected.Here().WithMessagef("request rejected by %s: %#v", peer.Address, resp)
+			m.penalize(peer, scoreOnReject, lastErr)
+			continue
+		}
+		peer.addScore(scoreOnSuccess, time.Since(stt))
+		return resp, peer, nil
+	}
+	if lastErr == nil {
+		return nil, nil, ErrNoPeers.Here()
+	}
+	return nil, nil, merry.Wrap(lastErr)
+}
+
+func peakAtLeast(height uint32) func(*Peer) bool {
+	return func(peer *Peer) bool {
+		peak := peer.Peak()
+		return peak == nil || peak.Height >= height
+	}
+}
+
+func (m *PeerManager) RequestPeers() (*types.RespondPeers, error) {
+	resp, _, err := m.Request(types.RequestPeers{}, nil)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	peers, ok := resp.(*types.RespondPeers)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	return peers, nil
+}
+
+func (m *PeerManager) RequestBlock(height uint32, includeTransactionBlock bool) (*types.FullBlock, error) {
+	req := types.RequestBlock{Height: height, IncludeTransactionBlock: includeTransactionBlock}
+	resp, _, err := m.Request(req, peakAtLeast(height))
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	block, ok := resp.(*types.RespondBlock)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	return &block.Block, nil
+}
+
+func (m *PeerManager) RequestBlocks(startHeight, endHeight uint32, includeTransactionBlock bool) ([]types.FullBlock, error) {
+	req := types.RequestBlocks{StartHeight: startHeight, EndHeight: endHeight, IncludeTransactionBlock: includeTransactionBlock}
+	resp, _, err := m.Request(req, peakAtLeast(endHeight))
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	blocks, ok := resp.(*types.RespondBlocks)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	return blocks.Blocks, nil
+}
+
+// RequestTransaction asks for a mempool transaction. Peers that do not have it just do not respond,
+// so it's better to send it to the peer that announced the transaction (see RequestTransactionFrom).
+func (m *PeerManager) RequestTransaction(txID [32]byte) (*types.SpendBundle, error) {
+	resp, _, err := m.Request(types.RequestTransaction{TransactionID: txID}, nil)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	tx, ok := resp.(*types.RespondTransaction)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	return &tx.Transaction, nil
+}
+
+// RequestTransactionFrom is like RequestTransaction but sends request only to the given peer.
+func (m *PeerManager) RequestTransactionFrom(peer *Peer, txID [32]byte) (*types.SpendBundle, error) {
+	resp, err := peer.Conn.SendRequestSyncTimeout(types.RequestTransaction{TransactionID: txID}, m.cfg.RequestTimeout)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	tx, ok := resp.(*types.RespondTransaction)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	return &tx.Transaction, nil
+}
@@ -0,0 +1,246 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/rate_limit_numbers.py
+type RateLimit struct {
+	Frequency    int // max number of messages per reset interval
+	MaxSize      int // max size of one message
+	MaxTotalSize int // max size of all messages per reset interval, Frequency*MaxSize if zero
+}
+
+var DEFAULT_RATE_LIMIT = RateLimit{100, 1024 * 1024, 100 * 1024 * 1024}
+
+const NON_TX_FREQ = 1000
+const NON_TX_MAX_TOTAL_SIZE = 100 * 1024 * 1024
+
+// Transaction-related messages, not counted in non-tx totals.
+var RATE_LIMITS_TX = map[uint8]RateLimit{
+	types.MSG_NEW_TRANSACTION:     {5000, 100, 5000 * 100},
+	types.MSG_REQUEST_TRANSACTION: {5000, 100, 5000 * 100},
+	types.MSG_RESPOND_TRANSACTION: {5000, 1 * 1024 * 1024, 20 * 1024 * 1024},
+	types.MSG_SEND_TRANSACTION:    {5000, 1024 * 1024, 0},
+	types.MSG_TRANSACTION_ACK:     {5000, 2048, 0},
+}
+
+var RATE_LIMITS_OTHER = map[uint8]RateLimit{
+	types.MSG_HANDSHAKE:                                {5, 10 * 1024, 5 * 10 * 1024},
+	types.MSG_HARVESTER_HANDSHAKE:                      {5, 1024 * 1024, 0},
+	types.MSG_NEW_SIGNAGE_POINT_HARVESTER:              {100, 1024, 0},
+	types.MSG_NEW_PROOF_OF_SPACE:                       {100, 2048, 0},
+	types.MSG_REQUEST_SIGNATURES:                       {100, 2048, 0},
+	types.MSG_RESPOND_SIGNATURES:                       {100, 2048, 0},
+	types.MSG_NEW_SIGNAGE_POINT:                        {200, 2048, 0},
+	types.MSG_DECLARE_PROOF_OF_SPACE:                   {100, 10 * 1024, 0},
+	types.MSG_REQUEST_SIGNED_VALUES:                    {100, 512, 0},
+	types.MSG_FARMING_INFO:                             {100, 1024, 0},
+	types.MSG_SIGNED_VALUES:                            {100, 1024, 0},
+	types.MSG_NEW_PEAK_TIMELORD:                        {100, 20 * 1024, 0},
+	types.MSG_NEW_UNFINISHED_BLOCK_TIMELORD:            {100, 10 * 1024, 0},
+	types.MSG_NEW_SIGNAGE_POINT_VDF:                    {100, 100 * 1024, 0},
+	types.MSG_NEW_INFUSION_POINT_VDF:                   {100, 100 * 1024, 0},
+	types.MSG_NEW_END_OF_SUB_SLOT_VDF:                  {100, 100 * 1024, 0},
+	types.MSG_REQUEST_COMPACT_PROOF_OF_TIME:            {100, 10 * 1024, 0},
+	types.MSG_RESPOND_COMPACT_PROOF_OF_TIME:            {100, 100 * 1024, 0},
+	types.MSG_NEW_PEAK:                                 {200, 512, 0},
+	types.MSG_REQUEST_PROOF_OF_WEIGHT:                  {5, 100, 0},
+	types.MSG_RESPOND_PROOF_OF_WEIGHT:                  {5, 50 * 1024 * 1024, 100 * 1024 * 1024},
+	types.MSG_REQUEST_BLOCK:                            {200, 100, 0},
+	types.MSG_REJECT_BLOCK:                             {200, 100, 0},
+	types.MSG_REQUEST_BLOCKS:                           {500, 100, 0},
+	types.MSG_RESPOND_BLOCKS:                           {100, 50 * 1024 * 1024, 5 * 50 * 1024 * 1024},
+	types.MSG_REJECT_BLOCKS:                            {100, 100, 0},
+	types.MSG_RESPOND_BLOCK:                            {200, 2 * 1024 * 1024, 10 * 2 * 1024 * 1024},
+	types.MSG_NEW_UNFINISHED_BLOCK:                     {200, 100, 0},
+	types.MSG_REQUEST_UNFINISHED_BLOCK:                 {200, 100, 0},
+	types.MSG_RESPOND_UNFINISHED_BLOCK:                 {200, 2 * 1024 * 1024, 10 * 2 * 1024 * 1024},
+	types.MSG_NEW_SIGNAGE_POINT_OR_END_OF_SUB_SLOT:     {200, 200, 0},
+	types.MSG_REQUEST_SIGNAGE_POINT_OR_END_OF_SUB_SLOT: {200, 200, 0},
+	types.MSG_RESPOND_SIGNAGE_POINT:                    {200, 50 * 1024, 0},
+	types.MSG_RESPOND_END_OF_SUB_SLOT:                  {100, 50 * 1024, 0},
+	types.MSG_REQUEST_MEMPOOL_TRANSACTIONS:             {5, 1024 * 1024, 0},
+	types.MSG_REQUEST_COMPACT_VDF:                      {200, 1024, 0},
+	types.MSG_RESPOND_COMPACT_VDF:                      {200, 100 * 1024, 0},
+	types.MSG_NEW_COMPACT_VDF:                          {100, 1024, 0},
+	types.MSG_REQUEST_PEERS:                            {10, 100, 0},
+	types.MSG_RESPOND_PEERS:                            {10, 1 * 1024 * 1024, 0},
+	types.MSG_REQUEST_PUZZLE_SOLUTION:                  {1000, 100, 0},
+	types.MSG_RESPOND_PUZZLE_SOLUTION:                  {1000, 1024 * 1024, 0},
+	types.MSG_REJECT_PUZZLE_SOLUTION:                   {1000, 100, 0},
+	types.MSG_NEW_PEAK_WALLET:                          {200, 300, 0},
+	types.MSG_REQUEST_BLOCK_HEADER:                     {500, 100, 0},
+	types.MSG_RESPOND_BLOCK_HEADER:                     {500, 500 * 1024, 0},
+	types.MSG_REJECT_HEADER_REQUEST:                    {500, 100, 0},
+	types.MSG_REQUEST_REMOVALS:                         {500, 50 * 1024, 10 * 1024 * 1024},
+	types.MSG_RESPOND_REMOVALS:                         {500, 1024 * 1024, 10 * 1024 * 1024},
+	types.MSG_REJECT_REMOVALS_REQUEST:                  {500, 100, 0},
+	types.MSG_REQUEST_ADDITIONS:                        {500, 1024 * 1024, 10 * 1024 * 1024},
+	types.MSG_RESPOND_ADDITIONS:                        {500, 1024 * 1024, 10 * 1024 * 1024},
+	types.MSG_REJECT_ADDITIONS_REQUEST:                 {500, 100, 0},
+	types.MSG_REQUEST_HEADER_BLOCKS:                    {500, 100, 0},
+	types.MSG_REJECT_HEADER_BLOCKS:                     {100, 100, 0},
+	types.MSG_RESPOND_HEADER_BLOCKS:                    {500, 2 * 1024 * 1024, 100 * 1024 * 1024},
+	types.MSG_REQUEST_PEERS_INTRODUCER:                 {100, 100, 0},
+	types.MSG_RESPOND_PEERS_INTRODUCER:                 {100, 1024 * 1024, 0},
+	types.MSG_FARM_NEW_BLOCK:                           {200, 200, 0},
+}
+
+type RateLimitConfig struct {
+	// RATE_LIMITS_TX/RATE_LIMITS_OTHER if nil
+	LimitsTx    map[uint8]RateLimit
+	LimitsOther map[uint8]RateLimit
+	// For message types missing in both maps, DEFAULT_RATE_LIMIT if zero
+	Default RateLimit
+	// Limits for all non-tx messages in total, NON_TX_FREQ/NON_TX_MAX_TOTAL_SIZE if zero
+	NonTxFrequency    int
+	NonTxMaxTotalSize int
+	// Percentage of frequency and total size limits actually used, 100 if zero
+	// (upstream uses 30% for outbound messages to stay well below peers' limits).
+	PercentOfLimit int
+	// Counters are reset every interval, one minute if zero.
+	ResetInterval time.Duration
+}
+
+func DefaultInboundRateLimitConfig() *RateLimitConfig {
+	return &RateLimitConfig{PercentOfLimit: 100}
+}
+
+func DefaultOutboundRateLimitConfig() *RateLimitConfig {
+	return &RateLimitConfig{PercentOfLimit: 30}
+}
+
+type RateLimitViolation struct {
+	Inbound bool
+	MsgType uint8
+	Size    int
+	Reason  string
+}
+
+func (v RateLimitViolation) Error() string {
+	dir := "outbound"
+	if v.Inbound {
+		dir = "inbound"
+	}
+	return fmt.Sprintf("%s rate limit exceeded for message type %d (size %d): %s", dir, v.MsgType, v.Size, v.Reason)
+}
+
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/rate_limits.py
+type RateLimiter struct {
+	cfg           RateLimitConfig
+	inbound       bool
+	mutex         sync.Mutex
+	now           func() time.Time
+	currentPeriod int64
+	counts        map[uint8]int
+	sizes         map[uint8]int
+	nonTxCount    int
+	nonTxSize     int
+}
+
+func NewRateLimiter(cfg *RateLimitConfig, inbound bool) *RateLimiter {
+	c := RateLimitConfig{}
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.LimitsTx == nil {
+		c.LimitsTx = RATE_LIMITS_TX
+	}
+	if c.LimitsOther == nil {
+		c.LimitsOther = RATE_LIMITS_OTHER
+	}
+	if c.Default == (RateLimit{}) {
+		c.Default = DEFAULT_RATE_LIMIT
+	}
+	if c.NonTxFrequency == 0 {
+		c.NonTxFrequency = NON_TX_FREQ
+	}
+	if c.NonTxMaxTotalSize == 0 {
+		c.NonTxMaxTotalSize = NON_TX_MAX_TOTAL_SIZE
+	}
+	if c.PercentOfLimit == 0 {
+		c.PercentOfLimit = 100
+	}
+	if c.ResetInterval == 0 {
+		c.ResetInterval = time.Minute
+	}
+	return &RateLimiter{
+		cfg:     c,
+		inbound: inbound,
+		now:     time.Now,
+		counts:  make(map[uint8]int),
+		sizes:   make(map[uint8]int),
+	}
+}
+
+// LimitFor returns limit for message type (with MaxTotalSize filled).
+func (l *RateLimiter) LimitFor(msgType uint8) (limit RateLimit, isTx bool) {
+	limit, isTx = l.cfg.LimitsTx[msgType]
+	if !isTx {
+		var ok bool
+		if limit, ok = l.cfg.LimitsOther[msgType]; !ok {
+			limit = l.cfg.Default
+		}
+	}
+	if limit.MaxTotalSize == 0 {
+		limit.MaxTotalSize = limit.Frequency * limit.MaxSize
+	}
+	return limit, isTx
+}
+
+// ProcessMsgAndCheck returns nil if message fits into limits.
+// Inbound messages are always counted (they are already received),
+// outbound ones are counted only if allowed (they won't be sent otherwise).
+func (l *RateLimiter) ProcessMsgAndCheck(msgType uint8, size int) *RateLimitViolation {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	period := l.now().UnixNano() / int64(l.cfg.ResetInterval)
+	if period != l.currentPeriod {
+		l.currentPeriod = period
+		l.counts = make(map[uint8]int)
+		l.sizes = make(map[uint8]int)
+		l.nonTxCount = 0
+		l.nonTxSize = 0
+	}
+
+	limit, isTx := l.LimitFor(msgType)
+	newCount := l.counts[msgType] + 1
+	newSize := l.sizes[msgType] + size
+	newNonTxCount := l.nonTxCount
+	newNonTxSize := l.nonTxSize
+	if !isTx {
+		newNonTxCount += 1
+		newNonTxSize += size
+	}
+
+	percent := l.cfg.PercentOfLimit
+	var reason string
+	switch {
+	case !isTx && newNonTxCount*100 > l.cfg.NonTxFrequency*percent:
+		reason = fmt.Sprintf("non-tx messages count %d > %d%% of %d", newNonTxCount, percent, l.cfg.NonTxFrequency)
+	case !isTx && newNonTxSize*100 > l.cfg.NonTxMaxTotalSize*percent:
+		reason = fmt.Sprintf("non-tx messages size %d > %d%% of %d", newNonTxSize, percent, l.cfg.NonTxMaxTotalSize)
+	case newCount*100 > limit.Frequency*percent:
+		reason = fmt.Sprintf("messages count %d > %d%% of %d", newCount, percent, limit.Frequency)
+	case size > limit.MaxSize:
+		reason = fmt.Sprintf("message size %d > %d", size, limit.MaxSize)
+	case newSize*100 > limit.MaxTotalSize*percent:
+		reason = fmt.Sprintf("messages size %d > %d%% of %d", newSize, percent, limit.MaxTotalSize)
+	}
+
+	if l.inbound || reason == "" {
+		l.counts[msgType] = newCount
+		l.sizes[msgType] = newSize
+		l.nonTxCount = newNonTxCount
+		l.nonTxSize = newNonTxSize
+	}
+	if reason == "" {
+		return nil
+	}
+	return &RateLimitViolation{Inbound: l.inbound, MsgType: msgType, Size: size, Reason: reason}
+}
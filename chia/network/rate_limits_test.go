@@ -0,0 +1,83 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	stamp := time.Unix(1600000000, 0)
+	makeLimiter := func(cfg *RateLimitConfig, inbound bool) *RateLimiter {
+		l := NewRateLimiter(cfg, inbound)
+		l.now = func() time.Time { return stamp }
+		return l
+	}
+	assertOk := func(l *RateLimiter, msgType uint8, size int) {
+		t.Helper()
+		if v := l.ProcessMsgAndCheck(msgType, size); v != nil {
+			t.Errorf("unexpected violation: %s", v)
+		}
+	}
+	assertViolation := func(l *RateLimiter, msgType uint8, size int) {
+		t.Helper()
+		if v := l.ProcessMsgAndCheck(msgType, size); v == nil {
+			t.Errorf("expected violation for type %d size %d", msgType, size)
+		}
+	}
+
+	// frequency
+	l := makeLimiter(nil, true)
+	for i := 0; i < 10; i++ {
+		assertOk(l, types.MSG_REQUEST_PEERS, 10)
+	}
+	assertViolation(l, types.MSG_REQUEST_PEERS, 10)
+	// other types are counted separately
+	assertOk(l, types.MSG_NEW_PEAK, 10)
+
+	// counters are reset after interval
+	stamp = stamp.Add(time.Minute)
+	assertOk(l, types.MSG_REQUEST_PEERS, 10)
+
+	// single message size
+	assertViolation(l, types.MSG_REQUEST_PEERS, 101)
+	// tx messages have separate limits
+	assertOk(l, types.MSG_NEW_TRANSACTION, 100)
+	assertViolation(l, types.MSG_NEW_TRANSACTION, 101)
+
+	// non-tx total
+	l = makeLimiter(&RateLimitConfig{NonTxFrequency: 3}, true)
+	assertOk(l, types.MSG_NEW_PEAK, 10)
+	assertOk(l, types.MSG_REQUEST_BLOCK, 10)
+	assertOk(l, types.MSG_REQUEST_PEERS, 10)
+	assertViolation(l, types.MSG_NEW_PEAK, 10)
+	assertOk(l, types.MSG_NEW_TRANSACTION, 10)
+
+	// outbound uses percentage of limit and does not count rejected messages
+	l = makeLimiter(&RateLimitConfig{PercentOfLimit: 30}, false)
+	for i := 0; i < 3; i++ {
+		assertOk(l, types.MSG_REQUEST_PEERS, 10)
+	}
+	assertViolation(l, types.MSG_REQUEST_PEERS, 10)
+	if l.counts[types.MSG_REQUEST_PEERS] != 3 {
+		t.Errorf("rejected outbound message should not be counted, got %d", l.counts[types.MSG_REQUEST_PEERS])
+	}
+	// inbound counts everything
+	l = makeLimiter(&RateLimitConfig{PercentOfLimit: 30}, true)
+	for i := 0; i < 3; i++ {
+		assertOk(l, types.MSG_REQUEST_PEERS, 10)
+	}
+	assertViolation(l, types.MSG_REQUEST_PEERS, 10)
+	if l.counts[types.MSG_REQUEST_PEERS] != 4 {
+		t.Errorf("rejected inbound message should be counted, got %d", l.counts[types.MSG_REQUEST_PEERS])
+	}
+
+	// total size (default MaxTotalSize is Frequency*MaxSize)
+	l = makeLimiter(&RateLimitConfig{LimitsOther: map[uint8]RateLimit{types.MSG_NEW_PEAK: {10, 100, 150}}}, true)
+	assertOk(l, types.MSG_NEW_PEAK, 100)
+	assertViolation(l, types.MSG_NEW_PEAK, 100)
+	limit, isTx := l.LimitFor(types.MSG_REQUEST_PEERS)
+	if isTx || limit != (RateLimit{100, 1024 * 1024, 100 * 1024 * 1024}) {
+		t.Errorf("unknown types should use default limit, got %#v", limit)
+	}
+}
@@ -0,0 +1,169 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ansel1/merry"
+	"github.com/gorilla/websocket"
+)
+
+var ErrConnEvicted = merry.New("connection evicted: too many connections")
+var ErrServerShutdown = merry.New("server shutdown")
+
+type ServerConfig struct {
+	Host string
+	// SERVER_PORT by default
+	Port        uint16
+	Certificate tls.Certificate
+	// If set, peer certificates must be signed by one of these CAs,
+	// otherwise any client certificate is accepted.
+	ClientCAs *x509.CertPool
+	// Maximum number of simultaneous connections, unlimited if zero.
+	MaxConns int
+	// When MaxConns is reached, close the oldest connection instead of rejecting the new one.
+	EvictOldest bool
+	ConnConfig  *WSChiaConnConfig
+}
+
+// Server accepts incoming peer connections on its own mux.
+// Handler is called for each new connection (before handshake) and
+// the connection is closed when handler returns.
+type Server struct {
+	cfg        ServerConfig
+	handler    func(*WSChiaConnection)
+	httpServer *http.Server
+	upgrader   websocket.Upgrader
+	conns      *connList
+	handlersWG sync.WaitGroup
+}
+
+func NewServer(cfg ServerConfig, handler func(*WSChiaConnection)) *Server {
+	if cfg.Port == 0 {
+		cfg.Port = SERVER_PORT
+	}
+	s := &Server{
+		cfg:     cfg,
+		handler: handler,
+		conns:   &connList{limit: int64(cfg.MaxConns)},
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cfg.Certificate},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	if cfg.ClientCAs != nil {
+		tlsCfg.VerifyPeerCertificate = makePeerCertVerifier(cfg.ClientCAs)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWS)
+	s.httpServer = &http.Server{
+		Addr:      net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port))),
+		Handler:   mux,
+		TLSConfig: tlsCfg,
+	}
+	return s
+}
+
+func NewServerFromFiles(cfg ServerConfig, certFilePath, keyFilePath string, handler func(*WSChiaConnection)) (*Server, error) {
+	cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	cfg.Certificate = cert
+	return NewServer(cfg, handler), nil
+}
+
+func (s *Server) Addr() string {
+	return s.httpServer.Addr
+}
+
+func (s *Server) ConnCount() int64 {
+	return s.conns.Len()
+}
+
+// ListenAndServe blocks until server fails or is shut down (returns nil in the latter case).
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return s.Serve(ln)
+}
+
+func (s *Server) Serve(ln net.Listener) error {
+	err := s.httpServer.ServeTLS(ln, "", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return merry.Wrap(err)
+}
+
+// Shutdown stops accepting new connections, closes active ones and
+// waits for their handlers to return (or for ctx to be done).
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	// websocket connections are hijacked, http.Server does not track them
+	for _, c := range s.conns.Conns() {
+		c.CloseWithErr(ErrServerShutdown)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlersWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return merry.Wrap(err)
+}
+
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.EvictOldest && s.cfg.MaxConns > 0 && s.conns.Len() >= int64(s.cfg.MaxConns) {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print("WARN: upgrade failed:", err)
+		return
+	}
+	s.handlersWG.Add(1)
+	defer s.handlersWG.Done()
+	c := NewWSChiaConnection(ws, false, s.cfg.ConnConfig)
+
+	var item *connListItem
+	if s.cfg.EvictOldest && s.cfg.MaxConns > 0 {
+		item = s.conns.PushConn(c)
+		if oldItem := s.conns.ShiftIfNeed(); oldItem != nil {
+			oldItem.conn.CloseWithErr(ErrConnEvicted)
+		}
+	} else {
+		var ok bool
+		item, ok = s.conns.PushConnIfRoom(c)
+		if !ok {
+			c.CloseWithErr(merry.New("too many connections"))
+			return
+		}
+	}
+
+	defer func() {
+		s.conns.DelItemUnlesRemoved(item)
+		c.Close()
+	}()
+	s.handler(c)
+}
@@ -11,10 +11,9 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"net/http"
 	"os"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ansel1/merry"
@@ -26,6 +25,20 @@ const PROTOCOL_VERSION = "0.0.32"
 const SOFTWARE_VERSION = "1.1.7"
 const SERVER_PORT = 8444
 
+// Largest expected message is RespondBlocks/RespondProofOfWeight (50 MiB), plus some overhead.
+const DEFAULT_MAX_MESSAGE_SIZE = 64 * 1024 * 1024
+const DEFAULT_HANDLER_WORKERS = 4
+const DEFAULT_HANDLER_QUEUE_SIZE = 256
+
+const DEFAULT_PING_INTERVAL = 30 * time.Second
+const DEFAULT_READ_TIMEOUT = 3 * DEFAULT_PING_INTERVAL
+const DEFAULT_WRITE_TIMEOUT = 30 * time.Second
+
+var ErrReadTimeout = merry.New("read timeout")
+var ErrIdleTimeout = merry.New("idle timeout")
+var ErrRequestTimeout = merry.New("request timeout")
+var ErrConnClosed = merry.New("connection closed")
+
 func MakeTSLConfigFromFiles(caCertPath, nodeCertPath, nodeKeyPath string) (*tls.Config, error) {
 	caCertBuf, err := os.ReadFile(caCertPath)
 	if err != nil {
@@ -61,31 +74,53 @@ func MakeTSLConfigFromBytes(caCertBuf, nodeCertBuf, nodeKeyBuf []byte) (*tls.Con
 
 func MakeTSLConfig(rootCAs *x509.CertPool, nodeCert tls.Certificate) *tls.Config {
 	return &tls.Config{
-		RootCAs:            rootCAs,
-		Certificates:       []tls.Certificate{nodeCert},
-		InsecureSkipVerify: true,
-		VerifyPeerCertificate: func(certificates [][]byte, verifiedChains [][]*x509.Certificate) error {
-			certs := make([]*x509.Certificate, len(certificates))
-			for i, asn1Data := range certificates {
-				// errors should be already checked in
-				// https://github.com/golang/gofrontend/blob/master/libgo/go/crypto/tls/handshake_client.go
-				// at verifyServerCertificate()
-				cert, _ := x509.ParseCertificate(asn1Data)
-				certs[i] = cert
-			}
+		RootCAs:               rootCAs,
+		Certificates:          []tls.Certificate{nodeCert},
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: makePeerCertVerifier(rootCAs),
+	}
+}
 
-			opts := x509.VerifyOptions{
-				Roots:       rootCAs,
-				CurrentTime: time.Now(),
-				// DNSName:       c.config.ServerName,
-				Intermediates: x509.NewCertPool(),
-			}
-			for _, cert := range certs[1:] {
-				opts.Intermediates.AddCert(cert)
-			}
-			_, err := certs[0].Verify(opts)
-			return err
-		},
+func LoadCACertPool(caCertPath string) (*x509.CertPool, error) {
+	caCertBuf, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCertBuf) {
+		return nil, merry.Errorf("no certificates found in %s", caCertPath)
+	}
+	return rootCAs, nil
+}
+
+// Chia node certificates are not bound to any hostname,
+// so only the chain is checked (hence InsecureSkipVerify + manual check).
+func makePeerCertVerifier(rootCAs *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(certificates [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(certificates) == 0 {
+			return merry.New("no peer certificate")
+		}
+		certs := make([]*x509.Certificate, len(certificates))
+		for i, asn1Data := range certificates {
+			// errors should be already checked in
+			// https://github.com/golang/gofrontend/blob/master/libgo/go/crypto/tls/handshake_client.go
+			// at verifyServerCertificate()
+			cert, _ := x509.ParseCertificate(asn1Data)
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:       rootCAs,
+			CurrentTime: time.Now(),
+			// DNSName:       c.config.ServerName,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
 	}
 }
 
@@ -99,6 +134,9 @@ func ensureBinaryMessage(msgType int) error {
 func readBinaryMessage(ws *websocket.Conn) ([]byte, error) {
 	msgType, buf, err := ws.ReadMessage()
 	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, ErrReadTimeout.Here()
+		}
 		return nil, merry.Wrap(err)
 	}
 	if err := ensureBinaryMessage(msgType); err != nil {
@@ -122,24 +160,110 @@ type Result struct {
 
 type MessageHandler func(id uint16, msg utils.FromBytes)
 
+// TimedMessageHandler also receives the time message was read from the connection
+// (handlers are called from a queue, so time.Now() in handler may be later).
+type TimedMessageHandler func(id uint16, msg utils.FromBytes, receivedAt time.Time)
+
+type incomingMessage struct {
+	id         uint16
+	data       utils.FromBytes
+	receivedAt time.Time
+	handler    TimedMessageHandler
+}
+
+func maxMessageSizeFor(msgType uint8, sizes map[uint8]int) int {
+	if size, ok := sizes[msgType]; ok {
+		return size
+	}
+	if limit, ok := RATE_LIMITS_TX[msgType]; ok {
+		return limit.MaxSize
+	}
+	if limit, ok := RATE_LIMITS_OTHER[msgType]; ok {
+		return limit.MaxSize
+	}
+	return DEFAULT_RATE_LIMIT.MaxSize
+}
+
 type WSChiaConnConfig struct {
 	Debug      bool
 	Dialer     *websocket.Dialer
 	ServerPort uint16
+	// Node type to send in handshake, types.NODE_FULL if zero.
+	NodeType uint8
+	// Capabilities to advertise during handshake, only CAP_BASE if nil.
+	Capabilities []uint16
+	// Per-message-type rate limits, upstream defaults if nil
+	// (DefaultInboundRateLimitConfig/DefaultOutboundRateLimitConfig).
+	// Inbound violation closes connection, outbound message is delayed until it fits limits.
+	InboundRateLimits  *RateLimitConfig
+	OutboundRateLimits *RateLimitConfig
+	DisableRateLimits  bool
+	// Called on every rate limit violation.
+	OnRateLimitViolation func(c *WSChiaConnection, violation RateLimitViolation)
+	// Max size of any incoming message, DEFAULT_MAX_MESSAGE_SIZE if zero.
+	MaxMessageSize int64
+	// Max incoming message data size per message type,
+	// missing types are limited by RateLimit.MaxSize from RATE_LIMITS_TX/RATE_LIMITS_OTHER.
+	MaxMessageSizes map[uint8]int
+	// Number of goroutines calling message handler for incoming non-response messages,
+	// DEFAULT_HANDLER_WORKERS if zero. With 1 worker messages are handled in order of arrival.
+	HandlerWorkers int
+	// Incoming non-response messages waiting for handler, DEFAULT_HANDLER_QUEUE_SIZE if zero.
+	// Connection is closed when queue overflows (peer sends faster than we can handle).
+	HandlerQueueSize int
+	// Websocket ping frequency, DEFAULT_PING_INTERVAL if zero, negative disables pings.
+	PingInterval time.Duration
+	// Connection is closed if nothing (including pongs) is received during this time.
+	// DEFAULT_READ_TIMEOUT if zero, negative disables timeout.
+	ReadTimeout time.Duration
+	// Max duration of a single message write, DEFAULT_WRITE_TIMEOUT if zero, negative disables timeout.
+	WriteTimeout time.Duration
+	// Connection is closed (with ErrIdleTimeout) if no protocol messages were sent or received
+	// during this time (pings are not counted). Disabled if zero.
+	IdleTimeout time.Duration
+	// Receives every sent and received message (see CaptureWriter).
+	Recorder MessageRecorder
+}
+
+func durationOrDefault(value, def time.Duration) time.Duration {
+	if value == 0 {
+		return def
+	}
+	if value < 0 {
+		return 0
+	}
+	return value
 }
 
 // https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/ws_connection.py
 type WSChiaConnection struct {
+	lastActivityNano       int64 // first for 64-bit alignment (atomic)
 	peerID                 [32]byte
 	ws                     *websocket.Conn
 	isOutbound             bool
 	serverPort             uint16
+	nodeType               uint8
 	lastRequestNonce       uint16
 	pendingRequests        map[uint16]chan Result
-	incomingMessageHandler MessageHandler
+	incomingMessageHandler TimedMessageHandler
 	closeErr               error
+	done                   chan struct{}
 	mutex                  *sync.Mutex
+	writeMutex             *sync.Mutex
 	debug                  bool
+	inboundLimiter         *RateLimiter
+	outboundLimiter        *RateLimiter
+	onRateLimitViolation   func(*WSChiaConnection, RateLimitViolation)
+	maxMessageSizes        map[uint8]int
+	handlerWorkers         int
+	incoming               chan incomingMessage
+	capabilities           []uint16
+	peerCapabilities       []uint16
+	pingInterval           time.Duration
+	readTimeout            time.Duration
+	writeTimeout           time.Duration
+	idleTimeout            time.Duration
+	recorder               MessageRecorder
 }
 
 func NewWSChiaConnection(ws *websocket.Conn, isOutbound bool, cfg *WSChiaConnConfig) *WSChiaConnection {
@@ -149,14 +273,72 @@ func NewWSChiaConnection(ws *websocket.Conn, isOutbound bool, cfg *WSChiaConnCon
 	certs := ws.UnderlyingConn().(*tls.Conn).ConnectionState().PeerCertificates
 	peerID := sha256.Sum256(certs[0].Raw)
 
-	return &WSChiaConnection{
-		peerID:          peerID,
-		ws:              ws,
-		isOutbound:      isOutbound,
-		pendingRequests: make(map[uint16]chan Result),
-		mutex:           &sync.Mutex{},
-		debug:           cfg.Debug,
+	serverPort := cfg.ServerPort
+	if serverPort == 0 {
+		serverPort = SERVER_PORT
+	}
+	nodeType := cfg.NodeType
+	if nodeType == 0 {
+		nodeType = types.NODE_FULL
+	}
+	capabilities := cfg.Capabilities
+	if capabilities == nil {
+		capabilities = []uint16{types.CAP_BASE}
+	}
+	maxMessageSize := cfg.MaxMessageSize
+	if maxMessageSize == 0 {
+		maxMessageSize = DEFAULT_MAX_MESSAGE_SIZE
+	}
+	ws.SetReadLimit(maxMessageSize)
+	handlerWorkers := cfg.HandlerWorkers
+	if handlerWorkers == 0 {
+		handlerWorkers = DEFAULT_HANDLER_WORKERS
+	}
+	handlerQueueSize := cfg.HandlerQueueSize
+	if handlerQueueSize == 0 {
+		handlerQueueSize = DEFAULT_HANDLER_QUEUE_SIZE
+	}
+
+	c := &WSChiaConnection{
+		peerID:               peerID,
+		ws:                   ws,
+		isOutbound:           isOutbound,
+		serverPort:           serverPort,
+		nodeType:             nodeType,
+		pendingRequests:      make(map[uint16]chan Result),
+		mutex:                &sync.Mutex{},
+		writeMutex:           &sync.Mutex{},
+		debug:                cfg.Debug,
+		capabilities:         capabilities,
+		onRateLimitViolation: cfg.OnRateLimitViolation,
+		maxMessageSizes:      cfg.MaxMessageSizes,
+		handlerWorkers:       handlerWorkers,
+		incoming:             make(chan incomingMessage, handlerQueueSize),
+		done:                 make(chan struct{}),
+		pingInterval:         durationOrDefault(cfg.PingInterval, DEFAULT_PING_INTERVAL),
+		readTimeout:          durationOrDefault(cfg.ReadTimeout, DEFAULT_READ_TIMEOUT),
+		writeTimeout:         durationOrDefault(cfg.WriteTimeout, DEFAULT_WRITE_TIMEOUT),
+		idleTimeout:          cfg.IdleTimeout,
+		recorder:             cfg.Recorder,
+		lastActivityNano:     time.Now().UnixNano(),
+	}
+	ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+	if !cfg.DisableRateLimits {
+		inCfg := cfg.InboundRateLimits
+		if inCfg == nil {
+			inCfg = DefaultInboundRateLimitConfig()
+		}
+		outCfg := cfg.OutboundRateLimits
+		if outCfg == nil {
+			outCfg = DefaultOutboundRateLimitConfig()
+		}
+		c.inboundLimiter = NewRateLimiter(inCfg, true)
+		c.outboundLimiter = NewRateLimiter(outCfg, false)
 	}
+	return c
 }
 
 func ConnectTo(address string, tlsConfig *tls.Config, cfg *WSChiaConnConfig) (*WSChiaConnection, error) {
@@ -167,9 +349,11 @@ func ConnectTo(address string, tlsConfig *tls.Config, cfg *WSChiaConnConfig) (*W
 	if dialer == nil {
 		dialer = &websocket.Dialer{}
 	}
-	dialer.TLSClientConfig = tlsConfig
+	// copying to not modify dialer shared between connections
+	dialerCopy := *dialer
+	dialerCopy.TLSClientConfig = tlsConfig
 
-	ws, _, err := dialer.Dial("wss://"+address+"/ws", nil)
+	ws, _, err := dialerCopy.Dial("wss://"+address+"/ws", nil)
 	if err != nil {
 		return nil, merry.Wrap(err)
 	}
@@ -177,40 +361,26 @@ func ConnectTo(address string, tlsConfig *tls.Config, cfg *WSChiaConnConfig) (*W
 	return NewWSChiaConnection(ws, true, cfg), nil
 }
 
-func ListenOn(addr, certFilePath, keyFilePath string, cfg *WSChiaConnConfig, handler func(*WSChiaConnection)) error {
-	upgrader := websocket.Upgrader{}
-
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		ws, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Print("WARN: upgrade failed:", err)
-			return
-		}
-		handler(NewWSChiaConnection(ws, false, cfg))
-	})
-
-	server := &http.Server{
-		Addr: addr + ":" + strconv.Itoa(SERVER_PORT),
-		TLSConfig: &tls.Config{
-			ClientAuth: tls.RequireAnyClientCert,
-		},
-	}
-	err := server.ListenAndServeTLS(certFilePath, keyFilePath)
-	return merry.Wrap(err)
-}
-
-func (c WSChiaConnection) PeerID() [32]byte {
+func (c *WSChiaConnection) PeerID() [32]byte {
 	return c.peerID
 }
-func (c WSChiaConnection) PeerIDHex() string {
+func (c *WSChiaConnection) PeerIDHex() string {
 	return hex.EncodeToString(c.peerID[:])
 }
 
-func (c WSChiaConnection) RemoteAddr() net.Addr {
+func (c *WSChiaConnection) RemoteAddr() net.Addr {
 	return c.ws.RemoteAddr()
 }
 
 func (c *WSChiaConnection) SetMessageHandler(handler MessageHandler) {
+	c.SetTimedMessageHandler(func(id uint16, msg utils.FromBytes, receivedAt time.Time) {
+		handler(id, msg)
+	})
+}
+
+func (c *WSChiaConnection) SetTimedMessageHandler(handler TimedMessageHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.incomingMessageHandler = handler
 }
 
@@ -218,101 +388,139 @@ func (c *WSChiaConnection) SetDebug(debug bool) {
 	c.debug = debug
 }
 
-// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/ws_connection.py#L106
-func (c WSChiaConnection) PerformHandshake() (*types.Handshake, error) {
-	if c.isOutbound {
-		msgOut := types.Message{
-			Type: types.MSG_HANDSHAKE,
-			Data: utils.ToByteSlice(types.Handshake{
-				NetworkID:       NETWORK_ID,
-				ProtocolVersion: PROTOCOL_VERSION,
-				SoftwareVersion: SOFTWARE_VERSION,
-				ServerPort:      SERVER_PORT,
-				NodeType:        types.NODE_FULL,
-				Capabilities:    []types.TupleUint16Str{{V0: types.CAP_BASE, V1: "1"}},
-			}),
-		}
-		err := c.ws.WriteMessage(websocket.BinaryMessage, utils.ToByteSlice(msgOut))
-		if err != nil {
-			return nil, merry.Wrap(err)
-		}
+// PeerCapabilities returns all capabilities enabled by peer during handshake.
+func (c *WSChiaConnection) PeerCapabilities() []uint16 {
+	return c.peerCapabilities
+}
 
-		buf, err := readBinaryMessage(c.ws)
-		if err != nil {
-			return nil, merry.Wrap(err)
+// NegotiatedCapabilities returns known capabilities enabled by both sides.
+func (c *WSChiaConnection) NegotiatedCapabilities() []uint16 {
+	var res []uint16
+	for _, capability := range c.capabilities {
+		if _, known := types.CapabilityName(capability); known && c.peerHasCapability(capability) {
+			res = append(res, capability)
 		}
+	}
+	return res
+}
 
-		var msgIn types.Message
-		if err := utils.FromByteSliceExact(buf, &msgIn); err != nil {
-			return nil, merry.Wrap(err)
-		}
-		if msgIn.Type != types.MSG_HANDSHAKE {
-			return nil, merry.Errorf("unexpected message type: expected handshake(%d), got %d",
-				types.MSG_HANDSHAKE, msgIn.Type)
+func (c *WSChiaConnection) HasCapability(capability uint16) bool {
+	for _, item := range c.NegotiatedCapabilities() {
+		if item == capability {
+			return true
 		}
+	}
+	return false
+}
 
-		var hs types.Handshake
-		if err := utils.FromByteSliceExact(msgIn.Data, &hs); err != nil {
-			return nil, merry.Wrap(err)
-		}
-		if hs.NetworkID != NETWORK_ID {
-			return nil, merry.Errorf("unexpected network ID: expected %s, got %s",
-				NETWORK_ID, hs.NetworkID)
-		}
-		return &hs, nil
-	} else {
-		buf, err := readBinaryMessage(c.ws)
-		if err != nil {
-			return nil, merry.Wrap(err)
+func (c *WSChiaConnection) peerHasCapability(capability uint16) bool {
+	for _, item := range c.peerCapabilities {
+		if item == capability {
+			return true
 		}
+	}
+	return false
+}
 
-		var msgIn types.Message
-		if err := utils.FromByteSliceExact(buf, &msgIn); err != nil {
-			return nil, merry.Wrap(err)
-		}
-		if msgIn.Type != types.MSG_HANDSHAKE {
-			return nil, merry.Errorf("unexpected message type: expected handshake(%d), got %d",
-				types.MSG_HANDSHAKE, msgIn.Type)
-		}
+func (c *WSChiaConnection) sendHandshake() error {
+	msgOut := types.Message{
+		Type: types.MSG_HANDSHAKE,
+		Data: utils.ToByteSlice(types.Handshake{
+			NetworkID:       NETWORK_ID,
+			ProtocolVersion: PROTOCOL_VERSION,
+			SoftwareVersion: SOFTWARE_VERSION,
+			ServerPort:      c.serverPort,
+			NodeType:        c.nodeType,
+			Capabilities:    types.CapabilitiesToHandshake(c.capabilities),
+		}),
+	}
+	c.record(CaptureOutbound, msgOut)
+	return merry.Wrap(c.writeMessage(utils.ToByteSlice(msgOut)))
+}
+
+func (c *WSChiaConnection) receiveHandshake() (*types.Handshake, error) {
+	buf, err := c.readMessage()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	var msgIn types.Message
+	if err := utils.FromByteSliceExact(buf, &msgIn); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	c.record(CaptureInbound, msgIn)
+	if msgIn.Type != types.MSG_HANDSHAKE {
+		return nil, merry.Errorf("unexpected message type: expected handshake(%d), got %d",
+			types.MSG_HANDSHAKE, msgIn.Type)
+	}
 
-		var hs types.Handshake
-		if err := utils.FromByteSliceExact(msgIn.Data, &hs); err != nil {
+	var hs types.Handshake
+	if err := utils.FromByteSliceExact(msgIn.Data, &hs); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if hs.NetworkID != NETWORK_ID {
+		return nil, merry.Errorf("unexpected network ID: expected %s, got %s",
+			NETWORK_ID, hs.NetworkID)
+	}
+	return &hs, nil
+}
+
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/ws_connection.py#L106
+func (c *WSChiaConnection) PerformHandshake() (*types.Handshake, error) {
+	var hs *types.Handshake
+	var err error
+	if c.isOutbound {
+		if err := c.sendHandshake(); err != nil {
 			return nil, merry.Wrap(err)
 		}
-		if hs.NetworkID != NETWORK_ID {
-			return nil, merry.Errorf("unexpected network ID: expected %s, got %s",
-				NETWORK_ID, hs.NetworkID)
+		if hs, err = c.receiveHandshake(); err != nil {
+			return nil, merry.Wrap(err)
 		}
-
-		msgOut := types.Message{
-			Type: types.MSG_HANDSHAKE,
-			Data: utils.ToByteSlice(types.Handshake{
-				NetworkID:       NETWORK_ID,
-				ProtocolVersion: PROTOCOL_VERSION,
-				SoftwareVersion: SOFTWARE_VERSION,
-				ServerPort:      SERVER_PORT,
-				NodeType:        types.NODE_FULL,
-				Capabilities:    []types.TupleUint16Str{{V0: types.CAP_BASE, V1: "1"}},
-			}),
+	} else {
+		if hs, err = c.receiveHandshake(); err != nil {
+			return nil, merry.Wrap(err)
 		}
-		err = c.ws.WriteMessage(websocket.BinaryMessage, utils.ToByteSlice(msgOut))
-		if err != nil {
+		if err := c.sendHandshake(); err != nil {
 			return nil, merry.Wrap(err)
 		}
-		return &hs, nil
 	}
+	c.peerCapabilities = types.CapabilitiesFromHandshake(hs.Capabilities)
+	return hs, nil
 }
 
 func (c *WSChiaConnection) StartRoutines() {
+	for i := 0; i < c.handlerWorkers; i++ {
+		go c.handlerRoutine()
+	}
 	go c.readRoutine()
+	if c.pingInterval > 0 || c.idleTimeout > 0 {
+		go c.keepaliveRoutine()
+	}
+}
+
+// Done returns a channel that is closed when connection is closed.
+func (c *WSChiaConnection) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason connection was closed with, nil if it is still open.
+func (c *WSChiaConnection) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeErr
 }
 
 func (c *WSChiaConnection) CloseWithErr(err error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	// closeErr is also a "closed" flag, so it must not stay nil
+	if err == nil {
+		err = ErrConnClosed.Here()
+	}
 	if c.closeErr == nil {
 		c.closeErr = err
+		close(c.done)
 		if err := c.ws.Close(); err != nil {
 			log.Printf("WARN: error while closing connection: %s", err)
 		}
@@ -334,67 +542,210 @@ func (c *WSChiaConnection) Close() {
 
 func (c *WSChiaConnection) readRoutine() {
 	for {
-		if c.closeErr != nil {
+		if c.isClosed() {
 			break
 		}
-		buf, err := readBinaryMessage(c.ws)
+		buf, err := c.readMessage()
 		if err != nil {
 			c.CloseWithErr(err)
 			break
 		}
-		if err := c.processMessageBytes(buf); err != nil {
+		receivedAt := time.Now()
+		c.touch(receivedAt)
+		if err := c.processMessageBytes(buf, receivedAt); err != nil {
 			c.CloseWithErr(err)
 			break
 		}
 	}
+	close(c.incoming)
+}
+
+// keepaliveRoutine sends pings (peer liveness is then checked by read deadline)
+// and closes connection if it stays idle for too long.
+func (c *WSChiaConnection) keepaliveRoutine() {
+	interval := c.pingInterval
+	if interval == 0 || (c.idleTimeout > 0 && c.idleTimeout/4 < interval) {
+		interval = c.idleTimeout / 4
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPing := time.Now()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			if c.idleTimeout > 0 {
+				lastActivity := time.Unix(0, atomic.LoadInt64(&c.lastActivityNano))
+				if now.Sub(lastActivity) > c.idleTimeout {
+					c.CloseWithErr(ErrIdleTimeout.Here())
+					return
+				}
+			}
+			if c.pingInterval > 0 && now.Sub(lastPing) >= c.pingInterval {
+				lastPing = now
+				deadline := now.Add(c.writeTimeout)
+				if c.writeTimeout == 0 {
+					deadline = time.Time{}
+				}
+				if err := c.ws.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					c.CloseWithErr(merry.Wrap(err))
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *WSChiaConnection) record(direction CaptureDirection, msg types.Message) {
+	if c.recorder != nil {
+		c.recorder.RecordMessage(c, direction, msg)
+	}
+}
+
+func (c *WSChiaConnection) touch(now time.Time) {
+	atomic.StoreInt64(&c.lastActivityNano, now.UnixNano())
+}
+
+func (c *WSChiaConnection) extendReadDeadline() {
+	if c.readTimeout > 0 {
+		c.ws.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+}
+
+func (c *WSChiaConnection) readMessage() ([]byte, error) {
+	c.extendReadDeadline()
+	return readBinaryMessage(c.ws)
+}
+
+func (c *WSChiaConnection) writeMessage(buf []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.writeTimeout > 0 {
+		c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return merry.Wrap(c.ws.WriteMessage(websocket.BinaryMessage, buf))
+}
+
+func (c *WSChiaConnection) handlerRoutine() {
+	for msg := range c.incoming {
+		msg.handler(msg.id, msg.data, msg.receivedAt)
+	}
 }
 
-func (c *WSChiaConnection) processMessageBytes(msgBuf []byte) error {
+func (c *WSChiaConnection) processMessageBytes(msgBuf []byte, receivedAt time.Time) error {
 	var msg types.Message
 	if err := utils.FromByteSliceExact(msgBuf, &msg); err != nil {
 		return merry.Wrap(err)
 	}
+	c.record(CaptureInbound, msg)
+	if maxSize := maxMessageSizeFor(msg.Type, c.maxMessageSizes); len(msg.Data) > maxSize {
+		return merry.Errorf("message of type %d is too large: %d > %d", msg.Type, len(msg.Data), maxSize)
+	}
+	if c.inboundLimiter != nil {
+		if violation := c.inboundLimiter.ProcessMsgAndCheck(msg.Type, len(msg.Data)); violation != nil {
+			c.reportRateLimitViolation(*violation)
+			return merry.Wrap(violation)
+		}
+	}
 	dataStruct, ok := types.MessageTypeStruct(msg.Type)
 	if !ok {
 		log.Printf("WARN: unsupported message type: %d", msg.Type)
 		return nil
 	}
-	return merry.Wrap(c.processMessageOfType(msg, dataStruct))
+	return merry.Wrap(c.processMessageOfType(msg, dataStruct, receivedAt))
 }
 
-func (c *WSChiaConnection) processMessageOfType(msg types.Message, data utils.FromBytes) error {
+func (c *WSChiaConnection) processMessageOfType(msg types.Message, data utils.FromBytes, receivedAt time.Time) error {
 	if err := utils.FromByteSliceExact(msg.Data, data); err != nil {
 		return merry.Wrap(err)
 	}
 
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	resChan, ok := c.pendingRequests[msg.ID]
 	if ok {
 		delete(c.pendingRequests, msg.ID)
 		resChan <- Result{Data: data}
 		close(resChan)
-	} else {
-		if c.incomingMessageHandler == nil {
-			if c.debug {
-				log.Printf("DEBUG: ignoring incoming non-response message with ID=%d", msg.ID)
-			}
-		} else {
-			go c.incomingMessageHandler(msg.ID, data)
+	}
+	handler := c.incomingMessageHandler
+	c.mutex.Unlock()
+
+	if ok {
+		return nil
+	}
+	if handler == nil {
+		if c.debug {
+			log.Printf("DEBUG: ignoring incoming non-response message with ID=%d", msg.ID)
 		}
+		return nil
 	}
-	return nil
+	select {
+	case c.incoming <- incomingMessage{id: msg.ID, data: data, receivedAt: receivedAt, handler: handler}:
+		return nil
+	default:
+		return merry.Errorf("incoming messages queue overflow (%d messages)", cap(c.incoming))
+	}
+}
+
+func (c *WSChiaConnection) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closeErr != nil
 }
 
-func (c *WSChiaConnection) SendMessage(msg types.Message) {
-	err := c.ws.WriteMessage(websocket.BinaryMessage, utils.ToByteSlice(msg))
+func (c *WSChiaConnection) reportRateLimitViolation(violation RateLimitViolation) {
+	if c.debug {
+		log.Printf("DEBUG: %s", violation)
+	}
+	if c.onRateLimitViolation != nil {
+		c.onRateLimitViolation(c, violation)
+	}
+}
+
+// waitOutboundRateLimit blocks until message fits outbound rate limits.
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/ws_connection.py (_wait_and_retry)
+func (c *WSChiaConnection) waitOutboundRateLimit(msg types.Message) error {
+	for {
+		violation := c.outboundLimiter.ProcessMsgAndCheck(msg.Type, len(msg.Data))
+		if violation == nil {
+			return nil
+		}
+		c.reportRateLimitViolation(*violation)
+		limit, _ := c.outboundLimiter.LimitFor(msg.Type)
+		// upstream drops RespondPeers too: its limits are too low to wait for
+		if msg.Type == types.MSG_RESPOND_PEERS || len(msg.Data) > limit.MaxSize {
+			return merry.Wrap(violation)
+		}
+		if c.isClosed() {
+			return merry.Wrap(violation)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (c *WSChiaConnection) SendMessage(msg types.Message) error {
+	if c.outboundLimiter != nil {
+		if err := c.waitOutboundRateLimit(msg); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	c.record(CaptureOutbound, msg)
+	err := c.writeMessage(utils.ToByteSlice(msg))
 	if err != nil {
 		c.CloseWithErr(err)
+		return merry.Wrap(err)
 	}
+	c.touch(time.Now())
+	return nil
 }
 
 func (c *WSChiaConnection) SendRequest(request utils.ToBytes) chan Result {
+	_, respChan := c.sendRequest(request)
+	return respChan
+}
+
+func (c *WSChiaConnection) sendRequest(request utils.ToBytes) (uint16, chan Result) {
 	msgType := mustGetMessageType(request)
 	c.mutex.Lock()
 
@@ -423,8 +774,17 @@ func (c *WSChiaConnection) SendRequest(request utils.ToBytes) chan Result {
 	c.pendingRequests[msg.ID] = respChan
 	c.mutex.Unlock()
 
-	c.SendMessage(msg)
-	return respChan
+	if err := c.SendMessage(msg); err != nil {
+		c.mutex.Lock()
+		// may be already removed (and closed) by CloseWithErr
+		if _, ok := c.pendingRequests[msg.ID]; ok {
+			delete(c.pendingRequests, msg.ID)
+			respChan <- Result{Err: err}
+			close(respChan)
+		}
+		c.mutex.Unlock()
+	}
+	return msg.ID, respChan
 }
 
 func (c *WSChiaConnection) SendRequestSync(request utils.ToBytes) (utils.FromBytes, error) {
@@ -436,16 +796,38 @@ func (c *WSChiaConnection) SendRequestSync(request utils.ToBytes) (utils.FromByt
 	return res.Data, nil
 }
 
-func (c *WSChiaConnection) SendReply(replyToID uint16, response utils.ToBytes) {
-	c.SendMessage(types.Message{
+// SendRequestSyncTimeout is like SendRequestSync but fails with ErrRequestTimeout
+// if there is no response during timeout (connection stays open).
+func (c *WSChiaConnection) SendRequestSyncTimeout(request utils.ToBytes, timeout time.Duration) (utils.FromBytes, error) {
+	msgID, respChan := c.sendRequest(request)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-respChan:
+		if res.Err != nil {
+			return nil, merry.Wrap(res.Err)
+		}
+		return res.Data, nil
+	case <-timer.C:
+		c.mutex.Lock()
+		if c.pendingRequests[msgID] == respChan {
+			delete(c.pendingRequests, msgID)
+		}
+		c.mutex.Unlock()
+		return nil, ErrRequestTimeout.Here()
+	}
+}
+
+func (c *WSChiaConnection) SendReply(replyToID uint16, response utils.ToBytes) error {
+	return c.SendMessage(types.Message{
 		Type: mustGetMessageType(response),
 		ID:   replyToID,
 		Data: utils.ToByteSlice(response),
 	})
 }
 
-func (c *WSChiaConnection) Send(data utils.ToBytes) {
-	c.SendMessage(types.Message{
+func (c *WSChiaConnection) Send(data utils.ToBytes) error {
+	return c.SendMessage(types.Message{
 		Type: mustGetMessageType(data),
 		Data: utils.ToByteSlice(data),
 	})
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessMessageBytes(t *testing.T) {
+	makeConn := func(queueSize int) *WSChiaConnection {
+		return &WSChiaConnection{
+			pendingRequests:        make(map[uint16]chan Result),
+			mutex:                  &sync.Mutex{},
+			incoming:               make(chan incomingMessage, queueSize),
+			incomingMessageHandler: func(id uint16, msg utils.FromBytes, receivedAt time.Time) {},
+		}
+	}
+	msgBytes := func(msgType uint8, id uint16, data utils.ToBytes) []byte {
+		return utils.ToByteSlice(types.Message{Type: msgType, ID: id, Data: utils.ToByteSlice(data)})
+	}
+	assertErrContains := func(err error, substr string) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), substr) {
+			t.Errorf("expected error with %q, got %v", substr, err)
+		}
+	}
+
+	// responses go to pending requests, others are queued
+	c := makeConn(1)
+	resChan := make(chan Result, 1)
+	c.pendingRequests[5] = resChan
+	if err := c.processMessageBytes(msgBytes(types.MSG_RESPOND_PEERS, 5, types.RespondPeers{}), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-resChan; res.Err != nil || res.Data.(*types.RespondPeers) == nil {
+		t.Errorf("unexpected result: %#v", res)
+	}
+	receivedAt := time.Unix(1600000000, 0)
+	if err := c.processMessageBytes(msgBytes(types.MSG_REQUEST_PEERS, 0, types.RequestPeers{}), receivedAt); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.incoming) != 1 {
+		t.Errorf("expected one queued message, got %d", len(c.incoming))
+	}
+
+	// queue overflow
+	err := c.processMessageBytes(msgBytes(types.MSG_REQUEST_PEERS, 0, types.RequestPeers{}), time.Now())
+	assertErrContains(err, "queue overflow")
+	if msg := <-c.incoming; !msg.receivedAt.Equal(receivedAt) {
+		t.Errorf("queued message: expected receive time %s, got %s", receivedAt, msg.receivedAt)
+	}
+
+	// size limits
+	c = makeConn(1)
+	peers := types.RespondPeers{PeerList: []types.TimestampedPeerInfo{{Host: strings.Repeat("a", 100)}}}
+	c.maxMessageSizes = map[uint8]int{types.MSG_RESPOND_PEERS: 100}
+	err = c.processMessageBytes(msgBytes(types.MSG_RESPOND_PEERS, 0, peers), time.Now())
+	assertErrContains(err, "too large")
+	c.maxMessageSizes = nil
+	if err := c.processMessageBytes(msgBytes(types.MSG_RESPOND_PEERS, 0, peers), time.Now()); err != nil {
+		t.Error(err)
+	}
+	// default limit comes from rate limits table
+	bigPeers := types.RespondPeers{PeerList: []types.TimestampedPeerInfo{{Host: strings.Repeat("a", 1000)}}}
+	err = c.processMessageBytes(msgBytes(types.MSG_NEW_PEAK, 0, bigPeers), time.Now())
+	assertErrContains(err, "too large")
+}
@@ -0,0 +1,265 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+type PlotsReportConfig struct {
+	StartHeight uint32
+	// Peak if zero.
+	EndHeight uint32
+	// Points are made either for every BucketDuration (aligned to UTC)
+	// or for every BucketBlocks blocks. One day if both are zero.
+	BucketDuration time.Duration
+	BucketBlocks   uint32
+}
+
+// ParseBucket sets bucket from "hour", "day", "week" or "<N>blocks" string.
+func (c *PlotsReportConfig) ParseBucket(str string) (err error) {
+	c.BucketDuration, c.BucketBlocks, err = parseBucket(str)
+	return merry.Wrap(err)
+}
+
+// PlotsStats contains winning proofs stats for some range of blocks.
+type PlotsStats struct {
+	BlocksCount int
+	// Blocks count by plot k-size.
+	KSizes map[uint8]int
+	// Blocks with pool public key (OG plots) and with pool contract puzzle hash (NFT plots).
+	OGBlocks  int
+	NFTBlocks int
+	// Number of distinct plot public keys.
+	UniquePlots int
+	// Number of plot public keys not seen in previous blocks of the report.
+	NewPlots int
+}
+
+func (s *PlotsStats) add(pos *types.ProofOfSpace) {
+	s.BlocksCount += 1
+	if s.KSizes == nil {
+		s.KSizes = make(map[uint8]int)
+	}
+	s.KSizes[pos.Size] += 1
+	if pos.PoolContractPuzzleHash != nil {
+		s.NFTBlocks += 1
+	} else {
+		s.OGBlocks += 1
+	}
+}
+
+func (s PlotsStats) KSizeShare(k uint8) float64 {
+	if s.BlocksCount == 0 {
+		return 0
+	}
+	return float64(s.KSizes[k]) / float64(s.BlocksCount)
+}
+
+func (s PlotsStats) NFTShare() float64 {
+	if s.BlocksCount == 0 {
+		return 0
+	}
+	return float64(s.NFTBlocks) / float64(s.BlocksCount)
+}
+
+type PlotsPoint struct {
+	PlotsStats
+	// Bucket start time for time buckets, first block time for block buckets.
+	BucketStart time.Time
+	StartHeight uint32
+	EndHeight   uint32
+}
+
+type PlotsReport struct {
+	Total  PlotsStats
+	Points []PlotsPoint
+}
+
+// KSizes returns all k-sizes found in report, ascending.
+func (r *PlotsReport) KSizes() []uint8 {
+	var res []uint8
+	for k := range r.Total.KSizes {
+		res = append(res, k)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// plotKey is a plot public key prefix, long enough to avoid collisions
+// but twice as small as the key itself (there may be millions of them).
+type plotKey [16]byte
+
+// MakePlotsReport aggregates winning proofs of space by k-size and pool type
+// (OG vs NFT) for consecutive buckets of blocks.
+func MakePlotsReport(src BlockSource, cfg PlotsReportConfig) (*PlotsReport, error) {
+	if cfg.BucketDuration == 0 && cfg.BucketBlocks == 0 {
+		cfg.BucketDuration = 24 * time.Hour
+	}
+	if cfg.EndHeight == 0 {
+		cfg.EndHeight = math.MaxUint32
+	}
+
+	// range may start with non-transaction block, its time is taken from previous blocks
+	prevStamp, err := lastTimestampBefore(src, cfg.StartHeight)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	report := &PlotsReport{Total: PlotsStats{KSizes: make(map[uint8]int)}}
+	allPlots := make(map[plotKey]struct{})
+	var bucketPlots map[plotKey]struct{}
+	var cur *PlotsPoint
+	buckets := newBucketer(cfg.BucketDuration, cfg.BucketBlocks)
+
+	finish := func() {
+		if cur != nil {
+			cur.UniquePlots = len(bucketPlots)
+			report.Points = append(report.Points, *cur)
+		}
+	}
+
+	err = src.ForEachFullBlock(cfg.StartHeight, cfg.EndHeight, func(block *types.FullBlock) error {
+		height := block.RewardChainBlock.Height
+		pos := &block.RewardChainBlock.ProofOfSpace
+
+		// non-transaction blocks have no timestamps, using the previous one
+		stamp := prevStamp
+		if block.FoliageTransactionBlock != nil {
+			stamp = time.Unix(int64(block.FoliageTransactionBlock.Timestamp), 0).UTC()
+		}
+		prevStamp = stamp
+
+		if bucketStart, isNew := buckets.next(height, stamp); isNew {
+			finish()
+			cur = &PlotsPoint{PlotsStats: PlotsStats{KSizes: make(map[uint8]int)}, BucketStart: bucketStart, StartHeight: height}
+			bucketPlots = make(map[plotKey]struct{})
+		}
+		cur.EndHeight = height
+		cur.add(pos)
+		report.Total.add(pos)
+
+		var pk plotKey
+		copy(pk[:], pos.PlotPublicKey.Bytes)
+		bucketPlots[pk] = struct{}{}
+		if _, ok := allPlots[pk]; !ok {
+			allPlots[pk] = struct{}{}
+			cur.NewPlots += 1
+			report.Total.NewPlots += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	finish()
+	report.Total.UniquePlots = len(allPlots)
+	return report, nil
+}
+
+// PrintPlotsReport writes report points in "csv", "json" or "table" format.
+func PrintPlotsReport(out io.Writer, report *PlotsReport, format string) error {
+	const timeFmt = "2006-01-02 15:04:05"
+	kSizes := report.KSizes()
+
+	switch format {
+	case "csv":
+		w := csv.NewWriter(out)
+		header := []string{"bucket_start", "start_height", "end_height", "blocks", "og_blocks", "nft_blocks", "unique_plots", "new_plots"}
+		for _, k := range kSizes {
+			header = append(header, "k"+strconv.Itoa(int(k)))
+		}
+		w.Write(header)
+		for _, p := range report.Points {
+			row := []string{
+				p.BucketStart.Format(time.RFC3339),
+				strconv.FormatUint(uint64(p.StartHeight), 10), strconv.FormatUint(uint64(p.EndHeight), 10),
+				strconv.Itoa(p.BlocksCount), strconv.Itoa(p.OGBlocks), strconv.Itoa(p.NFTBlocks),
+				strconv.Itoa(p.UniquePlots), strconv.Itoa(p.NewPlots),
+			}
+			for _, k := range kSizes {
+				row = append(row, strconv.Itoa(p.KSizes[k]))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return merry.Wrap(w.Error())
+	case "json":
+		type jsonStats struct {
+			Blocks      int            `json:"blocks"`
+			KSizes      map[string]int `json:"k_sizes"`
+			OGBlocks    int            `json:"og_blocks"`
+			NFTBlocks   int            `json:"nft_blocks"`
+			UniquePlots int            `json:"unique_plots"`
+			NewPlots    int            `json:"new_plots"`
+		}
+		type jsonPoint struct {
+			BucketStart time.Time `json:"bucket_start"`
+			StartHeight uint32    `json:"start_height"`
+			EndHeight   uint32    `json:"end_height"`
+			jsonStats
+		}
+		toJSON := func(s PlotsStats) jsonStats {
+			res := jsonStats{s.BlocksCount, make(map[string]int), s.OGBlocks, s.NFTBlocks, s.UniquePlots, s.NewPlots}
+			for k, count := range s.KSizes {
+				res.KSizes["k"+strconv.Itoa(int(k))] = count
+			}
+			return res
+		}
+		res := struct {
+			Total  jsonStats   `json:"total"`
+			Points []jsonPoint `json:"points"`
+		}{Total: toJSON(report.Total), Points: []jsonPoint{}}
+		for _, p := range report.Points {
+			res.Points = append(res.Points, jsonPoint{p.BucketStart, p.StartHeight, p.EndHeight, toJSON(p.PlotsStats)})
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return merry.Wrap(enc.Encode(res))
+	case "table":
+		printRow := func(label string, height uint32, s PlotsStats) error {
+			if _, err := fmt.Fprintf(out, "%-19s  %9d  %7d", label, height, s.BlocksCount); err != nil {
+				return merry.Wrap(err)
+			}
+			for _, k := range kSizes {
+				if _, err := fmt.Fprintf(out, "  %5.1f%%", s.KSizeShare(k)*100); err != nil {
+					return merry.Wrap(err)
+				}
+			}
+			_, err := fmt.Fprintf(out, "  %5.1f%%  %8d  %8d\n", s.NFTShare()*100, s.UniquePlots, s.NewPlots)
+			return merry.Wrap(err)
+		}
+
+		if _, err := fmt.Fprintf(out, "%-19s  %9s  %7s", "bucket start", "height", "blocks"); err != nil {
+			return merry.Wrap(err)
+		}
+		for _, k := range kSizes {
+			if _, err := fmt.Fprintf(out, "  %6s", "k"+strconv.Itoa(int(k))); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		if _, err := fmt.Fprintf(out, "  %6s  %8s  %8s\n", "NFT", "plots", "new"); err != nil {
+			return merry.Wrap(err)
+		}
+		for _, p := range report.Points {
+			if err := printRow(p.BucketStart.Format(timeFmt), p.EndHeight, p.PlotsStats); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		var endHeight uint32
+		if len(report.Points) > 0 {
+			endHeight = report.Points[len(report.Points)-1].EndHeight
+		}
+		return merry.Wrap(printRow("total", endHeight, report.Total))
+	default:
+		return merry.Errorf(`unexpected format "%s", expected "csv", "json" or "table"`, format)
+	}
+}
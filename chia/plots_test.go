@@ -0,0 +1,75 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"testing"
+	"time"
+)
+
+func TestMakePlotsReport(t *testing.T) {
+	// blocks [0-9]: OG k32 plots 0,1,2; [10-19]: NFT k33 plots 2,3 (plot 2 is replotted with same key)
+	blocks, records := testSourceBlocks(20, 1)
+	contract := [32]byte{5}
+	for i := range blocks {
+		pos := &blocks[i].RewardChainBlock.ProofOfSpace
+		if i < 10 {
+			pos.Size = 32
+			pos.PoolPublicKey = &types.G1Element{Bytes: make([]byte, 48)}
+			pos.PlotPublicKey.Bytes[0] = byte(i % 3)
+		} else {
+			pos.Size = 33
+			pos.PoolContractPuzzleHash = &contract
+			pos.PlotPublicKey.Bytes[0] = byte(2 + i%2)
+		}
+		if i > 0 {
+			blocks[i].Foliage.PrevBlockHash = blocks[i-1].HeaderHash()
+		}
+		if i != 5 {
+			blocks[i].FoliageTransactionBlock = &types.FoliageTransactionBlock{Timestamp: records[i].Timestamp}
+		} else {
+			records[i].Timestamp = 0
+		}
+		records[i].HeaderHash = blocks[i].HeaderHash()
+		records[i].PrevHash = blocks[i].Foliage.PrevBlockHash
+	}
+	src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	report, err := MakePlotsReport(src, PlotsReportConfig{BucketBlocks: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(report.Points))
+	}
+	p0, p1 := report.Points[0], report.Points[1]
+	if p0.StartHeight != 0 || p0.EndHeight != 9 || p0.BlocksCount != 10 || p0.KSizes[32] != 10 ||
+		p0.OGBlocks != 10 || p0.NFTBlocks != 0 || p0.UniquePlots != 3 || p0.NewPlots != 3 {
+		t.Errorf("unexpected first point: %+v", p0)
+	}
+	if p1.StartHeight != 10 || p1.EndHeight != 19 || p1.BlocksCount != 10 || p1.KSizes[33] != 10 ||
+		p1.OGBlocks != 0 || p1.NFTBlocks != 10 || p1.UniquePlots != 2 || p1.NewPlots != 1 {
+		t.Errorf("unexpected second point: %+v", p1)
+	}
+	total := report.Total
+	if total.BlocksCount != 20 || total.KSizeShare(32) != 0.5 || total.NFTShare() != 0.5 ||
+		total.UniquePlots != 4 || total.NewPlots != 4 {
+		t.Errorf("unexpected total: %+v", total)
+	}
+	if ks := report.KSizes(); len(ks) != 2 || ks[0] != 32 || ks[1] != 33 {
+		t.Errorf("unexpected k-sizes: %v", ks)
+	}
+
+	// starting at non-transaction block
+	report, err = MakePlotsReport(src, PlotsReportConfig{StartHeight: 5, EndHeight: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Unix(1600000000, 0).UTC().Truncate(24 * time.Hour)
+	if len(report.Points) != 1 || !report.Points[0].BucketStart.Equal(day) || report.Points[0].BlocksCount != 2 {
+		t.Errorf("unexpected points: %+v", report.Points)
+	}
+}
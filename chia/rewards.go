@@ -0,0 +1,313 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+const MOJO_PER_XCH = 1000 * 1000 * 1000 * 1000
+
+type RewardsReportConfig struct {
+	StartHeight uint32
+	// Peak if zero.
+	EndHeight uint32
+	// If not empty, only these farmer/pool puzzle hashes are reported.
+	PuzzleHashes map[[32]byte]bool
+	// Pool shares are calculated either for every ShareBucketDuration (aligned to UTC)
+	// or for every ShareBucketBlocks blocks. One day if both are zero.
+	ShareBucketDuration time.Duration
+	ShareBucketBlocks   uint32
+}
+
+// ParseShareBucket sets share bucket from "hour", "day", "week" or "<N>blocks" string.
+func (c *RewardsReportConfig) ParseShareBucket(str string) (err error) {
+	c.ShareBucketDuration, c.ShareBucketBlocks, err = parseBucket(str)
+	return merry.Wrap(err)
+}
+
+// AddAddresses adds puzzle hashes of comma-separated addresses to the filter.
+func (c *RewardsReportConfig) AddAddresses(addresses string) error {
+	for _, addr := range strings.Split(addresses, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		_, ph, err := DecodePuzzleHash(addr)
+		if err != nil {
+			return merry.Prependf(err, "address %s", addr)
+		}
+		if c.PuzzleHashes == nil {
+			c.PuzzleHashes = make(map[[32]byte]bool)
+		}
+		c.PuzzleHashes[ph] = true
+	}
+	return nil
+}
+
+type RewardsEntry struct {
+	PuzzleHash [32]byte
+	// Number of blocks with this puzzle hash as farmer reward target.
+	FarmerBlocks int
+	// Number of blocks with this puzzle hash as pool target.
+	PoolBlocks int
+	// Reward coins (and their total amount in mojos) sent to this puzzle hash.
+	RewardCoins int
+	Rewards     uint64
+	FirstHeight uint32
+	LastHeight  uint32
+}
+
+type PoolSharePoint struct {
+	// Bucket start time for time buckets, first block time for block buckets.
+	BucketStart time.Time
+	StartHeight uint32
+	EndHeight   uint32
+	BlocksCount int
+	// Blocks count per pool puzzle hash.
+	PoolBlocks map[[32]byte]int
+}
+
+func (p PoolSharePoint) Share(puzzleHash [32]byte) float64 {
+	if p.BlocksCount == 0 {
+		return 0
+	}
+	return float64(p.PoolBlocks[puzzleHash]) / float64(p.BlocksCount)
+}
+
+type RewardsReport struct {
+	// Sorted by rewards, then by blocks count, descending.
+	Entries []*RewardsEntry
+	Shares  []PoolSharePoint
+}
+
+// MakeRewardsReport aggregates blocks won and rewards claimed per farmer/pool puzzle hash.
+//
+// Rewards are taken from RewardClaimsIncorporated of transaction blocks in range, so claims
+// of the first transaction block may be for blocks a bit before StartHeight.
+func MakeRewardsReport(src BlockSource, cfg RewardsReportConfig) (*RewardsReport, error) {
+	if cfg.ShareBucketDuration == 0 && cfg.ShareBucketBlocks == 0 {
+		cfg.ShareBucketDuration = 24 * time.Hour
+	}
+	if cfg.EndHeight == 0 {
+		cfg.EndHeight = math.MaxUint32
+	}
+	matches := func(ph [32]byte) bool {
+		return len(cfg.PuzzleHashes) == 0 || cfg.PuzzleHashes[ph]
+	}
+
+	entries := make(map[[32]byte]*RewardsEntry)
+	getEntry := func(ph [32]byte, height uint32) *RewardsEntry {
+		entry, ok := entries[ph]
+		if !ok {
+			entry = &RewardsEntry{PuzzleHash: ph, FirstHeight: height}
+			entries[ph] = entry
+		}
+		entry.LastHeight = height
+		return entry
+	}
+
+	// range may start with non-transaction block, its time is taken from previous blocks
+	prevStamp, err := lastTimestampBefore(src, cfg.StartHeight)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	var shares []PoolSharePoint
+	var cur *PoolSharePoint
+	buckets := newBucketer(cfg.ShareBucketDuration, cfg.ShareBucketBlocks)
+
+	err = src.ForEachBlockRecord(cfg.StartHeight, cfg.EndHeight, func(br *types.BlockRecord) error {
+		if matches(br.FarmerPuzzleHash) {
+			getEntry(br.FarmerPuzzleHash, br.Height).FarmerBlocks += 1
+		}
+		if matches(br.PoolPuzzleHash) {
+			getEntry(br.PoolPuzzleHash, br.Height).PoolBlocks += 1
+		}
+		for _, coin := range br.RewardClaimsIncorporated {
+			if matches(coin.PuzzleHash) {
+				entry := getEntry(coin.PuzzleHash, br.Height)
+				entry.RewardCoins += 1
+				entry.Rewards += coin.Amount
+			}
+		}
+
+		// non-transaction blocks have no timestamps, using the previous one
+		stamp := prevStamp
+		if br.Timestamp != 0 {
+			stamp = time.Unix(int64(br.Timestamp), 0).UTC()
+		}
+		prevStamp = stamp
+
+		if bucketStart, isNew := buckets.next(br.Height, stamp); isNew {
+			if cur != nil {
+				shares = append(shares, *cur)
+			}
+			cur = &PoolSharePoint{BucketStart: bucketStart, StartHeight: br.Height, PoolBlocks: make(map[[32]byte]int)}
+		}
+		cur.EndHeight = br.Height
+		cur.BlocksCount += 1
+		if matches(br.PoolPuzzleHash) {
+			cur.PoolBlocks[br.PoolPuzzleHash] += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cur != nil {
+		shares = append(shares, *cur)
+	}
+
+	report := &RewardsReport{Shares: shares}
+	for _, entry := range entries {
+		report.Entries = append(report.Entries, entry)
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		a, b := report.Entries[i], report.Entries[j]
+		if a.Rewards != b.Rewards {
+			return a.Rewards > b.Rewards
+		}
+		if a.FarmerBlocks+a.PoolBlocks != b.FarmerBlocks+b.PoolBlocks {
+			return a.FarmerBlocks+a.PoolBlocks > b.FarmerBlocks+b.PoolBlocks
+		}
+		return string(a.PuzzleHash[:]) < string(b.PuzzleHash[:])
+	})
+	return report, nil
+}
+
+// topPools returns up to count pool puzzle hashes with the most blocks in the whole report.
+func (r *RewardsReport) topPools(count int) [][32]byte {
+	var pools []*RewardsEntry
+	for _, entry := range r.Entries {
+		if entry.PoolBlocks > 0 {
+			pools = append(pools, entry)
+		}
+	}
+	sort.SliceStable(pools, func(i, j int) bool { return pools[i].PoolBlocks > pools[j].PoolBlocks })
+	if len(pools) > count {
+		pools = pools[:count]
+	}
+	res := make([][32]byte, len(pools))
+	for i, entry := range pools {
+		res[i] = entry.PuzzleHash
+	}
+	return res
+}
+
+// PrintRewardsReport writes report in "table" or "json" format. Puzzle hashes are shown as
+// addresses with the given prefix ("xch", "txch"). Table output contains up to topCount
+// entries and pool shares of topCount pools with the most blocks.
+func PrintRewardsReport(out io.Writer, report *RewardsReport, format, prefix string, topCount int) error {
+	const timeFmt = "2006-01-02 15:04:05"
+
+	switch format {
+	case "json":
+		type jsonEntry struct {
+			Address      string  `json:"address"`
+			PuzzleHash   string  `json:"puzzle_hash"`
+			FarmerBlocks int     `json:"farmer_blocks"`
+			PoolBlocks   int     `json:"pool_blocks"`
+			RewardCoins  int     `json:"reward_coins"`
+			RewardsMojo  uint64  `json:"rewards_mojo"`
+			RewardsXCH   float64 `json:"rewards_xch"`
+			FirstHeight  uint32  `json:"first_height"`
+			LastHeight   uint32  `json:"last_height"`
+		}
+		type jsonShare struct {
+			BucketStart time.Time          `json:"bucket_start"`
+			StartHeight uint32             `json:"start_height"`
+			EndHeight   uint32             `json:"end_height"`
+			Blocks      int                `json:"blocks"`
+			PoolBlocks  map[string]int     `json:"pool_blocks"`
+			PoolShares  map[string]float64 `json:"pool_shares"`
+		}
+		res := struct {
+			Entries []jsonEntry `json:"entries"`
+			Shares  []jsonShare `json:"pool_shares"`
+		}{Entries: []jsonEntry{}, Shares: []jsonShare{}}
+		for _, e := range report.Entries {
+			res.Entries = append(res.Entries, jsonEntry{
+				EncodePuzzleHash(e.PuzzleHash, prefix), hex.EncodeToString(e.PuzzleHash[:]),
+				e.FarmerBlocks, e.PoolBlocks, e.RewardCoins, e.Rewards, float64(e.Rewards) / MOJO_PER_XCH,
+				e.FirstHeight, e.LastHeight,
+			})
+		}
+		for _, p := range report.Shares {
+			share := jsonShare{p.BucketStart, p.StartHeight, p.EndHeight, p.BlocksCount,
+				make(map[string]int), make(map[string]float64)}
+			for ph, count := range p.PoolBlocks {
+				addr := EncodePuzzleHash(ph, prefix)
+				share.PoolBlocks[addr] = count
+				share.PoolShares[addr] = p.Share(ph)
+			}
+			res.Shares = append(res.Shares, share)
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return merry.Wrap(enc.Encode(res))
+	case "table":
+		if _, err := fmt.Fprintf(out, "%-62s  %8s  %8s  %8s  %14s  %9s  %9s\n",
+			"address", "farmer", "pool", "coins", "rewards, XCH", "first", "last"); err != nil {
+			return merry.Wrap(err)
+		}
+		for i, e := range report.Entries {
+			if i == topCount {
+				if _, err := fmt.Fprintf(out, "... and %d more\n", len(report.Entries)-topCount); err != nil {
+					return merry.Wrap(err)
+				}
+				break
+			}
+			_, err := fmt.Fprintf(out, "%-62s  %8d  %8d  %8d  %14.3f  %9d  %9d\n",
+				EncodePuzzleHash(e.PuzzleHash, prefix), e.FarmerBlocks, e.PoolBlocks,
+				e.RewardCoins, float64(e.Rewards)/MOJO_PER_XCH, e.FirstHeight, e.LastHeight)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		}
+
+		pools := report.topPools(topCount)
+		if _, err := fmt.Fprintf(out, "\npool shares:\n%-19s  %9s  %6s", "bucket start", "height", "blocks"); err != nil {
+			return merry.Wrap(err)
+		}
+		for i := range pools {
+			if _, err := fmt.Fprintf(out, "  %6s", fmt.Sprintf("#%d", i+1)); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		if _, err := fmt.Fprintf(out, "  %6s\n", "other"); err != nil {
+			return merry.Wrap(err)
+		}
+		for _, p := range report.Shares {
+			if _, err := fmt.Fprintf(out, "%-19s  %9d  %6d", p.BucketStart.Format(timeFmt), p.EndHeight, p.BlocksCount); err != nil {
+				return merry.Wrap(err)
+			}
+			other := 1.0
+			for _, ph := range pools {
+				other -= p.Share(ph)
+				if _, err := fmt.Fprintf(out, "  %5.1f%%", p.Share(ph)*100); err != nil {
+					return merry.Wrap(err)
+				}
+			}
+			if _, err := fmt.Fprintf(out, "  %5.1f%%\n", math.Max(other, 0)*100); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		for i, ph := range pools {
+			if _, err := fmt.Fprintf(out, "#%d: %s\n", i+1, EncodePuzzleHash(ph, prefix)); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		return nil
+	default:
+		return merry.Errorf(`unexpected format "%s", expected "json" or "table"`, format)
+	}
+}
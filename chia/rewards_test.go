@@ -0,0 +1,90 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"testing"
+	"time"
+)
+
+func TestMakeRewardsReport(t *testing.T) {
+	farmers := [][32]byte{{1}, {2}, {3}}
+	poolA, poolB := [32]byte{10}, [32]byte{11}
+
+	blocks, records := testSourceBlocks(12, 1)
+	for i := range records {
+		br := &records[i]
+		br.FarmerPuzzleHash = farmers[i%3]
+		br.PoolPuzzleHash = poolA
+		if i >= 8 {
+			br.PoolPuzzleHash = poolB
+		}
+		if i%4 == 3 {
+			prev := &records[i-1]
+			br.RewardClaimsIncorporated = []types.Coin{
+				{PuzzleHash: prev.PoolPuzzleHash, Amount: 1750},
+				{PuzzleHash: prev.FarmerPuzzleHash, Amount: 250},
+			}
+		}
+	}
+	records[5].Timestamp = 0 //non-transaction block
+	src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	report, err := MakeRewardsReport(src, RewardsReportConfig{ShareBucketBlocks: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// claims at 3, 7, 11 for blocks 2, 6, 10: pool A, A, B; farmers 3, 1, 2
+	expected := []RewardsEntry{
+		{PuzzleHash: poolA, PoolBlocks: 8, RewardCoins: 2, Rewards: 3500, FirstHeight: 0, LastHeight: 7},
+		{PuzzleHash: poolB, PoolBlocks: 4, RewardCoins: 1, Rewards: 1750, FirstHeight: 8, LastHeight: 11},
+		{PuzzleHash: farmers[0], FarmerBlocks: 4, RewardCoins: 1, Rewards: 250, FirstHeight: 0, LastHeight: 9},
+		{PuzzleHash: farmers[1], FarmerBlocks: 4, RewardCoins: 1, Rewards: 250, FirstHeight: 1, LastHeight: 11},
+		{PuzzleHash: farmers[2], FarmerBlocks: 4, RewardCoins: 1, Rewards: 250, FirstHeight: 2, LastHeight: 11},
+	}
+	if len(report.Entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(report.Entries))
+	}
+	for i, entry := range report.Entries {
+		if *entry != expected[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, expected[i], *entry)
+		}
+	}
+
+	if len(report.Shares) != 2 {
+		t.Fatalf("expected 2 share points, got %d", len(report.Shares))
+	}
+	if s := report.Shares[0]; s.StartHeight != 0 || s.EndHeight != 5 || s.Share(poolA) != 1 || s.Share(poolB) != 0 {
+		t.Errorf("unexpected first share point: %+v", s)
+	}
+	if s := report.Shares[1]; s.StartHeight != 6 || s.EndHeight != 11 || s.Share(poolA) != 2.0/6 || s.Share(poolB) != 4.0/6 {
+		t.Errorf("unexpected second share point: %+v", s)
+	}
+
+	cfg := RewardsReportConfig{StartHeight: 4, EndHeight: 9}
+	if err := cfg.AddAddresses(EncodePuzzleHash(farmers[1], "xch") + ", " + EncodePuzzleHash(poolB, "xch")); err != nil {
+		t.Fatal(err)
+	}
+	report, err = MakeRewardsReport(src, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Entries) != 2 ||
+		*report.Entries[0] != (RewardsEntry{PuzzleHash: farmers[1], FarmerBlocks: 2, FirstHeight: 4, LastHeight: 7}) ||
+		*report.Entries[1] != (RewardsEntry{PuzzleHash: poolB, PoolBlocks: 2, FirstHeight: 8, LastHeight: 9}) {
+		t.Errorf("unexpected filtered entries: %+v", report.Entries)
+	}
+
+	// starting at non-transaction block
+	report, err = MakeRewardsReport(src, RewardsReportConfig{StartHeight: 5, EndHeight: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Unix(1600000000, 0).UTC().Truncate(24 * time.Hour)
+	if len(report.Shares) != 1 || !report.Shares[0].BucketStart.Equal(day) || report.Shares[0].BlocksCount != 2 {
+		t.Errorf("unexpected share points: %+v", report.Shares)
+	}
+}
@@ -0,0 +1,280 @@
+package chia
+
+import (
+	"chiastat/chia/types"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// same as UI_ACTUAL_SPACE_CONSTANT_FACTOR in upstream full_node_rpc_api.py
+const DEFAULT_ACTUAL_SPACE_FACTOR = 0.762
+
+const DEFAULT_SPACE_CONFIDENCE = 0.95
+
+type PlotFilterChange struct {
+	Height uint32
+	Bits   uint8
+}
+
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/consensus/default_constants.py
+// (NUMBER_ZERO_BITS_PLOT_FILTER and PLOT_FILTER_*_HEIGHT)
+var MAINNET_PLOT_FILTER = []PlotFilterChange{
+	{0, 9},
+	{10542000, 8},
+	{15592000, 7},
+	{20643000, 6},
+}
+
+// Approximate actual sizes of plots (in GiB) by k, from upstream docs.
+var PLOT_SIZES_GIB = map[uint8]float64{
+	32: 101.4,
+	33: 208.8,
+	34: 429.8,
+	35: 884.1,
+}
+
+type SpaceEstimateParams struct {
+	// Plot filter bits by height, sorted by height, MAINNET_PLOT_FILTER if empty.
+	PlotFilter []PlotFilterChange
+	// Actual plot size to expected plot size ratio, DEFAULT_ACTUAL_SPACE_FACTOR if zero.
+	ActualSpaceFactor float64
+	// MAINNET_CONSTANTS.DifficultyConstantFactor if nil.
+	DifficultyConstantFactor *big.Int
+}
+
+var DefaultSpaceEstimateParams = &SpaceEstimateParams{}
+
+// ParsePlotFilter sets plot filter from "<bits>" (for all heights) or "<height>:<bits>,<height>:<bits>,..." string.
+func (p *SpaceEstimateParams) ParsePlotFilter(str string) error {
+	p.PlotFilter = nil
+	for _, item := range strings.Split(str, ",") {
+		heightStr, bitsStr := "0", item
+		if i := strings.Index(item, ":"); i != -1 {
+			heightStr, bitsStr = item[:i], item[i+1:]
+		}
+		height, err := strconv.ParseUint(strings.TrimSpace(heightStr), 10, 32)
+		if err != nil {
+			return merry.Prependf(err, "plot filter height")
+		}
+		bits, err := strconv.ParseUint(strings.TrimSpace(bitsStr), 10, 8)
+		if err != nil {
+			return merry.Prependf(err, "plot filter bits")
+		}
+		p.PlotFilter = append(p.PlotFilter, PlotFilterChange{uint32(height), uint8(bits)})
+	}
+	sort.Slice(p.PlotFilter, func(i, j int) bool { return p.PlotFilter[i].Height < p.PlotFilter[j].Height })
+	return nil
+}
+
+func (p *SpaceEstimateParams) PlotFilterBits(height uint32) uint8 {
+	filter := p.PlotFilter
+	if len(filter) == 0 {
+		filter = MAINNET_PLOT_FILTER
+	}
+	bits := filter[0].Bits
+	for _, change := range filter {
+		if change.Height > height {
+			break
+		}
+		bits = change.Bits
+	}
+	return bits
+}
+
+func (p *SpaceEstimateParams) actualSpaceFactor() float64 {
+	if p.ActualSpaceFactor == 0 {
+		return DEFAULT_ACTUAL_SPACE_FACTOR
+	}
+	return p.ActualSpaceFactor
+}
+
+func (p *SpaceEstimateParams) difficultyConstantFactor() *big.Int {
+	if p.DifficultyConstantFactor == nil {
+		return MAINNET_CONSTANTS.DifficultyConstantFactor
+	}
+	return p.DifficultyConstantFactor
+}
+
+// PlotSpaceFactor returns actual to expected (used in quality calculation) size ratio of a k-sized plot.
+// Falls back to ActualSpaceFactor for unknown sizes.
+func (p *SpaceEstimateParams) PlotSpaceFactor(k uint8) float64 {
+	sizeGiB, ok := PLOT_SIZES_GIB[k]
+	if !ok {
+		return p.actualSpaceFactor()
+	}
+	// upstream _expected_plot_size
+	expected := float64(2*uint64(k)+1) * math.Pow(2, float64(k)-1)
+	return sizeGiB * (1 << 30) / expected
+}
+
+// Estimate is the upstream get_network_space formula: space from weight and iterations
+// increment, with plot filter of the given (last) block height.
+func (p *SpaceEstimateParams) Estimate(weight0, weight1, totalIters0, totalIters1 *big.Int, height uint32) *big.Int {
+	// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/rpc/full_node_rpc_api.py#L276
+	deltaWeight := (&big.Int{}).Sub(weight1, weight0)
+	deltaIters := (&big.Int{}).Sub(totalIters1, totalIters0)
+	eligiblePlotsFilterMultiplier := (&big.Int{}).Lsh(big.NewInt(1), uint(p.PlotFilterBits(height)))
+
+	spaceEstimate := &big.Int{}
+	spaceEstimate.Mul(p.difficultyConstantFactor(), eligiblePlotsFilterMultiplier)
+	spaceEstimate.Mul(spaceEstimate, deltaWeight)
+	spaceEstimate.Div(spaceEstimate, deltaIters)
+	spaceEstimate.Mul(spaceEstimate, big.NewInt(int64(p.actualSpaceFactor()*1000000)))
+	spaceEstimate.Div(spaceEstimate, big.NewInt(1000000))
+	return spaceEstimate
+}
+
+type SpaceEstimateConfig struct {
+	// Peak if zero.
+	EndHeight uint32
+	// DEFAULT_SPACE_WINDOW_BLOCKS if zero.
+	WindowBlocks uint32
+	// Confidence level of the interval, DEFAULT_SPACE_CONFIDENCE if zero.
+	Confidence float64
+	// DefaultSpaceEstimateParams if nil.
+	Params *SpaceEstimateParams
+}
+
+type SpaceEstimate struct {
+	// "window", "ema" or "proofs", see EstimateNetworkSpaces.
+	Estimator string
+	Space     *big.Int
+	// Confidence interval bounds.
+	Low  *big.Int
+	High *big.Int
+	// (Effective) number of blocks the estimate is based on.
+	Blocks uint32
+}
+
+func newSpaceEstimate(estimator string, space *big.Float, blocks uint32, z float64) SpaceEstimate {
+	// Blocks are found at random (Poisson process), so relative error of blocks count
+	// (and of space, which is proportional to it) is about 1/sqrt(count).
+	relErr := z / math.Sqrt(float64(blocks))
+	est := SpaceEstimate{Estimator: estimator, Blocks: blocks}
+	est.Space, _ = space.Int(nil)
+	est.Low, _ = new(big.Float).Mul(space, big.NewFloat(math.Max(1-relErr, 0))).Int(nil)
+	est.High, _ = new(big.Float).Mul(space, big.NewFloat(1+relErr)).Int(nil)
+	return est
+}
+
+// EstimateNetworkSpaces estimates space at the given height with several estimators:
+//
+// "window" is the upstream formula over WindowBlocks blocks;
+//
+// "ema" uses exponential moving averages (with the same effective window) of difficulty
+// and iterations per block, so recent blocks weigh more;
+//
+// "proofs" sums space implied by each proof in the window: every block is accounted with its
+// own plot filter and plot size (actual/expected size ratio of its k) instead of the constant factor.
+// It reads full blocks, so is slower.
+func EstimateNetworkSpaces(src BlockSource, cfg SpaceEstimateConfig) ([]SpaceEstimate, error) {
+	if cfg.WindowBlocks == 0 {
+		cfg.WindowBlocks = DEFAULT_SPACE_WINDOW_BLOCKS
+	}
+	if cfg.Confidence == 0 {
+		cfg.Confidence = DEFAULT_SPACE_CONFIDENCE
+	}
+	if !(cfg.Confidence > 0 && cfg.Confidence < 1) {
+		return nil, merry.Errorf("confidence should be between 0 and 1 (exclusive), got %g", cfg.Confidence)
+	}
+	if cfg.Params == nil {
+		cfg.Params = DefaultSpaceEstimateParams
+	}
+	if cfg.EndHeight == 0 {
+		cfg.EndHeight = math.MaxUint32
+	}
+	endHeight, err := clampEndHeight(src, cfg.EndHeight)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if endHeight == 0 {
+		return nil, merry.New("at least two blocks are required")
+	}
+	windowBlocks := cfg.WindowBlocks
+	if windowBlocks > endHeight {
+		windowBlocks = endHeight
+	}
+	startHeight := endHeight - windowBlocks
+	z := math.Sqrt2 * math.Erfinv(cfg.Confidence)
+	params := cfg.Params
+	diffFactor := new(big.Float).SetInt(params.difficultyConstantFactor())
+
+	var res []SpaceEstimate
+
+	// window
+	br0, err := src.BlockRecordByHeight(startHeight)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	br1, err := src.BlockRecordByHeight(endHeight)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	space := params.Estimate(br0.Weight, br1.Weight, br0.TotalIters, br1.TotalIters, endHeight)
+	res = append(res, newSpaceEstimate("window", new(big.Float).SetInt(space), windowBlocks, z))
+
+	// ema, warming up over few windows before
+	alpha := 2 / (float64(windowBlocks) + 1)
+	emaFrom := uint32(0)
+	if endHeight > windowBlocks*4 {
+		emaFrom = endHeight - windowBlocks*4
+	}
+	var emaDifficulty, emaIters float64
+	var prev *types.BlockRecord
+	err = src.ForEachBlockRecord(emaFrom, endHeight, func(br *types.BlockRecord) error {
+		if prev != nil {
+			difficulty, _ := new(big.Float).SetInt(new(big.Int).Sub(br.Weight, prev.Weight)).Float64()
+			iters, _ := new(big.Float).SetInt(new(big.Int).Sub(br.TotalIters, prev.TotalIters)).Float64()
+			if emaIters == 0 {
+				emaDifficulty, emaIters = difficulty, iters
+			} else {
+				emaDifficulty += alpha * (difficulty - emaDifficulty)
+				emaIters += alpha * (iters - emaIters)
+			}
+		}
+		prev = br
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if emaIters > 0 {
+		space := new(big.Float).Mul(diffFactor, big.NewFloat(emaDifficulty/emaIters*params.actualSpaceFactor()))
+		space.Mul(space, big.NewFloat(math.Pow(2, float64(params.PlotFilterBits(endHeight)))))
+		// effective number of blocks of EMA is (2-alpha)/alpha = windowBlocks
+		res = append(res, newSpaceEstimate("ema", space, windowBlocks, z))
+	}
+
+	// proofs
+	var prevWeight, firstIters, lastIters *big.Int
+	sum := new(big.Float)
+	err = src.ForEachFullBlock(startHeight, endHeight, func(block *types.FullBlock) error {
+		rcb := &block.RewardChainBlock
+		if prevWeight != nil {
+			difficulty := new(big.Float).SetInt(new(big.Int).Sub(rcb.Weight, prevWeight))
+			factor := params.PlotSpaceFactor(rcb.ProofOfSpace.Size) * math.Pow(2, float64(params.PlotFilterBits(rcb.Height)))
+			sum.Add(sum, difficulty.Mul(difficulty, big.NewFloat(factor)))
+		} else {
+			firstIters = rcb.TotalIters
+		}
+		prevWeight = rcb.Weight
+		lastIters = rcb.TotalIters
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if firstIters != nil {
+		if deltaIters := new(big.Int).Sub(lastIters, firstIters); deltaIters.Sign() > 0 {
+			space := new(big.Float).Mul(sum, diffFactor)
+			space.Quo(space, new(big.Float).SetInt(deltaIters))
+			res = append(res, newSpaceEstimate("proofs", space, windowBlocks, z))
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,101 @@
+package chia
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestSpaceEstimateParams(t *testing.T) {
+	p := &SpaceEstimateParams{}
+	for _, check := range []struct {
+		height uint32
+		bits   uint8
+	}{{0, 9}, {10541999, 9}, {10542000, 8}, {15592000, 7}, {30000000, 6}} {
+		if bits := p.PlotFilterBits(check.height); bits != check.bits {
+			t.Errorf("PlotFilterBits(%d): expected %d, got %d", check.height, check.bits, bits)
+		}
+	}
+
+	if err := p.ParsePlotFilter("100:5, 0:9"); err != nil {
+		t.Fatal(err)
+	}
+	if p.PlotFilterBits(99) != 9 || p.PlotFilterBits(100) != 5 {
+		t.Errorf("unexpected parsed plot filter: %v", p.PlotFilter)
+	}
+	if err := p.ParsePlotFilter("7"); err != nil || p.PlotFilterBits(20000000) != 7 {
+		t.Errorf("unexpected parsed plot filter: %v (%v)", p.PlotFilter, err)
+	}
+	if err := p.ParsePlotFilter("1:x"); err == nil {
+		t.Errorf("expected error for invalid plot filter")
+	}
+
+	// 2^67 * 2^9 * 1000 / 10000 * 0.762
+	expected, _ := new(big.Int).SetString("5757509215914671444537", 10)
+	space := DefaultSpaceEstimateParams.Estimate(big.NewInt(0), big.NewInt(1000), big.NewInt(0), big.NewInt(10000), 100)
+	if space.Cmp(expected) != 0 {
+		t.Errorf("expected %s, got %s", expected, space)
+	}
+	p = &SpaceEstimateParams{PlotFilter: []PlotFilterChange{{0, 8}}, ActualSpaceFactor: 0.381}
+	// 2^67 * 2^8 * 1000 / 10000 * 0.381
+	expected, _ = new(big.Int).SetString("1439377303978667861134", 10)
+	space = p.Estimate(big.NewInt(0), big.NewInt(1000), big.NewInt(0), big.NewInt(10000), 100)
+	if space.Cmp(expected) != 0 {
+		t.Errorf("expected %s, got %s", expected, space)
+	}
+
+	if f := p.PlotSpaceFactor(32); math.Abs(f-0.78) > 0.01 {
+		t.Errorf("unexpected k32 space factor: %f", f)
+	}
+	if f := p.PlotSpaceFactor(20); f != 0.381 {
+		t.Errorf("expected fallback space factor, got %f", f)
+	}
+}
+
+func TestEstimateNetworkSpaces(t *testing.T) {
+	blocks, records := testSourceBlocks(30, 1)
+	for i := range blocks {
+		blocks[i].RewardChainBlock.ProofOfSpace.Size = 40 //unknown size, same factor as "window"
+	}
+	src, err := OpenSqliteBlockSource(makeTestDBv2(t, blocks, records, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	for _, confidence := range []float64{-0.5, 1, 95} {
+		if _, err := EstimateNetworkSpaces(src, SpaceEstimateConfig{EndHeight: 25, Confidence: confidence}); err == nil {
+			t.Errorf("confidence %g: expected error", confidence)
+		}
+	}
+
+	estimates, err := EstimateNetworkSpaces(src, SpaceEstimateConfig{EndHeight: 25, WindowBlocks: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimates) != 3 {
+		t.Fatalf("expected 3 estimates, got %d", len(estimates))
+	}
+	expected := EstimateNetworkSpace(&records[9], &records[25])
+	relErr := 1.96 / 4
+	for i, name := range []string{"window", "ema", "proofs"} {
+		est := estimates[i]
+		if est.Estimator != name || est.Blocks != 16 {
+			t.Errorf("%s: unexpected estimate %+v", name, est)
+		}
+		spaceF, _ := new(big.Float).SetInt(est.Space).Float64()
+		expectedF, _ := new(big.Float).SetInt(expected).Float64()
+		if math.Abs(spaceF/expectedF-1) > 1e-9 {
+			t.Errorf("%s: expected space %s, got %s", name, expected, est.Space)
+		}
+		lowF, _ := new(big.Float).SetInt(est.Low).Float64()
+		highF, _ := new(big.Float).SetInt(est.High).Float64()
+		if math.Abs(lowF/spaceF-(1-relErr)) > 1e-4 || math.Abs(highF/spaceF-(1+relErr)) > 1e-4 {
+			t.Errorf("%s: unexpected interval %s..%s for %s", name, est.Low, est.High, est.Space)
+		}
+	}
+
+	if _, err := EstimateNetworkSpaces(src, SpaceEstimateConfig{WindowBlocks: 100}); err != nil {
+		t.Errorf("window larger than chain: %v", err)
+	}
+}
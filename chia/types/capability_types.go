@@ -5,4 +5,54 @@ package types
 // These are passed in as uint16 into the Handshake
 const (
 	CAP_BASE uint16 = 1 // Base capability just means it supports the chia protocol at mainnet
+	// introduces RequestBlockHeaders, which is a faster API for fetching header blocks
+	// !! the old API is *RequestHeaderBlock* !!
+	CAP_BLOCK_HEADERS uint16 = 2
+	// Specifies support for v1 and v2 versions of rate limits. Peers will use the lower version between them
+	CAP_RATE_LIMITS_V2 uint16 = 3
+	// a node can handle a None response and not wait the full timeout
+	CAP_NONE_RESPONSE uint16 = 4
+	// Opts in to receiving mempool updates for subscribed transactions
+	// This is between a full node and receiving wallet
+	CAP_MEMPOOL_UPDATES uint16 = 5
 )
+
+var ALL_CAPABILITIES = []uint16{CAP_BASE, CAP_BLOCK_HEADERS, CAP_RATE_LIMITS_V2, CAP_NONE_RESPONSE, CAP_MEMPOOL_UPDATES}
+
+func CapabilityName(capability uint16) (string, bool) {
+	switch capability {
+	case CAP_BASE:
+		return "BASE", true
+	case CAP_BLOCK_HEADERS:
+		return "BLOCK_HEADERS", true
+	case CAP_RATE_LIMITS_V2:
+		return "RATE_LIMITS_V2", true
+	case CAP_NONE_RESPONSE:
+		return "NONE_RESPONSE", true
+	case CAP_MEMPOOL_UPDATES:
+		return "MEMPOOL_UPDATES", true
+	default:
+		return "UNKNOWN", false
+	}
+}
+
+// CapabilitiesToHandshake makes handshake capabilities list with all the given ones enabled.
+func CapabilitiesToHandshake(capabilities []uint16) []TupleUint16Str {
+	res := make([]TupleUint16Str, len(capabilities))
+	for i, capability := range capabilities {
+		res[i] = TupleUint16Str{V0: capability, V1: "1"}
+	}
+	return res
+}
+
+// CapabilitiesFromHandshake returns all enabled capabilities (including unknown ones).
+// https://github.com/Chia-Network/chia-blockchain/blob/latest/chia/server/capabilities.py
+func CapabilitiesFromHandshake(capabilities []TupleUint16Str) []uint16 {
+	res := make([]uint16, 0, len(capabilities))
+	for _, item := range capabilities {
+		if item.V1 == "1" {
+			res = append(res, item.V0)
+		}
+	}
+	return res
+}
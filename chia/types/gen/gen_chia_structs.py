@@ -294,6 +294,7 @@ source_classes_groups = {
             'NewCompactVDF', 'RequestCompactVDF', 'RespondCompactVDF',
             'RequestPeers', 'RespondPeers',
         ],
+        'protocols/introducer_protocol.py': ['RequestPeersIntroducer', 'RespondPeersIntroducer'],
     }
 }
 
@@ -159,6 +159,7 @@ var groups = []constGroups{
 					{"REQUEST_PEERS_INTRODUCER", 63},
 					{"RESPOND_PEERS_INTRODUCER", 64},
 				},
+				useInGetter: true,
 			},
 			{
 				comment: "Simulator protocol",
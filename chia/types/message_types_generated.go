@@ -141,6 +141,10 @@ func MessageTypeStruct(type_ uint8) (utils.FromToBytes, bool) {
 		return &RequestPeers{}, true
 	case MSG_RESPOND_PEERS:
 		return &RespondPeers{}, true
+	case MSG_REQUEST_PEERS_INTRODUCER:
+		return &RequestPeersIntroducer{}, true
+	case MSG_RESPOND_PEERS_INTRODUCER:
+		return &RespondPeersIntroducer{}, true
 	default:
 		return nil, false
 	}
@@ -200,6 +204,10 @@ func MessageTypeFromStruct(obj interface{}) (uint8, bool) {
 		return MSG_REQUEST_PEERS, true
 	case RespondPeers, *RespondPeers:
 		return MSG_RESPOND_PEERS, true
+	case RequestPeersIntroducer, *RequestPeersIntroducer:
+		return MSG_REQUEST_PEERS_INTRODUCER, true
+	case RespondPeersIntroducer, *RespondPeersIntroducer:
+		return MSG_RESPOND_PEERS_INTRODUCER, true
 	default:
 		return 0, false
 	}
@@ -494,3 +494,35 @@ func (obj RespondPeers) ToBytes(buf *[]byte) {
 		item.ToBytes(buf)
 	}
 }
+
+// Return full list of peers
+type RequestPeersIntroducer struct {
+}
+
+func (obj *RequestPeersIntroducer) FromBytes(buf *utils.ParseBuf) {
+}
+
+func (obj RequestPeersIntroducer) ToBytes(buf *[]byte) {
+}
+
+type RespondPeersIntroducer struct {
+	PeerList []TimestampedPeerInfo
+}
+
+func (obj *RespondPeersIntroducer) FromBytes(buf *utils.ParseBuf) {
+	len_obj_PeerList := buf.Uint32()
+	obj.PeerList = make([]TimestampedPeerInfo, len_obj_PeerList)
+	for i := uint32(0); i < len_obj_PeerList; i++ {
+		obj.PeerList[i].FromBytes(buf)
+		if buf.Err() != nil {
+			return
+		}
+	}
+}
+
+func (obj RespondPeersIntroducer) ToBytes(buf *[]byte) {
+	utils.Uint32ToBytes(buf, uint32(len(obj.PeerList)))
+	for _, item := range obj.PeerList {
+		item.ToBytes(buf)
+	}
+}
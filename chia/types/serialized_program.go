@@ -3,6 +3,7 @@ package types
 import (
 	"chiastat/chia/clvm"
 	"chiastat/chia/utils"
+	"encoding/hex"
 )
 
 type SerializedProgram struct {
@@ -22,5 +23,9 @@ func (prog *SerializedProgram) FromBytes(buf *utils.ParseBuf) {
 }
 
 func (p SerializedProgram) ToBytes(buf *[]byte) {
-	panic("not implemented yet")
+	utils.BytesWOSizeToBytes(buf, p.Bytes)
+}
+
+func (p SerializedProgram) JSONValue() interface{} {
+	return "0x" + hex.EncodeToString(p.Bytes)
 }
@@ -1,3 +1,32 @@
 package types
 
+import (
+	"chiastat/chia/clvm"
+	"chiastat/chia/utils"
+	"crypto/sha256"
+	"math/big"
+)
+
 //go:generate go run gen/gen_type_getters.go
+
+// HeaderHash returns block's header hash (same as in upstream FullBlock.header_hash: hash of the foliage).
+func (b *FullBlock) HeaderHash() [32]byte {
+	return sha256.Sum256(utils.ToByteSlice(b.Foliage))
+}
+
+func (b *HeaderBlock) HeaderHash() [32]byte {
+	return sha256.Sum256(utils.ToByteSlice(b.Foliage))
+}
+
+// ID returns coin name (upstream Coin.name()): hash of parent coin ID, puzzle hash and amount encoded as clvm int.
+func (c Coin) ID() [32]byte {
+	amount := clvm.AtomFromInt(new(big.Int).SetUint64(c.Amount))
+	buf := make([]byte, 0, 32+32+len(amount.Bytes))
+	buf = append(append(append(buf, c.ParentCoinInfo[:]...), c.PuzzleHash[:]...), amount.Bytes...)
+	return sha256.Sum256(buf)
+}
+
+// ID returns spend bundle name, it is the TransactionID used in NewTransaction/RequestTransaction.
+func (b *SpendBundle) ID() [32]byte {
+	return sha256.Sum256(utils.ToByteSlice(b))
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"reflect"
+)
+
+// JSONValuer can be implemented by types that need custom representation in ToJSONValue.
+type JSONValuer interface {
+	JSONValue() interface{}
+}
+
+// JSONObject is a JSON object that keeps fields order.
+type JSONObject struct {
+	Keys   []string
+	Values []interface{}
+}
+
+func (o JSONObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyBuf, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keyBuf)
+		buf.WriteByte(':')
+		valBuf, err := json.Marshal(o.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(valBuf)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// ToJSONValue converts chia structure to a value suitable for json.Marshal:
+// byte arrays and slices become 0x-prefixed hex strings, big ints become numbers,
+// structs become objects with fields in declaration order.
+func ToJSONValue(obj interface{}) interface{} {
+	return toJSONValue(reflect.ValueOf(obj))
+}
+
+func toJSONValue(v reflect.Value) interface{} {
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()) {
+		return nil
+	}
+	if v.CanInterface() {
+		if valuer, ok := v.Interface().(JSONValuer); ok {
+			return valuer.JSONValue()
+		}
+		if v.CanAddr() {
+			if valuer, ok := v.Addr().Interface().(JSONValuer); ok {
+				return valuer.JSONValue()
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return toJSONValue(v.Elem())
+	case reflect.Struct:
+		if v.Type() == bigIntType {
+			n := new(big.Int)
+			reflect.ValueOf(n).Elem().Set(v)
+			return json.Number(n.String())
+		}
+		obj := JSONObject{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" { //unexported
+				continue
+			}
+			obj.Keys = append(obj.Keys, field.Name)
+			obj.Values = append(obj.Values, toJSONValue(v.Field(i)))
+		}
+		return obj
+	case reflect.Array, reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			return "0x" + hex.EncodeToString(buf)
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return []interface{}{}
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = toJSONValue(v.Index(i))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
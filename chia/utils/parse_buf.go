@@ -150,6 +150,9 @@ func (b *ParseBuf) Bytes100() [100]byte {
 }
 
 func (b *ParseBuf) BytesN(n int) []byte {
+	if b.err != nil || !b.EnsureBytes(n) {
+		return []byte{}
+	}
 	v := make([]byte, n)
 	copy(v, b.buf[b.pos:b.pos+n])
 	b.pos += n
@@ -200,7 +203,7 @@ func Uint64ToBytes(buf *[]byte, val uint64) {
 
 func Uint128ToBytes(buf *[]byte, val *big.Int) {
 	t := make([]byte, 16)
-	copy(t, val.Bytes())
+	val.FillBytes(t)
 	*buf = append(*buf, t...)
 }
 
@@ -0,0 +1,10 @@
+// Package weightproof requests and checks weight proofs, a compact proof of the chain weight
+// that full nodes exchange instead of downloading all blocks (upstream chia/full_node/weight_proof.py).
+//
+// Verification here is partial. Sub-epoch summaries are rebuilt and hash-linked, total weight
+// is compared with the recent chain, sub-epoch sampling is checked with the same random generator
+// upstream uses, and sampled segments are checked for structure and reward chain hashes.
+// VDF proofs, proofs of space and BLS signatures are NOT verified (there are no Go
+// implementations of classgroup VDFs and chiapos here), so a proof that passes Verify is consistent,
+// but not necessarily backed by real work. It is enough to catch peers serving broken or light chains.
+package weightproof
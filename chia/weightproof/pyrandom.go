@@ -0,0 +1,121 @@
+package weightproof
+
+import (
+	"crypto/sha512"
+	"math/bits"
+)
+
+// pyRandom reproduces CPython's random.Random (MT19937) seeded with bytes,
+// upstream uses it to choose sampled sub-epochs and segments, so verifier must get the same numbers.
+// https://github.com/python/cpython/blob/3.9/Modules/_randommodule.c
+type pyRandom struct {
+	state [624]uint32
+	index int
+}
+
+func newPyRandom(seed []byte) *pyRandom {
+	// random.seed(bytes, version=2): int.from_bytes(seed + sha512(seed).digest(), 'big')
+	hash := sha512.Sum512(seed)
+	num := append(append([]byte(nil), seed...), hash[:]...)
+	// CPython splits abs(int) into 32-bit words, least significant first, without leading zero words
+	for len(num) > 0 && num[0] == 0 {
+		num = num[1:]
+	}
+	var key []uint32
+	for end := len(num); end > 0; end -= 4 {
+		start := end - 4
+		if start < 0 {
+			start = 0
+		}
+		word := uint32(0)
+		for _, b := range num[start:end] {
+			word = word<<8 | uint32(b)
+		}
+		key = append(key, word)
+	}
+	if len(key) == 0 {
+		key = []uint32{0}
+	}
+	r := &pyRandom{}
+	r.initByArray(key)
+	return r
+}
+
+func (r *pyRandom) initGenrand(s uint32) {
+	r.state[0] = s
+	for i := 1; i < len(r.state); i++ {
+		r.state[i] = 1812433253*(r.state[i-1]^(r.state[i-1]>>30)) + uint32(i)
+	}
+	r.index = len(r.state)
+}
+
+func (r *pyRandom) initByArray(key []uint32) {
+	const n = len(r.state)
+	r.initGenrand(19650218)
+	i, j := 1, 0
+	k := n
+	if len(key) > k {
+		k = len(key)
+	}
+	for ; k > 0; k-- {
+		r.state[i] = (r.state[i] ^ ((r.state[i-1] ^ (r.state[i-1] >> 30)) * 1664525)) + key[j] + uint32(j)
+		i++
+		j++
+		if i >= n {
+			r.state[0] = r.state[n-1]
+			i = 1
+		}
+		if j >= len(key) {
+			j = 0
+		}
+	}
+	for k = n - 1; k > 0; k-- {
+		r.state[i] = (r.state[i] ^ ((r.state[i-1] ^ (r.state[i-1] >> 30)) * 1566083941)) - uint32(i)
+		i++
+		if i >= n {
+			r.state[0] = r.state[n-1]
+			i = 1
+		}
+	}
+	r.state[0] = 0x80000000
+}
+
+func (r *pyRandom) uint32() uint32 {
+	const n, m = 624, 397
+	if r.index >= n {
+		for kk := 0; kk < n; kk++ {
+			y := (r.state[kk] & 0x80000000) | (r.state[(kk+1)%n] & 0x7fffffff)
+			next := r.state[(kk+m)%n] ^ (y >> 1)
+			if y&1 != 0 {
+				next ^= 0x9908b0df
+			}
+			r.state[kk] = next
+		}
+		r.index = 0
+	}
+	y := r.state[r.index]
+	r.index++
+	y ^= y >> 11
+	y ^= (y << 7) & 0x9d2c5680
+	y ^= (y << 15) & 0xefc60000
+	y ^= y >> 18
+	return y
+}
+
+// random is random.random(): float in [0, 1) with 53-bit resolution.
+func (r *pyRandom) random() float64 {
+	a := r.uint32() >> 5
+	b := r.uint32() >> 6
+	return (float64(a)*67108864.0 + float64(b)) * (1.0 / 9007199254740992.0)
+}
+
+// randBelow is random.choice(range(n)) (_randbelow_with_getrandbits), n must be in (0, 2^32).
+func (r *pyRandom) randBelow(n int) int {
+	k := bits.Len32(uint32(n))
+	for {
+		v := int(r.uint32() >> (32 - k))
+		if v < n {
+			return v
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package weightproof
+
+import "testing"
+
+func TestPyRandom(t *testing.T) {
+	// python3 -c "import random; r = random.Random(bytes(range(32))); print(r.random(), r.random(), r.choice(range(5)), r.choice(range(1000)), r.choice(range(1)))"
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	r := newPyRandom(seed)
+	if v := r.random(); v != 0.9592884430034848 {
+		t.Errorf("unexpected random(): %v", v)
+	}
+	if v := r.random(); v != 0.904383003978874 {
+		t.Errorf("unexpected random(): %v", v)
+	}
+	if v := r.randBelow(5); v != 3 {
+		t.Errorf("unexpected choice: %d", v)
+	}
+	if v := r.randBelow(1000); v != 569 {
+		t.Errorf("unexpected choice: %d", v)
+	}
+	if v := r.randBelow(1); v != 0 {
+		t.Errorf("unexpected choice: %d", v)
+	}
+
+	// python3 -c "import random; print(random.Random(bytes(32)).random())"
+	if v := newPyRandom(make([]byte, 32)).random(); v != 0.279945442455909 {
+		t.Errorf("unexpected random() for zero seed: %v", v)
+	}
+}
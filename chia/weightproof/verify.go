@@ -0,0 +1,463 @@
+package weightproof
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	"chiastat/chia/utils"
+	"crypto/sha256"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// upstream WeightProofHandler constants
+const LAMBDA_L = 100
+const C = 0.5
+const MAX_SAMPLES = 20
+
+const DEFAULT_REQUEST_TIMEOUT = 3 * time.Minute
+
+var ErrInvalid = merry.New("invalid weight proof")
+
+// Result is what can be learned from a (partially) verified weight proof.
+type Result struct {
+	Peak      *types.HeaderBlock
+	Summaries []types.SubEpochSummary
+	// sub-epochs that had to be sampled (and were present in the proof)
+	SampledSubEpochs []uint32
+	// estimated over recent chain (up to EpochBlocks blocks)
+	SpaceEstimate *big.Int
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return ErrInvalid.Here().WithMessagef("invalid weight proof: "+format, args...)
+}
+
+func hashOf(obj utils.ToBytes) [32]byte {
+	return sha256.Sum256(utils.ToByteSlice(obj))
+}
+
+// Request asks peer for the weight proof of the given peak (usually taken from peer's NewPeak).
+func Request(c *network.WSChiaConnection, peak *types.NewPeak, timeout time.Duration) (*types.WeightProof, error) {
+	req := types.RequestProofOfWeight{TotalNumberOfBlocks: peak.Height + 1, Tip: peak.HeaderHash}
+	resp, err := c.SendRequestSyncTimeout(req, timeout)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	wpResp, ok := resp.(*types.RespondProofOfWeight)
+	if !ok {
+		return nil, utils.WrongRespError(resp)
+	}
+	if wpResp.Tip != peak.HeaderHash {
+		return nil, invalidf("requested tip %x, got %x", peak.HeaderHash, wpResp.Tip)
+	}
+	return &wpResp.Wp, nil
+}
+
+// Verify checks weight proof (see package doc for what is NOT checked).
+// If peak is not nil, proof must end with this peak.
+func Verify(consts *chia.ConsensusConstants, wp *types.WeightProof, peak *types.NewPeak) (*Result, error) {
+	if len(wp.SubEpochs) == 0 || len(wp.RecentChainData) == 0 {
+		return nil, invalidf("empty sub-epochs or recent chain")
+	}
+	tip := &wp.RecentChainData[len(wp.RecentChainData)-1]
+	if peak != nil {
+		if tip.HeaderHash() != peak.HeaderHash || tip.RewardChainBlock.Weight.Cmp(peak.Weight) != 0 {
+			return nil, invalidf("recent chain does not end with peak %d", peak.Height)
+		}
+	}
+	if err := validateRecentChainLinks(wp.RecentChainData); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	summaries, subEpochWeights, err := validateSubEpochSummaries(consts, wp)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if len(summaries) < 2 {
+		return nil, invalidf("too few sub-epochs: %d", len(summaries))
+	}
+
+	seed := hashOf(summaries[len(summaries)-2])
+	rng := newPyRandom(seed[:])
+	sampled, err := validateSubEpochSampling(rng, subEpochWeights, wp)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err := validateSubEpochSegments(consts, rng, wp.SubEpochSegments, summaries); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if err := validateRecentSummaries(wp.RecentChainData, summaries); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	first := &wp.RecentChainData[0]
+	if len(wp.RecentChainData) > int(consts.EpochBlocks) {
+		first = &wp.RecentChainData[len(wp.RecentChainData)-1-int(consts.EpochBlocks)]
+	}
+	res := &Result{Peak: tip, Summaries: summaries, SampledSubEpochs: sampled}
+	if first != tip {
+		res.SpaceEstimate = chia.EstimateNetworkSpaceHeader(first, tip)
+	}
+	return res, nil
+}
+
+func validateRecentChainLinks(blocks []types.HeaderBlock) error {
+	for i := 1; i < len(blocks); i++ {
+		prev, cur := &blocks[i-1], &blocks[i]
+		if cur.RewardChainBlock.Height != prev.RewardChainBlock.Height+1 {
+			return invalidf("recent chain: height %d after %d", cur.RewardChainBlock.Height, prev.RewardChainBlock.Height)
+		}
+		if cur.Foliage.PrevBlockHash != prev.HeaderHash() {
+			return invalidf("recent chain: block %d prev hash mismatch", cur.RewardChainBlock.Height)
+		}
+		if cur.RewardChainBlock.Weight.Cmp(prev.RewardChainBlock.Weight) <= 0 {
+			return invalidf("recent chain: block %d weight is not increasing", cur.RewardChainBlock.Height)
+		}
+	}
+	return nil
+}
+
+// upstream _get_last_ses_hash
+func getLastSesHash(consts *chia.ConsensusConstants, recent []types.HeaderBlock) (*[32]byte, bool) {
+	for i := len(recent) - 1; i >= 0; i-- {
+		if recent[i].RewardChainBlock.Height%consts.SubEpochBlocks != 0 {
+			continue
+		}
+		// first block after sub slot end
+		for _, block := range recent[i:] {
+			for _, slot := range block.FinishedSubSlots {
+				if slot.ChallengeChain.SubepochSummaryHash != nil {
+					return slot.ChallengeChain.SubepochSummaryHash, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// upstream _map_sub_epoch_summaries
+func mapSubEpochSummaries(consts *chia.ConsensusConstants, subEpochs []types.SubEpochData) ([]types.SubEpochSummary, *big.Int, []*big.Int) {
+	totalWeight := new(big.Int)
+	curDifficulty := consts.DifficultyStarting
+	sesHash := consts.GenesisChallenge
+	summaries := make([]types.SubEpochSummary, 0, len(subEpochs))
+	var weights []*big.Int
+	for i, data := range subEpochs {
+		ses := types.SubEpochSummary{
+			PrevSubepochSummaryHash: sesHash,
+			RewardChainHash:         data.RewardChainHash,
+			NumBlocksOverflow:       data.NumBlocksOverflow,
+			NewDifficulty:           data.NewDifficulty,
+			NewSubSlotIters:         data.NewSubSlotIters,
+		}
+		if i < len(subEpochs)-1 {
+			delta := uint64(0)
+			if i > 0 {
+				delta = uint64(data.NumBlocksOverflow)
+			}
+			weights = append(weights, new(big.Int).Add(totalWeight, new(big.Int).SetUint64(curDifficulty)))
+			blocks := uint64(consts.SubEpochBlocks) + uint64(subEpochs[i+1].NumBlocksOverflow) - delta
+			totalWeight.Add(totalWeight, new(big.Int).Mul(new(big.Int).SetUint64(curDifficulty), new(big.Int).SetUint64(blocks)))
+		}
+		if data.NewDifficulty != 0 {
+			curDifficulty = data.NewDifficulty
+		}
+		summaries = append(summaries, ses)
+		sesHash = hashOf(ses)
+	}
+	weights = append(weights, new(big.Int).Add(totalWeight, new(big.Int).SetUint64(curDifficulty)))
+	return summaries, totalWeight, weights
+}
+
+// upstream _validate_sub_epoch_summaries and _validate_summaries_weight
+func validateSubEpochSummaries(consts *chia.ConsensusConstants, wp *types.WeightProof) ([]types.SubEpochSummary, []*big.Int, error) {
+	lastSesHash, ok := getLastSesHash(consts, wp.RecentChainData)
+	if !ok {
+		return nil, nil, invalidf("could not find last sub-epoch summary block")
+	}
+	summaries, totalWeight, weights := mapSubEpochSummaries(consts, wp.SubEpochs)
+
+	last := summaries[len(summaries)-1]
+	sesEndHeight := int64(len(summaries)-1)*int64(consts.SubEpochBlocks) + int64(last.NumBlocksOverflow) - 1
+	var sesEnd *types.HeaderBlock
+	for i := range wp.RecentChainData {
+		if int64(wp.RecentChainData[i].RewardChainBlock.Height) == sesEndHeight {
+			sesEnd = &wp.RecentChainData[i]
+		}
+	}
+	if sesEnd == nil {
+		return nil, nil, invalidf("sub-epoch end block %d is not in recent chain", sesEndHeight)
+	}
+	if sesEnd.RewardChainBlock.Weight.Cmp(totalWeight) != 0 {
+		return nil, nil, invalidf("sub-epochs weight %s does not match block %d weight %s",
+			totalWeight, sesEndHeight, sesEnd.RewardChainBlock.Weight)
+	}
+	if hashOf(last) != *lastSesHash {
+		return nil, nil, invalidf("last sub-epoch summary hash mismatch")
+	}
+	return summaries, weights, nil
+}
+
+// upstream _get_weights_for_sampling, returns nil if every sub-epoch should be sampled
+func weightsForSampling(rng *pyRandom, totalWeight *big.Int, recent []types.HeaderBlock) []*big.Int {
+	lastLWeight := new(big.Int).Sub(recent[len(recent)-1].RewardChainBlock.Weight, recent[0].RewardChainBlock.Weight)
+	delta, _ := new(big.Rat).SetFrac(lastLWeight, totalWeight).Float64()
+	probOfAdvSucceeding := 1 - math.Log(C)/math.Log(delta)
+	if probOfAdvSucceeding <= 0 {
+		return nil
+	}
+	queries := -LAMBDA_L * math.Log(2) / math.Log(probOfAdvSucceeding)
+	totalWeightF, _ := new(big.Float).SetInt(totalWeight).Float64()
+	var weights []*big.Int
+	for i := 0; i < int(queries)+1; i++ {
+		u := rng.random()
+		q := 1 - math.Pow(delta, u)
+		weight, _ := big.NewFloat(q * totalWeightF).Int(nil)
+		weights = append(weights, weight)
+	}
+	sort.Slice(weights, func(i, j int) bool { return weights[i].Cmp(weights[j]) < 0 })
+	return weights
+}
+
+// upstream _sample_sub_epoch
+func sampleSubEpoch(start, end *big.Int, weightsToCheck []*big.Int) bool {
+	if weightsToCheck == nil {
+		return true
+	}
+	if weightsToCheck[len(weightsToCheck)-1].Cmp(start) < 0 {
+		return false
+	}
+	if weightsToCheck[0].Cmp(end) > 0 {
+		return false
+	}
+	for _, weight := range weightsToCheck {
+		if weight.Cmp(end) > 0 {
+			return false
+		}
+		if weight.Cmp(start) > 0 && weight.Cmp(end) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// upstream validate_sub_epoch_sampling: all sub-epochs chosen by rng must have segments in proof
+func validateSubEpochSampling(rng *pyRandom, subEpochWeights []*big.Int, wp *types.WeightProof) ([]uint32, error) {
+	tip := &wp.RecentChainData[len(wp.RecentChainData)-1]
+	weightsToCheck := weightsForSampling(rng, tip.RewardChainBlock.Weight, wp.RecentChainData)
+
+	var sampled []uint32
+	for i := 1; i < len(subEpochWeights); i++ {
+		if sampleSubEpoch(subEpochWeights[i-1], subEpochWeights[i], weightsToCheck) {
+			sampled = append(sampled, uint32(i-1))
+			if len(sampled) == MAX_SAMPLES {
+				break
+			}
+		}
+	}
+
+	present := make(map[uint32]bool)
+	for _, segment := range wp.SubEpochSegments {
+		present[segment.SubEpochN] = true
+	}
+	for _, n := range sampled {
+		if !present[n] {
+			return nil, invalidf("sampled sub-epoch %d has no segments", n)
+		}
+	}
+	return sampled, nil
+}
+
+// upstream _get_curr_diff_ssi
+func currDiffSSI(consts *chia.ConsensusConstants, n uint32, summaries []types.SubEpochSummary) (uint64, uint64) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if summaries[i].NewSubSlotIters != 0 {
+			return summaries[i].NewDifficulty, summaries[i].NewSubSlotIters
+		}
+	}
+	return consts.DifficultyStarting, consts.SubSlotItersStarting
+}
+
+// upstream __get_rc_sub_slot: rebuilds reward chain sub-slot at the start of the segment's sub-epoch
+func rewardChainSubSlot(consts *chia.ConsensusConstants, segment *types.SubEpochChallengeSegment,
+	summaries []types.SubEpochSummary, ssi uint64) (*types.RewardChainSubSlot, error) {
+
+	ses := &summaries[segment.SubEpochN-1]
+	slots := segment.SubSlots
+
+	firstIdx := -1
+	for i := range slots {
+		if slots[i].CcSlotEnd == nil {
+			firstIdx = i
+			break
+		}
+	}
+	// upstream asserts first_idx is truthy, so 0 is invalid too
+	if firstIdx <= 0 || segment.RcSlotEndInfo == nil {
+		return nil, invalidf("sub-epoch %d: malformed first segment", segment.SubEpochN)
+	}
+	first := &slots[firstIdx]
+
+	slotsN := 1
+	overflow := consts.IsOverflowBlock(first.SignagePointIndex)
+	if overflow && firstIdx >= 2 && slots[firstIdx-2].CcSlotEnd == nil {
+		slotsN = 2
+	}
+	newDiff := ses.NewDifficulty
+	newSSI := ses.NewSubSlotIters
+	sesHash := hashOf(*ses)
+	sesHashPtr := &sesHash
+	if overflow && firstIdx >= 2 && slots[firstIdx-2].CcSlotEnd != nil && slots[firstIdx-1].CcSlotEnd != nil {
+		sesHashPtr = nil
+		newSSI = 0
+		newDiff = 0
+	}
+
+	idx := firstIdx
+	for {
+		if slots[idx].CcSlotEnd != nil {
+			slotsN -= 1
+			if slotsN == 0 {
+				break
+			}
+		}
+		idx -= 1
+		if idx < 0 {
+			return nil, invalidf("sub-epoch %d: no slot end in first segment", segment.SubEpochN)
+		}
+	}
+	subSlot := &slots[idx]
+	if subSlot.CcSlotEndInfo == nil {
+		return nil, invalidf("sub-epoch %d: missing cc slot end info", segment.SubEpochN)
+	}
+
+	var iccSubSlotHash *[32]byte
+	ccVDFInfo := *subSlot.CcSlotEndInfo
+	if idx != 0 {
+		ccVDFInfo.NumberOfIterations = ssi
+		if subSlot.IccSlotEndInfo != nil {
+			iccInfo := *subSlot.IccSlotEndInfo
+			iccInfo.NumberOfIterations = ssi
+			hash := hashOf(iccInfo)
+			iccSubSlotHash = &hash
+		}
+	} else if subSlot.IccSlotEndInfo != nil {
+		hash := hashOf(*subSlot.IccSlotEndInfo)
+		iccSubSlotHash = &hash
+	}
+	ccSubSlot := types.ChallengeChainSubSlot{
+		ChallengeChainEndOfSlotVdf:       ccVDFInfo,
+		InfusedChallengeChainSubSlotHash: iccSubSlotHash,
+		SubepochSummaryHash:              sesHashPtr,
+		NewSubSlotIters:                  newSSI,
+		NewDifficulty:                    newDiff,
+	}
+	return &types.RewardChainSubSlot{
+		EndOfSlotVdf:                     *segment.RcSlotEndInfo,
+		ChallengeChainSubSlotHash:        hashOf(ccSubSlot),
+		InfusedChallengeChainSubSlotHash: iccSubSlotHash,
+		Deficit:                          consts.MinBlocksPerChallengeBlock,
+	}, nil
+}
+
+// Replaces upstream _validate_segment for sampled segments: proofs of space and VDFs
+// can not be verified here, so only checks that everything required for the verification is present.
+func validateSampledSegment(consts *chia.ConsensusConstants, segment *types.SubEpochChallengeSegment) error {
+	afterChallenge := false
+	for i := range segment.SubSlots {
+		ssd := &segment.SubSlots[i]
+		if ssd.ProofOfSpace != nil {
+			afterChallenge = true
+			pos := ssd.ProofOfSpace
+			if pos.Size < 32 || pos.Size > 50 || len(pos.Proof) != int(pos.Size)*8 {
+				return invalidf("sub-epoch %d: malformed proof of space (k%d, %d bytes)", segment.SubEpochN, pos.Size, len(pos.Proof))
+			}
+			if ssd.SignagePointIndex >= consts.NumSPsSubSlot {
+				return invalidf("sub-epoch %d: signage point index %d", segment.SubEpochN, ssd.SignagePointIndex)
+			}
+			if ssd.CcInfusionPoint == nil || ssd.CcIpVdfInfo == nil {
+				return invalidf("sub-epoch %d: challenge block without infusion point VDF", segment.SubEpochN)
+			}
+		} else if afterChallenge {
+			if ssd.CcSlotEndInfo != nil {
+				if ssd.CcSlotEnd == nil {
+					return invalidf("sub-epoch %d: slot end without VDF proof", segment.SubEpochN)
+				}
+			} else if ssd.CcInfusionPoint == nil || ssd.CcIpVdfInfo == nil {
+				return invalidf("sub-epoch %d: block without infusion point VDF", segment.SubEpochN)
+			}
+		}
+	}
+	return nil
+}
+
+// upstream _validate_sub_epoch_segments (without VDF and proof of space checks)
+func validateSubEpochSegments(consts *chia.ConsensusConstants, rng *pyRandom,
+	segments []types.SubEpochChallengeSegment, summaries []types.SubEpochSummary) error {
+
+	var order []uint32
+	bySubEpoch := make(map[uint32][]*types.SubEpochChallengeSegment)
+	for i := range segments {
+		n := segments[i].SubEpochN
+		if int(n) >= len(summaries) {
+			return invalidf("segment for unknown sub-epoch %d", n)
+		}
+		if _, ok := bySubEpoch[n]; !ok {
+			order = append(order, n)
+		}
+		bySubEpoch[n] = append(bySubEpoch[n], &segments[i])
+	}
+
+	rcSubSlotHash := consts.GenesisChallenge
+	for _, n := range order {
+		segs := bySubEpoch[n]
+		_, ssi := currDiffSSI(consts, n, summaries)
+		sampledIdx := rng.randBelow(len(segs))
+		if n > 0 {
+			rcSubSlot, err := rewardChainSubSlot(consts, segs[0], summaries, ssi)
+			if err != nil {
+				return merry.Wrap(err)
+			}
+			rcSubSlotHash = hashOf(*rcSubSlot)
+		}
+		if summaries[n].RewardChainHash != rcSubSlotHash {
+			return invalidf("sub-epoch %d: reward chain hash mismatch", n)
+		}
+		if err := validateSampledSegment(consts, segs[sampledIdx]); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// part of upstream _validate_recent_blocks: sub-epoch summaries included into recent chain must match
+func validateRecentSummaries(recent []types.HeaderBlock, summaries []types.SubEpochSummary) error {
+	count := 0
+	for i := range recent {
+		for _, slot := range recent[i].FinishedSubSlots {
+			if slot.ChallengeChain.SubepochSummaryHash != nil {
+				count += 1
+			}
+		}
+	}
+	sesIdx := len(summaries) - count
+	if sesIdx < 0 {
+		return invalidf("recent chain has more sub-epoch summaries than proof")
+	}
+	for i := range recent {
+		for _, slot := range recent[i].FinishedSubSlots {
+			if hash := slot.ChallengeChain.SubepochSummaryHash; hash != nil {
+				if hashOf(summaries[sesIdx]) != *hash {
+					return invalidf("block %d: sub-epoch summary hash mismatch", recent[i].RewardChainBlock.Height)
+				}
+				sesIdx += 1
+			}
+		}
+	}
+	return nil
+}
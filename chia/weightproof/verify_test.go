@@ -0,0 +1,119 @@
+package weightproof
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/types"
+	"math/big"
+	"testing"
+
+	"github.com/ansel1/merry"
+)
+
+var testConsts = func() chia.ConsensusConstants {
+	consts := chia.MAINNET_CONSTANTS
+	consts.SubEpochBlocks = 4
+	return consts
+}()
+
+func testHeaderBlock(height uint32, prev *types.HeaderBlock) types.HeaderBlock {
+	var block types.HeaderBlock
+	block.RewardChainBlock.Height = height
+	block.RewardChainBlock.Weight = new(big.Int).SetUint64(testConsts.DifficultyStarting * uint64(height+1))
+	block.RewardChainBlock.TotalIters = big.NewInt(int64(height) * 1000000)
+	block.RewardChainBlock.ChallengeChainSpSignature.Bytes = make([]byte, 96)
+	block.RewardChainBlock.RewardChainSpSignature.Bytes = make([]byte, 96)
+	block.RewardChainBlock.ProofOfSpace.PlotPublicKey.Bytes = make([]byte, 48)
+	block.Foliage.FoliageBlockDataSignature.Bytes = make([]byte, 96)
+	if prev != nil {
+		block.Foliage.PrevBlockHash = prev.HeaderHash()
+	}
+	return block
+}
+
+func testSegment(n uint32) types.SubEpochChallengeSegment {
+	var endInfo types.VDFInfo
+	endInfo.Challenge[0] = byte(n)
+	endInfo.NumberOfIterations = 12345
+	return types.SubEpochChallengeSegment{
+		SubEpochN: n,
+		SubSlots: []types.SubSlotData{
+			{CcSlotEnd: &types.VDFProof{}, CcSlotEndInfo: &endInfo},
+			{
+				ProofOfSpace:      &types.ProofOfSpace{Size: 32, Proof: make([]byte, 32*8)},
+				SignagePointIndex: 5,
+				CcInfusionPoint:   &types.VDFProof{},
+				CcIpVdfInfo:       &types.VDFInfo{},
+			},
+		},
+		RcSlotEndInfo: &endInfo,
+	}
+}
+
+// makeWeightProof builds consistent proof with 3 sub-epochs (4 blocks each, difficulty 7)
+// and recent chain of blocks 5..10.
+func makeWeightProof(t *testing.T) *types.WeightProof {
+	wp := &types.WeightProof{}
+	wp.SubEpochs = []types.SubEpochData{{RewardChainHash: testConsts.GenesisChallenge}}
+	wp.SubEpochSegments = []types.SubEpochChallengeSegment{testSegment(0)}
+	for n := uint32(1); n < 3; n++ {
+		segment := testSegment(n)
+		summaries, _, _ := mapSubEpochSummaries(&testConsts, wp.SubEpochs)
+		rcSubSlot, err := rewardChainSubSlot(&testConsts, &segment, summaries, testConsts.SubSlotItersStarting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wp.SubEpochs = append(wp.SubEpochs, types.SubEpochData{RewardChainHash: hashOf(*rcSubSlot)})
+		wp.SubEpochSegments = append(wp.SubEpochSegments, segment)
+	}
+	summaries, _, _ := mapSubEpochSummaries(&testConsts, wp.SubEpochs)
+	lastSesHash := hashOf(summaries[2])
+
+	var prev *types.HeaderBlock
+	for height := uint32(5); height <= 10; height++ {
+		block := testHeaderBlock(height, prev)
+		if height == 8 {
+			block.FinishedSubSlots = []types.EndOfSubSlotBundle{{
+				ChallengeChain: types.ChallengeChainSubSlot{SubepochSummaryHash: &lastSesHash},
+			}}
+		}
+		wp.RecentChainData = append(wp.RecentChainData, block)
+		prev = &wp.RecentChainData[len(wp.RecentChainData)-1]
+	}
+	return wp
+}
+
+func TestVerify(t *testing.T) {
+	wp := makeWeightProof(t)
+	tip := &wp.RecentChainData[len(wp.RecentChainData)-1]
+	peak := &types.NewPeak{HeaderHash: tip.HeaderHash(), Height: 10, Weight: tip.RewardChainBlock.Weight}
+	res, err := Verify(&testConsts, wp, peak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Peak.RewardChainBlock.Height != 10 || len(res.Summaries) != 3 || res.SpaceEstimate == nil {
+		t.Errorf("unexpected result: %#v", res)
+	}
+
+	breakers := map[string]func(wp *types.WeightProof){
+		"wrong difficulty": func(wp *types.WeightProof) { wp.SubEpochs[1].NewDifficulty = 100 },
+		"light chain": func(wp *types.WeightProof) {
+			for i := range wp.RecentChainData {
+				wp.RecentChainData[i].RewardChainBlock.Weight.Sub(wp.RecentChainData[i].RewardChainBlock.Weight, big.NewInt(1))
+			}
+		},
+		"broken link":       func(wp *types.WeightProof) { wp.RecentChainData[3].Foliage.PrevBlockHash[0] ^= 1 },
+		"missing segments":  func(wp *types.WeightProof) { wp.SubEpochSegments = wp.SubEpochSegments[2:] },
+		"wrong rc hash":     func(wp *types.WeightProof) { wp.SubEpochSegments[1].RcSlotEndInfo.NumberOfIterations += 1 },
+		"bad pos":           func(wp *types.WeightProof) { wp.SubEpochSegments[0].SubSlots[1].ProofOfSpace.Size = 20 },
+		"no sub-epochs":     func(wp *types.WeightProof) { wp.SubEpochs = nil },
+		"no ses in recent":  func(wp *types.WeightProof) { wp.RecentChainData[3].FinishedSubSlots = nil },
+		"another peak only": func(wp *types.WeightProof) { wp.RecentChainData = wp.RecentChainData[:5] },
+	}
+	for name, breakProof := range breakers {
+		wp := makeWeightProof(t)
+		breakProof(wp)
+		if _, err := Verify(&testConsts, wp, peak); !merry.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected invalid proof error, got %v", name, err)
+		}
+	}
+}
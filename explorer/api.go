@@ -0,0 +1,440 @@
+package explorer
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/clvm"
+	"chiastat/chia/mempool"
+	"chiastat/chia/types"
+	chiautils "chiastat/chia/utils"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+const DEFAULT_ADDRESS_PREFIX = "xch"
+
+// Coins are searched by scanning blocks (running their generators), so range is limited.
+const DEFAULT_MAX_SCAN_BLOCKS = 1000
+
+// Space history is returned for about a month by default (less if MaxSpaceBlocks is too small for that).
+const DEFAULT_SPACE_HISTORY_BLOCKS = 30 * chia.DEFAULT_SPACE_WINDOW_BLOCKS
+
+// Space history is made by reading block records of the range (and the window before it), so range is limited too.
+const DEFAULT_MAX_SPACE_BLOCKS = 4 * DEFAULT_SPACE_HISTORY_BLOCKS
+
+// https://github.com/Chia-Network/chia-blockchain/blob/main/chia/types/condition_opcodes.py
+var CONDITION_NAMES = map[byte]string{
+	mempool.COND_REMARK:                     "REMARK",
+	mempool.COND_AGG_SIG_UNSAFE:             "AGG_SIG_UNSAFE",
+	mempool.COND_AGG_SIG_ME:                 "AGG_SIG_ME",
+	mempool.COND_CREATE_COIN:                "CREATE_COIN",
+	mempool.COND_RESERVE_FEE:                "RESERVE_FEE",
+	mempool.COND_CREATE_COIN_ANNOUNCEMENT:   "CREATE_COIN_ANNOUNCEMENT",
+	mempool.COND_ASSERT_COIN_ANNOUNCEMENT:   "ASSERT_COIN_ANNOUNCEMENT",
+	mempool.COND_CREATE_PUZZLE_ANNOUNCEMENT: "CREATE_PUZZLE_ANNOUNCEMENT",
+	mempool.COND_ASSERT_PUZZLE_ANNOUNCEMENT: "ASSERT_PUZZLE_ANNOUNCEMENT",
+	mempool.COND_ASSERT_MY_COIN_ID:          "ASSERT_MY_COIN_ID",
+	mempool.COND_ASSERT_MY_PARENT_ID:        "ASSERT_MY_PARENT_ID",
+	mempool.COND_ASSERT_MY_PUZZLEHASH:       "ASSERT_MY_PUZZLEHASH",
+	mempool.COND_ASSERT_MY_AMOUNT:           "ASSERT_MY_AMOUNT",
+	mempool.COND_ASSERT_SECONDS_RELATIVE:    "ASSERT_SECONDS_RELATIVE",
+	mempool.COND_ASSERT_SECONDS_ABSOLUTE:    "ASSERT_SECONDS_ABSOLUTE",
+	mempool.COND_ASSERT_HEIGHT_RELATIVE:     "ASSERT_HEIGHT_RELATIVE",
+	mempool.COND_ASSERT_HEIGHT_ABSOLUTE:     "ASSERT_HEIGHT_ABSOLUTE",
+}
+
+var errBadRequest = merry.New("bad request").WithHTTPCode(http.StatusBadRequest)
+var errNotFound = merry.New("not found").WithHTTPCode(http.StatusNotFound)
+
+type Config struct {
+	// DEFAULT_ADDRESS_PREFIX if empty.
+	AddressPrefix string
+	// DEFAULT_MAX_SCAN_BLOCKS if zero.
+	MaxScanBlocks uint32
+	// Max space history range including estimation window, DEFAULT_MAX_SPACE_BLOCKS if zero.
+	MaxSpaceBlocks uint32
+}
+
+// Handler serves JSON API over a block source:
+//
+//	GET /api/peak
+//	GET /api/blocks/<height or header hash>[?full=1]
+//	GET /api/blocks/<height or header hash>/spends
+//	GET /api/coins/<address or puzzle hash>[?from=<height>&to=<height>]
+//	GET /api/space[?bucket=day&window=4608&from=<height>&to=<height>&unit=PiB]
+type Handler struct {
+	// wrapped with lockedSource: block sources are not required to be safe for concurrent use
+	src chia.BlockSource
+	cfg Config
+	mux *http.ServeMux
+}
+
+func NewHandler(src chia.BlockSource, cfg Config) *Handler {
+	if cfg.AddressPrefix == "" {
+		cfg.AddressPrefix = DEFAULT_ADDRESS_PREFIX
+	}
+	if cfg.MaxScanBlocks == 0 {
+		cfg.MaxScanBlocks = DEFAULT_MAX_SCAN_BLOCKS
+	}
+	if cfg.MaxSpaceBlocks == 0 {
+		cfg.MaxSpaceBlocks = DEFAULT_MAX_SPACE_BLOCKS
+	}
+	h := &Handler{src: &lockedSource{src: src}, cfg: cfg, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/api/peak", h.wrap(h.handlePeak))
+	h.mux.HandleFunc("/api/blocks/", h.wrap(h.handleBlock))
+	h.mux.HandleFunc("/api/coins/", h.wrap(h.handleCoins))
+	h.mux.HandleFunc("/api/space", h.wrap(h.handleSpace))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) wrap(handler func(*http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		res, err := handler(r)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			if merry.Is(err, chia.ErrBlockNotFound) {
+				err = errNotFound.Here().WithCause(err)
+			}
+			code := merry.HTTPCode(err)
+			if code == http.StatusInternalServerError {
+				log.Printf("WARN: explorer: %s: %s", r.URL, merry.Details(err))
+			}
+			w.WriteHeader(code)
+			res = map[string]string{"error": err.Error()}
+		}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("WARN: explorer: %s: writing response: %s", r.URL, err)
+		}
+	}
+}
+
+func queryUint32(r *http.Request, name string, def uint32) (uint32, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseUint(str, 10, 32)
+	if err != nil {
+		return 0, errBadRequest.Here().WithMessagef("invalid %s: %s", name, str)
+	}
+	return uint32(val), nil
+}
+
+func parseHash(str string) ([32]byte, bool) {
+	var res [32]byte
+	buf, err := hex.DecodeString(strings.TrimPrefix(str, "0x"))
+	if err != nil || len(buf) != 32 {
+		return res, false
+	}
+	copy(res[:], buf)
+	return res, true
+}
+
+func hexStr(buf []byte) string {
+	return "0x" + hex.EncodeToString(buf)
+}
+
+func (h *Handler) coinJSON(coin types.Coin) interface{} {
+	id := coin.ID()
+	return chiautils.JSONObject{
+		Keys: []string{"id", "parent_coin_info", "puzzle_hash", "address", "amount"},
+		Values: []interface{}{hexStr(id[:]), hexStr(coin.ParentCoinInfo[:]), hexStr(coin.PuzzleHash[:]),
+			chia.EncodePuzzleHash(coin.PuzzleHash, h.cfg.AddressPrefix), coin.Amount},
+	}
+}
+
+func (h *Handler) handlePeak(r *http.Request) (interface{}, error) {
+	height, err := h.src.PeakHeight()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	br, err := h.src.BlockRecordByHeight(height)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return h.blockRecordJSON(br), nil
+}
+
+func (h *Handler) blockRecordJSON(br *types.BlockRecord) chiautils.JSONObject {
+	claims := make([]interface{}, len(br.RewardClaimsIncorporated))
+	for i, coin := range br.RewardClaimsIncorporated {
+		claims[i] = h.coinJSON(coin)
+	}
+	var timestamp interface{}
+	if br.Timestamp != 0 {
+		timestamp = br.Timestamp
+	}
+	return chiautils.JSONObject{
+		Keys: []string{"height", "header_hash", "prev_hash", "weight", "total_iters", "timestamp",
+			"farmer_address", "pool_address", "fees", "reward_claims"},
+		Values: []interface{}{br.Height, hexStr(br.HeaderHash[:]), hexStr(br.PrevHash[:]),
+			json.Number(br.Weight.String()), json.Number(br.TotalIters.String()), timestamp,
+			chia.EncodePuzzleHash(br.FarmerPuzzleHash, h.cfg.AddressPrefix),
+			chia.EncodePuzzleHash(br.PoolPuzzleHash, h.cfg.AddressPrefix),
+			br.Fees, claims},
+	}
+}
+
+func (h *Handler) handleBlock(r *http.Request) (interface{}, error) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/blocks/")
+	id, suffix := path, ""
+	if i := strings.Index(path, "/"); i != -1 {
+		id, suffix = path[:i], path[i+1:]
+	}
+	if suffix != "" && suffix != "spends" {
+		return nil, errNotFound.Here()
+	}
+
+	var br *types.BlockRecord
+	var err error
+	if height, parseErr := strconv.ParseUint(id, 10, 32); parseErr == nil {
+		br, err = h.src.BlockRecordByHeight(uint32(height))
+	} else if hash, ok := parseHash(id); ok {
+		br, err = h.src.BlockRecordByHash(hash)
+	} else {
+		return nil, errBadRequest.Here().WithMessagef("expected height or header hash, got: %s", id)
+	}
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	if suffix == "spends" {
+		return h.blockSpendsJSON(br.Height)
+	}
+	res := h.blockRecordJSON(br)
+	if r.URL.Query().Get("full") == "1" {
+		block, err := h.src.FullBlockByHeight(br.Height)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		res.Keys = append(res.Keys, "block")
+		res.Values = append(res.Values, chiautils.ToJSONValue(block))
+	}
+	return res, nil
+}
+
+func (h *Handler) conditionJSON(cond clvm.SExp) interface{} {
+	var opcode interface{}
+	var name string
+	var args []string
+	for i := 0; ; i++ {
+		pair, ok := cond.(clvm.Pair)
+		if !ok {
+			break
+		}
+		atom, isAtom := pair.First.(clvm.Atom)
+		if i == 0 && isAtom {
+			if code, err := atom.AsInt64(); err == nil {
+				opcode = code
+				name = CONDITION_NAMES[byte(code)]
+			}
+		} else if isAtom {
+			args = append(args, hexStr(atom.Bytes))
+		} else {
+			args = append(args, pair.First.String())
+		}
+		cond = pair.Rest
+	}
+	return chiautils.JSONObject{Keys: []string{"opcode", "name", "args"}, Values: []interface{}{opcode, name, args}}
+}
+
+func (h *Handler) blockSpendsJSON(height uint32) (interface{}, error) {
+	block, err := h.src.FullBlockByHeight(height)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	spends, err := chia.RunBlockGenerator(h.src, block)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	items := make([]interface{}, len(spends))
+	for i, spend := range spends {
+		conds := make([]interface{}, len(spend.Conditions))
+		for j, cond := range spend.Conditions {
+			conds[j] = h.conditionJSON(cond)
+		}
+		items[i] = chiautils.JSONObject{Keys: []string{"coin", "conditions"}, Values: []interface{}{h.coinJSON(spend.Coin), conds}}
+	}
+	hash := block.HeaderHash()
+	return chiautils.JSONObject{
+		Keys:   []string{"height", "header_hash", "generator_ref_list", "spends"},
+		Values: []interface{}{height, hexStr(hash[:]), chiautils.ToJSONValue(block.TransactionsGeneratorRefList), items},
+	}, nil
+}
+
+type coinRecord struct {
+	coin          types.Coin
+	reward        bool
+	createdHeight *uint32
+	spentHeight   *uint32
+}
+
+func (h *Handler) handleCoins(r *http.Request) (interface{}, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/coins/")
+	puzzleHash, ok := parseHash(id)
+	if !ok {
+		var err error
+		if _, puzzleHash, err = chia.DecodePuzzleHash(id); err != nil {
+			return nil, errBadRequest.Here().WithMessagef("expected address or puzzle hash, got: %s", id)
+		}
+	}
+
+	peakHeight, err := h.src.PeakHeight()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	endHeight, err := queryUint32(r, "to", peakHeight)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	startHeight := uint32(0)
+	if endHeight >= h.cfg.MaxScanBlocks {
+		startHeight = endHeight - h.cfg.MaxScanBlocks + 1
+	}
+	if startHeight, err = queryUint32(r, "from", startHeight); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if startHeight > endHeight || endHeight-startHeight >= h.cfg.MaxScanBlocks {
+		return nil, errBadRequest.Here().WithMessagef("expected range of 1..%d blocks, got %d..%d",
+			h.cfg.MaxScanBlocks, startHeight, endHeight)
+	}
+
+	var records []*coinRecord
+	byID := make(map[[32]byte]*coinRecord)
+	addCreated := func(coin types.Coin, height uint32, reward bool) {
+		rec := &coinRecord{coin: coin, reward: reward, createdHeight: &height}
+		records = append(records, rec)
+		byID[coin.ID()] = rec
+	}
+
+	err = h.src.ForEachFullBlock(startHeight, endHeight, func(block *types.FullBlock) error {
+		height := block.RewardChainBlock.Height
+		if block.TransactionsInfo != nil {
+			for _, coin := range block.TransactionsInfo.RewardClaimsIncorporated {
+				if coin.PuzzleHash == puzzleHash {
+					addCreated(coin, height, true)
+				}
+			}
+		}
+		spends, err := chia.RunBlockGenerator(h.src, block)
+		if err != nil {
+			return merry.Prependf(err, "block %d", height)
+		}
+		for _, spend := range spends {
+			coinID := spend.Coin.ID()
+			if spend.Coin.PuzzleHash == puzzleHash {
+				rec, ok := byID[coinID]
+				if !ok {
+					rec = &coinRecord{coin: spend.Coin}
+					records = append(records, rec)
+				}
+				rec.spentHeight = &height
+			}
+			for _, cond := range spend.Conditions {
+				coin, ok, err := mempool.CreatedCoin(cond, coinID)
+				if err != nil {
+					return merry.Prependf(err, "block %d", height)
+				}
+				if ok && coin.PuzzleHash == puzzleHash {
+					addCreated(coin, height, false)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	coins := make([]interface{}, len(records))
+	for i, rec := range records {
+		coins[i] = chiautils.JSONObject{
+			Keys:   []string{"coin", "reward", "created_height", "spent_height"},
+			Values: []interface{}{h.coinJSON(rec.coin), rec.reward, rec.createdHeight, rec.spentHeight},
+		}
+	}
+	return chiautils.JSONObject{
+		Keys: []string{"puzzle_hash", "address", "from", "to", "coins"},
+		Values: []interface{}{hexStr(puzzleHash[:]), chia.EncodePuzzleHash(puzzleHash, h.cfg.AddressPrefix),
+			startHeight, endHeight, coins},
+	}, nil
+}
+
+func (h *Handler) handleSpace(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	var cfg chia.NetSpaceSeriesConfig
+	bucket := query.Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if err := cfg.ParseBucket(bucket); err != nil {
+		return nil, errBadRequest.Here().WithMessage(err.Error())
+	}
+	unit := query.Get("unit")
+	if unit == "" {
+		unit = "PiB"
+	}
+	if _, ok := chia.SPACE_UNITS[unit]; !ok {
+		return nil, errBadRequest.Here().WithMessagef("unknown unit: %s", unit)
+	}
+
+	peakHeight, err := h.src.PeakHeight()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cfg.WindowBlocks, err = queryUint32(r, "window", chia.DEFAULT_SPACE_WINDOW_BLOCKS); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cfg.WindowBlocks == 0 {
+		cfg.WindowBlocks = chia.DEFAULT_SPACE_WINDOW_BLOCKS
+	}
+	if cfg.WindowBlocks >= h.cfg.MaxSpaceBlocks {
+		return nil, errBadRequest.Here().WithMessagef("expected window below %d blocks, got %d", h.cfg.MaxSpaceBlocks, cfg.WindowBlocks)
+	}
+	if cfg.EndHeight, err = queryUint32(r, "to", peakHeight); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cfg.EndHeight > peakHeight {
+		cfg.EndHeight = peakHeight
+	}
+	history := uint32(DEFAULT_SPACE_HISTORY_BLOCKS)
+	if maxHistory := h.cfg.MaxSpaceBlocks - cfg.WindowBlocks - 1; history > maxHistory {
+		history = maxHistory
+	}
+	startHeight := uint32(0)
+	if cfg.EndHeight > history {
+		startHeight = cfg.EndHeight - history
+	}
+	if cfg.StartHeight, err = queryUint32(r, "from", startHeight); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if cfg.StartHeight > cfg.EndHeight ||
+		uint64(cfg.EndHeight-cfg.StartHeight)+uint64(cfg.WindowBlocks) >= uint64(h.cfg.MaxSpaceBlocks) {
+		return nil, errBadRequest.Here().WithMessagef("expected range of 1..%d blocks including window, got %d..%d with window %d",
+			h.cfg.MaxSpaceBlocks, cfg.StartHeight, cfg.EndHeight, cfg.WindowBlocks)
+	}
+
+	points, err := chia.NetworkSpaceSeries(h.src, cfg)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	var buf strings.Builder
+	if err := chia.PrintNetworkSpaceSeries(&buf, points, "json", unit); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return json.RawMessage(buf.String()), nil
+}
@@ -0,0 +1,211 @@
+package explorer
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/clvm"
+	"chiastat/chia/types"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// memSource is a BlockSource over in-memory blocks (heights are indexes).
+type memSource struct {
+	blocks []types.FullBlock
+}
+
+func (s *memSource) PeakHeight() (uint32, error) {
+	return uint32(len(s.blocks) - 1), nil
+}
+func (s *memSource) FullBlockByHeight(height uint32) (*types.FullBlock, error) {
+	if int(height) >= len(s.blocks) {
+		return nil, chia.ErrBlockNotFound.Here()
+	}
+	return &s.blocks[height], nil
+}
+func (s *memSource) FullBlockByHash(headerHash [32]byte) (*types.FullBlock, error) {
+	for i := range s.blocks {
+		if s.blocks[i].HeaderHash() == headerHash {
+			return &s.blocks[i], nil
+		}
+	}
+	return nil, chia.ErrBlockNotFound.Here()
+}
+func (s *memSource) BlockRecordByHeight(height uint32) (*types.BlockRecord, error) {
+	block, err := s.FullBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return chia.BlockRecordFromFullBlock(block), nil
+}
+func (s *memSource) BlockRecordByHash(headerHash [32]byte) (*types.BlockRecord, error) {
+	block, err := s.FullBlockByHash(headerHash)
+	if err != nil {
+		return nil, err
+	}
+	return chia.BlockRecordFromFullBlock(block), nil
+}
+func (s *memSource) ForEachFullBlock(startHeight, endHeight uint32, handler func(*types.FullBlock) error) error {
+	for h := startHeight; h <= endHeight && int(h) < len(s.blocks); h++ {
+		if err := handler(&s.blocks[h]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (s *memSource) ForEachBlockRecord(startHeight, endHeight uint32, handler func(*types.BlockRecord) error) error {
+	return s.ForEachFullBlock(startHeight, endHeight, func(block *types.FullBlock) error {
+		return handler(chia.BlockRecordFromFullBlock(block))
+	})
+}
+func (s *memSource) Close() error {
+	return nil
+}
+
+func mustSExp(t *testing.T, ir string) clvm.SExp {
+	t.Helper()
+	sexp, err := clvm.SExpFromIRString(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sexp
+}
+
+// makeTestSource returns three blocks: block 1 claims reward to puzzle hash of (q . ()),
+// block 2 spends this reward coin and another one, which creates a coin for the same puzzle hash.
+func makeTestSource(t *testing.T) (*memSource, [32]byte, types.Coin) {
+	target := clvm.TreeHash(mustSExp(t, "(q . ())"))
+	reward := types.Coin{ParentCoinInfo: [32]byte{1}, PuzzleHash: target, Amount: 1750}
+	payIR := "(q . ((51 0x" + hex.EncodeToString(target[:]) + " 70) (51 0x" + strings.Repeat("33", 32) + " 30)))"
+	gen := mustSExp(t, "(q . (("+
+		"(0x"+hex.EncodeToString(reward.ParentCoinInfo[:])+" (q . ()) 1750 ()) "+
+		"(0x"+strings.Repeat("11", 32)+" "+payIR+" 100 ()))))")
+	payer := types.Coin{PuzzleHash: clvm.TreeHash(mustSExp(t, payIR)), Amount: 100}
+	for i := range payer.ParentCoinInfo {
+		payer.ParentCoinInfo[i] = 0x11
+	}
+
+	src := &memSource{}
+	for i := 0; i < 3; i++ {
+		var block types.FullBlock
+		block.RewardChainBlock.Height = uint32(i)
+		block.RewardChainBlock.Weight = big.NewInt(int64(i+1) * 100)
+		block.RewardChainBlock.TotalIters = big.NewInt(int64(i+1) * 1000)
+		block.FoliageTransactionBlock = &types.FoliageTransactionBlock{Timestamp: 1600000000 + uint64(i)*20}
+		block.TransactionsInfo = &types.TransactionsInfo{}
+		if i == 1 {
+			block.TransactionsInfo.RewardClaimsIncorporated = []types.Coin{reward}
+		}
+		if i == 2 {
+			block.TransactionsGenerator = &types.SerializedProgram{Root: gen, Bytes: gen.Dump()}
+		}
+		if i > 0 {
+			block.Foliage.PrevBlockHash = src.blocks[i-1].HeaderHash()
+		}
+		src.blocks = append(src.blocks, block)
+	}
+	created := types.Coin{ParentCoinInfo: payer.ID(), PuzzleHash: target, Amount: 70}
+	return src, target, created
+}
+
+func get(t *testing.T, handler http.Handler, url string, expectedCode int) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != expectedCode {
+		t.Fatalf("%s: expected code %d, got %d: %s", url, expectedCode, rec.Code, rec.Body.String())
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s: %s", url, err)
+	}
+	return res
+}
+
+func coinID(coin types.Coin) string {
+	id := coin.ID()
+	return "0x" + hex.EncodeToString(id[:])
+}
+
+func TestHandler(t *testing.T) {
+	src, target, created := makeTestSource(t)
+	handler := NewHandler(src, Config{MaxScanBlocks: 10})
+
+	if res := get(t, handler, "/api/peak", 200); res["height"] != 2.0 {
+		t.Errorf("unexpected peak: %v", res)
+	}
+	hash := src.blocks[1].HeaderHash()
+	res := get(t, handler, "/api/blocks/"+hex.EncodeToString(hash[:]), 200)
+	claims := res["reward_claims"].([]interface{})
+	if res["height"] != 1.0 || len(claims) != 1 || claims[0].(map[string]interface{})["address"] != chia.EncodePuzzleHash(target, "xch") {
+		t.Errorf("unexpected block: %v", res)
+	}
+	if res := get(t, handler, "/api/blocks/1?full=1", 200); res["block"] == nil {
+		t.Errorf("expected full block: %v", res)
+	}
+	get(t, handler, "/api/blocks/5", 404)
+	get(t, handler, "/api/blocks/abc", 400)
+	get(t, handler, "/api/blocks/1/unknown", 404)
+
+	res = get(t, handler, "/api/blocks/2/spends", 200)
+	spends := res["spends"].([]interface{})
+	if len(spends) != 2 {
+		t.Fatalf("expected 2 spends, got %v", res)
+	}
+	conds := spends[1].(map[string]interface{})["conditions"].([]interface{})
+	if len(conds) != 2 {
+		t.Fatalf("expected 2 conditions, got %v", spends[1])
+	}
+	cond := conds[0].(map[string]interface{})
+	if cond["opcode"] != 51.0 || cond["name"] != "CREATE_COIN" || cond["args"].([]interface{})[1] != "0x46" {
+		t.Errorf("unexpected condition: %v", cond)
+	}
+
+	res = get(t, handler, "/api/coins/"+chia.EncodePuzzleHash(target, "xch"), 200)
+	coins := res["coins"].([]interface{})
+	if res["from"] != 0.0 || res["to"] != 2.0 || len(coins) != 2 {
+		t.Fatalf("unexpected coins: %v", res)
+	}
+	reward, payment := coins[0].(map[string]interface{}), coins[1].(map[string]interface{})
+	rewardCoin := types.Coin{ParentCoinInfo: [32]byte{1}, PuzzleHash: target, Amount: 1750}
+	if reward["coin"].(map[string]interface{})["id"] != coinID(rewardCoin) || reward["reward"] != true ||
+		reward["created_height"] != 1.0 || reward["spent_height"] != 2.0 {
+		t.Errorf("unexpected reward coin: %v", reward)
+	}
+	if payment["coin"].(map[string]interface{})["id"] != coinID(created) || payment["reward"] != false ||
+		payment["created_height"] != 2.0 || payment["spent_height"] != nil {
+		t.Errorf("unexpected created coin: %v", payment)
+	}
+	res = get(t, handler, "/api/coins/"+hex.EncodeToString(target[:])+"?from=2", 200)
+	if coins := res["coins"].([]interface{}); len(coins) != 2 || coins[0].(map[string]interface{})["created_height"] != nil {
+		t.Errorf("expected spent coin without creation height: %v", res)
+	}
+	get(t, handler, "/api/coins/xch1abc", 400)
+	get(t, handler, "/api/coins/"+hex.EncodeToString(target[:])+"?from=0&to=20", 400)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/space?bucket=1blocks&window=1&from=1", nil))
+	var points []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &points); err != nil || len(points) != 2 || points[0]["end_height"] != 1.0 {
+		t.Errorf("unexpected space points: %s (%v)", rec.Body.String(), err)
+	}
+	get(t, handler, "/api/space?unit=XiB", 400)
+
+	limited := NewHandler(src, Config{MaxSpaceBlocks: 3})
+	get(t, limited, "/api/space?bucket=1blocks&window=1&from=0", 400)
+	get(t, limited, "/api/space?bucket=1blocks&window=0&from=2", 400)
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest("GET", "/api/space?bucket=1blocks&window=1", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &points); err != nil || len(points) != 2 {
+		t.Errorf("space with default range: %d %s (%v)", rec.Code, rec.Body.String(), err)
+	}
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest("GET", "/api/space?bucket=1blocks&window=1&from=1", nil))
+	if rec.Code != 200 {
+		t.Errorf("space in limited range: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
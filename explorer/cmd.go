@@ -0,0 +1,39 @@
+package explorer
+
+import (
+	"chiastat/utils"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+func CMDExplorer() error {
+	srcFlags := utils.AddBlockSourceFlags()
+	listen := flag.String("listen", "127.0.0.1:8080", "HTTP API listen address")
+	prefix := flag.String("prefix", DEFAULT_ADDRESS_PREFIX, "address prefix")
+	maxScanBlocks := flag.Uint("max-scan-blocks", DEFAULT_MAX_SCAN_BLOCKS, "max blocks range of coins search")
+	maxSpaceBlocks := flag.Uint("max-space-blocks", DEFAULT_MAX_SPACE_BLOCKS, "max blocks range (including window) of space history")
+	flag.Parse()
+
+	src, err := srcFlags.Open()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+
+	handler := NewHandler(src, Config{
+		AddressPrefix:  *prefix,
+		MaxScanBlocks:  uint32(*maxScanBlocks),
+		MaxSpaceBlocks: uint32(*maxSpaceBlocks),
+	})
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("EXPLORER: listening on %s", *listen)
+	return merry.Wrap(server.ListenAndServe())
+}
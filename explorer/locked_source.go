@@ -0,0 +1,122 @@
+package explorer
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/types"
+	"sync"
+
+	"github.com/ansel1/merry"
+)
+
+const lockedSourceFullBlocksChunk = 100
+const lockedSourceRecordsChunk = 1000
+
+// lockedSource makes block source (not required to be safe for concurrent use) shareable
+// between requests: every call is made under the mutex, ForEach* read blocks in chunks,
+// so the lock is not held while handler runs (and handler may use the source itself).
+type lockedSource struct {
+	src   chia.BlockSource
+	mutex sync.Mutex
+}
+
+func (s *lockedSource) PeakHeight() (uint32, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.PeakHeight()
+}
+
+func (s *lockedSource) FullBlockByHeight(height uint32) (*types.FullBlock, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.FullBlockByHeight(height)
+}
+
+func (s *lockedSource) FullBlockByHash(headerHash [32]byte) (*types.FullBlock, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.FullBlockByHash(headerHash)
+}
+
+func (s *lockedSource) BlockRecordByHeight(height uint32) (*types.BlockRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.BlockRecordByHeight(height)
+}
+
+func (s *lockedSource) BlockRecordByHash(headerHash [32]byte) (*types.BlockRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.BlockRecordByHash(headerHash)
+}
+
+// forEachChunk calls readChunk under the lock for consecutive height ranges of chunkSize
+// and then handleChunk without it, stops on empty chunk (source peak is reached).
+func (s *lockedSource) forEachChunk(startHeight, endHeight uint32, chunkSize uint32, readChunk func(start, end uint32) (int, error), handleChunk func() error) error {
+	for start := uint64(startHeight); start <= uint64(endHeight); start += uint64(chunkSize) {
+		end := start + uint64(chunkSize) - 1
+		if end > uint64(endHeight) {
+			end = uint64(endHeight)
+		}
+		s.mutex.Lock()
+		count, err := readChunk(uint32(start), uint32(end))
+		s.mutex.Unlock()
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if count == 0 {
+			return nil
+		}
+		if err := handleChunk(); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (s *lockedSource) ForEachFullBlock(startHeight, endHeight uint32, handler func(*types.FullBlock) error) error {
+	var blocks []*types.FullBlock
+	return s.forEachChunk(startHeight, endHeight, lockedSourceFullBlocksChunk,
+		func(start, end uint32) (int, error) {
+			blocks = blocks[:0]
+			err := s.src.ForEachFullBlock(start, end, func(block *types.FullBlock) error {
+				blocks = append(blocks, block)
+				return nil
+			})
+			return len(blocks), err
+		},
+		func() error {
+			for _, block := range blocks {
+				if err := handler(block); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+func (s *lockedSource) ForEachBlockRecord(startHeight, endHeight uint32, handler func(*types.BlockRecord) error) error {
+	var records []*types.BlockRecord
+	return s.forEachChunk(startHeight, endHeight, lockedSourceRecordsChunk,
+		func(start, end uint32) (int, error) {
+			records = records[:0]
+			err := s.src.ForEachBlockRecord(start, end, func(br *types.BlockRecord) error {
+				records = append(records, br)
+				return nil
+			})
+			return len(records), err
+		},
+		func() error {
+			for _, br := range records {
+				if err := handler(br); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+func (s *lockedSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.src.Close()
+}
@@ -0,0 +1,36 @@
+package explorer
+
+import (
+	"chiastat/chia/types"
+	"math"
+	"testing"
+)
+
+func TestLockedSource(t *testing.T) {
+	mem := &memSource{blocks: make([]types.FullBlock, 250)}
+	for i := range mem.blocks {
+		mem.blocks[i].RewardChainBlock.Height = uint32(i)
+	}
+	src := &lockedSource{src: mem}
+
+	var heights []uint32
+	err := src.ForEachFullBlock(5, math.MaxUint32, func(block *types.FullBlock) error {
+		// handler is called without the lock held
+		if _, err := src.BlockRecordByHeight(block.RewardChainBlock.Height); err != nil {
+			return err
+		}
+		heights = append(heights, block.RewardChainBlock.Height)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(heights) != 245 || heights[0] != 5 || heights[244] != 249 {
+		t.Errorf("unexpected heights: %d items, %v", len(heights), heights)
+	}
+	for i, h := range heights {
+		if h != uint32(i+5) {
+			t.Fatalf("expected height %d, got %d", i+5, h)
+		}
+	}
+}
@@ -3,10 +3,12 @@ module chiastat
 go 1.16
 
 require (
-	github.com/abh/geoip v0.0.0-20160510155516-07cea4480daa // indirect
+	github.com/abh/geoip v0.0.0-20160510155516-07cea4480daa
 	github.com/ansel1/merry v1.5.1
-	github.com/go-pg/migrations/v8 v8.1.0 // indirect
-	github.com/go-pg/pg/v10 v10.9.1 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/go-pg/migrations/v8 v8.1.0
+	github.com/go-pg/pg/v10 v10.9.1
+	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.13.6
 	github.com/mattn/go-sqlite3 v1.14.7
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
 )
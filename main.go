@@ -1,18 +1,29 @@
 package main
 
 import (
+	"chiastat/chainstats"
 	"chiastat/chia"
+	"chiastat/chia/blocksync"
 	"chiastat/chia/network"
 	"chiastat/chia/types"
 	chiautils "chiastat/chia/utils"
+	"chiastat/chia/weightproof"
+	"chiastat/explorer"
+	"chiastat/mempoolwatch"
 	"chiastat/nodes"
 	"chiastat/utils"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/big"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ansel1/merry"
@@ -20,54 +31,192 @@ import (
 )
 
 func CMDEstimateSize() error {
-	dbPath := flag.String("db-path", utils.HomeDirOrEmpty("/.chia/mainnet/db/")+"blockchain_v1_mainnet.sqlite", "path to blockchain_v1_mainnet.sqlite")
+	srcFlags := utils.AddBlockSourceFlags()
+	endHeight := flag.Uint("to", 0, "estimate at this height (peak by default)")
+	window := flag.Uint("window", chia.DEFAULT_SPACE_WINDOW_BLOCKS, "number of blocks space is estimated over")
+	confidence := flag.Float64("confidence", chia.DEFAULT_SPACE_CONFIDENCE, "confidence interval level")
+	plotFilter := flag.String("plot-filter", "", `plot filter bits, "<bits>" or "<height>:<bits>,..." (mainnet schedule by default)`)
+	spaceFactor := flag.Float64("space-factor", chia.DEFAULT_ACTUAL_SPACE_FACTOR, "actual to expected plot size ratio")
+	unit := flag.String("unit", "PiB", "space unit: B, GiB, TiB, PiB or EiB")
 	flag.Parse()
 
-	db, err := utils.OpenExistingSqlite3(*dbPath)
+	unitSize, ok := chia.SPACE_UNITS[*unit]
+	if !ok {
+		return merry.Errorf("unknown unit: %s", *unit)
+	}
+	params := &chia.SpaceEstimateParams{ActualSpaceFactor: *spaceFactor}
+	if *plotFilter != "" {
+		if err := params.ParsePlotFilter(*plotFilter); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	src, err := srcFlags.Open()
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	defer db.Close()
-
-	spaceEstimate, err := chia.EstimateNetworkSpaceFromDB(db, -1, 4608)
+	defer src.Close()
+
+	estimates, err := chia.EstimateNetworkSpaces(src, chia.SpaceEstimateConfig{
+		EndHeight:    uint32(*endHeight),
+		WindowBlocks: uint32(*window),
+		Confidence:   *confidence,
+		Params:       params,
+	})
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	fmt.Printf("%dB %dPiB\n", spaceEstimate, (&big.Int{}).Div(spaceEstimate, big.NewInt(1024*1024*1024*1024*1024)))
+	inUnit := func(val *big.Int) float64 {
+		res, _ := new(big.Float).Quo(new(big.Float).SetInt(val), big.NewFloat(float64(unitSize))).Float64()
+		return res
+	}
+	fmt.Printf("%-8s  %24s  %10s  %21s  %6s\n", "", "space, B", *unit, fmt.Sprintf("%g%% interval", *confidence*100), "blocks")
+	for _, est := range estimates {
+		fmt.Printf("%-8s  %24d  %10.2f  %10.2f..%-9.2f  %6d\n",
+			est.Estimator, est.Space, inUnit(est.Space), inUnit(est.Low), inUnit(est.High), est.Blocks)
+	}
 	return nil
 }
 
 func CMDSizeChart() error {
-	dbPath := flag.String("db-path", utils.HomeDirOrEmpty("/.chia/mainnet/db/")+"blockchain_v1_mainnet.sqlite", "path to blockchain_v1_mainnet.sqlite")
+	srcFlags := utils.AddBlockSourceFlags()
+	bucket := flag.String("bucket", "day", `point per "hour", "day", "week" or "<N>blocks"`)
+	window := flag.Uint("window", chia.DEFAULT_SPACE_WINDOW_BLOCKS, "number of blocks space is estimated over")
+	startHeight := flag.Uint("from", 0, "first block height")
+	endHeight := flag.Uint("to", math.MaxUint32, "last block height")
+	format := flag.String("format", "table", "output format: table, csv or json")
+	unit := flag.String("unit", "PiB", "space unit: B, GiB, TiB, PiB or EiB")
+	flag.Parse()
+
+	cfg := chia.NetSpaceSeriesConfig{
+		WindowBlocks: uint32(*window),
+		StartHeight:  uint32(*startHeight),
+		EndHeight:    uint32(*endHeight),
+	}
+	if err := cfg.ParseBucket(*bucket); err != nil {
+		return merry.Wrap(err)
+	}
+
+	src, err := srcFlags.Open()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+
+	points, err := chia.NetworkSpaceSeries(src, cfg)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(chia.PrintNetworkSpaceSeries(os.Stdout, points, *format, *unit))
+}
+
+func CMDDifficultyChart() error {
+	srcFlags := utils.AddBlockSourceFlags()
+	startHeight := flag.Uint("from", 0, "first block height")
+	endHeight := flag.Uint("to", math.MaxUint32, "last block height")
+	format := flag.String("format", "table", "output format: table, csv or json")
 	flag.Parse()
 
-	db, err := utils.OpenExistingSqlite3(*dbPath)
+	src, err := srcFlags.Open()
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	defer db.Close()
+	defer src.Close()
 
-	if err := chia.PrintNetworkSpaceChartFromDB(db); err != nil {
+	epochs, err := chia.DifficultySeries(src, chia.DifficultySeriesConfig{
+		StartHeight: uint32(*startHeight),
+		EndHeight:   uint32(*endHeight),
+	})
+	if err != nil {
 		return merry.Wrap(err)
 	}
-	return nil
+	if len(epochs) > 0 && epochs[0].SubSlotIters == 0 {
+		log.Print("WARN: block source has no block records (exported blocks, blocksync store or peer), sub-slot iters and sub-slot time are unknown")
+	}
+	return merry.Wrap(chia.PrintDifficultySeries(os.Stdout, epochs, *format))
+}
+
+func CMDRewardsReport() error {
+	srcFlags := utils.AddBlockSourceFlags()
+	addresses := flag.String("address", "", "comma-separated farmer/pool addresses to report (all by default)")
+	startHeight := flag.Uint("from", 0, "first block height")
+	endHeight := flag.Uint("to", math.MaxUint32, "last block height")
+	bucket := flag.String("bucket", "day", `pool shares per "hour", "day", "week" or "<N>blocks"`)
+	format := flag.String("format", "table", "output format: table or json")
+	prefix := flag.String("prefix", "xch", "address prefix")
+	top := flag.Int("top", 20, "number of addresses (and pools in shares) in table output")
+	flag.Parse()
+
+	cfg := chia.RewardsReportConfig{
+		StartHeight: uint32(*startHeight),
+		EndHeight:   uint32(*endHeight),
+	}
+	if err := cfg.ParseShareBucket(*bucket); err != nil {
+		return merry.Wrap(err)
+	}
+	if err := cfg.AddAddresses(*addresses); err != nil {
+		return merry.Wrap(err)
+	}
+
+	src, err := srcFlags.Open()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+
+	report, err := chia.MakeRewardsReport(src, cfg)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(chia.PrintRewardsReport(os.Stdout, report, *format, *prefix, *top))
+}
+
+func CMDPlotsReport() error {
+	srcFlags := utils.AddBlockSourceFlags()
+	bucket := flag.String("bucket", "day", `point per "hour", "day", "week" or "<N>blocks"`)
+	startHeight := flag.Uint("from", 0, "first block height")
+	endHeight := flag.Uint("to", math.MaxUint32, "last block height")
+	format := flag.String("format", "table", "output format: table, csv or json")
+	flag.Parse()
+
+	cfg := chia.PlotsReportConfig{
+		StartHeight: uint32(*startHeight),
+		EndHeight:   uint32(*endHeight),
+	}
+	if err := cfg.ParseBucket(*bucket); err != nil {
+		return merry.Wrap(err)
+	}
+
+	src, err := srcFlags.Open()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+
+	report, err := chia.MakePlotsReport(src, cfg)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(chia.PrintPlotsReport(os.Stdout, report, *format))
 }
 
 func CMDExportBlocks() error {
-	dbPath := flag.String("db-path", utils.HomeDirOrEmpty("/.chia/mainnet/db/")+"blockchain_v1_mainnet.sqlite", "path to blockchain_v1_mainnet.sqlite")
+	dbPath := flag.String("db-path", utils.DefaultBlockchainDBPath(), "path to blockchain_v2_mainnet.sqlite or blockchain_v1_mainnet.sqlite")
 	tableName := flag.String("table", "full_blocks", `table name, "full_blocks" or "block_records"`)
 	fname := flag.String("fname", "", "out file name (<table>.raw by default)")
+	withIndex := flag.Bool("index", false, "append height->offset index")
+	withZstd := flag.Bool("zstd", false, "compress blocks with zstd (each one separately)")
 	flag.Parse()
 
 	if *fname == "" {
 		*fname = *tableName + ".raw"
 	}
 
-	db, err := utils.OpenExistingSqlite3(*dbPath)
+	src, err := chia.OpenSqliteBlockSource(*dbPath)
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	defer db.Close()
+	defer src.Close()
 
 	f, err := os.Create(*fname)
 	if err != nil {
@@ -75,34 +224,168 @@ func CMDExportBlocks() error {
 	}
 	defer f.Close()
 
-	if err := chia.ExportBlocksData(db, *tableName, f); err != nil {
+	cfg := chia.RawFileConfig{Index: *withIndex, Zstd: *withZstd}
+	if err := chia.ExportBlocksData(src, *tableName, f, cfg); err != nil {
 		return merry.Wrap(err)
 	}
 
 	return merry.Wrap(f.Close())
 }
 
+func CMDCatBlocks() error {
+	fname := flag.String("fname", "full_blocks.raw", "file written by export-blocks")
+	tableName := flag.String("table", "full_blocks", `table the file was exported from, "full_blocks" or "block_records"`)
+	startHeight := flag.Uint("from", 0, "first block height")
+	endHeight := flag.Uint("to", math.MaxUint32, "last block height")
+	format := flag.String("format", "text", "output format: text or json (one object per line)")
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		return merry.Errorf("unexpected format: %s", *format)
+	}
+
+	src, err := chia.OpenRawFileBlockSource(*fname, *tableName)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+	peakHeight, err := src.PeakHeight()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	log.Printf("%s: %d blocks, indexed: %t, compressed: %t", *fname, peakHeight+1, src.IsIndexed(), src.IsCompressed())
+
+	out := json.NewEncoder(os.Stdout)
+	return src.ForEachRawBlock(uint32(*startHeight), uint32(*endHeight), func(height uint32, data []byte) error {
+		var block *types.FullBlock
+		var br *types.BlockRecord
+		if *tableName == "full_blocks" {
+			block = &types.FullBlock{}
+			if err := chiautils.FromByteSliceExact(data, block); err != nil {
+				return merry.Prependf(err, "block %d", height)
+			}
+			br = chia.BlockRecordFromFullBlock(block)
+		} else {
+			br = &types.BlockRecord{}
+			if err := chiautils.FromByteSliceExact(data, br); err != nil {
+				return merry.Prependf(err, "block record %d", height)
+			}
+		}
+
+		if *format == "json" {
+			if block != nil {
+				return merry.Wrap(out.Encode(chiautils.ToJSONValue(block)))
+			}
+			return merry.Wrap(out.Encode(chiautils.ToJSONValue(br)))
+		}
+		stamp := "-"
+		if br.Timestamp != 0 {
+			stamp = time.Unix(int64(br.Timestamp), 0).UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%d %s %s %7d B weight=%s farmer=%s\n",
+			br.Height, hex.EncodeToString(br.HeaderHash[:]), stamp, len(data), br.Weight,
+			chia.EncodePuzzleHash(br.FarmerPuzzleHash, "xch"))
+		return nil
+	})
+}
+
+func CMDImportBlocks() error {
+	fname := flag.String("fname", "full_blocks.raw", "full blocks file written by export-blocks")
+	dbPath := flag.String("db-path", "blocks_mainnet.sqlite", "path to blocks store (see sync-blocks), import is resumed from its peak")
+	batchSize := flag.Int("batch-size", 1000, "blocks per transaction")
+	flag.Parse()
+
+	src, err := chia.OpenRawFileBlockSource(*fname, "full_blocks")
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer src.Close()
+	fileHeight, err := src.PeakHeight()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+
+	store, err := blocksync.OpenStore(*dbPath)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer store.Close()
+	startHeight := uint32(0)
+	if peak, err := store.Peak(); err != nil {
+		return merry.Wrap(err)
+	} else if peak != nil {
+		startHeight = peak.Height + 1
+		log.Printf("IMPORT: resuming from height %d", startHeight)
+	}
+
+	batch := make([]types.FullBlock, 0, *batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := store.AddBlocks(batch); err != nil {
+			return merry.Wrap(err)
+		}
+		height := batch[len(batch)-1].RewardChainBlock.Height
+		if (height+1)%10000 < uint32(len(batch)) || height == fileHeight {
+			log.Printf("IMPORT: height %d / %d", height, fileHeight)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err = src.ForEachFullBlock(startHeight, math.MaxUint32, func(block *types.FullBlock) error {
+		batch = append(batch, *block)
+		if len(batch) < *batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return flush()
+}
+
 func CMDEvalBlock() error {
-	dbPath := flag.String("db-path", utils.HomeDirOrEmpty("/.chia/mainnet/db/")+"blockchain_v1_mainnet.sqlite", "path to blockchain_v1_mainnet.sqlite")
+	srcFlags := utils.AddBlockSourceFlags()
 	height := flag.Int("height", 225698, "block height (225698 is the first block with non-empty transaction generator, 225703 is the next one)")
 	flag.Parse()
 
-	db, err := utils.OpenExistingSqlite3(*dbPath)
+	src, err := srcFlags.Open()
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	defer db.Close()
+	defer src.Close()
 
-	return merry.Wrap(chia.EvalFullBlockFromDB(db, uint32(*height)))
+	return merry.Wrap(chia.EvalFullBlockFromDB(src, uint32(*height)))
+}
+
+func parseCapabilities(str string) ([]uint16, error) {
+	var res []uint16
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		capability, err := strconv.ParseUint(item, 10, 16)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		res = append(res, uint16(capability))
+	}
+	return res, nil
 }
 
 func CMDHandshake() error {
 	address := flag.String("addr", "", "host:port")
 	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory")
+	capsStr := flag.String("caps", strconv.Itoa(int(types.CAP_BASE)), "comma-separated capabilities to advertise")
 	flag.Parse()
 	if *address == "" {
 		return merry.Errorf("-addr is required")
 	}
+	caps, err := parseCapabilities(*capsStr)
+	if err != nil {
+		return merry.Wrap(err)
+	}
 
 	cfg, err := network.MakeTSLConfigFromFiles(
 		*sslDir+"/ca/chia_ca.crt",
@@ -111,7 +394,7 @@ func CMDHandshake() error {
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	c, err := network.ConnectTo(*address, cfg, nil)
+	c, err := network.ConnectTo(*address, cfg, &network.WSChiaConnConfig{Capabilities: caps})
 	if err != nil {
 		return merry.Wrap(err)
 	}
@@ -122,12 +405,18 @@ func CMDHandshake() error {
 
 	fmt.Printf("node ID: %s\n", c.PeerIDHex())
 	fmt.Printf("handshake response: %#v\n", hs)
+	for _, capability := range c.PeerCapabilities() {
+		name, _ := types.CapabilityName(capability)
+		fmt.Printf("peer capability: %d %s\n", capability, name)
+	}
+	fmt.Printf("negotiated capabilities: %v\n", c.NegotiatedCapabilities())
 	return nil
 }
 
 func CMDRequestPeers() error {
 	address := flag.String("addr", "", "host:port")
 	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory")
+	capturePath := flag.String("capture", "", "record all messages to this file")
 	flag.Parse()
 	if *address == "" {
 		return merry.Errorf("-addr is required")
@@ -140,10 +429,20 @@ func CMDRequestPeers() error {
 	if err != nil {
 		return merry.Wrap(err)
 	}
-	c, err := network.ConnectTo(*address, cfg, nil)
+	connCfg := &network.WSChiaConnConfig{}
+	if *capturePath != "" {
+		capture, err := network.CreateCaptureFile(*capturePath)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		defer capture.Close()
+		connCfg.Recorder = capture
+	}
+	c, err := network.ConnectTo(*address, cfg, connCfg)
 	if err != nil {
 		return merry.Wrap(err)
 	}
+	defer c.Close()
 	_, err = c.PerformHandshake()
 	if err != nil {
 		return merry.Wrap(err)
@@ -167,11 +466,15 @@ func CMDRequestPeers() error {
 
 func CMDListenIncoming() error {
 	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory")
+	host := flag.String("host", "0.0.0.0", "address to listen on")
+	port := flag.Int("port", network.SERVER_PORT, "port to listen on")
+	maxConns := flag.Int("max-conns", 0, "maximum number of simultaneous connections (0 is unlimited)")
+	verifyPeers := flag.Bool("verify-peers", false, "accept only peers with certificates signed by chia_ca")
+	capturePath := flag.String("capture", "", "record all messages to this file")
 	flag.Parse()
 
 	connHandler := func(c *network.WSChiaConnection) {
 		fmt.Println("new connection from:", c.PeerIDHex())
-		// defer c.Close()
 
 		c.SetMessageHandler(func(msgID uint16, msg chiautils.FromBytes) {
 			switch msg := msg.(type) {
@@ -199,29 +502,299 @@ func CMDListenIncoming() error {
 
 		c.StartRoutines()
 
-		peers, err := c.RequestPeers()
+		// connection is closed by server when handler returns
+		for {
+			peers, err := c.RequestPeers()
+			if err != nil {
+				log.Printf("peers error: %s", err)
+				return
+			}
+			fmt.Println("total peers:", len(peers.PeerList))
+			select {
+			case <-c.Done():
+				log.Printf("connection closed: %s", c.Err())
+				return
+			case <-time.After(5 * time.Minute):
+			}
+		}
+	}
+
+	var err error
+	cfg := network.ServerConfig{Host: *host, Port: uint16(*port), MaxConns: *maxConns}
+	if *capturePath != "" {
+		capture, err := network.CreateCaptureFile(*capturePath)
 		if err != nil {
-			log.Printf("peers error: %s", err)
-			return
+			return merry.Wrap(err)
 		}
-		fmt.Println("total peers:", len(peers.PeerList))
+		defer capture.Close()
+		cfg.ConnConfig = &network.WSChiaConnConfig{Recorder: capture}
 	}
+	if *verifyPeers {
+		cfg.ClientCAs, err = network.LoadCACertPool(*sslDir + "/ca/chia_ca.crt")
+		if err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	server, err := network.NewServerFromFiles(cfg, *sslDir+"/ca/chia_ca.crt", *sslDir+"/ca/chia_ca.key", connHandler)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(server.ListenAndServe())
+}
 
-	err := network.ListenOn("0.0.0.0", *sslDir+"/ca/chia_ca.crt", *sslDir+"/ca/chia_ca.key", nil, connHandler)
-	return merry.Wrap(err)
+func CMDDecodeCapture() error {
+	inPath := flag.String("in", "", "capture file path")
+	format := flag.String("format", "text", "output format: text or json (one object per line)")
+	flag.Parse()
+	if *inPath == "" {
+		return merry.Errorf("-in is required")
+	}
+	if *format != "text" && *format != "json" {
+		return merry.Errorf("unexpected format: %s", *format)
+	}
+
+	f, err := os.Open(*inPath)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer f.Close()
+	r, err := network.NewCaptureReader(f)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	out := json.NewEncoder(os.Stdout)
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return merry.Wrap(err)
+		}
+
+		var data interface{}
+		typeName := "Unknown"
+		if dataStruct, ok := types.MessageTypeStruct(rec.Message.Type); ok {
+			typeName = strings.TrimPrefix(fmt.Sprintf("%T", dataStruct), "*types.")
+			if err := chiautils.FromByteSliceExact(rec.Message.Data, dataStruct); err != nil {
+				log.Printf("WARN: %s: %s", typeName, err)
+			} else {
+				data = dataStruct
+			}
+		}
+		peerID := hex.EncodeToString(rec.PeerID[:])
+
+		if *format == "json" {
+			err := out.Encode(chiautils.JSONObject{
+				Keys: []string{"time", "direction", "peer_id", "type", "type_name", "id", "data"},
+				Values: []interface{}{rec.Time.Format(time.RFC3339Nano), rec.Direction.String(), peerID,
+					rec.Message.Type, typeName, rec.Message.ID, chiautils.ToJSONValue(data)},
+			})
+			if err != nil {
+				return merry.Wrap(err)
+			}
+		} else {
+			fmt.Printf("%s %-3s %s #%d %s(%d) %+v\n",
+				rec.Time.Format("2006-01-02 15:04:05.000000"), rec.Direction, peerID[:8],
+				rec.Message.ID, typeName, rec.Message.Type, data)
+		}
+	}
+}
+
+func CMDGenCerts() error {
+	outDir := flag.String("out-dir", "ssl", "where to create ssl directory (with ca/ and full_node/ subdirectories)")
+	privateCA := flag.Bool("private-ca", false, "sign public certificates with new private CA instead of chia_ca (mainnet nodes will reject them)")
+	chiaCACrt := flag.String("chia-ca-crt", "", "path to chia_ca.crt, embedded well-known chia_ca is used if empty")
+	chiaCAKey := flag.String("chia-ca-key", "", "path to chia_ca.key")
+	nodes := flag.String("nodes", "full_node", "comma-separated node names to generate certificates for")
+	overwrite := flag.Bool("overwrite", false, "overwrite existing certificates")
+	flag.Parse()
+
+	cfg := network.SSLDirConfig{Nodes: utils.SplitList(*nodes), PrivateChiaCA: *privateCA, Overwrite: *overwrite}
+	if *chiaCACrt != "" || *chiaCAKey != "" {
+		if *chiaCACrt == "" || *chiaCAKey == "" {
+			return merry.Errorf("both -chia-ca-crt and -chia-ca-key are required")
+		}
+		if *privateCA {
+			return merry.Errorf("-private-ca can not be used with -chia-ca-crt and -chia-ca-key")
+		}
+		ca, err := network.ReadCertAndKey(*chiaCACrt, *chiaCAKey)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if _, _, err := network.ParseCA(ca); err != nil {
+			return merry.Wrap(err)
+		}
+		cfg.ChiaCA = ca
+	}
+
+	usedChiaCA, err := network.GenerateSSLDir(*outDir, cfg)
+	if merry.Is(err, network.ErrNoEmbeddedChiaCA) {
+		return merry.Append(err, "or use -chia-ca-crt and -chia-ca-key, or -private-ca")
+	}
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if !usedChiaCA {
+		log.Print("WARN: public certificates are signed by new private CA, mainnet nodes will reject them")
+	}
+	log.Printf("certificates saved to %s, use it as -ssl-dir", *outDir)
+	return nil
+}
+
+func CMDSyncBlocks() error {
+	dbPath := flag.String("db-path", "blocks_mainnet.sqlite", "path to blocks store (created if not exists, sync is resumed otherwise)")
+	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory (see gen-certs)")
+	peersStr := flag.String("peers", "", "comma-separated host:port list of initial peers, resolved via DNS seeders if empty")
+	dnsSeedsStr := flag.String("dns-seeds", strings.Join(network.DEFAULT_DNS_SEEDS, ","), "comma-separated DNS seeders list")
+	targetOutbound := flag.Int("target-outbound", network.DEFAULT_TARGET_OUTBOUND, "number of peers to keep connected")
+	batchSize := flag.Int("batch-size", blocksync.DEFAULT_BATCH_SIZE, "blocks per request")
+	parallel := flag.Int("parallel", blocksync.DEFAULT_PARALLEL_BATCHES, "simultaneous requests")
+	flag.Parse()
+
+	store, err := blocksync.OpenStore(*dbPath)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer store.Close()
+	if peak, err := store.Peak(); err != nil {
+		return merry.Wrap(err)
+	} else if peak != nil {
+		log.Printf("SYNC: resuming from height %d", peak.Height)
+	}
+
+	peers, err := utils.StartPeerManager(*sslDir, *peersStr, *dnsSeedsStr, *targetOutbound)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer peers.Stop()
+
+	syncer := blocksync.NewSyncer(store, peers, blocksync.SyncerConfig{
+		BatchSize:       uint32(*batchSize),
+		ParallelBatches: *parallel,
+	})
+	return merry.Wrap(syncer.Run())
+}
+
+func CMDCheckWeightProofs() error {
+	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory (see gen-certs)")
+	peersStr := flag.String("peers", "", "comma-separated host:port list of peers to check, resolved via DNS seeders if empty")
+	dnsSeedsStr := flag.String("dns-seeds", strings.Join(network.DEFAULT_DNS_SEEDS, ","), "comma-separated DNS seeders list")
+	count := flag.Int("count", 4, "number of peers to check")
+	timeout := flag.Duration("timeout", weightproof.DEFAULT_REQUEST_TIMEOUT, "weight proof request timeout")
+	lightBlocks := flag.Int("light-blocks", 100, "peer's chain is marked as light if it is behind the heaviest one by more than this number of blocks")
+	flag.Parse()
+
+	peers, err := utils.StartPeerManager(*sslDir, *peersStr, *dnsSeedsStr, *count)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer peers.Stop()
+	if err := peers.WaitForPeers(*count, time.Minute); err != nil {
+		if len(peers.Peers()) == 0 {
+			return merry.Wrap(err)
+		}
+		log.Printf("WARN: %s, checking available ones", err)
+	}
+	time.Sleep(5 * time.Second) //waiting for NewPeak from everyone
+
+	type checkResult struct {
+		peer *network.Peer
+		peak *types.NewPeak
+		res  *weightproof.Result
+		err  error
+	}
+	var results []*checkResult
+	wg := sync.WaitGroup{}
+	for _, peer := range peers.Peers() {
+		peak := peer.Peak()
+		if peak == nil {
+			log.Printf("WARN: %s: no peak received, skipping", peer.Address)
+			continue
+		}
+		item := &checkResult{peer: peer, peak: peak}
+		results = append(results, item)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stt := time.Now()
+			wp, err := weightproof.Request(item.peer.Conn, item.peak, *timeout)
+			if err == nil {
+				log.Printf("%s: weight proof received in %s", item.peer.Address, time.Since(stt).Round(time.Millisecond))
+				item.res, item.err = weightproof.Verify(&chia.MAINNET_CONSTANTS, wp, item.peak)
+			} else {
+				item.err = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	var maxWeight *big.Int
+	difficulty := chia.MAINNET_CONSTANTS.DifficultyStarting
+	for _, item := range results {
+		if item.err == nil && (maxWeight == nil || item.peak.Weight.Cmp(maxWeight) > 0) {
+			maxWeight = item.peak.Weight
+			for _, ses := range item.res.Summaries {
+				if ses.NewDifficulty != 0 {
+					difficulty = ses.NewDifficulty
+				}
+			}
+		}
+	}
+	lightWeight := new(big.Int).SetUint64(difficulty * uint64(*lightBlocks))
+
+	for _, item := range results {
+		if item.err != nil {
+			status := "ERROR"
+			if merry.Is(item.err, weightproof.ErrInvalid) {
+				status = "INVALID"
+			}
+			fmt.Printf("%-40s %-7s %d %s\n", item.peer.Address, status, item.peak.Height, item.err)
+			continue
+		}
+		status := "OK"
+		if new(big.Int).Sub(maxWeight, item.peak.Weight).Cmp(lightWeight) > 0 {
+			status = "LIGHT"
+		}
+		space := "-"
+		if item.res.SpaceEstimate != nil {
+			space = fmt.Sprintf("%dPiB", (&big.Int{}).Div(item.res.SpaceEstimate, big.NewInt(1024*1024*1024*1024*1024)))
+		}
+		fmt.Printf("%-40s %-7s %d weight=%s sub-epochs=%d sampled=%v space=%s\n",
+			item.peer.Address, status, item.peak.Height, item.peak.Weight,
+			len(item.res.Summaries), item.res.SampledSubEpochs, space)
+	}
+	fmt.Println("NOTE: VDFs, proofs of space and signatures are not verified")
+	return nil
 }
 
 var commands = map[string]func() error{
-	"update-nodes":    nodes.CMDUpdateNodes,
-	"import-nodes":    nodes.CMDImportNodes,
-	"save-stats":      nodes.CMDSaveStats,
-	"estimate-size":   CMDEstimateSize,
-	"size-chart":      CMDSizeChart,
-	"export-blocks":   CMDExportBlocks,
-	"eval-block":      CMDEvalBlock,
-	"handshake":       CMDHandshake,
-	"request-peers":   CMDRequestPeers,
-	"listen-incoming": CMDListenIncoming,
+	"update-nodes":        nodes.CMDUpdateNodes,
+	"import-nodes":        nodes.CMDImportNodes,
+	"bootstrap-nodes":     nodes.CMDBootstrapNodes,
+	"introducer":          nodes.CMDIntroducer,
+	"save-stats":          nodes.CMDSaveStats,
+	"propagation-stats":   nodes.CMDPropagationStats,
+	"estimate-size":       CMDEstimateSize,
+	"size-chart":          CMDSizeChart,
+	"difficulty-chart":    CMDDifficultyChart,
+	"rewards-report":      CMDRewardsReport,
+	"plots-report":        CMDPlotsReport,
+	"export-blocks":       CMDExportBlocks,
+	"cat-blocks":          CMDCatBlocks,
+	"import-blocks":       CMDImportBlocks,
+	"eval-block":          CMDEvalBlock,
+	"handshake":           CMDHandshake,
+	"request-peers":       CMDRequestPeers,
+	"listen-incoming":     CMDListenIncoming,
+	"decode-capture":      CMDDecodeCapture,
+	"gen-certs":           CMDGenCerts,
+	"sync-blocks":         CMDSyncBlocks,
+	"check-weight-proofs": CMDCheckWeightProofs,
+	"watch-mempool":       mempoolwatch.CMDWatchMempool,
+	"save-chain-stats":    chainstats.CMDSaveChainStats,
+	"explorer":            explorer.CMDExplorer,
 }
 
 func printUsage() {
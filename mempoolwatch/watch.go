@@ -0,0 +1,153 @@
+package mempoolwatch
+
+import (
+	"chiastat/chia/mempool"
+	"chiastat/chia/network"
+	chiautils "chiastat/chia/utils"
+	"chiastat/utils"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/go-pg/pg/v10"
+)
+
+func errStr(err error) *string {
+	if err == nil {
+		return nil
+	}
+	s := err.Error()
+	return &s
+}
+
+func startTxSaver(db *pg.DB, txChan chan *mempool.Transaction, chunkSize int) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+	txChanI := make(chan interface{}, 16)
+	count := 0
+	decodedCount := 0
+
+	go func() {
+		for tx := range txChan {
+			txChanI <- tx
+		}
+		close(txChanI)
+	}()
+
+	go func() {
+		defer worker.Done()
+
+		var savesDurSum, savesDurCount int64
+		logPrint := utils.NewSyncInterval(10*time.Second, func() {
+			log.Printf("SAVE:MEMPOOL: count: +%d, decoded: +%d (%d chunks, avg %d ms)",
+				count, decodedCount, savesDurCount, savesDurSum/savesDurCount)
+			savesDurSum = 0
+			savesDurCount = 0
+			count = 0
+			decodedCount = 0
+		})
+
+		err := utils.SaveChunked(db, chunkSize, txChanI, func(tx *pg.Tx, items []interface{}) error {
+			for _, txI := range items {
+				mtx := txI.(*mempool.Transaction)
+				first := mtx.Announces[0]
+
+				var size, coinSpends, additions *int
+				var cost, fees, reservedFee *uint64
+				var feePerCost *float64
+				if mtx.Bundle != nil {
+					s, cs := len(chiautils.ToByteSlice(mtx.Bundle)), len(mtx.Bundle.CoinSolutions)
+					size, coinSpends = &s, &cs
+				}
+				if info := mtx.Info; info != nil {
+					a, c, fpc := len(info.Additions), info.Cost(), info.FeePerCost()
+					additions, cost, fees, reservedFee, feePerCost = &a, &c, &info.Fees, &info.ReservedFee, &fpc
+				}
+
+				_, err := tx.Exec(`
+					INSERT INTO mempool_txs (
+						id, first_seen_at, announced_cost, announced_fees, peers_count,
+						size, coin_spends, additions, cost, fees, reserved_fee, fee_per_cost,
+						fetch_error, decode_error
+					) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+					ON CONFLICT (id) DO NOTHING`,
+					mtx.ID[:], first.At, first.Cost, first.Fees, len(mtx.Delays()),
+					size, coinSpends, additions, cost, fees, reservedFee, feePerCost,
+					errStr(mtx.FetchErr), errStr(mtx.DecodeErr),
+				)
+				if err != nil {
+					return merry.Wrap(err)
+				}
+				for peer, delay := range mtx.Delays() {
+					_, err := tx.Exec(`
+						INSERT INTO mempool_tx_announces (tx_id, peer, delay_ms) VALUES (?, ?, ?)
+						ON CONFLICT (tx_id, peer) DO NOTHING`,
+						mtx.ID[:], peer, delay.Milliseconds(),
+					)
+					if err != nil {
+						return merry.Wrap(err)
+					}
+				}
+				count += 1
+				if mtx.Info != nil {
+					decodedCount += 1
+				}
+			}
+			return nil
+		}, func(saveDur time.Duration) {
+			savesDurSum += int64(saveDur / time.Millisecond)
+			savesDurCount += 1
+			logPrint.Trigger()
+		})
+		log.Println("SAVE:MEMPOOL: done")
+		if err != nil {
+			worker.AddError(err)
+		}
+	}()
+	return worker
+}
+
+func startObserver(peers *network.PeerManager, cfg mempool.ObserverConfig, txChan chan *mempool.Transaction) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+	go func() {
+		defer worker.Done()
+		mempool.NewObserver(peers, cfg).Run(txChan)
+	}()
+	return worker
+}
+
+func CMDWatchMempool() error {
+	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory (see gen-certs)")
+	peersStr := flag.String("peers", "", "comma-separated host:port list of initial peers, resolved via DNS seeders if empty")
+	dnsSeedsStr := flag.String("dns-seeds", strings.Join(network.DEFAULT_DNS_SEEDS, ","), "comma-separated DNS seeders list")
+	targetOutbound := flag.Int("target-outbound", 32, "number of peers to keep connected (more peers - better propagation timings)")
+	trackDuration := flag.Duration("track-duration", mempool.DEFAULT_TRACK_DURATION, "how long announces of every transaction are collected")
+	flag.Parse()
+
+	db := utils.MakePGConnection()
+
+	peers, err := utils.StartPeerManager(*sslDir, *peersStr, *dnsSeedsStr, *targetOutbound)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer peers.Stop()
+
+	txChan := make(chan *mempool.Transaction, 256)
+	workers := []utils.Worker{
+		startObserver(peers, mempool.ObserverConfig{TrackDuration: *trackDuration}, txChan),
+		startTxSaver(db, txChan, 64),
+	}
+	logPrint := utils.NewSyncInterval(10*time.Second, func() {
+		log.Printf("MEMPOOL: peers: %d, chans: (%d)", len(peers.Peers()), len(txChan))
+	})
+	for {
+		for _, worker := range workers {
+			if err := worker.PopError(); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		logPrint.Trigger()
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "github.com/go-pg/migrations/v8"
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		return execSome(db, `
+			ALTER TABLE nodes ADD COLUMN capabilities smallint[];
+			ALTER TABLE node_stats ADD COLUMN capabilities jsonb;
+			`)
+	}, func(db migrations.DB) error {
+		return execSome(db, `
+			ALTER TABLE nodes DROP COLUMN capabilities;
+			ALTER TABLE node_stats DROP COLUMN capabilities;
+			`)
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import "github.com/go-pg/migrations/v8"
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		return execSome(db, `
+			CREATE TABLE chiastat.mempool_txs (
+				id bytea PRIMARY KEY,
+				first_seen_at timestamptz NOT NULL,
+				announced_cost bigint NOT NULL,
+				announced_fees bigint NOT NULL,
+				peers_count int NOT NULL,
+				size int,
+				coin_spends int,
+				additions int,
+				cost bigint,
+				fees bigint,
+				reserved_fee bigint,
+				fee_per_cost double precision,
+				fetch_error text,
+				decode_error text,
+				CHECK (length(id) = 32)
+			);
+			CREATE INDEX mempool_txs__first_seen_at ON chiastat.mempool_txs (first_seen_at);
+
+			CREATE TABLE chiastat.mempool_tx_announces (
+				tx_id bytea NOT NULL REFERENCES chiastat.mempool_txs (id) ON DELETE CASCADE,
+				peer text NOT NULL,
+				delay_ms int NOT NULL,
+				PRIMARY KEY (tx_id, peer)
+			);
+			CREATE INDEX mempool_tx_announces__peer ON chiastat.mempool_tx_announces (peer);
+			`)
+	}, func(db migrations.DB) error {
+		return execSome(db, `
+			DROP TABLE chiastat.mempool_tx_announces;
+			DROP TABLE chiastat.mempool_txs;
+			`)
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import "github.com/go-pg/migrations/v8"
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		return execSome(db, `
+			CREATE TABLE chiastat.propagation_events (
+				id bigserial PRIMARY KEY,
+				kind text NOT NULL,
+				key bytea NOT NULL,
+				height int,
+				sp_index smallint,
+				first_seen_at timestamptz NOT NULL,
+				peers_count int NOT NULL,
+				delay_p50_ms int NOT NULL,
+				delay_p90_ms int NOT NULL,
+				delay_p99_ms int NOT NULL,
+				delay_max_ms int NOT NULL,
+				first_peers bytea[] NOT NULL,
+				CHECK (length(key) = 32)
+			);
+			CREATE INDEX propagation_events__kind_first_seen_at ON chiastat.propagation_events (kind, first_seen_at);
+			CREATE INDEX propagation_events__height ON chiastat.propagation_events (height) WHERE height IS NOT NULL;
+
+			CREATE TABLE chiastat.propagation_announces (
+				event_id bigint NOT NULL REFERENCES chiastat.propagation_events (id) ON DELETE CASCADE,
+				node_id bytea NOT NULL,
+				delay_ms int NOT NULL,
+				PRIMARY KEY (event_id, node_id)
+			);
+			CREATE INDEX propagation_announces__node_id ON chiastat.propagation_announces (node_id);
+			`)
+	}, func(db migrations.DB) error {
+		return execSome(db, `
+			DROP TABLE chiastat.propagation_announces;
+			DROP TABLE chiastat.propagation_events;
+			`)
+	})
+}
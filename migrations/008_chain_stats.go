@@ -0,0 +1,30 @@
+package main
+
+import "github.com/go-pg/migrations/v8"
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		return execSome(db, `
+			CREATE TABLE chiastat.chain_stats (
+				interval_seconds int NOT NULL,
+				interval_start timestamptz NOT NULL,
+				start_height int NOT NULL,
+				end_height int NOT NULL,
+				blocks_count int NOT NULL,
+				tx_blocks_count int NOT NULL,
+				netspace numeric NOT NULL,
+				difficulty bigint NOT NULL,
+				total_fees numeric NOT NULL,
+				total_cost numeric NOT NULL,
+				mean_block_time real,
+				created_at timestamptz NOT NULL DEFAULT now(),
+				PRIMARY KEY (interval_seconds, interval_start)
+			);
+			CREATE INDEX chain_stats__interval_seconds_end_height ON chiastat.chain_stats (interval_seconds, end_height);
+			`)
+	}, func(db migrations.DB) error {
+		return execSome(db, `
+			DROP TABLE chiastat.chain_stats;
+			`)
+	})
+}
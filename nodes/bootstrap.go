@@ -0,0 +1,96 @@
+package nodes
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	"chiastat/utils"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+func CMDBootstrapNodes() error {
+	introducersStr := flag.String("introducers", strings.Join(network.DEFAULT_INTRODUCERS, ","), "comma-separated introducers host:port list")
+	dnsSeedsStr := flag.String("dns-seeds", strings.Join(network.DEFAULT_DNS_SEEDS, ","), "comma-separated DNS seeders list")
+	dnsPort := flag.Int("dns-port", network.SERVER_PORT, "port for nodes resolved via DNS seeders")
+	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory (required only for introducers)")
+	timeout := flag.Duration("timeout", 15*time.Second, "timeout for every introducer and DNS seeder")
+	flag.Parse()
+
+	var peers []types.TimestampedPeerInfo
+
+	for _, seed := range utils.SplitList(*dnsSeedsStr) {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		seedPeers, err := network.ResolveDNSSeed(ctx, seed, uint16(*dnsPort))
+		cancel()
+		if err != nil {
+			log.Printf("BOOTSTRAP: %s: %s", seed, err)
+			continue
+		}
+		log.Printf("BOOTSTRAP: %s: %d node(s)", seed, len(seedPeers))
+		peers = append(peers, seedPeers...)
+	}
+
+	introducers := utils.SplitList(*introducersStr)
+	if len(introducers) > 0 {
+		// introducers require client certificate (like any other chia node)
+		certPath := *sslDir + "/full_node/public_full_node.crt"
+		if _, err := os.Stat(certPath); os.IsNotExist(err) {
+			log.Printf("BOOTSTRAP: %s not found, skipping introducers", certPath)
+			introducers = nil
+		}
+	}
+	if len(introducers) > 0 {
+		tlsCfg, err := network.MakeTSLConfigFromFiles(
+			*sslDir+"/ca/chia_ca.crt",
+			*sslDir+"/full_node/public_full_node.crt",
+			*sslDir+"/full_node/public_full_node.key")
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		for _, address := range introducers {
+			introPeers, err := network.RequestPeersFromIntroducer(address, tlsCfg, *timeout)
+			if err != nil {
+				log.Printf("BOOTSTRAP: %s: %s", address, err)
+				continue
+			}
+			log.Printf("BOOTSTRAP: %s: %d node(s)", address, len(introPeers))
+			peers = append(peers, introPeers...)
+		}
+	}
+
+	if len(peers) == 0 {
+		return merry.New("no nodes received")
+	}
+
+	db := utils.MakePGConnection()
+	tx, err := db.Begin()
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, peer := range peers {
+		res, err := tx.Exec(`
+			INSERT INTO raw_nodes (host, port, created_at) VALUES (?, ?, ?)
+			ON CONFLICT (host, port) DO UPDATE SET
+				created_at = least(raw_nodes.created_at, EXCLUDED.created_at)`,
+			peer.Host, peer.Port, time.Unix(int64(peer.Timestamp), 0))
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		count += res.RowsAffected()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return merry.Wrap(err)
+	}
+	log.Printf("done, %d node(s)", count)
+	return nil
+}
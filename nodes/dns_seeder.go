@@ -0,0 +1,195 @@
+package nodes
+
+import (
+	"chiastat/chia/network"
+	"chiastat/utils"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansel1/merry"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Max DNS over UDP message size without EDNS.
+const dnsMaxUDPSize = 512
+
+// dnsSeeder is a minimal authoritative DNS server for a single name:
+// it answers A/AAAA queries with addresses of random reachable nodes (with default port only).
+type dnsSeeder struct {
+	domain  string
+	pool    *seedNodesPool
+	answers int
+	ttl     uint32
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, ".")) + "."
+}
+
+func (s dnsSeeder) pickIPs(ipv6 bool) []net.IP {
+	nodes := s.pool.Pick(s.answers, func(node *seedNode) bool {
+		return node.Port == network.SERVER_PORT && (node.ip.To4() == nil) == ipv6
+	})
+	ips := make([]net.IP, len(nodes))
+	for i, node := range nodes {
+		ips[i] = node.ip
+	}
+	return ips
+}
+
+// handleQuery returns response for request message, nil if request should be ignored.
+func (s dnsSeeder) handleQuery(reqBuf []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	reqHeader, err := parser.Start(reqBuf)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if reqHeader.Response {
+		return nil, nil
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	header := dnsmessage.Header{
+		ID:                 reqHeader.ID,
+		Response:           true,
+		OpCode:             reqHeader.OpCode,
+		Authoritative:      true,
+		RecursionDesired:   reqHeader.RecursionDesired,
+		RecursionAvailable: false,
+		RCode:              dnsmessage.RCodeSuccess,
+	}
+	var question *dnsmessage.Question
+	var ips []net.IP
+	if reqHeader.OpCode != 0 {
+		header.RCode = dnsmessage.RCodeNotImplemented
+	} else if len(questions) != 1 {
+		header.RCode = dnsmessage.RCodeFormatError
+	} else {
+		question = &questions[0]
+		name := strings.ToLower(question.Name.String())
+		if question.Class != dnsmessage.ClassINET {
+			header.RCode = dnsmessage.RCodeRefused
+		} else if name == s.domain {
+			switch question.Type {
+			case dnsmessage.TypeA:
+				ips = s.pickIPs(false)
+			case dnsmessage.TypeAAAA:
+				ips = s.pickIPs(true)
+			}
+		} else if strings.HasSuffix(name, "."+s.domain) {
+			header.RCode = dnsmessage.RCodeNameError
+		} else {
+			header.Authoritative = false
+			header.RCode = dnsmessage.RCodeRefused
+		}
+	}
+
+	// answers are random nodes anyway, so extra ones are dropped instead of setting TC bit
+	// (there is no TCP server for clients to retry with)
+	for {
+		buf, err := s.buildResponse(header, question, ips)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if len(buf) <= dnsMaxUDPSize {
+			return buf, nil
+		}
+		if len(ips) == 0 {
+			return nil, merry.Errorf("DNS response is too large: %d bytes", len(buf))
+		}
+		ips = ips[:len(ips)-1]
+	}
+}
+
+func (s dnsSeeder) buildResponse(header dnsmessage.Header, question *dnsmessage.Question, ips []net.IP) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(make([]byte, 0, dnsMaxUDPSize), header)
+	builder.EnableCompression()
+	if question != nil {
+		if err := builder.StartQuestions(); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		if err := builder.Question(*question); err != nil {
+			return nil, merry.Wrap(err)
+		}
+	}
+	if len(ips) > 0 {
+		if err := builder.StartAnswers(); err != nil {
+			return nil, merry.Wrap(err)
+		}
+		rh := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: s.ttl}
+		for _, ip := range ips {
+			var err error
+			if ip4 := ip.To4(); ip4 != nil {
+				var res dnsmessage.AResource
+				copy(res.A[:], ip4)
+				err = builder.AResource(rh, res)
+			} else {
+				var res dnsmessage.AAAAResource
+				copy(res.AAAA[:], ip)
+				err = builder.AAAAResource(rh, res)
+			}
+			if err != nil {
+				return nil, merry.Wrap(err)
+			}
+		}
+	}
+	buf, err := builder.Finish()
+	return buf, merry.Wrap(err)
+}
+
+func startDNSSeeder(addr, domain string, pool *seedNodesPool, answers int, ttl time.Duration) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+	seeder := dnsSeeder{
+		domain:  normalizeDomain(domain),
+		pool:    pool,
+		answers: answers,
+		ttl:     uint32(ttl.Seconds()),
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		worker.AddError(err)
+		worker.Done()
+		return worker
+	}
+
+	var requestsCount, errorsCount int64
+	logPrint := utils.NewSyncInterval(time.Minute, func() {
+		log.Printf("DNS: requests: +%d, errors: +%d",
+			atomic.SwapInt64(&requestsCount, 0), atomic.SwapInt64(&errorsCount, 0))
+	})
+
+	go func() {
+		defer worker.Done()
+		defer conn.Close()
+		log.Printf("DNS: serving %s on %s", seeder.domain, conn.LocalAddr())
+		buf := make([]byte, dnsMaxUDPSize)
+		for {
+			n, remoteAddr, err := conn.ReadFrom(buf)
+			if err != nil {
+				worker.AddError(err)
+				return
+			}
+			atomic.AddInt64(&requestsCount, 1)
+			resp, err := seeder.handleQuery(buf[:n])
+			if err != nil {
+				atomic.AddInt64(&errorsCount, 1)
+				continue
+			}
+			if resp != nil {
+				if _, err := conn.WriteTo(resp, remoteAddr); err != nil {
+					atomic.AddInt64(&errorsCount, 1)
+				}
+			}
+			logPrint.Trigger()
+		}
+	}()
+
+	return worker
+}
@@ -0,0 +1,271 @@
+package nodes
+
+import (
+	"chiastat/chia/network"
+	"chiastat/chia/types"
+	chiautils "chiastat/chia/utils"
+	"chiastat/utils"
+	"flag"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/go-pg/pg/v10"
+)
+
+type seedNode struct {
+	Host      string
+	Port      uint16
+	Country   string
+	UpdatedAt time.Time
+	ip        net.IP
+}
+
+// subnet returns /16 for IPv4 and /32 for IPv6 (roughly "same provider").
+func (n seedNode) subnet() string {
+	if ip4 := n.ip.To4(); ip4 != nil {
+		return string(ip4[:2])
+	}
+	if len(n.ip) == net.IPv6len {
+		return string(n.ip[:4])
+	}
+	return n.Host
+}
+
+func loadSeedNodes(db *pg.DB, maxAge time.Duration) ([]seedNode, error) {
+	var nodes []seedNode
+	_, err := db.Query(&nodes, `
+		SELECT host, port, coalesce(country, '') AS country, updated_at
+		FROM nodes
+		WHERE NOT seems_off
+		  AND node_type = ?
+		  AND updated_at > NOW() - ? * INTERVAL '1 second'`,
+		"FULL_NODE", maxAge.Seconds())
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	res := nodes[:0]
+	for _, node := range nodes {
+		if node.ip = net.ParseIP(node.Host); node.ip != nil {
+			res = append(res, node)
+		}
+	}
+	return res, nil
+}
+
+// Only this many random nodes are considered (weighted) for every pick.
+const seedPickCandidates = 1024
+
+// pickSeedNodes returns up to count random nodes that match filter.
+// More recently updated nodes are more likely to be picked,
+// nodes from already picked countries and subnets are less likely to be picked.
+func pickSeedNodes(nodes []seedNode, count int, now time.Time, rnd *rand.Rand, filter func(*seedNode) bool) []seedNode {
+	candidates := make([]*seedNode, 0, seedPickCandidates)
+	for _, i := range rnd.Perm(len(nodes)) {
+		if filter == nil || filter(&nodes[i]) {
+			candidates = append(candidates, &nodes[i])
+			if len(candidates) == seedPickCandidates {
+				break
+			}
+		}
+	}
+
+	weights := make([]float64, len(candidates))
+	for i, node := range candidates {
+		ageHours := now.Sub(node.UpdatedAt).Hours()
+		if ageHours < 0 {
+			ageHours = 0
+		}
+		weights[i] = 1 / (1 + ageHours)
+	}
+
+	res := make([]seedNode, 0, count)
+	for len(res) < count && len(res) < len(candidates) {
+		total := 0.
+		for _, w := range weights {
+			total += w
+		}
+		if total <= 0 {
+			break
+		}
+		x := rnd.Float64() * total
+		picked := len(weights) - 1
+		for i, w := range weights {
+			if x < w {
+				picked = i
+				break
+			}
+			x -= w
+		}
+		node := candidates[picked]
+		res = append(res, *node)
+		weights[picked] = 0
+
+		for i, other := range candidates {
+			if weights[i] == 0 {
+				continue
+			}
+			if other.Country != "" && other.Country == node.Country {
+				weights[i] *= 0.5
+			}
+			if other.subnet() == node.subnet() {
+				weights[i] *= 0.1
+			}
+		}
+	}
+	return res
+}
+
+// seedNodesPool keeps recently updated healthy full nodes in memory (reloading them periodically).
+type seedNodesPool struct {
+	nodes []seedNode
+	rnd   *rand.Rand
+	mutex sync.Mutex
+}
+
+func newSeedNodesPool() *seedNodesPool {
+	return &seedNodesPool{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (p *seedNodesPool) Set(nodes []seedNode) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.nodes = nodes
+}
+
+func (p *seedNodesPool) Pick(count int, filter func(*seedNode) bool) []seedNode {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return pickSeedNodes(p.nodes, count, time.Now(), p.rnd, filter)
+}
+
+func startSeedNodesLoader(db *pg.DB, pool *seedNodesPool, maxAge time.Duration) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+
+	go func() {
+		defer worker.Done()
+		for {
+			nodes, err := loadSeedNodes(db, maxAge)
+			if err != nil {
+				worker.AddError(err)
+				return
+			}
+			pool.Set(nodes)
+			log.Printf("SEED:LOAD: %d node(s)", len(nodes))
+			time.Sleep(5 * time.Minute)
+		}
+	}()
+
+	return worker
+}
+
+func startIntroducerServer(sslDir, host string, port uint16, pool *seedNodesPool, peersCount int) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+
+	var requestsCount, emptyCount int64
+	logPrint := utils.NewSyncInterval(time.Minute, func() {
+		log.Printf("INTRODUCER: requests: +%d, empty responses: +%d",
+			atomic.SwapInt64(&requestsCount, 0), atomic.SwapInt64(&emptyCount, 0))
+	})
+
+	connHandler := func(c *network.WSChiaConnection) {
+		peerHost := c.RemoteAddr().(*net.TCPAddr).IP.String()
+		c.SetMessageHandler(func(msgID uint16, msg chiautils.FromBytes) {
+			switch msg.(type) {
+			case *types.RequestPeersIntroducer:
+				nodes := pool.Pick(peersCount, func(node *seedNode) bool {
+					return node.Host != peerHost
+				})
+				peers := make([]types.TimestampedPeerInfo, len(nodes))
+				for i, node := range nodes {
+					peers[i] = types.TimestampedPeerInfo{
+						Host:      node.Host,
+						Port:      node.Port,
+						Timestamp: uint64(node.UpdatedAt.Unix()),
+					}
+				}
+				atomic.AddInt64(&requestsCount, 1)
+				if len(peers) == 0 {
+					atomic.AddInt64(&emptyCount, 1)
+				}
+				c.SendReply(msgID, types.RespondPeersIntroducer{PeerList: peers})
+				logPrint.Trigger()
+			case *types.RequestPeers:
+				c.SendReply(msgID, types.RespondPeers{PeerList: nil})
+			}
+		})
+
+		if _, err := c.PerformHandshake(); err != nil {
+			return
+		}
+		c.StartRoutines()
+
+		// clients disconnect right after receiving peers
+		select {
+		case <-c.Done():
+		case <-time.After(30 * time.Second):
+		}
+	}
+
+	connCfg := &network.WSChiaConnConfig{NodeType: types.NODE_INTRODUCER, ServerPort: port}
+	cfg := network.ServerConfig{Host: host, Port: port, MaxConns: 1024, EvictOldest: true, ConnConfig: connCfg}
+	server, err := network.NewServerFromFiles(cfg,
+		sslDir+"/full_node/public_full_node.crt",
+		sslDir+"/full_node/public_full_node.key",
+		connHandler)
+	if err != nil {
+		worker.AddError(err)
+		worker.Done()
+		return worker
+	}
+
+	go func() {
+		defer worker.Done()
+		log.Printf("INTRODUCER: listening on %s", server.Addr())
+		if err := server.ListenAndServe(); err != nil {
+			worker.AddError(err)
+		}
+	}()
+
+	return worker
+}
+
+func CMDIntroducer() error {
+	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory")
+	host := flag.String("host", "0.0.0.0", "address to listen on")
+	port := flag.Int("port", 8445, "port to listen on")
+	peersCount := flag.Int("peers", 20, "max number of peers in every response")
+	maxAge := flag.Duration("max-age", 24*time.Hour, "serve only nodes updated during this period")
+	dnsAddr := flag.String("dns-addr", "", "address (like :53) to start DNS seeder on, disabled if empty")
+	dnsDomain := flag.String("dns-domain", "", "domain DNS seeder is authoritative for (like seeder.example.com)")
+	dnsAnswers := flag.Int("dns-answers", 16, "max number of addresses in every DNS response")
+	dnsTTL := flag.Duration("dns-ttl", 5*time.Minute, "TTL of DNS records")
+	flag.Parse()
+
+	if *dnsAddr != "" && *dnsDomain == "" {
+		return merry.New("-dns-domain is required for DNS seeder")
+	}
+
+	db := utils.MakePGConnection()
+	pool := newSeedNodesPool()
+
+	workers := []utils.Worker{
+		startSeedNodesLoader(db, pool, *maxAge),
+		startIntroducerServer(*sslDir, *host, uint16(*port), pool, *peersCount),
+	}
+	if *dnsAddr != "" {
+		workers = append(workers, startDNSSeeder(*dnsAddr, *dnsDomain, pool, *dnsAnswers, *dnsTTL))
+	}
+	for {
+		for _, worker := range workers {
+			if err := worker.PopError(); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,144 @@
+package nodes
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func makeSeedNode(host, country string, updatedAt time.Time) seedNode {
+	return seedNode{Host: host, Port: 8444, Country: country, UpdatedAt: updatedAt, ip: net.ParseIP(host)}
+}
+
+func TestPickSeedNodes(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	rnd := rand.New(rand.NewSource(1))
+	nodes := []seedNode{
+		makeSeedNode("1.1.0.1", "US", now),
+		makeSeedNode("1.1.0.2", "US", now),
+		makeSeedNode("2.2.0.1", "DE", now),
+		makeSeedNode("3.3.0.1", "FR", now.Add(-100*time.Hour)),
+		makeSeedNode("::1", "JP", now),
+	}
+
+	// no duplicates, filter is applied
+	res := pickSeedNodes(nodes, 10, now, rnd, func(n *seedNode) bool { return n.ip.To4() != nil })
+	if len(res) != 4 {
+		t.Fatalf("expected 4 nodes, got %d", len(res))
+	}
+	seen := map[string]bool{}
+	for _, node := range res {
+		if seen[node.Host] {
+			t.Errorf("duplicate node %s", node.Host)
+		}
+		seen[node.Host] = true
+	}
+
+	// recent nodes and different subnets are preferred
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		for _, node := range pickSeedNodes(nodes[:4], 2, now, rnd, nil) {
+			counts[node.Host] += 1
+		}
+	}
+	if counts["3.3.0.1"]*5 > counts["2.2.0.1"] {
+		t.Errorf("old node is picked too often: %v", counts)
+	}
+	if counts["1.1.0.1"]+counts["1.1.0.2"] > counts["2.2.0.1"]*3/2 {
+		t.Errorf("nodes from same subnet are picked too often: %v", counts)
+	}
+}
+
+func TestDNSSeederHandleQuery(t *testing.T) {
+	now := time.Now()
+	pool := newSeedNodesPool()
+	pool.Set([]seedNode{
+		makeSeedNode("1.1.0.1", "US", now),
+		makeSeedNode("2.2.0.1", "DE", now),
+		makeSeedNode("2001:db8::1", "JP", now),
+		{Host: "3.3.0.1", Port: 1234, UpdatedAt: now, ip: net.ParseIP("3.3.0.1")},
+	})
+	seeder := dnsSeeder{domain: normalizeDomain("Seeder.Example.com"), pool: pool, answers: 16, ttl: 60}
+
+	query := func(name string, qtype dnsmessage.Type) (dnsmessage.Message, error) {
+		t.Helper()
+		req := dnsmessage.Message{
+			Header: dnsmessage.Header{ID: 123, RecursionDesired: true},
+			Questions: []dnsmessage.Question{
+				{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET},
+			},
+		}
+		reqBuf, err := req.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		respBuf, err := seeder.handleQuery(reqBuf)
+		if err != nil {
+			return dnsmessage.Message{}, err
+		}
+		var resp dnsmessage.Message
+		return resp, resp.Unpack(respBuf)
+	}
+
+	resp, err := query("seeder.example.com.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 123 || !resp.Authoritative || resp.RCode != dnsmessage.RCodeSuccess {
+		t.Errorf("unexpected header: %#v", resp.Header)
+	}
+	if len(resp.Answers) != 2 {
+		t.Fatalf("expected 2 A records (non-default port is skipped), got %d", len(resp.Answers))
+	}
+	for _, answer := range resp.Answers {
+		if _, ok := answer.Body.(*dnsmessage.AResource); !ok {
+			t.Errorf("unexpected answer: %#v", answer.Body)
+		}
+	}
+
+	resp, err = query("SEEDER.example.com.", dnsmessage.TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 {
+		t.Fatalf("expected 1 AAAA record, got %d", len(resp.Answers))
+	}
+	if body := resp.Answers[0].Body.(*dnsmessage.AAAAResource); !net.IP(body.AAAA[:]).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("unexpected AAAA: %v", body.AAAA)
+	}
+
+	resp, err = query("sub.seeder.example.com.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("expected NXDOMAIN, got %s", resp.RCode)
+	}
+
+	resp, err = query("example.org.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RCode != dnsmessage.RCodeRefused {
+		t.Errorf("expected REFUSED, got %s", resp.RCode)
+	}
+
+	// answers that do not fit into UDP response are dropped
+	var manyNodes []seedNode
+	for i := 0; i < 40; i++ {
+		manyNodes = append(manyNodes, makeSeedNode(fmt.Sprintf("2001:db8::%x", i+1), "JP", now))
+	}
+	pool.Set(manyNodes)
+	seeder.answers = 40
+	resp, err = query("seeder.example.com.", dnsmessage.TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess || len(resp.Answers) < 10 || len(resp.Answers) >= 40 {
+		t.Errorf("expected some of 40 AAAA records, got %d (%s)", len(resp.Answers), resp.RCode)
+	}
+}
@@ -0,0 +1,358 @@
+package nodes
+
+import (
+	"chiastat/utils"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/go-pg/pg/v10"
+)
+
+const (
+	PROPAGATION_PEAK             = "peak"
+	PROPAGATION_SIGNAGE_POINT    = "signage_point"
+	PROPAGATION_UNFINISHED_BLOCK = "unfinished_block"
+)
+
+// Number of first announcers saved with every event.
+const propagationFirstPeersCount = 5
+
+type PropagationAnnounce struct {
+	NodeID [32]byte
+	At     time.Time
+}
+
+// PropagationEvent is a single peak, signage point (or end of sub-slot) or unfinished block
+// with the arrival time of its announce from every connected peer.
+type PropagationEvent struct {
+	Kind string
+	// Header hash for peak, challenge hash for signage point, reward hash for unfinished block.
+	Key     [32]byte
+	Height  *uint32 //only for peak
+	SPIndex *uint8  //only for signage point
+	// Ordered by arrival, one per peer.
+	Announces []PropagationAnnounce
+}
+
+func (e *PropagationEvent) FirstSeenAt() time.Time {
+	return e.Announces[0].At
+}
+
+// Delays returns sorted delays between the first announce and announces from other peers.
+func (e *PropagationEvent) Delays() []time.Duration {
+	delays := make([]time.Duration, len(e.Announces))
+	for i, ann := range e.Announces {
+		delays[i] = ann.At.Sub(e.FirstSeenAt())
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	return delays
+}
+
+func (e *PropagationEvent) FirstPeers(count int) [][]byte {
+	if count > len(e.Announces) {
+		count = len(e.Announces)
+	}
+	ids := make([][]byte, count)
+	for i := range ids {
+		id := e.Announces[i].NodeID
+		ids[i] = id[:]
+	}
+	return ids
+}
+
+// percentile returns nearest-rank percentile (p in [0, 1]) of sorted delays.
+func percentile(sortedDelays []time.Duration, p float64) time.Duration {
+	if len(sortedDelays) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sortedDelays))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sortedDelays[rank-1]
+}
+
+type propagationKey struct {
+	kind    string
+	key     [32]byte
+	spIndex uint8
+}
+
+type propagationState struct {
+	event    *PropagationEvent
+	peers    map[[32]byte]struct{}
+	finished bool
+}
+
+// PropagationTracker collects announces of the same event from different peers during window
+// after the first one, finished events are returned by PopFinished.
+type PropagationTracker struct {
+	window time.Duration
+	events map[propagationKey]*propagationState
+	mutex  sync.Mutex
+}
+
+func NewPropagationTracker(window time.Duration) *PropagationTracker {
+	return &PropagationTracker{window: window, events: make(map[propagationKey]*propagationState)}
+}
+
+func (t *PropagationTracker) add(key propagationKey, nodeID [32]byte, at time.Time, fillEvent func(*PropagationEvent)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state, ok := t.events[key]
+	if !ok {
+		event := &PropagationEvent{Kind: key.kind, Key: key.key}
+		fillEvent(event)
+		state = &propagationState{event: event, peers: make(map[[32]byte]struct{})}
+		t.events[key] = state
+	}
+	if state.finished {
+		return //late announce, event is already saved
+	}
+	if _, ok := state.peers[nodeID]; ok {
+		return
+	}
+	state.peers[nodeID] = struct{}{}
+	state.event.Announces = append(state.event.Announces, PropagationAnnounce{NodeID: nodeID, At: at})
+}
+
+func (t *PropagationTracker) AddPeak(headerHash [32]byte, height uint32, nodeID [32]byte, at time.Time) {
+	t.add(propagationKey{kind: PROPAGATION_PEAK, key: headerHash}, nodeID, at, func(e *PropagationEvent) {
+		e.Height = &height
+	})
+}
+
+func (t *PropagationTracker) AddSignagePoint(challengeHash [32]byte, index uint8, nodeID [32]byte, at time.Time) {
+	t.add(propagationKey{kind: PROPAGATION_SIGNAGE_POINT, key: challengeHash, spIndex: index}, nodeID, at, func(e *PropagationEvent) {
+		e.SPIndex = &index
+	})
+}
+
+func (t *PropagationTracker) AddUnfinishedBlock(rewardHash [32]byte, nodeID [32]byte, at time.Time) {
+	t.add(propagationKey{kind: PROPAGATION_UNFINISHED_BLOCK, key: rewardHash}, nodeID, at, func(e *PropagationEvent) {})
+}
+
+// PopFinished returns events first seen at least window ago.
+// Finished events are remembered for a while to ignore their late announces.
+func (t *PropagationTracker) PopFinished(now time.Time) []*PropagationEvent {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var res []*PropagationEvent
+	for key, state := range t.events {
+		age := now.Sub(state.event.FirstSeenAt())
+		if !state.finished && age >= t.window {
+			state.finished = true
+			state.peers = nil
+			res = append(res, state.event)
+		}
+		if state.finished && age >= 10*t.window {
+			delete(t.events, key)
+		}
+	}
+	return res
+}
+
+func startPropagationFlusher(tracker *PropagationTracker, eventsChan chan *PropagationEvent) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+	go func() {
+		defer worker.Done()
+		for {
+			for _, event := range tracker.PopFinished(time.Now()) {
+				eventsChan <- event
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+	return worker
+}
+
+func startPropagationSaver(db *pg.DB, eventsChan chan *PropagationEvent, chunkSize int) utils.Worker {
+	worker := utils.NewSimpleWorker(1)
+	eventsChanI := make(chan interface{}, 16)
+	count := 0
+	announcesCount := 0
+
+	go func() {
+		for event := range eventsChan {
+			eventsChanI <- event
+		}
+		close(eventsChanI)
+	}()
+
+	go func() {
+		defer worker.Done()
+
+		var savesDurSum, savesDurCount int64
+		logPrint := utils.NewSyncInterval(10*time.Second, func() {
+			log.Printf("SAVE:PROPAGATION: events: +%d, announces: +%d (%d chunks, avg %d ms)",
+				count, announcesCount, savesDurCount, savesDurSum/savesDurCount)
+			savesDurSum = 0
+			savesDurCount = 0
+			count = 0
+			announcesCount = 0
+		})
+
+		err := utils.SaveChunked(db, chunkSize, eventsChanI, func(tx *pg.Tx, items []interface{}) error {
+			for _, eventI := range items {
+				event := eventI.(*PropagationEvent)
+				delays := event.Delays()
+				ms := func(d time.Duration) int64 { return d.Milliseconds() }
+
+				var eventID int64
+				_, err := tx.QueryOne(pg.Scan(&eventID), `
+					INSERT INTO propagation_events (
+						kind, key, height, sp_index, first_seen_at, peers_count,
+						delay_p50_ms, delay_p90_ms, delay_p99_ms, delay_max_ms, first_peers
+					) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+					RETURNING id`,
+					event.Kind, event.Key[:], event.Height, event.SPIndex, event.FirstSeenAt(), len(event.Announces),
+					ms(percentile(delays, 0.5)), ms(percentile(delays, 0.9)), ms(percentile(delays, 0.99)),
+					ms(delays[len(delays)-1]), pg.Array(event.FirstPeers(propagationFirstPeersCount)),
+				)
+				if err != nil {
+					return merry.Wrap(err)
+				}
+
+				nodeIDs := make([][]byte, len(event.Announces))
+				annDelays := make([]int64, len(event.Announces))
+				for i, ann := range event.Announces {
+					id := ann.NodeID
+					nodeIDs[i] = id[:]
+					annDelays[i] = ms(ann.At.Sub(event.FirstSeenAt()))
+				}
+				_, err = tx.Exec(`
+					INSERT INTO propagation_announces (event_id, node_id, delay_ms)
+					SELECT ?, unnest(?::bytea[]), unnest(?::int[])`,
+					eventID, pg.Array(nodeIDs), pg.Array(annDelays),
+				)
+				if err != nil {
+					return merry.Wrap(err)
+				}
+				count += 1
+				announcesCount += len(event.Announces)
+			}
+			return nil
+		}, func(saveDur time.Duration) {
+			savesDurSum += int64(saveDur / time.Millisecond)
+			savesDurCount += 1
+			logPrint.Trigger()
+		})
+		log.Println("SAVE:PROPAGATION: done")
+		if err != nil {
+			worker.AddError(err)
+		}
+	}()
+	return worker
+}
+
+func CMDPropagationStats() error {
+	hours := flag.Int("hours", 24, "summary period in hours")
+	topCount := flag.Int("top", 10, "number of fastest and slowest nodes to show")
+	minEvents := flag.Int("min-events", 100, "skip nodes that announced fewer peaks during the period")
+	flag.Parse()
+
+	db := utils.MakePGConnection()
+	since := time.Now().Add(-time.Duration(*hours) * time.Hour)
+
+	var kinds []struct {
+		Kind                             string
+		Count                            int
+		PeersAvg                         float64
+		P50Avg, P90Avg, P99Avg, P90Worst float64
+		FirstNodes                       int
+	}
+	_, err := db.Query(&kinds, `
+		SELECT kind, count(*) AS count, avg(peers_count) AS peers_avg,
+			avg(delay_p50_ms) AS p50_avg, avg(delay_p90_ms) AS p90_avg, avg(delay_p99_ms) AS p99_avg,
+			percentile_cont(0.99) WITHIN GROUP (ORDER BY delay_p90_ms) AS p90_worst,
+			(SELECT count(DISTINCT first_peers[1]) FROM propagation_events AS e
+			 WHERE e.kind = propagation_events.kind AND e.first_seen_at > ?0) AS first_nodes
+		FROM propagation_events
+		WHERE first_seen_at > ?0
+		GROUP BY kind
+		ORDER BY kind`, since)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	fmt.Printf("last %d hours\n", *hours)
+	fmt.Printf("%-17s %7s %9s %9s %9s %9s %13s %11s\n",
+		"kind", "events", "peers", "p50 ms", "p90 ms", "p99 ms", "p90 worst 1%", "first nodes")
+	for _, k := range kinds {
+		fmt.Printf("%-17s %7d %9.1f %9.0f %9.0f %9.0f %13.0f %11d\n",
+			k.Kind, k.Count, k.PeersAvg, k.P50Avg, k.P90Avg, k.P99Avg, k.P90Worst, k.FirstNodes)
+	}
+
+	var firstSeen []struct {
+		Host    string
+		Country *string
+		Count   int
+	}
+	_, err = db.Query(&firstSeen, `
+		SELECT COALESCE(nodes.host, encode(t.node_id, 'hex')) AS host, nodes.country, t.count
+		FROM (
+			SELECT first_peers[1] AS node_id, count(*) AS count
+			FROM propagation_events
+			WHERE kind = ? AND first_seen_at > ?
+			GROUP BY first_peers[1]
+			ORDER BY count DESC
+			LIMIT ?
+		) AS t
+		LEFT JOIN nodes ON nodes.id = t.node_id
+		ORDER BY t.count DESC`, PROPAGATION_PEAK, since, *topCount)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	fmt.Println("\nmost often first to announce peak:")
+	for _, n := range firstSeen {
+		fmt.Printf("  %-40s %-4s %d\n", n.Host, strOrDash(n.Country), n.Count)
+	}
+
+	var nodeDelays []struct {
+		Host     string
+		Country  *string
+		Count    int
+		DelayAvg float64
+	}
+	for _, order := range []string{"ASC", "DESC"} {
+		_, err = db.Query(&nodeDelays, `
+			SELECT COALESCE(nodes.host, encode(t.node_id, 'hex')) AS host, nodes.country, t.count, t.delay_avg
+			FROM (
+				SELECT a.node_id, count(*) AS count, avg(a.delay_ms) AS delay_avg
+				FROM propagation_announces AS a
+				JOIN propagation_events AS e ON e.id = a.event_id
+				WHERE e.kind = ? AND e.first_seen_at > ?
+				GROUP BY a.node_id
+				HAVING count(*) >= ?
+				ORDER BY delay_avg `+order+`
+				LIMIT ?
+			) AS t
+			LEFT JOIN nodes ON nodes.id = t.node_id
+			ORDER BY t.delay_avg `+order, PROPAGATION_PEAK, since, *minEvents, *topCount)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		if order == "ASC" {
+			fmt.Println("\nfastest nodes (avg peak delay):")
+		} else {
+			fmt.Println("\nslowest nodes (avg peak delay):")
+		}
+		for _, n := range nodeDelays {
+			fmt.Printf("  %-40s %-4s %6d peaks %8.0f ms\n", n.Host, strOrDash(n.Country), n.Count, n.DelayAvg)
+		}
+		nodeDelays = nodeDelays[:0]
+	}
+	return nil
+}
+
+func strOrDash(s *string) string {
+	if s == nil {
+		return "-"
+	}
+	return *s
+}
@@ -0,0 +1,55 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPropagationTracker(t *testing.T) {
+	tracker := NewPropagationTracker(time.Minute)
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	hash := [32]byte{1}
+	for i := 0; i < 10; i++ {
+		nodeID := [32]byte{byte(i)}
+		tracker.AddPeak(hash, 100, nodeID, start.Add(time.Duration(i*i)*time.Second))
+		tracker.AddPeak(hash, 100, nodeID, start.Add(time.Hour)) //repeated announce is ignored
+	}
+	tracker.AddSignagePoint(hash, 1, [32]byte{1}, start)
+	tracker.AddSignagePoint(hash, 2, [32]byte{1}, start)
+
+	if events := tracker.PopFinished(start.Add(59 * time.Second)); len(events) != 0 {
+		t.Fatalf("expected no finished events, got %d", len(events))
+	}
+	events := tracker.PopFinished(start.Add(time.Minute))
+	if len(events) != 3 {
+		t.Fatalf("expected 3 finished events, got %d", len(events))
+	}
+	var peak *PropagationEvent
+	for _, e := range events {
+		if e.Kind == PROPAGATION_PEAK {
+			peak = e
+		}
+	}
+	if peak == nil || *peak.Height != 100 || len(peak.Announces) != 10 {
+		t.Fatalf("unexpected peak event: %#v", peak)
+	}
+	delays := peak.Delays()
+	if p := percentile(delays, 0.5); p != 16*time.Second {
+		t.Errorf("unexpected p50: %s", p)
+	}
+	if p := percentile(delays, 0.9); p != 64*time.Second {
+		t.Errorf("unexpected p90: %s", p)
+	}
+	if p := percentile(delays, 0.99); p != 81*time.Second {
+		t.Errorf("unexpected p99: %s", p)
+	}
+	if first := peak.FirstPeers(2); len(first) != 2 || first[0][0] != 0 || first[1][0] != 1 {
+		t.Errorf("unexpected first peers: %v", first)
+	}
+
+	// late announce of the finished event does not start a new one
+	tracker.AddPeak(hash, 100, [32]byte{42}, start.Add(2*time.Minute))
+	if events := tracker.PopFinished(start.Add(5 * time.Minute)); len(events) != 0 {
+		t.Errorf("expected no finished events, got %d", len(events))
+	}
+}
@@ -25,7 +25,8 @@ func CMDSaveStats() error {
 
 			protocol_version,
 			software_version,
-			node_types
+			node_types,
+			capabilities
 		) VALUES ((
 			SELECT count(*) FROM nodes
 		), (
@@ -95,6 +96,13 @@ func CMDSaveStats() error {
 				WHERE updated_at > NOW() - INTERVAL '1 day'
 				GROUP BY node_type
 			) AS t
+		), (
+			SELECT json_object_agg(capability, cnt) FROM (
+				SELECT capability, count(*) AS cnt
+				FROM nodes, unnest(capabilities) AS capability
+				WHERE updated_at > NOW() - INTERVAL '1 day'
+				GROUP BY capability
+			) AS t
 		))`)
 	return merry.Wrap(err)
 }
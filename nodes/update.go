@@ -15,7 +15,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -60,73 +59,6 @@ func askIP() (string, error) {
 	return strings.TrimSpace(string(body)), nil
 }
 
-type ConnListItem struct {
-	prev *ConnListItem
-	next *ConnListItem
-	conn *network.WSChiaConnection
-	stop chan struct{}
-}
-type ConnList struct {
-	limit  int64
-	length int64
-	start  *ConnListItem
-	end    *ConnListItem
-	mutex  sync.Mutex
-}
-
-func (l *ConnList) PushConn(c *network.WSChiaConnection) *ConnListItem {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-	item := &ConnListItem{prev: l.end, conn: c, stop: make(chan struct{}, 1)}
-	if l.end != nil {
-		l.end.next = item
-	}
-	l.end = item
-	if l.start == nil {
-		l.start = item
-	}
-	l.length += 1
-	return item
-}
-func (l *ConnList) delItemNoLock(item *ConnListItem) {
-	next := item.next
-	prev := item.prev
-	if l.start == item {
-		l.start = next
-	} else {
-		item.prev.next = next
-	}
-	if l.end == item {
-		l.end = prev
-	} else {
-		item.next.prev = prev
-	}
-	item.prev = nil
-	item.next = nil
-	l.length -= 1
-}
-func (l *ConnList) DelItemUnlesRemoved(item *ConnListItem) {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-	if item.prev == nil && item.next == nil && l.start != item {
-		return
-	}
-	l.delItemNoLock(item)
-}
-func (l *ConnList) ShiftIfNeed() *ConnListItem {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
-	if l.length <= l.limit {
-		return nil
-	}
-	if l.start == nil {
-		return nil
-	}
-	item := l.start
-	l.delItemNoLock(item)
-	return item
-}
-
 type NodeAddr struct {
 	Host    string
 	Port    uint16
@@ -140,6 +72,7 @@ type Node struct {
 	ProtocolVersion string
 	SoftwareVersion string
 	NodeType        string
+	Capabilities    []uint16
 	Country         *string
 }
 
@@ -310,6 +243,7 @@ func startNodesChecker(db *pg.DB, sslDir string, nodesInChan chan *NodeAddr, nod
 				if err != nil {
 					return merry.Wrap(err)
 				}
+				defer c.Close()
 				hs, err := c.PerformHandshake()
 				if err != nil {
 					return merry.Wrap(err)
@@ -328,6 +262,7 @@ func startNodesChecker(db *pg.DB, sslDir string, nodesInChan chan *NodeAddr, nod
 					ProtocolVersion: hs.ProtocolVersion,
 					SoftwareVersion: hs.SoftwareVersion,
 					NodeType:        nodeType,
+					Capabilities:    c.PeerCapabilities(),
 				}
 
 				for i := 0; i < 3; i++ {
@@ -518,18 +453,20 @@ func startNodesSaver(db *pg.DB, nodesChan chan *Node, chunkSize int) utils.Worke
 			for _, nodeI := range items {
 				node := nodeI.(*Node)
 				_, err := tx.Exec(`
-					INSERT INTO nodes (id, host, port, protocol_version, software_version, node_type, country, updated_at)
-					VALUES (?, ?, ?, ?, ?, ?, ?, now())
+					INSERT INTO nodes (id, host, port, protocol_version, software_version, node_type, capabilities, country, updated_at)
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, now())
 					ON CONFLICT (id) DO UPDATE SET
 						host = EXCLUDED.host,
 						port = EXCLUDED.port,
 						protocol_version = EXCLUDED.protocol_version,
 						software_version = EXCLUDED.software_version,
 						node_type = EXCLUDED.node_type,
+						capabilities = EXCLUDED.capabilities,
 						country = EXCLUDED.country,
 						seems_off = false,
 						updated_at = now()`,
-					node.ID, node.Host, node.Port, node.ProtocolVersion, node.SoftwareVersion, node.NodeType, node.Country,
+					node.ID, node.Host, node.Port, node.ProtocolVersion, node.SoftwareVersion, node.NodeType,
+					pg.Array(node.Capabilities), node.Country,
 				)
 				if err != nil {
 					return merry.Wrap(err)
@@ -604,45 +541,31 @@ func startRawNodesSaver(db *pg.DB, nodesChan chan *NodeAddr, chunkSize int) util
 	return worker
 }
 
-func startNodesListener(sslDir string, nodesChan chan *Node, rawNodesChan chan []types.TimestampedPeerInfo) utils.Worker {
+func startNodesListener(sslDir string, nodesChan chan *Node, rawNodesChan chan []types.TimestampedPeerInfo, propagation *PropagationTracker) utils.Worker {
 	worker := utils.NewSimpleWorker(1)
 
-	type ConnListItem struct {
-		prev *ConnListItem
-		next *ConnListItem
-		conn *network.WSChiaConnection
-		stop chan struct{}
-	}
-
-	connList := ConnList{limit: 1024}
-
 	go func() {
 		defer worker.Done()
 
-		var newConns, peersCount, unexpectedPeersCount int64
+		var server *network.Server
+		var newConns, peersCount, unexpectedPeersCount, rateLimitedCount int64
 		logPrint := utils.NewSyncInterval(10*time.Second, func() {
 			peersCount := atomic.SwapInt64(&peersCount, 0)
 			unexpCount := atomic.SwapInt64(&unexpectedPeersCount, 0)
 			curNewConns := atomic.SwapInt64(&newConns, 0)
-			log.Printf("LISTEN: conns: %d (+%d), peers: +%d, unexp.peers: +%d",
-				atomic.LoadInt64(&connList.length), curNewConns, peersCount, unexpCount)
+			rateLimited := atomic.SwapInt64(&rateLimitedCount, 0)
+			log.Printf("LISTEN: conns: %d (+%d), peers: +%d, unexp.peers: +%d, rate limited: +%d",
+				server.ConnCount(), curNewConns, peersCount, unexpCount, rateLimited)
 		})
 
 		connHandler := func(c *network.WSChiaConnection) {
 			atomic.AddInt64(&newConns, 1)
-			connItem := connList.PushConn(c)
-			defer func() {
-				connList.DelItemUnlesRemoved(connItem)
-				c.Close()
-			}()
-			if item := connList.ShiftIfNeed(); item != nil {
-				item.stop <- struct{}{}
-			}
 
 			shortID := c.PeerIDHex()[0:8]
 			logPrint.Trigger()
 
-			c.SetMessageHandler(func(msgID uint16, msg chiautils.FromBytes) {
+			// announces are stamped with receive time: handler may wait for rawNodesChan
+			c.SetTimedMessageHandler(func(msgID uint16, msg chiautils.FromBytes, receivedAt time.Time) {
 				switch msg := msg.(type) {
 				case *types.RequestPeers:
 					c.SendReply(msgID, types.RespondPeers{PeerList: nil})
@@ -651,10 +574,13 @@ func startNodesListener(sslDir string, nodesChan chan *Node, rawNodesChan chan [
 					rawNodesChan <- msg.PeerList
 				case *types.RequestBlock:
 					c.SendReply(msgID, types.RejectBlock{Height: msg.Height})
-				case *types.NewPeak,
-					*types.NewCompactVDF,
-					*types.NewSignagePointOrEndOfSubSlot,
-					*types.NewUnfinishedBlock,
+				case *types.NewPeak:
+					propagation.AddPeak(msg.HeaderHash, msg.Height, c.PeerID(), receivedAt)
+				case *types.NewSignagePointOrEndOfSubSlot:
+					propagation.AddSignagePoint(msg.ChallengeHash, msg.IndexFromChallenge, c.PeerID(), receivedAt)
+				case *types.NewUnfinishedBlock:
+					propagation.AddUnfinishedBlock(msg.UnfinishedRewardHash, c.PeerID(), receivedAt)
+				case *types.NewCompactVDF,
 					*types.RequestMempoolTransactions,
 					*types.NewTransaction:
 					// do nothing
@@ -666,7 +592,6 @@ func startNodesListener(sslDir string, nodesChan chan *Node, rawNodesChan chan [
 			hs, err := c.PerformHandshake()
 			if err != nil {
 				log.Printf("LISTEN: %s: handshake error: %s", shortID, err)
-				c.Close()
 				return
 			}
 			c.StartRoutines()
@@ -680,8 +605,11 @@ func startNodesListener(sslDir string, nodesChan chan *Node, rawNodesChan chan [
 				ProtocolVersion: hs.ProtocolVersion,
 				SoftwareVersion: hs.SoftwareVersion,
 				NodeType:        nodeType,
+				Capabilities:    c.PeerCapabilities(),
 			}
 
+			// Connection is closed by server when this handler returns
+			// (or when it is evicted by a newer one or stops responding to pings).
 			for i := 0; ; i++ {
 				peers, err := c.RequestPeers()
 				if err != nil {
@@ -709,20 +637,29 @@ func startNodesListener(sslDir string, nodesChan chan *Node, rawNodesChan chan [
 				}
 
 				logPrint.Trigger()
-				timer = time.NewTimer(5 * time.Minute)
 				select {
-				case <-connItem.stop:
-					timer.Stop()
+				case <-c.Done():
+					log.Printf("LISTEN: %s: closed: %s", shortID, c.Err())
 					return
-				case <-timer.C:
-					//
+				case <-time.After(5 * time.Minute):
 				}
 			}
 		}
 
-		err := network.ListenOn("0.0.0.0", sslDir+"/ca/chia_ca.crt", sslDir+"/ca/chia_ca.key", nil, connHandler)
+		connCfg := &network.WSChiaConnConfig{
+			OnRateLimitViolation: func(c *network.WSChiaConnection, violation network.RateLimitViolation) {
+				atomic.AddInt64(&rateLimitedCount, 1)
+			},
+		}
+		cfg := network.ServerConfig{Host: "0.0.0.0", MaxConns: 1024, EvictOldest: true, ConnConfig: connCfg}
+		var err error
+		server, err = network.NewServerFromFiles(cfg, sslDir+"/ca/chia_ca.crt", sslDir+"/ca/chia_ca.key", connHandler)
 		if err != nil {
 			worker.AddError(err)
+			return
+		}
+		if err := server.ListenAndServe(); err != nil {
+			worker.AddError(err)
 		}
 	}()
 	return worker
@@ -767,6 +704,7 @@ func startSeemsOffUpdater(db *pg.DB) utils.Worker {
 
 func CMDUpdateNodes() error {
 	sslDir := flag.String("ssl-dir", utils.HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory")
+	propagationWindow := flag.Duration("propagation-window", time.Minute, "how long announces of peaks, signage points and unfinished blocks are collected")
 	flag.Parse()
 
 	db := utils.MakePGConnection()
@@ -785,25 +723,30 @@ func CMDUpdateNodes() error {
 	nodesOut := make(chan *Node, 32)
 	rawNodesOut := make(chan *NodeAddr, 256)
 
+	propagation := NewPropagationTracker(*propagationWindow)
+	propagationEvents := make(chan *PropagationEvent, 256)
+
 	workers := []utils.Worker{
 		// input
 		startOldNodesLoader(db, dbNodeAddrs, 512),
 		startNodesChecker(db, *sslDir, dbNodeAddrs, nodesNoLoc, rawNodeChunks, 256),
-		startNodesListener(*sslDir, nodesNoLoc, rawNodeChunks),
+		startNodesListener(*sslDir, nodesNoLoc, rawNodeChunks, propagation),
+		startPropagationFlusher(propagation, propagationEvents),
 		// process
 		startRawNodesFilter(db, rawNodeChunks, rawNodesNoLoc),
 		startNodesLocationChecker(gdb, gdb6, nodesNoLoc, nodesOut, rawNodesNoLoc, rawNodesOut, 32),
 		// save
 		startNodesSaver(db, nodesOut, 32),
 		startRawNodesSaver(db, rawNodesOut, 512),
+		startPropagationSaver(db, propagationEvents, 16),
 		// misc
 		startSeemsOffUpdater(db),
 	}
 	logPrint := utils.NewSyncInterval(10*time.Second, func() {
-		log.Printf("UPDATE: chans: (%d) -> (%d, %d -> %d) -> (%d, %d)",
+		log.Printf("UPDATE: chans: (%d) -> (%d, %d -> %d) -> (%d, %d, %d)",
 			len(dbNodeAddrs),
 			len(nodesNoLoc), len(rawNodeChunks), len(rawNodesNoLoc),
-			len(nodesOut), len(rawNodesOut))
+			len(nodesOut), len(rawNodesOut), len(propagationEvents))
 		time.Sleep(10 * time.Second)
 	})
 	for {
@@ -2,95 +2,68 @@ package nodes
 
 import (
 	"chiastat/chia/network"
+	"chiastat/chia/network/nettest"
+	"chiastat/chia/types"
+	"reflect"
 	"testing"
 )
 
-func TestConnList(t *testing.T) {
-	assertEq := func(a interface{}, b interface{}) {
-		if a != b {
-			t.Errorf("%v != %v", a, b)
-		}
-	}
-	assertNilItem := func(a *ConnListItem) {
-		if a != nil {
-			t.Errorf("%v != nil", a)
-		}
-	}
-	assertTwoItems := func(list *ConnList, c0, c1 *network.WSChiaConnection) {
-		assertEq(list.length, int64(2))
-		assertEq(list.start.conn, c0)
-		assertEq(list.end.conn, c1)
-		assertNilItem(list.start.prev)
-		assertEq(list.start.next, list.end)
-		assertNilItem(list.end.next)
-		assertEq(list.end.prev, list.start)
-	}
-	assertOneItem := func(list *ConnList, c0 *network.WSChiaConnection) {
-		assertEq(list.length, int64(1))
-		assertEq(list.start.conn, c0)
-		assertEq(list.end.conn, c0)
-		assertNilItem(list.start.prev)
-		assertNilItem(list.start.next)
+func TestNodesChecker(t *testing.T) {
+	node, err := nettest.Start(nettest.Config{Capabilities: []uint16{types.CAP_BASE, types.CAP_BLOCK_HEADERS}})
+	if err != nil {
+		t.Fatal(err)
 	}
-	assertEmpty := func(list *ConnList) {
-		assertEq(list.length, int64(0))
-		assertNilItem(list.start)
-		assertNilItem(list.end)
-	}
-
-	var list *ConnList
-	c0 := &network.WSChiaConnection{}
-	c1 := &network.WSChiaConnection{}
-	c2 := &network.WSChiaConnection{}
-
-	fill := func() {
-		list = &ConnList{limit: 2}
-		assertEmpty(list)
-
-		list.PushConn(c0)
-		assertOneItem(list, c0)
-
-		list.PushConn(c1)
-		assertTwoItems(list, c0, c1)
+	defer node.Close()
+	peers := []types.TimestampedPeerInfo{{Host: "1.2.3.4", Port: 8444, Timestamp: 1600000000}}
+	node.On(types.MSG_REQUEST_PEERS, nettest.Response{Message: types.RespondPeers{PeerList: peers}})
 
-		list.PushConn(c2)
-		assertEq(list.length, int64(3))
-		assertEq(list.start.conn, c0)
-		assertEq(list.start.next.conn, c1)
-		assertEq(list.end.conn, c2)
+	deadNode, err := nettest.StartWithCA(node.CA, nettest.Config{DisconnectBeforeHandshake: true})
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer deadNode.Close()
 
-	fill()
-	dest := list.start
-	assertEq(list.ShiftIfNeed(), dest)
-	assertTwoItems(list, c1, c2)
-
-	assertNilItem(list.ShiftIfNeed())
-	assertTwoItems(list, c1, c2)
-
-	// ---
+	sslDir := t.TempDir()
+	if err := node.CA.WriteSSLDir(sslDir); err != nil {
+		t.Fatal(err)
+	}
 
-	fill()
-	item := list.start.next
-	list.DelItemUnlesRemoved(item)
-	assertTwoItems(list, c0, c2)
-	assertNilItem(item.prev)
-	assertNilItem(item.next)
-	list.DelItemUnlesRemoved(item)
-	assertTwoItems(list, c0, c2)
+	nodesIn := make(chan *NodeAddr, 2)
+	nodesOut := make(chan *Node, 2)
+	rawNodesOut := make(chan []types.TimestampedPeerInfo, 8)
+	nodesIn <- &NodeAddr{Host: "127.0.0.1", Port: node.Port()}
+	nodesIn <- &NodeAddr{Host: "127.0.0.1", Port: deadNode.Port()}
+	close(nodesIn)
 
-	list.DelItemUnlesRemoved(list.start)
-	assertOneItem(list, c2)
+	worker := startNodesChecker(nil, sslDir, nodesIn, nodesOut, rawNodesOut, 2)
+	if err := worker.CloseAndWait(); err != nil {
+		t.Fatal(err)
+	}
+	close(nodesOut)
+	close(rawNodesOut)
 
-	list.DelItemUnlesRemoved(list.start)
-	assertEmpty(list)
+	var checked []*Node
+	for n := range nodesOut {
+		checked = append(checked, n)
+	}
+	if len(checked) != 1 {
+		t.Fatalf("expected 1 checked node, got %d", len(checked))
+	}
+	n := checked[0]
+	if n.Host != "127.0.0.1" || n.Port != node.Port() || n.NodeType != "FULL_NODE" ||
+		n.ProtocolVersion != network.PROTOCOL_VERSION || len(n.ID) != 32 ||
+		!reflect.DeepEqual(n.Capabilities, []uint16{types.CAP_BASE, types.CAP_BLOCK_HEADERS}) {
+		t.Errorf("unexpected node: %#v", n)
+	}
 
-	fill()
-	item = list.end
-	list.DelItemUnlesRemoved(item)
-	assertTwoItems(list, c0, c1)
-	assertNilItem(item.prev)
-	assertNilItem(item.next)
-	list.DelItemUnlesRemoved(item)
-	assertTwoItems(list, c0, c1)
+	chunks := 0
+	for chunk := range rawNodesOut {
+		if !reflect.DeepEqual(chunk, peers) {
+			t.Errorf("unexpected peers: %#v", chunk)
+		}
+		chunks += 1
+	}
+	if chunks != 3 || node.ReceivedCount(types.MSG_REQUEST_PEERS) != 3 {
+		t.Errorf("expected 3 peers requests, got %d (%d received)", chunks, node.ReceivedCount(types.MSG_REQUEST_PEERS))
+	}
 }
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"chiastat/chia"
+	"chiastat/chia/network"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// DefaultBlockchainDBPath returns path to v2 full node database if it exists, v1 otherwise.
+func DefaultBlockchainDBPath() string {
+	dir := HomeDirOrEmpty("/.chia/mainnet/db/")
+	if _, err := os.Stat(dir + "blockchain_v2_mainnet.sqlite"); err == nil {
+		return dir + "blockchain_v2_mainnet.sqlite"
+	}
+	return dir + "blockchain_v1_mainnet.sqlite"
+}
+
+// BlockSourceFlags are command line flags selecting chia.BlockSource:
+// full node database (default), file written by export-blocks or network peers.
+type BlockSourceFlags struct {
+	Source      *string
+	DBPath      *string
+	RawPath     *string
+	RawTable    *string
+	SSLDir      *string
+	Peers       *string
+	DNSSeeds    *string
+	PeerTimeout *time.Duration
+}
+
+// AddBlockSourceFlags registers flags, must be called before flag.Parse.
+func AddBlockSourceFlags() *BlockSourceFlags {
+	return &BlockSourceFlags{
+		Source:      flag.String("source", "db", `blocks source: "db", "raw" or "peers"`),
+		DBPath:      flag.String("db-path", DefaultBlockchainDBPath(), "path to blockchain_v2_mainnet.sqlite or blockchain_v1_mainnet.sqlite (for -source=db)"),
+		RawPath:     flag.String("raw-path", "full_blocks.raw", "path to file written by export-blocks (for -source=raw)"),
+		RawTable:    flag.String("raw-table", "full_blocks", `table the raw file was exported from, "full_blocks" or "block_records" (for -source=raw)`),
+		SSLDir:      flag.String("ssl-dir", HomeDirOrEmpty("/.chia/mainnet/ssl"), "path to chia/mainnet/ssl directory (for -source=peers)"),
+		Peers:       flag.String("peers", "", "comma-separated host:port list of peers, resolved via DNS seeders if empty (for -source=peers)"),
+		DNSSeeds:    flag.String("dns-seeds", strings.Join(network.DEFAULT_DNS_SEEDS, ","), "comma-separated DNS seeders list (for -source=peers)"),
+		PeerTimeout: flag.Duration("peer-timeout", 30*time.Second, "how long to wait for peers to connect (for -source=peers)"),
+	}
+}
+
+func (f *BlockSourceFlags) Open() (chia.BlockSource, error) {
+	switch *f.Source {
+	case "db":
+		src, err := chia.OpenSqliteBlockSource(*f.DBPath)
+		return src, merry.Wrap(err)
+	case "raw":
+		src, err := chia.OpenRawFileBlockSource(*f.RawPath, *f.RawTable)
+		return src, merry.Wrap(err)
+	case "peers":
+		peers, err := StartPeerManager(*f.SSLDir, *f.Peers, *f.DNSSeeds, network.DEFAULT_TARGET_OUTBOUND)
+		if err != nil {
+			return nil, merry.Wrap(err)
+		}
+		src := chia.NewPeerBlockSource(peers)
+		if err := src.WaitForPeak(*f.PeerTimeout); err != nil {
+			src.Close()
+			return nil, merry.Wrap(err)
+		}
+		return src, nil
+	default:
+		return nil, merry.Errorf(`unknown blocks source "%s", expected "db", "raw" or "peers"`, *f.Source)
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"chiastat/chia/network"
+	"context"
+	"log"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// StartPeerManager starts PeerManager with full_node certificate from sslDir.
+// Peers are resolved via DNS seeders if peersStr (comma-separated host:port list) is empty.
+func StartPeerManager(sslDir, peersStr, dnsSeedsStr string, targetOutbound int) (*network.PeerManager, error) {
+	tlsCfg, err := network.MakeTSLConfigFromFiles(
+		sslDir+"/ca/chia_ca.crt",
+		sslDir+"/full_node/public_full_node.crt",
+		sslDir+"/full_node/public_full_node.key")
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	peers := network.NewPeerManager(network.PeerManagerConfig{
+		TLSConfig:      tlsCfg,
+		TargetOutbound: targetOutbound,
+		DiscoverPeers:  true,
+	})
+
+	addresses := SplitList(peersStr)
+	for _, address := range addresses {
+		peers.AddAddress(address)
+	}
+	if len(addresses) == 0 {
+		for _, seed := range SplitList(dnsSeedsStr) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			seedPeers, err := network.ResolveDNSSeed(ctx, seed, network.SERVER_PORT)
+			cancel()
+			if err != nil {
+				log.Printf("WARN: %s: %s", seed, err)
+				continue
+			}
+			peers.AddressBook().AddPeers(seedPeers)
+		}
+	}
+	if peers.AddressBook().Len() == 0 {
+		return nil, merry.New("no peers to connect to")
+	}
+	peers.Start()
+	return peers, nil
+}
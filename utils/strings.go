@@ -0,0 +1,14 @@
+package utils
+
+import "strings"
+
+// SplitList splits comma-separated list, trims items and skips empty ones.
+func SplitList(str string) []string {
+	var items []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}